@@ -0,0 +1,282 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// DefaultAckTimeout is how long a DataFrame waits for the ack before it's retransmitted by default.
+const DefaultAckTimeout = time.Second
+
+// ackRetention is how long the server keeps the ackReceiver of an identified client after it
+// disconnects, twice the max delay of the default reconnect backoff.
+const ackRetention = time.Minute
+
+// errAckWindowClosed is returned when tracking a frame after the ack window is closed.
+var errAckWindowClosed = errors.New("ack window is closed")
+
+// ackWindow tracks the DataFrames written by the client until the server acknowledges
+// them, the frames unacknowledged for the timeout are retransmitted. Track blocks while
+// the window holds size frames, so the sender is paused until the acks catch up.
+type ackWindow struct {
+	size    int
+	timeout time.Duration
+	mu      sync.Mutex
+	notFull *sync.Cond
+	pending map[string]*unacked // transaction id -> frame
+	order   []string            // transaction ids in the order they're tracked
+	retries int64
+	closed  bool
+	empty   chan struct{} // closed once the pending frames are all acknowledged
+}
+
+type unacked struct {
+	frame  *frame.DataFrame
+	sentAt time.Time
+}
+
+func newAckWindow(size int, timeout time.Duration) *ackWindow {
+	w := &ackWindow{
+		size:    size,
+		timeout: timeout,
+		pending: make(map[string]*unacked),
+	}
+	w.notFull = sync.NewCond(&w.mu)
+	return w
+}
+
+// track the frame sent at now, it blocks while the window is full.
+func (w *ackWindow) track(f *frame.DataFrame, now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.pending) >= w.size && !w.closed {
+		w.notFull.Wait()
+	}
+	if w.closed {
+		return errAckWindowClosed
+	}
+	tid := f.TransactionID()
+	if _, ok := w.pending[tid]; !ok {
+		w.order = append(w.order, tid)
+	}
+	w.pending[tid] = &unacked{frame: f, sentAt: now}
+	return nil
+}
+
+// ack removes the acknowledged frames from the window.
+func (w *ackWindow) ack(tids []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, tid := range tids {
+		delete(w.pending, tid)
+	}
+	// drop the acknowledged ids at the head, the order is compacted lazily
+	i := 0
+	for i < len(w.order) {
+		if _, ok := w.pending[w.order[i]]; ok {
+			break
+		}
+		i++
+	}
+	w.order = w.order[i:]
+	if len(w.order) > 2*w.size {
+		order := make([]string, 0, len(w.pending))
+		for _, tid := range w.order {
+			if _, ok := w.pending[tid]; ok {
+				order = append(order, tid)
+			}
+		}
+		w.order = order
+	}
+	if len(w.pending) == 0 && w.empty != nil {
+		close(w.empty)
+		w.empty = nil
+	}
+	w.notFull.Broadcast()
+}
+
+// drained returns a channel closed once all the frames tracked by now are acknowledged.
+func (w *ackWindow) drained() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if w.empty == nil {
+		w.empty = make(chan struct{})
+	}
+	return w.empty
+}
+
+// due returns the frames unacknowledged for the timeout in the order they're tracked,
+// they're considered sent again at now.
+func (w *ackWindow) due(now time.Time) []*frame.DataFrame {
+	return w.resend(now, w.timeout)
+}
+
+// unacked returns all the unacknowledged frames in the order they're tracked, e.g. to
+// deliver them again after reconnecting, they're considered sent again at now.
+func (w *ackWindow) unacked(now time.Time) []*frame.DataFrame {
+	return w.resend(now, 0)
+}
+
+// resend returns the frames unacknowledged for the age in the order they're tracked.
+func (w *ackWindow) resend(now time.Time, age time.Duration) []*frame.DataFrame {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make([]*frame.DataFrame, 0)
+	for _, tid := range w.order {
+		item, ok := w.pending[tid]
+		if !ok || now.Sub(item.sentAt) < age {
+			continue
+		}
+		item.sentAt = now
+		result = append(result, item.frame)
+	}
+	w.retries += int64(len(result))
+	return result
+}
+
+// Size returns the max number of the unacknowledged frames.
+func (w *ackWindow) Size() int {
+	return w.size
+}
+
+// Len returns the number of the unacknowledged frames.
+func (w *ackWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+// Retries returns how many times the frames are retransmitted.
+func (w *ackWindow) Retries() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.retries
+}
+
+// close the window, the blocked track returns errAckWindowClosed.
+func (w *ackWindow) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.notFull.Broadcast()
+	w.mu.Unlock()
+}
+
+// ackReceiver remembers the transaction ids of the last DataFrames received from a client,
+// so the retransmitted frames are acknowledged again but not routed twice. The receiver of an
+// identified client is shared by its connections, so are the frames redelivered after
+// reconnecting.
+type ackReceiver struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+	ring []string
+	next int
+	key  string // the identity of the client, empty if it isn't identified
+	// conns is the number of the connections of the client, the receiver is released at
+	// releasedAt once it's 0
+	conns      int
+	releasedAt time.Time
+}
+
+// newAckReceiver remembers the last size transaction ids, twice the window of the client
+// covers the frames retransmitted once their acks are lost.
+func newAckReceiver(window int) *ackReceiver {
+	size := 2 * window
+	return &ackReceiver{
+		seen: make(map[string]struct{}, size),
+		ring: make([]string, size),
+	}
+}
+
+// receive reports whether the transaction id is received for the first time.
+func (r *ackReceiver) receive(tid string) bool {
+	if tid == "" {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.seen[tid]; ok {
+		return false
+	}
+	if old := r.ring[r.next]; old != "" {
+		delete(r.seen, old)
+	}
+	r.ring[r.next] = tid
+	r.next = (r.next + 1) % len(r.ring)
+	r.seen[tid] = struct{}{}
+	return true
+}
+
+// attach a connection of the client to the receiver.
+func (r *ackReceiver) attach() {
+	r.mu.Lock()
+	r.conns++
+	r.mu.Unlock()
+}
+
+// detach a connection of the client from the receiver at now.
+func (r *ackReceiver) detach(now time.Time) {
+	r.mu.Lock()
+	r.conns--
+	if r.conns == 0 {
+		r.releasedAt = now
+	}
+	r.mu.Unlock()
+}
+
+// expired reports whether the client has no connection for the retention at now.
+func (r *ackReceiver) expired(now time.Time, retention time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns == 0 && now.Sub(r.releasedAt) >= retention
+}
+
+// ackReceiverOf returns the ackReceiver of the connection of a client enabling the ack window.
+// The client identified by its app, name and client id gets the receiver of its previous
+// connection back within the ackRetention, as long as the window is the same.
+func (s *Server) ackReceiverOf(appID string, f *frame.HandshakeFrame) *ackReceiver {
+	window := int(f.AckWindow)
+	if int64(f.AckWindow) > int64(s.opts.MaxAckWindow) {
+		logger.Warnf("%sack window of [%s] is %d, clamped to %d", ServerLogPrefix, f.Name, f.AckWindow, s.opts.MaxAckWindow)
+		window = s.opts.MaxAckWindow
+	}
+	if f.ClientID == "" {
+		r := newAckReceiver(window)
+		r.attach()
+		return r
+	}
+	now := s.opts.Clock.Now()
+	s.ackClients.Range(func(key interface{}, val interface{}) bool {
+		if val.(*ackReceiver).expired(now, ackRetention) {
+			s.ackClients.Delete(key)
+		}
+		return true
+	})
+	key := appID + "\x00" + f.Name + "\x00" + f.ClientID
+	if v, ok := s.ackClients.Load(key); ok && len(v.(*ackReceiver).ring) == 2*window {
+		r := v.(*ackReceiver)
+		r.attach()
+		return r
+	}
+	r := newAckReceiver(window)
+	r.key = key
+	r.attach()
+	s.ackClients.Store(key, r)
+	return r
+}
+
+// releaseAckReceiver detaches the connection from its ackReceiver, the receiver of an
+// identified client is kept for the ackRetention.
+func (s *Server) releaseAckReceiver(connID string) {
+	if v, ok := s.ackReceivers.LoadAndDelete(connID); ok {
+		v.(*ackReceiver).detach(s.opts.Clock.Now())
+	}
+}
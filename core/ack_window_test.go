@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestAckWindow(t *testing.T) {
+	w := newAckWindow(2, time.Second)
+	now := time.Unix(0, 0)
+	assert.NoError(t, w.track(newDataFrame("tid-1", "source", 0x33), now))
+	assert.NoError(t, w.track(newDataFrame("tid-2", "source", 0x33), now.Add(500*time.Millisecond)))
+
+	// the window is full
+	tracked := make(chan error, 1)
+	go func() { tracked <- w.track(newDataFrame("tid-3", "source", 0x33), now) }()
+	select {
+	case <-tracked:
+		t.Fatal("track is not blocked by the full window")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.ack([]string{"tid-1", "unknown"})
+	assert.NoError(t, <-tracked)
+	assert.Equal(t, 2, w.Len())
+
+	// only the frames unacknowledged for the timeout are due
+	due := w.due(now.Add(time.Second))
+	assert.Len(t, due, 1)
+	assert.Equal(t, "tid-3", due[0].TransactionID())
+	assert.Empty(t, w.due(now.Add(time.Second)))
+	due = w.due(now.Add(2 * time.Second))
+	assert.Len(t, due, 2)
+	assert.Equal(t, "tid-2", due[0].TransactionID())
+	assert.EqualValues(t, 3, w.Retries())
+
+	w.close()
+	assert.Equal(t, errAckWindowClosed, w.track(newDataFrame("tid-4", "source", 0x33), now))
+}
+
+func TestAckReceiver(t *testing.T) {
+	r := newAckReceiver(2)
+	for i := 0; i < 4; i++ {
+		assert.True(t, r.receive(fmt.Sprintf("tid-%d", i)))
+	}
+	assert.False(t, r.receive("tid-0"))
+	// the oldest id is forgotten
+	assert.True(t, r.receive("tid-4"))
+	assert.True(t, r.receive("tid-0"))
+}
+
+func TestClientFlush(t *testing.T) {
+	c := NewClient("source", ClientTypeSource, WithAckWindow(8, time.Second))
+	assert.NoError(t, c.Flush(context.Background()))
+	assert.NoError(t, c.acks.track(newDataFrame("tid-1", "source", 0x33), time.Now()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := c.Flush(ctx)
+	unflushed, ok := err.(*UnflushedError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, unflushed.Remaining)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	time.AfterFunc(10*time.Millisecond, func() { c.acks.ack([]string{"tid-1"}) })
+	assert.NoError(t, c.Flush(context.Background()))
+}
+
+func TestClientRedeliver(t *testing.T) {
+	c := NewClient("zipper-1", ClientTypeUpstreamZipper, WithAckWindow(8, time.Minute))
+	out := &syncBuffer{}
+	c.stream = &mockQuicStream{mockStream: &mockStream{w: out}}
+	c.state = ConnStateConnected
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, c.acks.track(newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33), now))
+	}
+	c.acks.ack([]string{"tid-1"})
+
+	// the unacknowledged frames are written again in order, though they aren't due yet
+	c.redeliver()
+	assert.NoError(t, c.WriteFrame(newDataFrame("tid-4", "source", 0x33)))
+	var tids []string
+	for _, f := range out.Frames() {
+		tids = append(tids, f.(*frame.DataFrame).TransactionID())
+	}
+	assert.Equal(t, []string{"tid-2", "tid-3", "tid-4"}, tids)
+	assert.Equal(t, 3, c.AckPending())
+	assert.EqualValues(t, 2, c.AckRetries())
+	assert.Equal(t, 8, c.AckWindow())
+}
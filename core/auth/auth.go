@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"context"
+
 	"github.com/yomorun/yomo/core/frame"
 )
 
@@ -32,6 +34,30 @@ type Authentication interface {
 	Authenticate(f *frame.HandshakeFrame) bool
 }
 
+// ContextAuthentication is an Authentication which enriches the context of the connection, e.g.
+// sets the tenant id of the credential, the server calls AuthenticateContext instead of
+// Authenticate, and the handlers of the following frames read the values by Context.Context().
+type ContextAuthentication interface {
+	Authentication
+	AuthenticateContext(ctx context.Context, f *frame.HandshakeFrame) (context.Context, bool)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns the context carrying the tenant of the connection. The server passes the
+// tenant selected by the TLS server name to AuthenticateContext, which checks the credential
+// against it. The returned context may carry the tenant of the credential instead, the
+// handshake is refused if it's another one.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the connection, false if there's none.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
 // Credential for client
 type Credential interface {
 	AppID() string
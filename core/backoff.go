@@ -0,0 +1,86 @@
+package core
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff computes the delays between the reconnect attempts, the delay grows
+// exponentially from Initial to Max, and is shortened by a random jitter so the clients
+// disconnected at the same time don't reconnect at the same time.
+type Backoff struct {
+	// Initial is the delay of the first attempt.
+	Initial time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Multiplier is the factor the delay grows by after every attempt.
+	Multiplier float64
+	// Jitter is the max fraction of the delay to subtract randomly, in [0, 1].
+	Jitter float64
+	// MaxRetries is the max number of attempts, 0 means infinite.
+	MaxRetries int
+
+	mu       sync.Mutex
+	attempts int
+	delay    time.Duration
+	rand     func() float64 // rand.Float64 if it's nil, e.g. the Backoff isn't made by NewBackoff
+}
+
+// BackoffState describes the reconnect attempts.
+type BackoffState struct {
+	// Attempts is the number of the attempts since the last successful connect.
+	Attempts int
+	// Delay is the delay before the last attempt.
+	Delay time.Duration
+}
+
+// NewBackoff creates a Backoff doubling the delay with 50% jitter.
+func NewBackoff(initial time.Duration, max time.Duration, maxRetries int) *Backoff {
+	return &Backoff{
+		Initial:    initial,
+		Max:        max,
+		Multiplier: 2,
+		Jitter:     0.5,
+		MaxRetries: maxRetries,
+		rand:       rand.Float64,
+	}
+}
+
+// Next returns the delay before the next attempt, false if the retries are exhausted.
+func (b *Backoff) Next() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxRetries > 0 && b.attempts >= b.MaxRetries {
+		return 0, false
+	}
+	base := b.Initial
+	for i := 0; i < b.attempts && base < b.Max; i++ {
+		base = time.Duration(float64(base) * b.Multiplier)
+	}
+	if base > b.Max {
+		base = b.Max
+	}
+	b.attempts++
+	random := b.rand
+	if random == nil {
+		random = rand.Float64
+	}
+	b.delay = base - time.Duration(float64(base)*b.Jitter*random())
+	return b.delay, true
+}
+
+// Reset the attempts after a successful connect.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	b.attempts = 0
+	b.delay = 0
+	b.mu.Unlock()
+}
+
+// State returns the current state.
+func (b *Backoff) State() BackoffState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BackoffState{Attempts: b.attempts, Delay: b.delay}
+}
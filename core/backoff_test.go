@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+)
+
+func TestBackoffSchedule(t *testing.T) {
+	b := NewBackoff(time.Second, 5*time.Second, 0)
+	b.rand = func() float64 { return 0 }
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d, ok := b.Next()
+		assert.True(t, ok)
+		assert.Equal(t, want, d)
+	}
+	assert.Equal(t, BackoffState{Attempts: 5, Delay: 5 * time.Second}, b.State())
+
+	b.Reset()
+	d, _ := b.Next()
+	assert.Equal(t, time.Second, d)
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := NewBackoff(time.Second, 30*time.Second, 0)
+	b.rand = func() float64 { return 1 }
+	d, _ := b.Next()
+	assert.Equal(t, 500*time.Millisecond, d)
+
+	b = NewBackoff(time.Second, 30*time.Second, 0)
+	for i := 0; i < 100; i++ {
+		d, _ := b.Next()
+		b.Reset()
+		assert.True(t, d > 500*time.Millisecond && d <= time.Second, d)
+	}
+}
+
+func TestBackoffLiteral(t *testing.T) {
+	b := &Backoff{Initial: time.Second, Max: 30 * time.Second, Multiplier: 2, Jitter: 0.5}
+	d, ok := b.Next()
+	assert.True(t, ok)
+	assert.True(t, d > 500*time.Millisecond && d <= time.Second, d)
+}
+
+func TestBackoffMaxRetries(t *testing.T) {
+	b := NewBackoff(time.Second, 30*time.Second, 2)
+	_, ok := b.Next()
+	assert.True(t, ok)
+	_, ok = b.Next()
+	assert.True(t, ok)
+	_, ok = b.Next()
+	assert.False(t, ok)
+}
+
+func TestClientReconnectBackoff(t *testing.T) {
+	start := time.Unix(0, 0)
+	fake := clock.NewFake(start)
+	c := NewClient("test", ClientTypeSource, WithClientClock(fake), WithReconnectBackoff(time.Second, 30*time.Second, 3))
+	c.opts.Backoff.rand = func() float64 { return 0 }
+	c.setState(ConnStateDisconnected)
+
+	var mu sync.Mutex
+	dials := make([]time.Duration, 0)
+	dialed := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dials)
+	}
+	done := make(chan struct{})
+	go func() {
+		c.reconnectWith(context.Background(), "localhost:9000", func() error {
+			mu.Lock()
+			dials = append(dials, fake.Now().Sub(start))
+			mu.Unlock()
+			return errors.New("refused")
+		})
+		close(done)
+	}()
+
+	assert.True(t, waitFor(func() bool { return fake.Waiters() == 1 }))
+	// the ticker finds the client disconnected
+	fake.Advance(time.Second)
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		assert.True(t, waitFor(func() bool { return dialed() == i && fake.Waiters() == 2 }))
+		fake.Advance(delay)
+	}
+
+	<-done
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, dials)
+	assert.Equal(t, ConnStateAborted, c.getState())
+	assert.Equal(t, 3, c.ReconnectState().Attempts)
+}
+
+func TestClientReconnectAfterConnectContext(t *testing.T) {
+	s := newTestServer("sfn-1")
+	addr := freeAddr(t)
+	go s.ListenAndServe(context.Background(), addr)
+	defer s.Close()
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	c := NewClient("source", ClientTypeSource, WithReconnectBackoff(10*time.Millisecond, time.Second, 0))
+	defer c.Close()
+	// the ctx bounds the dial only
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	assert.NoError(t, c.Connect(ctx, addr))
+	cancel()
+	assert.True(t, waitFor(func() bool { return len(s.ListSessions()) == 1 }))
+
+	id := s.ListSessions()[0].ID
+	assert.NoError(t, s.CloseSession(id))
+	assert.True(t, waitFor(func() bool {
+		sessions := s.ListSessions()
+		return len(sessions) == 1 && sessions[0].ID != id && c.getState() == ConnStateConnected
+	}))
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// CompressionDeflate is the codec of the streams compressed by ALPNDeflate, it's reported by
+// the CapabilitiesFrame.
+const CompressionDeflate = "deflate"
+
+// Capabilities returns the capability set of the server, which is responded to the
+// CapabilitiesRequestFrame.
+func (s *Server) Capabilities() *frame.CapabilitiesFrame {
+	capabilities := &frame.CapabilitiesFrame{
+		MaxFrameSize:  uint32(s.opts.MaxFrameSize),
+		ControlStream: true,
+	}
+	if s.opts.Compression {
+		capabilities.Compression = []string{CompressionDeflate}
+	}
+	qc, err := s.quicConfig()
+	if err != nil || qc == nil || len(qc.Versions) == 0 {
+		qc = defaultServerQuicConfig()
+	}
+	capabilities.Datagram = qc.EnableDatagrams
+	for _, v := range qc.Versions {
+		capabilities.Versions = append(capabilities.Versions, uint32(v))
+	}
+	for _, a := range s.opts.Auths {
+		if a.Type() != auth.AuthTypeNone {
+			capabilities.AuthRequired = true
+		}
+	}
+	return capabilities
+}
+
+// handleCapabilitiesRequestFrame responds the capabilities of the server, the connection
+// which isn't registered yet is responded on the stream of the request.
+func (s *Server) handleCapabilitiesRequestFrame(c *Context) {
+	capabilities := s.Capabilities()
+	if s.connector.Get(c.ConnID) != nil {
+		if err := s.connector.WriteControl(capabilities, c.ConnID); err != nil {
+			c.Logger().Debugf("%scapabilities [%s] err=%v", ServerLogPrefix, c.ConnID, err)
+		}
+		return
+	}
+	if _, err := c.Stream.Write(capabilities.Encode()); err != nil {
+		c.Logger().Debugf("%scapabilities [%s] err=%v", ServerLogPrefix, c.ConnID, err)
+	}
+}
+
+// QueryCapabilities discovers the capabilities of the server at addr before connecting to it,
+// e.g. to enable only the options the server supports. It dials a connection by the options
+// of the client which is closed once the server responds.
+func (c *Client) QueryCapabilities(ctx context.Context, addr string) (*frame.CapabilitiesFrame, error) {
+	if c.opts.Versions != nil && len(c.opts.Versions) == 0 {
+		return nil, ErrNoQuicVersions
+	}
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.CloseWithError(0, "capabilities queried")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if isCompressed(conn) {
+		stream = compressStream(stream)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if _, err := stream.Write(frame.NewCapabilitiesRequestFrame().Encode()); err != nil {
+		return nil, err
+	}
+	f, err := NewFrameStream(stream).ReadFrame()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	capabilities, ok := f.(*frame.CapabilitiesFrame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s responded to the capabilities request", f.Type())
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerCapabilities(t *testing.T) {
+	s := NewServer("test-zipper", WithServerCompression(), WithMaxFrameSize(1<<20), WithServerVersions(quic.Version1))
+	capabilities := s.Capabilities()
+	assert.Equal(t, []string{CompressionDeflate}, capabilities.Compression)
+	assert.EqualValues(t, 1<<20, capabilities.MaxFrameSize)
+	assert.Equal(t, []uint32{uint32(quic.Version1)}, capabilities.Versions)
+	assert.False(t, capabilities.AuthRequired)
+	assert.True(t, capabilities.ControlStream)
+
+	s = NewServer("test-zipper", WithAuth(&tenantAuth{}))
+	capabilities = s.Capabilities()
+	assert.Empty(t, capabilities.Compression)
+	assert.True(t, capabilities.AuthRequired)
+	assert.Len(t, capabilities.Versions, 2)
+}
+
+func TestHandleCapabilitiesRequestFrame(t *testing.T) {
+	s := newTestServer("sfn-1")
+	// the capabilities are queried before the handshake
+	out := &syncBuffer{}
+	in := encodeFrames(
+		frame.NewCapabilitiesRequestFrame(),
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(in), w: out}})
+	frames := out.Frames()
+	if assert.NotEmpty(t, frames) {
+		assert.Equal(t, frame.TagOfCapabilitiesFrame, frames[0].Type())
+	}
+	assert.Equal(t, ClientTypeSource, s.clientType("source-conn"))
+	assert.Zero(t, s.StatsProtocolViolation())
+}
+
+func TestClientQueryCapabilities(t *testing.T) {
+	s := NewServer("test-zipper", WithServerCompression())
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	client := NewClient("source", ClientTypeSource, WithClientCompression())
+	qctx, qcancel := context.WithTimeout(ctx, time.Second)
+	defer qcancel()
+	capabilities, err := client.QueryCapabilities(qctx, addr)
+	assert.NoError(t, err)
+	assert.Equal(t, s.Capabilities(), capabilities)
+
+	// the client connects by the same options as usual
+	assert.NoError(t, client.Connect(ctx, addr))
+	defer client.Close()
+	assert.True(t, waitFor(func() bool { return len(s.connector.GetSnapshot()) == 1 }))
+}
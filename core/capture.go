@@ -0,0 +1,94 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// ReplayResult is the outcome of replaying a captured stream, see ReplayCapture.
+type ReplayResult struct {
+	// Reason is why the replayed stream ended, its Cause is DisconnectClientClose once the
+	// capture is read to the end.
+	Reason DisconnectReason
+	// Replies is the frames written back to the replayed client in order, e.g. the
+	// AcceptedFrame, the acks or the RejectedFrame.
+	Replies []frame.Frame
+}
+
+// ReplayCapture feeds the raw bytes sent by a client, i.e. the concatenated frames as they're
+// read from the decompressed stream, into the server as if they're received from the
+// connection connID, to reproduce the routing of a captured stream on a test instance. The
+// frames are handled one by one in order by the same path as the accepted streams, a capture
+// usually starts with the HandshakeFrame. The frames routed to the other clients are
+// written to their connections as usual.
+//
+// Once the capture is read to the end, it waits until the replies queued to the replayed
+// connection are written, then the connection is deregistered. The error is the ctx's if
+// it's done before that.
+func (s *Server) ReplayCapture(ctx context.Context, connID string, capture io.Reader) (ReplayResult, error) {
+	stream := &replayStream{r: capture}
+	c := &Context{ConnID: connID, Stream: stream}
+	defer c.Clean()
+	reason := s.handleConnection(ctx, c)
+	var err error
+	select {
+	case <-s.connector.Flushed(connID):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.deregister(connID, reason)
+	return ReplayResult{Reason: reason, Replies: stream.replies()}, err
+}
+
+// ReplayCaptureFile replays the capture file, see ReplayCapture.
+func (s *Server) ReplayCaptureFile(ctx context.Context, connID string, path string) (ReplayResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ReplayResult{}, fmt.Errorf("open capture: %w", err)
+	}
+	defer f.Close()
+	return s.ReplayCapture(ctx, connID, f)
+}
+
+// replayStream is the in-memory stream of a replayed connection, it reads the capture and
+// records the replies.
+type replayStream struct {
+	r   io.Reader
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *replayStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *replayStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *replayStream) Close() error {
+	return nil
+}
+
+// replies parses the frames written to the stream.
+func (s *replayStream) replies() []frame.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := []frame.Frame{}
+	r := bytes.NewReader(s.buf.Bytes())
+	for {
+		f, err := ParseFrame(r)
+		if err != nil {
+			return frames
+		}
+		frames = append(frames, f)
+	}
+}
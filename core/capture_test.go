@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerReplayCapture(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	path := filepath.Join(t.TempDir(), "source.capture")
+	capture := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x34),
+		newDataFrame("tid-3", "source", 0x33),
+	)
+	assert.NoError(t, ioutil.WriteFile(path, capture, 0o600))
+
+	result, err := s.ReplayCaptureFile(context.Background(), "replay-conn", path)
+	assert.NoError(t, err)
+	assert.Equal(t, DisconnectClientClose, result.Reason.Cause)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	frames := sfn.Frames()
+	assert.Equal(t, "tid-1", frames[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "tid-3", frames[1].(*frame.DataFrame).TransactionID())
+	// the replayed connection is deregistered
+	_, ok := s.connector.App("replay-conn")
+	assert.False(t, ok)
+
+	// the same capture is routed the same way again
+	_, err = s.ReplayCaptureFile(context.Background(), "replay-conn", path)
+	assert.NoError(t, err)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 4 }))
+
+	_, err = s.ReplayCaptureFile(context.Background(), "replay-conn", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestServerReplayCaptureReplies(t *testing.T) {
+	s := newTestServer("sfn-1")
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	handshake.AckWindow = 8
+	capture := encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))
+
+	result, err := s.ReplayCapture(context.Background(), "replay-conn", bytes.NewReader(capture))
+	assert.NoError(t, err)
+	assert.Equal(t, DisconnectClientClose, result.Reason.Cause)
+	if assert.Len(t, result.Replies, 1) {
+		assert.Equal(t, frame.TagOfAckFrame, result.Replies[0].Type())
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// errEmptyCertificate is returned by ReloadCertificate when the certificate has no chain or key.
+var errEmptyCertificate = errors.New("core: the certificate has no chain or private key")
+
+// errNoCertificate is returned by the handshakes when the server has no certificate.
+var errNoCertificate = errors.New("core: no certificate configured")
+
+// certificateHolder holds the certificate swapped by ReloadCertificate, which is read by
+// the handshakes concurrently.
+type certificateHolder struct {
+	v atomic.Value // *tls.Certificate
+}
+
+func (h *certificateHolder) load() *tls.Certificate {
+	cert, _ := h.v.Load().(*tls.Certificate)
+	return cert
+}
+
+func (h *certificateHolder) store(cert *tls.Certificate) {
+	h.v.Store(cert)
+}
+
+// getCertificate returns the GetCertificate of the tls config, the reloaded certificate takes
+// precedence over the certificates of the config. The Certificates of the config are moved into
+// it, since the tls package doesn't call the GetCertificate without SNI if they're set.
+func (h *certificateHolder) getCertificate(tc *tls.Config) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	next := tc.GetCertificate
+	certs := tc.Certificates
+	tc.Certificates = nil
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert := h.load(); cert != nil {
+			return cert, nil
+		}
+		if next != nil {
+			if cert, err := next(hello); cert != nil || err != nil {
+				return cert, err
+			}
+		}
+		if len(certs) == 0 {
+			return nil, errNoCertificate
+		}
+		// the hellos of quic-go are converted from qtls, SupportsCertificate can't be
+		// called on them, so the certificates are matched by the server name only.
+		if hello.ServerName != "" {
+			for i := range certs {
+				if matchServerName(&certs[i], hello.ServerName) {
+					return &certs[i], nil
+				}
+			}
+		}
+		return &certs[0], nil
+	}
+}
+
+// matchServerName reports whether the leaf of the certificate is valid for the server name.
+func matchServerName(cert *tls.Certificate, name string) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return false
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false
+		}
+	}
+	return leaf.VerifyHostname(name) == nil
+}
+
+// ReloadCertificate replaces the certificate of the server, e.g. renewed by cert-manager,
+// the new handshakes use it while the established connections keep running.
+func (s *Server) ReloadCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) == 0 || cert.PrivateKey == nil {
+		return errEmptyCertificate
+	}
+	s.certificate.store(&cert)
+	logger.Printf("%s[%s] the certificate is reloaded", ServerLogPrefix, s.name)
+	return nil
+}
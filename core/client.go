@@ -7,10 +7,12 @@ import (
 	"net"
 
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/clock"
 	"github.com/yomorun/yomo/core/frame"
 	"github.com/yomorun/yomo/core/log"
 	"github.com/yomorun/yomo/pkg/logger"
@@ -36,6 +38,17 @@ type Client struct {
 	opts       ClientOptions
 	localAddr  string // client local addr, it will be changed on reconnect
 	logger     log.Logger
+	acks       *ackWindow  // the DataFrames waiting for the ack, nil if it's disabled
+	delivery   sync.Mutex  // orders the tracked DataFrames, the redelivered ones go first
+	control    quic.Stream // the control stream, nil if it isn't negotiated
+	controlMu  sync.Mutex  // guards the control stream and the writes to it
+	writing    int64       // the frames being written to the stream, accessed atomically
+	id         string      // identifies the client across its connections, see HandshakeFrame.ClientID
+	// the heartbeat interval confirmed by the server last, 0 if it isn't, accessed atomically
+	heartbeatInterval int64
+	heartbeating      int32 // 1 if the connection is pinged by the heartbeat, accessed atomically
+	ctx               context.Context
+	cancel            context.CancelFunc // stops the reconnecting and the retransmitting, called by Close
 }
 
 // NewClient creates a new YoMo-Client.
@@ -45,7 +58,9 @@ func NewClient(appName string, connType ClientType, opts ...ClientOption) *Clien
 		clientType: connType,
 		state:      ConnStateReady,
 		opts:       ClientOptions{},
+		id:         NewUUID(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 	c.Init(opts...)
 	once.Do(func() {
 		c.init()
@@ -62,14 +77,18 @@ func (c *Client) Init(opts ...ClientOption) error {
 	return c.initOptions()
 }
 
-// Connect connects to YoMo-Zipper.
+// Connect connects to YoMo-Zipper, the ctx bounds the dial and the handshake only, the
+// client keeps reconnecting and retransmitting in the background until it's closed.
 func (c *Client) Connect(ctx context.Context, addr string) error {
 
 	// TODO: refactor this later as a Connection Manager
 	// reconnect
 	// for download zipper
 	// If you do not check for errors, the connection will be automatically reconnected
-	go c.reconnect(ctx, addr)
+	go c.reconnect(c.ctx, addr)
+	if c.acks != nil {
+		go c.retransmit(c.ctx)
+	}
 
 	// connect
 	if err := c.connect(ctx, addr); err != nil {
@@ -81,42 +100,72 @@ func (c *Client) Connect(ctx context.Context, addr string) error {
 
 func (c *Client) connect(ctx context.Context, addr string) error {
 	c.addr = addr
-	c.state = ConnStateConnecting
+	if c.opts.Versions != nil && len(c.opts.Versions) == 0 {
+		return ErrNoQuicVersions
+	}
+	c.setState(ConnStateConnecting)
+	atomic.StoreInt32(&c.heartbeating, 0)
+	// it's dialed without the QUIC keep-alive once the server confirmed the heartbeat
+	keepAlive := atomic.LoadInt64(&c.heartbeatInterval) == 0
 
 	// create quic connection
-	conn, err := quic.DialAddrContext(ctx, addr, c.opts.TLSConfig, c.opts.QuicConfig)
+	conn, err := c.dial(ctx, addr)
 	if err != nil {
-		c.state = ConnStateDisconnected
+		c.setState(ConnStateDisconnected)
 		return err
 	}
 
 	// quic stream
 	stream, err := conn.OpenStreamSync(ctx)
 	if err != nil {
-		c.state = ConnStateDisconnected
+		conn.CloseWithError(0, err.Error())
+		c.setState(ConnStateDisconnected)
 		return err
 	}
+	if isCompressed(conn) {
+		stream = compressStream(stream)
+	}
+	// the handshake is written within the deadline of the ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+		defer stream.SetWriteDeadline(time.Time{})
+	}
 
 	c.stream = stream
 	c.conn = conn
+	c.closeControl()
 
-	c.state = ConnStateAuthenticating
+	c.setState(ConnStateAuthenticating)
 	// send handshake
+	tags, weight := c.subscription()
 	handshake := frame.NewHandshakeFrame(
 		c.name,
 		byte(c.clientType),
-		c.opts.ObserveDataTags,
+		tags,
 		c.opts.Credential.AppID(),
 		byte(c.opts.Credential.Type()),
 		c.opts.Credential.Payload(),
 	)
+	handshake.MaxPayloadSize = c.opts.MaxPayloadSize
+	handshake.Weight = weight
+	handshake.AckWindow = uint32(c.opts.AckWindow)
+	handshake.Heartbeat = uint32(c.opts.Heartbeat / time.Millisecond)
+	handshake.ControlStream = c.opts.ControlStream
+	handshake.ClientID = c.id
 	err = c.WriteFrame(handshake)
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
-		c.state = ConnStateRejected
+		conn.CloseWithError(0, err.Error())
+		c.setState(ConnStateRejected)
 		return err
 	}
-	c.state = ConnStateConnected
+	c.setState(ConnStateConnected)
 	c.localAddr = c.conn.LocalAddr().String()
+	if !keepAlive && atomic.CompareAndSwapInt32(&c.heartbeating, 0, 1) {
+		go c.heartbeat(conn)
+	}
 
 	c.logger.Printf("%s❤️  [%s](%s) is connected to YoMo-Zipper %s", ClientLogPrefix, c.name, c.localAddr, addr)
 
@@ -131,7 +180,7 @@ func (c *Client) handleFrame() {
 	// transform raw QUIC stream to wire format
 	fs := NewFrameStream(c.stream)
 	for {
-		c.logger.Debugf("%shandleFrame connection state=%v", ClientLogPrefix, c.state)
+		c.logger.Debugf("%shandleFrame connection state=%v", ClientLogPrefix, c.getState())
 		// this will block until a frame is received
 		f, err := fs.ReadFrame()
 		if err != nil {
@@ -168,40 +217,83 @@ func (c *Client) handleFrame() {
 			c.setState(ConnStateDisconnected)
 			break
 		}
+		c.handle(f)
+	}
+}
 
-		// read frame
-		// first, get frame type
-		frameType := f.Type()
-		c.logger.Debugf("%stype=%s, frame=%# x", ClientLogPrefix, frameType, frame.Shortly(f.Encode()))
-		switch frameType {
-		case frame.TagOfPongFrame:
-			c.setState(ConnStatePong)
-		case frame.TagOfAcceptedFrame:
-			c.setState(ConnStateAccepted)
-		case frame.TagOfRejectedFrame:
-			c.setState(ConnStateRejected)
-			c.Close()
-		case frame.TagOfDataFrame: // DataFrame carries user's data
-			if v, ok := f.(*frame.DataFrame); ok {
-				c.setState(ConnStateTransportData)
-				c.logger.Debugf("%sreceive DataFrame, tag=%# x, tid=%s, carry=%# x", ClientLogPrefix, v.GetDataTag(), v.TransactionID(), v.GetCarriage())
-				if c.processor == nil {
-					c.logger.Warnf("%sprocessor is nil", ClientLogPrefix)
-				} else {
-					// TODO: should c.processor accept a DataFrame as parameter?
-					// c.processor(v.GetDataTagID(), v.GetCarriage(), v.GetMetaFrame())
-					c.processor(v)
-				}
+// handle the frame received from the stream or the control stream.
+func (c *Client) handle(f frame.Frame) {
+	// read frame
+	// first, get frame type
+	frameType := f.Type()
+	c.logger.Debugf("%stype=%s, frame=%# x", ClientLogPrefix, frameType, frame.Shortly(f.Encode()))
+	switch frameType {
+	case frame.TagOfPingFrame:
+		// the server probes the liveness of the client
+		if err := c.writeControl(frame.NewPongFrame()); err != nil {
+			c.logger.Errorf("%swrite PongFrame error: %v", ClientLogPrefix, err)
+		}
+	case frame.TagOfPongFrame:
+		c.setState(ConnStatePong)
+	case frame.TagOfAcceptedFrame:
+		c.setState(ConnStateAccepted)
+		if v, ok := f.(*frame.AcceptedFrame); ok && c.opts.Heartbeat > 0 {
+			c.acceptHeartbeat(c.conn, time.Duration(v.Heartbeat)*time.Millisecond)
+		}
+		if v, ok := f.(*frame.AcceptedFrame); ok && v.ControlStream && c.opts.ControlStream {
+			go c.openControlStream(c.conn)
+		}
+	case frame.TagOfAckFrame:
+		if v, ok := f.(*frame.AckFrame); ok && c.acks != nil {
+			c.acks.ack(v.TransactionIDs())
+		}
+	case frame.TagOfRejectedFrame:
+		if v, ok := f.(*frame.RejectedFrame); ok && v.Message() == frame.RejectedMessagePaused {
+			// the zipper is paused for the maintenance, the connection is kept
+			c.logger.Warnf("%s[%s] DataFrame is rejected, YoMo-Zipper %s is paused", ClientLogPrefix, c.name, c.addr)
+			break
+		}
+		if v, ok := f.(*frame.RejectedFrame); ok && v.Message() == frame.RejectedMessageTooManyTransactions {
+			c.logger.Warnf("%s[%s] DataFrame is rejected, too many open transactions on YoMo-Zipper %s", ClientLogPrefix, c.name, c.addr)
+			break
+		}
+		if v, ok := f.(*frame.RejectedFrame); ok && v.Message() == frame.RejectedMessageStageFull {
+			c.logger.Warnf("%s[%s] DataFrame is rejected, the stage is full on YoMo-Zipper %s", ClientLogPrefix, c.name, c.addr)
+			break
+		}
+		if v, ok := f.(*frame.RejectedFrame); ok && v.Message() == frame.RejectedMessageInvalidTransactionID {
+			c.logger.Warnf("%s[%s] DataFrame is rejected, invalid transaction id on YoMo-Zipper %s", ClientLogPrefix, c.name, c.addr)
+			break
+		}
+		if v, ok := f.(*frame.RejectedFrame); ok {
+			c.logger.Errorf("%s[%s] is rejected by YoMo-Zipper %s: %s", ClientLogPrefix, c.name, c.addr, v.Message())
+		}
+		c.setState(ConnStateRejected)
+		c.Close()
+	case frame.TagOfDataFrame: // DataFrame carries user's data
+		if v, ok := f.(*frame.DataFrame); ok {
+			c.setState(ConnStateTransportData)
+			c.logger.Debugf("%sreceive DataFrame, tag=%# x, tid=%s, carry=%# x", ClientLogPrefix, v.GetDataTag(), v.TransactionID(), v.GetCarriage())
+			if c.processor == nil {
+				c.logger.Warnf("%sprocessor is nil", ClientLogPrefix)
+			} else {
+				// TODO: should c.processor accept a DataFrame as parameter?
+				// c.processor(v.GetDataTagID(), v.GetCarriage(), v.GetMetaFrame())
+				c.processor(v)
 			}
-		default:
-			c.logger.Errorf("%sunknown signal", ClientLogPrefix)
 		}
+	default:
+		c.logger.Errorf("%sunknown signal", ClientLogPrefix)
 	}
 }
 
-// Close the client.
+// Close the client, it stops reconnecting.
 func (c *Client) Close() (err error) {
-	c.logger.Printf("%sclose the connection, name:%s, addr:%s", ClientLogPrefix, c.name, c.conn.RemoteAddr().String())
+	c.cancel()
+	if c.acks != nil {
+		c.acks.close()
+	}
+	c.closeControl()
 	if c.stream != nil {
 		err = c.stream.Close()
 		if err != nil {
@@ -209,6 +301,7 @@ func (c *Client) Close() (err error) {
 		}
 	}
 	if c.conn != nil {
+		c.logger.Printf("%sclose the connection, name:%s, addr:%s", ClientLogPrefix, c.name, c.conn.RemoteAddr().String())
 		err = c.conn.CloseWithError(0, "client-ask-to-close-this-connection")
 		if err != nil {
 			c.logger.Errorf("%s connection.Close(): %v", ClientLogPrefix, err)
@@ -223,22 +316,44 @@ func (c *Client) Close() (err error) {
 // 	logger.EnableDebug()
 // }
 
-// WriteFrame writes a frame to the connection, gurantee threadsafe.
+// WriteFrame writes a frame to the connection, gurantee threadsafe. The DataFrame is tracked
+// until it's acknowledged if the ack window is enabled, it blocks while the window is full.
 func (c *Client) WriteFrame(frm frame.Frame) error {
+	if f, ok := frm.(*frame.DataFrame); ok && c.acks != nil {
+		if err := c.acks.track(f, c.opts.Clock.Now()); err != nil {
+			return err
+		}
+		c.delivery.Lock()
+		defer c.delivery.Unlock()
+	}
+	return c.writeFrame(frm)
+}
+
+// writeFrame writes a frame to the connection without tracking it.
+func (c *Client) writeFrame(frm frame.Frame) error {
 	// write on QUIC stream
 	if c.stream == nil {
 		return errors.New("stream is nil")
 	}
-	if c.state == ConnStateDisconnected || c.state == ConnStateRejected {
-		return fmt.Errorf("client connection state is %s", c.state)
+	// the state is set by the goroutines handling the frames of the server
+	state := c.getState()
+	if state == ConnStateDisconnected || state == ConnStateRejected {
+		return fmt.Errorf("client connection state is %s", state)
+	}
+	c.logger.Debugf("%s[%s](%s)@%s WriteFrame() will write frame: %s", ClientLogPrefix, c.name, c.localAddr, state, frm.Type())
+	// the issuer of DataFrame is always the client itself, except the upstream zipper which
+	// forwards the frames issued by others.
+	if f, ok := frm.(*frame.DataFrame); ok && c.clientType != ClientTypeUpstreamZipper {
+		f.SetIssuer(c.name)
 	}
-	c.logger.Debugf("%s[%s](%s)@%s WriteFrame() will write frame: %s", ClientLogPrefix, c.name, c.localAddr, c.state, frm.Type())
 
 	data := frm.Encode()
 	// emit raw bytes of Frame
+	atomic.AddInt64(&c.writing, 1)
 	c.mu.Lock()
 	n, err := c.stream.Write(data)
 	c.mu.Unlock()
+	atomic.AddInt64(&c.writing, -1)
 	c.logger.Debugf("%sWriteFrame() wrote n=%d, data=%# x", ClientLogPrefix, n, frame.Shortly(data))
 	if err != nil {
 		c.setState(ConnStateDisconnected)
@@ -288,17 +403,173 @@ func (c *Client) SetDataFrameObserver(fn func(*frame.DataFrame)) {
 
 // reconnect the connection between client and server.
 func (c *Client) reconnect(ctx context.Context, addr string) {
-	t := time.NewTicker(1 * time.Second)
+	c.reconnectWith(ctx, addr, func() error {
+		if err := c.connect(ctx, addr); err != nil {
+			return err
+		}
+		c.redeliver()
+		return nil
+	})
+}
+
+// redeliver writes all the DataFrames unacknowledged by the server again in the order they're
+// written, ahead of the new ones, e.g. the frames lost with the broken connection. The frames
+// received before are acknowledged again but not routed twice by the server if the client
+// reconnects within a minute, the server forgets them once it restarts.
+func (c *Client) redeliver() {
+	if c.acks == nil {
+		return
+	}
+	c.delivery.Lock()
+	defer c.delivery.Unlock()
+	frames := c.acks.unacked(c.opts.Clock.Now())
+	if len(frames) > 0 {
+		c.logger.Printf("%s[%s] redeliver %d unacknowledged DataFrames", ClientLogPrefix, c.name, len(frames))
+	}
+	for _, f := range frames {
+		if err := c.writeFrame(f); err != nil {
+			// retransmitted once they're due
+			return
+		}
+	}
+}
+
+// reconnectWith checks the connection every second, redials with the backoff once it's
+// disconnected.
+func (c *Client) reconnectWith(ctx context.Context, addr string, dial func() error) {
+	t := c.opts.Clock.NewTicker(1 * time.Second)
 	defer t.Stop()
-	for range t.C {
-		if c.getState() == ConnStateDisconnected {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+		for c.getState() == ConnStateDisconnected {
+			delay, ok := c.opts.Backoff.Next()
+			if !ok {
+				c.logger.Errorf("%s[%s](%s) stop reconnecting to YoMo-Zipper %s, retries exhausted", ClientLogPrefix, c.name, c.localAddr, addr)
+				c.setState(ConnStateAborted)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.opts.Clock.After(delay):
+			}
 			c.logger.Printf("%s[%s](%s) is reconnecting to YoMo-Zipper %s...\n", ClientLogPrefix, c.name, c.localAddr, addr)
-			err := c.connect(ctx, addr)
-			if err != nil {
+			if err := dial(); err != nil {
 				c.logger.Errorf("%s[%s](%s) reconnect error:%v", ClientLogPrefix, c.name, c.localAddr, err)
+				continue
+			}
+			c.opts.Backoff.Reset()
+		}
+	}
+}
+
+// retransmit writes the DataFrames unacknowledged for the ack timeout again until the ctx is done.
+func (c *Client) retransmit(ctx context.Context) {
+	t := c.opts.Clock.NewTicker(c.opts.AckTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+		c.delivery.Lock()
+		for _, f := range c.acks.due(c.opts.Clock.Now()) {
+			c.logger.Debugf("%s[%s] retransmit DataFrame, tid=%s", ClientLogPrefix, c.name, f.TransactionID())
+			if err := c.writeFrame(f); err != nil {
+				// retry on the next tick, e.g. after reconnecting
+				break
 			}
 		}
+		c.delivery.Unlock()
+	}
+}
+
+// UnflushedError is returned by Flush when the ctx is done before the frames are flushed.
+type UnflushedError struct {
+	// Remaining is the number of the frames not flushed yet.
+	Remaining int
+	// Err is the error of the ctx.
+	Err error
+}
+
+func (e *UnflushedError) Error() string {
+	return fmt.Sprintf("%d frames are not flushed: %v", e.Remaining, e.Err)
+}
+
+// Unwrap returns the error of the ctx.
+func (e *UnflushedError) Unwrap() error {
+	return e.Err
+}
+
+// Flush blocks until the frames written by now are handed to the stream, and acknowledged by
+// the server if the ack window is enabled. Without the ack window the frames handed to the
+// stream may still be lost once the connection is closed, enable it for the guarantee. It
+// returns an *UnflushedError with the number of the remaining frames once the ctx is done.
+func (c *Client) Flush(ctx context.Context) error {
+	// the writes are serialized by mu, the frames being written are done once it's acquired
+	written := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		c.mu.Unlock()
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-ctx.Done():
+		return &UnflushedError{Remaining: c.unflushed(), Err: ctx.Err()}
 	}
+	if c.acks == nil {
+		return nil
+	}
+	select {
+	case <-c.acks.drained():
+		return nil
+	case <-ctx.Done():
+		return &UnflushedError{Remaining: c.unflushed(), Err: ctx.Err()}
+	}
+}
+
+// unflushed returns the number of the frames not flushed, see Flush.
+func (c *Client) unflushed() int {
+	if c.acks != nil {
+		return c.acks.Len()
+	}
+	return int(atomic.LoadInt64(&c.writing))
+}
+
+// AckWindow returns the max number of the DataFrames waiting for the ack of the server, 0 if
+// the ack window is disabled.
+func (c *Client) AckWindow() int {
+	if c.acks == nil {
+		return 0
+	}
+	return c.acks.Size()
+}
+
+// AckPending returns the number of the DataFrames waiting for the ack of the server.
+func (c *Client) AckPending() int {
+	if c.acks == nil {
+		return 0
+	}
+	return c.acks.Len()
+}
+
+// AckRetries returns how many times the DataFrames are retransmitted.
+func (c *Client) AckRetries() int64 {
+	if c.acks == nil {
+		return 0
+	}
+	return c.acks.Retries()
+}
+
+// ReconnectState returns the state of the reconnect attempts.
+func (c *Client) ReconnectState() BackoffState {
+	return c.opts.Backoff.State()
 }
 
 func (c *Client) init() {
@@ -324,6 +595,25 @@ func (c *Client) initOptions() error {
 			c.logger = logger.Default()
 		}
 	}
+	// clock
+	if c.opts.Clock == nil {
+		c.opts.Clock = clock.New()
+	}
+	// reconnect
+	if c.opts.Backoff == nil {
+		c.opts.Backoff = NewBackoff(time.Second, 30*time.Second, 0)
+	}
+	// ack window
+	if c.opts.AckWindow > 0 && c.acks == nil {
+		if c.opts.AckTimeout <= 0 {
+			c.opts.AckTimeout = DefaultAckTimeout
+		}
+		c.acks = newAckWindow(c.opts.AckWindow, c.opts.AckTimeout)
+	}
+	// transaction id
+	if c.opts.TransactionIDGenerator == nil {
+		c.opts.TransactionIDGenerator = NewUUID
+	}
 	// observe tag list
 	if c.opts.ObserveDataTags == nil {
 		c.opts.ObserveDataTags = make([]byte, 0)
@@ -341,21 +631,22 @@ func (c *Client) initOptions() error {
 		}
 		c.opts.TLSConfig = tc
 	}
+	if c.opts.Compression {
+		c.opts.TLSConfig = withCompressionALPN(c.opts.TLSConfig)
+	}
 	// quic config
 	if c.opts.QuicConfig == nil {
-		c.opts.QuicConfig = &quic.Config{
-			Versions:                       []quic.VersionNumber{quic.Version1, quic.VersionDraft29},
-			MaxIdleTimeout:                 time.Second * 40,
-			KeepAlive:                      true,
-			MaxIncomingStreams:             1000,
-			MaxIncomingUniStreams:          1000,
-			HandshakeIdleTimeout:           time.Second * 3,
-			InitialStreamReceiveWindow:     1024 * 1024 * 2,
-			InitialConnectionReceiveWindow: 1024 * 1024 * 2,
-			TokenStore:                     quic.NewLRUTokenStore(10, 5),
-			DisablePathMTUDiscovery:        true,
-		}
+		c.opts.QuicConfig = defaultClientQuicConfig()
+	}
+	if c.opts.Profile != nil {
+		c.opts.QuicConfig = c.opts.Profile.Apply(c.opts.QuicConfig)
+	}
+	qc, err := applyVersions(c.opts.QuicConfig, c.opts.Versions)
+	if err != nil {
+		c.logger.Errorf("%squic config: %v", ClientLogPrefix, err)
+		return err
 	}
+	c.opts.QuicConfig = qc
 	// credential
 	if c.opts.Credential != nil {
 		c.logger.Printf("%suse credential: [%s]", ClientLogPrefix, c.opts.Credential.Type())
@@ -367,7 +658,38 @@ func (c *Client) initOptions() error {
 // SetObserveDataTags set the data tag list that will be observed.
 // Deprecated: use yomo.WithObserveDataTags instead
 func (c *Client) SetObserveDataTags(tag ...byte) {
+	c.mu.Lock()
 	c.opts.ObserveDataTags = append(c.opts.ObserveDataTags, tag...)
+	c.mu.Unlock()
+}
+
+// UpdateSubscription replaces the observed data tags and the weight of the stream function
+// without reconnecting, the zipper routes the next frames by them, and they're declared by
+// the handshake when it reconnects.
+func (c *Client) UpdateSubscription(tags []byte, weight uint32) error {
+	c.mu.Lock()
+	c.opts.ObserveDataTags = append(make([]byte, 0, len(tags)), tags...)
+	c.opts.Weight = weight
+	c.mu.Unlock()
+	return c.writeControl(frame.NewSubscriptionFrame(tags, weight))
+}
+
+// subscription returns the observed data tags and the weight, the handshake reads them under
+// the lock since UpdateSubscription replaces them concurrently.
+func (c *Client) subscription() ([]byte, uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.opts.ObserveDataTags, c.opts.Weight
+}
+
+// Clock returns the clock of the client.
+func (c *Client) Clock() clock.Clock {
+	return c.opts.Clock
+}
+
+// NewTransactionID generates a transaction id for the DataFrames written by the client.
+func (c *Client) NewTransactionID() string {
+	return c.opts.TransactionIDGenerator()
 }
 
 // Logger get client's logger instance, you can customize this using `yomo.WithLogger`
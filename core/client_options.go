@@ -2,18 +2,55 @@ package core
 
 import (
 	"crypto/tls"
+	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/clock"
 	"github.com/yomorun/yomo/core/log"
 )
 
 type ClientOptions struct {
 	ObserveDataTags []byte
-	QuicConfig      *quic.Config
-	TLSConfig       *tls.Config
-	Credential      auth.Credential
-	Logger          log.Logger
+	// MaxPayloadSize is the max carriage length the stream function can handle, 0 means unlimited.
+	MaxPayloadSize uint32
+	// Weight is the share of the frames the stream function receives among the instances
+	// of the same name, 0 means DefaultWeight.
+	Weight     uint32
+	QuicConfig *quic.Config
+	// Profile overrides the stream limits and receive windows of the QuicConfig.
+	Profile *Profile
+	// Versions overrides the QUIC versions of the QuicConfig, nil keeps them.
+	Versions   []quic.VersionNumber
+	TLSConfig  *tls.Config
+	Credential auth.Credential
+	Logger     log.Logger
+	// TransactionIDGenerator generates the transaction id of the DataFrames written by the client.
+	TransactionIDGenerator TransactionIDGenerator
+	// Backoff is the reconnect policy, default is from 1s to 30s with infinite retries.
+	Backoff *Backoff
+	// Clock is the source of time of the timing-dependent features, default is the real clock.
+	Clock clock.Clock
+	// AckWindow is the max number of the DataFrames unacknowledged by the server, the frames
+	// are retransmitted until they're acknowledged, 0 means disabled.
+	AckWindow int
+	// AckTimeout is how long a DataFrame waits for the ack before it's retransmitted.
+	AckTimeout time.Duration
+	// Dialer dials the connection to the zipper, default dials by quic.DialAddrContext.
+	Dialer Dialer
+	// LocalAddr is the local UDP address the client binds to, empty means any.
+	LocalAddr string
+	// PacketConn is the pre-dialed packet conn the client dials over, nil means a new UDP socket.
+	PacketConn net.PacketConn
+	// Heartbeat is the interval the client pings the server instead of the QUIC keep-alive,
+	// 0 means disabled.
+	Heartbeat time.Duration
+	// ControlStream opens a dedicated stream for the control frames if the server supports it.
+	ControlStream bool
+	// Compression offers ALPNDeflate by the TLS handshake, the streams are compressed if the
+	// server enables it too.
+	Compression bool
 }
 
 // WithObserveDataTags sets data tag list for the client.
@@ -23,6 +60,39 @@ func WithObserveDataTags(tags ...byte) ClientOption {
 	}
 }
 
+// WithMaxPayloadSize sets the max carriage length the client can handle, the zipper
+// will not send larger DataFrames to it.
+func WithMaxPayloadSize(size uint32) ClientOption {
+	return func(o *ClientOptions) {
+		o.MaxPayloadSize = size
+	}
+}
+
+// WithWeight sets the weight of the client, the zipper sends the frames to the instances
+// of the same stream function in proportion to their weights, e.g. the instance of weight
+// 3 receives 3 times as many frames as the instance of weight 1. It's capped by MaxWeight.
+func WithWeight(weight uint32) ClientOption {
+	return func(o *ClientOptions) {
+		o.Weight = weight
+	}
+}
+
+// WithReconnectBackoff sets the reconnect policy, the delay grows exponentially from
+// initial to max with jitter, maxRetries 0 means retrying infinitely.
+func WithReconnectBackoff(initial time.Duration, max time.Duration, maxRetries int) ClientOption {
+	return func(o *ClientOptions) {
+		o.Backoff = NewBackoff(initial, max, maxRetries)
+	}
+}
+
+// WithClientProfile sets the deployment profile, e.g. ProfileEdge, which overrides the
+// stream limits and receive windows of the quic config together.
+func WithClientProfile(p Profile) ClientOption {
+	return func(o *ClientOptions) {
+		o.Profile = &p
+	}
+}
+
 // WithCredential sets app auth for the client.
 func WithCredential(cred auth.Credential) ClientOption {
 	return func(o *ClientOptions) {
@@ -30,6 +100,50 @@ func WithCredential(cred auth.Credential) ClientOption {
 	}
 }
 
+// WithAckWindow makes the server acknowledge the DataFrames of the client, the frames not
+// acknowledged in the timeout are retransmitted, e.g. the ones lost with a broken connection,
+// and writing blocks while size frames are unacknowledged. The unacknowledged frames are
+// delivered again in order ahead of the new ones once reconnected. The delivery is at least
+// once, the server drops the retransmitted frames it has received from the client, also on
+// its previous connection if it reconnects within a minute, but not the frames received
+// before the server restarts.
+func WithAckWindow(size int, timeout time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.AckWindow = size
+		o.AckTimeout = timeout
+	}
+}
+
+// WithHeartbeat pings the server every interval instead of the QUIC keep-alive, e.g. for the
+// battery-powered devices. The interval is negotiated at the handshake, the client pings by
+// the shorter one of it and the one granted by the server. The QUIC keep-alive is kept until
+// the server confirms the interval, so it's only disabled for the connections dialed after
+// the confirmation, and it's kept if the server doesn't support it.
+func WithHeartbeat(interval time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.Heartbeat = interval
+	}
+}
+
+// WithControlStream opens a dedicated stream for the control frames, i.e. the pings, the pongs,
+// the acks and the subscription updates, so they aren't blocked behind the backlog of the
+// DataFrames. It's negotiated at the handshake, the client keeps the single stream if the
+// server doesn't support it.
+func WithControlStream() ClientOption {
+	return func(o *ClientOptions) {
+		o.ControlStream = true
+	}
+}
+
+// WithClientCompression compresses all the frames of the connection transparently if the
+// server supports it, it's negotiated by the ALPN of the TLS handshake, the connection is
+// uncompressed otherwise.
+func WithClientCompression() ClientOption {
+	return func(o *ClientOptions) {
+		o.Compression = true
+	}
+}
+
 // WithClientTLSConfig sets tls config for the client.
 func WithClientTLSConfig(tc *tls.Config) ClientOption {
 	return func(o *ClientOptions) {
@@ -37,6 +151,15 @@ func WithClientTLSConfig(tc *tls.Config) ClientOption {
 	}
 }
 
+// WithClientVersions sets the QUIC versions the client offers, it must share one with the
+// zipper, see WithServerVersions. The list must not be empty, the client fails to connect
+// with ErrNoQuicVersions otherwise.
+func WithClientVersions(versions ...quic.VersionNumber) ClientOption {
+	return func(o *ClientOptions) {
+		o.Versions = append([]quic.VersionNumber{}, versions...)
+	}
+}
+
 // WithClientQuicConfig sets quic config for the client.
 func WithClientQuicConfig(qc *quic.Config) ClientOption {
 	return func(o *ClientOptions) {
@@ -50,3 +173,17 @@ func WithLogger(logger log.Logger) ClientOption {
 		o.Logger = logger
 	}
 }
+
+// WithTransactionIDGenerator sets the transaction id generator for the client.
+func WithTransactionIDGenerator(gen TransactionIDGenerator) ClientOption {
+	return func(o *ClientOptions) {
+		o.TransactionIDGenerator = gen
+	}
+}
+
+// WithClientClock sets the clock of the client, tests use it to control the time.
+func WithClientClock(c clock.Clock) ClientOption {
+	return func(o *ClientOptions) {
+		o.Clock = c
+	}
+}
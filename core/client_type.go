@@ -1,5 +1,7 @@
 package core
 
+import "github.com/yomorun/yomo/core/frame"
+
 const (
 	// ClientTypeNone is connection type "None".
 	ClientTypeNone ClientType = 0xFF
@@ -9,6 +11,9 @@ const (
 	ClientTypeUpstreamZipper ClientType = 0x5E
 	// ClientTypeStreamFunction is connection type "Stream Function".
 	ClientTypeStreamFunction ClientType = 0x5D
+	// ClientTypeObserver is connection type "Observer", e.g. an admin client, it receives the
+	// mirror of the DataFrames but it isn't a stage of the workflow.
+	ClientTypeObserver ClientType = 0x5C
 )
 
 // ClientType represents the connection type.
@@ -22,7 +27,36 @@ func (c ClientType) String() string {
 		return "Upstream Zipper"
 	case ClientTypeStreamFunction:
 		return "Stream Function"
+	case ClientTypeObserver:
+		return "Observer"
 	default:
 		return "None"
 	}
 }
+
+// allowedFrames are the frames each role is allowed to send to the server, a connection
+// is ClientTypeNone until its handshake succeeds:
+//   - None: HandshakeFrame and CapabilitiesRequestFrame, which queries the capabilities of
+//     the server before the handshake
+//   - Source and Upstream Zipper: DataFrame, PingFrame and CapabilitiesRequestFrame
+//   - Stream Function: DataFrame, PingFrame, PongFrame, which responds the ping of the server,
+//     SubscriptionFrame, which updates its data tags and weight, and CapabilitiesRequestFrame
+//   - Observer: PingFrame, SubscriptionFrame and CapabilitiesRequestFrame, it doesn't issue
+//     any DataFrame
+var allowedFrames = map[ClientType][]frame.Type{
+	ClientTypeNone:           {frame.TagOfHandshakeFrame, frame.TagOfCapabilitiesRequestFrame},
+	ClientTypeSource:         {frame.TagOfDataFrame, frame.TagOfPingFrame, frame.TagOfCapabilitiesRequestFrame},
+	ClientTypeStreamFunction: {frame.TagOfDataFrame, frame.TagOfPingFrame, frame.TagOfPongFrame, frame.TagOfSubscriptionFrame, frame.TagOfCapabilitiesRequestFrame},
+	ClientTypeUpstreamZipper: {frame.TagOfDataFrame, frame.TagOfPingFrame, frame.TagOfCapabilitiesRequestFrame},
+	ClientTypeObserver:       {frame.TagOfPingFrame, frame.TagOfSubscriptionFrame, frame.TagOfCapabilitiesRequestFrame},
+}
+
+// CanSend reports whether the role is allowed to send the frame type to the server.
+func (c ClientType) CanSend(t frame.Type) bool {
+	for _, allowed := range allowedFrames[c] {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package clock
+
+import "time"
+
+// Clock is the source of time, the timing-dependent features use it instead of the
+// time package directly, so they can be tested with a Fake clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a new Ticker which ticks with a period specified by the duration.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker holds a channel that delivers ticks of a clock at intervals.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+var _ Clock = (*Real)(nil)
+
+// Real is the Clock backed by the time package.
+type Real struct{}
+
+// New returns the real clock.
+func New() *Real {
+	return &Real{}
+}
+
+// Now returns time.Now().
+func (c *Real) Now() time.Time {
+	return time.Now()
+}
+
+// After returns time.After(d).
+func (c *Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NewTicker returns a ticker wraps time.NewTicker(d).
+func (c *Real) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t *realTicker) Stop() {
+	t.t.Stop()
+}
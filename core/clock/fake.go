@@ -0,0 +1,118 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+var _ Clock = (*Fake)(nil)
+
+// Fake is a Clock for testing, the time only moves when Advance is called.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	until   time.Time
+	period  time.Duration
+	c       chan time.Time
+	stopped bool
+}
+
+// NewFake returns a fake clock starts at the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the current fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel which receives the fake time once it is advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.add(d, 0).c
+}
+
+// NewTicker returns a ticker which ticks every time the fake time is advanced by d,
+// like time.Ticker, the ticks are dropped if the receiver is slow.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{f: f, w: f.add(d, d)}
+}
+
+// Advance moves the fake time forward, fires the timers and tickers which are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		for !w.stopped && !w.until.After(f.now) {
+			select {
+			case w.c <- f.now:
+			default:
+			}
+			if w.period == 0 {
+				w.stopped = true
+				break
+			}
+			w.until = w.until.Add(w.period)
+		}
+		if !w.stopped {
+			waiters = append(waiters, w)
+		}
+	}
+	f.waiters = waiters
+}
+
+// Waiters returns the number of the pending timers and tickers, tests use it to
+// know a goroutine is waiting on the clock before advancing it.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func (f *Fake) add(d time.Duration, period time.Duration) *waiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{until: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+func (f *Fake) stop(w *waiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.stopped = true
+	for i, v := range f.waiters {
+		if v == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.f.stop(t.w)
+}
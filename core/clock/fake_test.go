@@ -0,0 +1,39 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeAfter(t *testing.T) {
+	start := time.Unix(0, 0)
+	f := NewFake(start)
+	c := f.After(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+	assert.Len(t, c, 0)
+	f.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-c)
+	assert.Equal(t, 0, f.Waiters())
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	ticker := f.NewTicker(time.Second)
+
+	f.Advance(time.Second)
+	assert.Len(t, ticker.C(), 1)
+	<-ticker.C()
+
+	// the ticks are dropped when the receiver is slow
+	f.Advance(3 * time.Second)
+	assert.Len(t, ticker.C(), 1)
+	<-ticker.C()
+
+	ticker.Stop()
+	f.Advance(time.Second)
+	assert.Len(t, ticker.C(), 0)
+	assert.Equal(t, 0, f.Waiters())
+}
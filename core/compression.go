@@ -0,0 +1,83 @@
+package core
+
+import (
+	"compress/flate"
+	"crypto/tls"
+	"io"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	pkgtls "github.com/yomorun/yomo/pkg/tls"
+)
+
+const (
+	// ALPN is the protocol of YoMo negotiated by the TLS handshake, the configs of pkg/tls
+	// offer it.
+	ALPN = pkgtls.ALPN
+	// ALPNDeflate is the variant of the protocol whose streams are compressed by DEFLATE, it's
+	// negotiated if both of the client and the server enable the compression, see
+	// WithClientCompression and WithServerCompression.
+	ALPNDeflate = "yomo-deflate"
+)
+
+// withCompressionALPN returns the tls config which prefers ALPNDeflate to the other protocols.
+func withCompressionALPN(tc *tls.Config) *tls.Config {
+	for _, proto := range tc.NextProtos {
+		if proto == ALPNDeflate {
+			return tc
+		}
+	}
+	tc = tc.Clone()
+	tc.NextProtos = append([]string{ALPNDeflate}, tc.NextProtos...)
+	return tc
+}
+
+// isCompressed reports whether the streams of the connection are compressed.
+func isCompressed(conn quic.Connection) bool {
+	return conn.ConnectionState().TLS.NegotiatedProtocol == ALPNDeflate
+}
+
+// compressedStream compresses the data written to the stream and decompresses the data read
+// from it. Every Write is flushed, so a frame is readable by the peer once it's written.
+type compressedStream struct {
+	quic.Stream
+	r  io.ReadCloser
+	mu sync.Mutex
+	w  *flate.Writer
+}
+
+// compressStream wraps the stream of the compressed connection.
+func compressStream(stream quic.Stream) quic.Stream {
+	// the error is returned only for the invalid level
+	w, _ := flate.NewWriter(stream, flate.DefaultCompression)
+	return &compressedStream{
+		Stream: stream,
+		r:      flate.NewReader(stream),
+		w:      w,
+	}
+}
+
+func (s *compressedStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *compressedStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, s.w.Flush()
+}
+
+// Close writes the end of the compressed data and closes the write direction of the stream.
+func (s *compressedStream) Close() error {
+	s.mu.Lock()
+	err := s.w.Close()
+	s.mu.Unlock()
+	if cerr := s.Stream.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -1,19 +1,64 @@
 package core
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/yomorun/yomo/core/clock"
 	"github.com/yomorun/yomo/core/frame"
 	"github.com/yomorun/yomo/pkg/logger"
 )
 
+const (
+	// DefaultWeight is the weight of the stream function instances which don't declare one.
+	DefaultWeight = 1
+	// MaxWeight is the max weight of a stream function instance, the larger ones are capped
+	// so the total weight of the instances doesn't overflow.
+	MaxWeight = 1 << 16
+)
+
 type app struct {
-	id       string // app id
-	name     string // app name
+	frames     int64        // frames written to the app, accessed atomically
+	id         string       // app id
+	name       string       // app name
+	clientType ClientType   // client type
+	sub        atomic.Value // *subscription
+	maxPayload uint32       // max carriage length, 0 means unlimited
+	draining   int32        // 1 if it's out of rotation, accessed atomically
+	lastData   int64        // unix nano of the DataFrame received from it last, accessed atomically
+}
+
+// subscription is what the app observes, it's replaced as a whole by UpdateSubscription.
+type subscription struct {
 	observed []byte // data tags
+	weight   uint32 // share of the frames among the instances, 0 means DefaultWeight
+}
+
+// newSubscription returns the subscription of the data tags, the weight is capped by MaxWeight.
+func newSubscription(observed []byte, weight uint32) *subscription {
+	if weight > MaxWeight {
+		weight = MaxWeight
+	}
+	return &subscription{observed: observed, weight: weight}
+}
+
+// observes reports whether the data tag is observed.
+func (s *subscription) observes(tag byte) bool {
+	for _, v := range s.observed {
+		if v == tag {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *app) ID() string {
@@ -24,22 +69,166 @@ func (a *app) Name() string {
 	return a.name
 }
 
+func (a *app) ClientType() ClientType {
+	return a.clientType
+}
+
+// Draining reports whether the app is taken out of rotation by Drain.
+func (a *app) Draining() bool {
+	return atomic.LoadInt32(&a.draining) == 1
+}
+
+// receivedData records a DataFrame is received from the app at now.
+func (a *app) receivedData(now time.Time) {
+	atomic.StoreInt64(&a.lastData, now.UnixNano())
+}
+
+// lastDataAt returns when the last DataFrame is received from the app, zero if never.
+func (a *app) lastDataAt() time.Time {
+	if n := atomic.LoadInt64(&a.lastData); n > 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+func (a *app) subscription() *subscription {
+	return a.sub.Load().(*subscription)
+}
+
+// Observed returns the data tags the app observes.
+func (a *app) Observed() []byte {
+	return a.subscription().observed
+}
+
+// Weight returns the weight of the app, DefaultWeight if it's undeclared.
+func (a *app) Weight() uint32 {
+	return a.subscription().Weight()
+}
+
+// Weight returns the weight of the subscription, DefaultWeight if it's undeclared.
+func (s *subscription) Weight() uint32 {
+	if s.weight == 0 {
+		return DefaultWeight
+	}
+	return s.weight
+}
+
+// BufferStat describes the frames buffered by the server for a connection, it tells whether
+// a stall is in the send queue or in writing to the stream, i.e. the QUIC send buffer is full
+// or the peer's receive window is exhausted.
+type BufferStat struct {
+	// Queued is the number of the frames in the send queue.
+	Queued int
+	// QueuedBytes is the size of the DataFrames in the send queue.
+	QueuedBytes int64
+	// PendingWrite is the bytes taken from the send queue and not written to the stream yet,
+	// including the write buffer.
+	PendingWrite int64
+}
+
+// InstanceStat describes a stream function instance and the DataFrames written to it.
+type InstanceStat struct {
+	ConnID string
+	Name   string
+	Weight uint32
+	Frames int64
+}
+
 var _ Connector = &connector{}
 
 // Connector is a interface to manage the connections and applications.
 type Connector interface {
 	// Add a connection.
 	Add(connID string, stream io.ReadWriteCloser)
-	// Remove a connection.
-	Remove(connID string)
+	// Remove a connection, it returns the app linked to the connection to the first Remove of
+	// it only, so the teardown of the connection runs once.
+	Remove(connID string) (*app, bool)
 	// Get a connection by connection id.
 	Get(connID string) io.ReadWriteCloser
-	// GetConnIDs gets the connection ids by appID, name and tag.
-	GetConnIDs(appID string, name string, tags byte) []string
+	// GetConnIDs gets the connection ids by appID, name and tag, the name can be a pattern
+	// matched by MatchName. The connections can't handle the carriage of size are skipped,
+	// and one of the matched connections is picked by their weights.
+	GetConnIDs(appID string, name string, tags byte, size int) []string
+	// GetConnIDsByKey is GetConnIDs but picks the connection by the key instead of randomly,
+	// so the frames of the same key go to the same connection while the connections don't
+	// change. The empty key is picked randomly.
+	GetConnIDsByKey(appID string, name string, tags byte, size int, key string) []string
+	// Candidates is GetConnIDsByKey but doesn't count the skipped frames, e.g. for the dry run.
+	Candidates(appID string, name string, tags byte, size int, key string) []string
+	// GetConnIDsByName is GetConnIDsByKey regardless of the data tags the connections observe,
+	// e.g. for the dead-letter stream function.
+	GetConnIDsByName(appID string, name string, size int, key string) []string
 	// Write a DataFrame to a connection.
 	Write(f *frame.DataFrame, toID string) error
+	// Drain takes a connection out of rotation, returns a channel closed once the frames
+	// queued to it are written, false if there's no such connection.
+	Drain(connID string) (<-chan struct{}, bool)
+	// Flushed returns a channel closed once the frames queued to a connection are written,
+	// it's closed at once if there's no such connection.
+	Flushed(connID string) <-chan struct{}
+	// WriteStream writes a DataFrame to a connection with its carriage of size bytes copied
+	// from the reader, it blocks until the frame is written.
+	WriteStream(f *frame.DataFrame, carriage io.Reader, size int, toID string) error
+	// WriteControl writes a control frame to a connection ahead of the queued DataFrames, it's
+	// written to the control stream of the connection if there's one.
+	WriteControl(f frame.Frame, toID string) error
+	// AddControl adds the control stream of a connection, the control frames are written to
+	// it instead of the stream of the DataFrames.
+	AddControl(connID string, stream io.ReadWriteCloser)
+	// RemoveControl removes the control stream of a connection, the control frames are written
+	// to the stream of the DataFrames again.
+	RemoveControl(connID string)
 	// GetSnapshot gets the snapshot of all connections.
 	GetSnapshot() map[string]io.ReadWriteCloser
+	// Live reports whether the stream of a connection is usable, see Server.LiveFunctions.
+	Live(connID string) bool
+	// SetWindow sets the max number of the DataFrames in flight to a connection, Write refuses
+	// the DataFrames while it's full. 0 means unlimited.
+	SetWindow(connID string, window int)
+	// WindowFull reports whether the windows of all the stream functions of the name observing
+	// the tag are full, false if there's none.
+	WindowFull(appID string, name string, tag byte) bool
+	// SetQueueSize sets the max number of the DataFrames queued to every connection added
+	// later, the oldest of the lowest priority are dropped beyond it. 0 means unlimited.
+	SetQueueSize(size int)
+	// OnBroken sets the handler invoked once writing to the stream of a connection fails, e.g.
+	// to disconnect it, the frames can't be written to it anymore.
+	OnBroken(handler func(connID string, err error))
+	// TrackTransactions references the DataFrames queued to every connection added later in
+	// the open transactions, nil means untracked.
+	TrackTransactions(transactions *openTransactions)
+	// InFlight gets the number of the DataFrames in flight to the stream functions per name.
+	InFlight() map[string]int
+	// Instances gets the weight and the number of the frames written to every stream
+	// function instance.
+	Instances() []InstanceStat
+	// QueueWaitStats gets how long the frames waited in the send queues per priority.
+	QueueWaitStats() map[frame.Priority]QueueWaitStat
+	// CapacitySkipped gets how many times the frames are not sent to a target because
+	// the carriage exceeds the max payload size of all its connections.
+	CapacitySkipped() int64
+	// Expired gets how many frames are dropped from the send queues for their expiry.
+	Expired() int64
+	// NoStream gets how many DataFrames are dropped since the target has no stream, e.g. it's
+	// removed while the frame is being routed, or a nil stream is added.
+	NoStream() int64
+	// Overflowed gets how many DataFrames are dropped from the full send queues.
+	Overflowed() int64
+	// ResetStats zeroes the cumulative counters, i.e. the skipped, expired, overflowed and
+	// undeliverable frames, the frames written to every instance and the queue wait stats.
+	ResetStats()
+	// Buffering gets the frames buffered for a connection, returns false if the connection
+	// doesn't exist.
+	Buffering(connID string) (BufferStat, bool)
+	// Depth gets the number of the DataFrames queued to a connection, it's read without
+	// locking the send queue, 0 if the connection doesn't exist.
+	Depth(connID string) int
+	// Pending gets the number of the DataFrames queued to a connection or being written to it,
+	// 0 if the connection doesn't exist.
+	Pending(connID string) int
+	// Broken reports whether writing to the stream of a connection failed, the frames can't
+	// be written to it anymore, see OnBroken.
+	Broken(connID string) bool
 
 	// App gets the app by connID.
 	App(connID string) (*app, bool)
@@ -48,45 +237,246 @@ type Connector interface {
 	// AppName gets the name of app by connID.
 	AppName(connID string) (string, bool)
 	// LinkApp links the app and connection.
-	LinkApp(connID string, appID string, name string, observed []byte)
+	LinkApp(connID string, appID string, name string, clientType ClientType, observed []byte, maxPayload uint32, weight uint32)
 	// UnlinkApp removes the app by connID.
 	UnlinkApp(connID string, appID string, name string)
+	// UpdateSubscription replaces the data tags and the weight of the app by connID, returns
+	// false if the app doesn't exist.
+	UpdateSubscription(connID string, observed []byte, weight uint32) bool
 
 	// Clean the connector.
 	Clean()
 }
 
 type connector struct {
-	conns sync.Map
-	apps  sync.Map
-	mu    sync.Mutex
+	skipped   int64 // frames skipped by capacity
+	expired   int64 // frames dropped from the send queues for their expiry
+	noStream  int64 // frames dropped for the target without a stream
+	overflow  int64 // frames dropped from the full send queues
+	queueSize int   // max number of the DataFrames per send queue, 0 means unlimited
+	clock     clock.Clock
+	bufSize   int // size of the write buffer per target stream, 0 means unbuffered
+	conns     sync.Map
+	apps      sync.Map
+	queues    sync.Map
+	controls  sync.Map // connID -> *sendQueue of the control stream
+	waitStats *queueWaitStats
+	budget    *memoryBudget // tracks the frames in the send queues, nil means untracked
+	mu        sync.Mutex
+	// transactions references the queued DataFrames of the open transactions, nil means untracked
+	transactions *openTransactions
+	// onBroken is invoked once writing to the stream of a connection fails, nil means ignored
+	onBroken func(connID string, err error)
 }
 
-func newConnector() Connector {
+func newConnector(clock clock.Clock, bufSize int, budget *memoryBudget) Connector {
 	return &connector{
-		conns: sync.Map{},
-		apps:  sync.Map{},
-		mu:    sync.Mutex{},
+		clock:     clock,
+		bufSize:   bufSize,
+		budget:    budget,
+		conns:     sync.Map{},
+		apps:      sync.Map{},
+		queues:    sync.Map{},
+		waitStats: &queueWaitStats{},
+		mu:        sync.Mutex{},
 	}
 }
 
 // Add a connection.
 func (c *connector) Add(connID string, stream io.ReadWriteCloser) {
-	logger.Debugf("%sconnector add: connID=%s", ServerLogPrefix, connID)
+	if logger.IsDebug() {
+		logger.Debugf("%sconnector add: connID=%s", ServerLogPrefix, connID)
+	}
+	// a nil stream would panic the drain, the connection is unreachable instead
+	if isNilStream(stream) {
+		logger.Errorf("%sconnector add: connID=%s, stream is nil", ServerLogPrefix, connID)
+		if q, ok := c.queues.LoadAndDelete(connID); ok {
+			q.(*sendQueue).Close()
+		}
+		c.conns.Delete(connID)
+		return
+	}
 	c.conns.Store(connID, stream)
+	q := newSendQueue(c.clock)
+	q.budget = c.budget
+	q.transactions = c.transactions
+	q.limit = c.queueSize
+	q.overflowed = &c.overflow
+	if old, loaded := c.queues.LoadOrStore(connID, q); loaded {
+		// the connection is re-added, e.g. handshake again on the same stream
+		old.(*sendQueue).Close()
+		c.queues.Store(connID, q)
+	}
+	go c.drain(connID, stream, q)
 }
 
 // Remove a connection.
-func (c *connector) Remove(connID string) {
-	logger.Debugf("%sconnector remove: connID=%s", ServerLogPrefix, connID)
+func (c *connector) Remove(connID string) (*app, bool) {
+	if logger.IsDebug() {
+		logger.Debugf("%sconnector remove: connID=%s", ServerLogPrefix, connID)
+	}
 	c.conns.Delete(connID)
 	// c.funcs.Delete(connID)
-	c.apps.Delete(connID)
+	val, ok := c.apps.LoadAndDelete(connID)
+	if q, ok := c.queues.LoadAndDelete(connID); ok {
+		q.(*sendQueue).Close()
+	}
+	c.RemoveControl(connID)
+	if !ok {
+		return nil, false
+	}
+	return val.(*app), true
+}
+
+// drain writes the frames in the send queue to the target stream until the queue is closed.
+// the small frames are coalesced in the write buffer, which is flushed once the queue is empty.
+func (c *connector) drain(connID string, stream io.Writer, q *sendQueue) {
+	var buf *bufio.Writer
+	w := stream
+	if c.bufSize > 0 {
+		buf = bufio.NewWriterSize(stream, c.bufSize)
+		w = buf
+	}
+	for {
+		item, ok := q.Pop()
+		if !ok {
+			return
+		}
+		var data []byte
+		if item.control != nil {
+			data = item.control.Encode()
+		} else {
+			now := c.clock.Now()
+			c.waitStats.observe(item.frame.Priority(), now.Sub(item.enqueuedAt))
+			// the frame got stale while it's queued, it's useless to deliver it late
+			if item.frame.Expired(now) {
+				atomic.AddInt64(&c.expired, 1)
+				if buf != nil && q.Len() == 0 {
+					buf.Flush()
+					atomic.StoreInt64(&q.writing, int64(buf.Buffered()))
+				}
+				item.finish(errFrameExpired)
+				c.transactions.done(item.frame)
+				continue
+			}
+			if item.carriage != nil {
+				c.drainStreaming(connID, stream, buf, q, item)
+				c.transactions.done(item.frame)
+				continue
+			}
+			data = item.frame.Encode()
+		}
+		atomic.StoreInt64(&q.writing, int64(buffered(buf)+len(data)))
+		_, err := w.Write(data)
+		if err == nil && buf != nil && q.Len() == 0 {
+			err = buf.Flush()
+		}
+		if err != nil {
+			logger.Errorf("%sconnector drain: write to [%s] err=%v", ServerLogPrefix, connID, err)
+			c.broken(connID, q, err)
+			if buf != nil {
+				// the buffer keeps failing after an error, drop the buffered frames
+				buf.Reset(stream)
+			}
+		}
+		atomic.StoreInt64(&q.writing, int64(buffered(buf)))
+		if item.frame != nil {
+			// the popped DataFrame is referenced until it's written
+			c.transactions.done(item.frame)
+		}
+	}
+}
+
+// drainStreaming writes the streamed DataFrame, the sender waits for it so it's flushed at once.
+// A partially written frame breaks the stream, so the stream is closed on failure. If the
+// carriage can't be read from the source, the rest of it is padded with zeros instead, the
+// stream of the target is still usable.
+func (c *connector) drainStreaming(connID string, stream io.Writer, buf *bufio.Writer, q *sendQueue, item *queuedFrame) {
+	w := stream
+	if buf != nil {
+		w = buf
+	}
+	head := item.frame.EncodeHead(item.carriageSize)
+	atomic.StoreInt64(&q.writing, int64(buffered(buf)+len(head)+item.carriageSize))
+	var readErr error
+	_, err := w.Write(head)
+	if err == nil {
+		carriage := &carriageReader{r: item.carriage}
+		var n int64
+		n, err = io.CopyN(w, carriage, int64(item.carriageSize))
+		if err != nil && carriage.err != nil && err == carriage.err {
+			readErr = err
+			_, err = io.CopyN(w, zeros{}, int64(item.carriageSize)-n)
+		}
+	}
+	if err == nil && buf != nil {
+		err = buf.Flush()
+	}
+	if err == nil && readErr != nil {
+		logger.Errorf("%sconnector drain: stream to [%s] carriage err=%v", ServerLogPrefix, connID, readErr)
+		err = fmt.Errorf("%w: %v", errCarriageRead, readErr)
+	} else if err != nil {
+		logger.Errorf("%sconnector drain: stream to [%s] err=%v", ServerLogPrefix, connID, err)
+		c.broken(connID, q, err)
+		if buf != nil {
+			buf.Reset(stream)
+		}
+		if closer, ok := stream.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	atomic.StoreInt64(&q.writing, int64(buffered(buf)))
+	item.finish(err)
+}
+
+// carriageReader records the error of reading the carriage, to tell it from the write errors.
+type carriageReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *carriageReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// zeros reads the zero bytes endlessly.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// broken marks the send queue broken by the write error, the OnBroken handler is invoked once
+// if it's the queue of the DataFrames of the connection, the control stream falls back to it.
+func (c *connector) broken(connID string, q *sendQueue, err error) {
+	if !q.markBroken(err) || c.onBroken == nil {
+		return
+	}
+	if current, ok := c.queues.Load(connID); ok && current == q {
+		c.onBroken(connID, err)
+	}
+}
+
+// buffered returns the bytes in the write buffer, 0 if it's unbuffered.
+func buffered(buf *bufio.Writer) int {
+	if buf == nil {
+		return 0
+	}
+	return buf.Buffered()
 }
 
 // Get a connection by connection id.
 func (c *connector) Get(connID string) io.ReadWriteCloser {
-	logger.Debugf("%sconnector get connection: connID=%s", ServerLogPrefix, connID)
+	if logger.IsDebug() {
+		logger.Debugf("%sconnector get connection: connID=%s", ServerLogPrefix, connID)
+	}
 	if stream, ok := c.conns.Load(connID); ok {
 		return stream.(io.ReadWriteCloser)
 	}
@@ -98,7 +488,9 @@ func (c *connector) App(connID string) (*app, bool) {
 	if result, found := c.apps.Load(connID); found {
 		app, ok := result.(*app)
 		if ok {
-			logger.Debugf("%sconnector get app=%s::%s, connID=%s", ServerLogPrefix, app.id, app.name, connID)
+			if logger.IsDebug() {
+				logger.Debugf("%sconnector get app=%s::%s, connID=%s", ServerLogPrefix, app.id, app.name, connID)
+			}
 			return app, true
 		}
 		logger.Warnf("%sconnector get app convert fails, connID=%s", ServerLogPrefix, connID)
@@ -124,42 +516,381 @@ func (c *connector) AppName(connID string) (string, bool) {
 	return "", false
 }
 
-// GetConnIDs gets the connection ids by appID, name and tag.
-func (c *connector) GetConnIDs(appID string, name string, tag byte) []string {
+// GetConnIDs gets the connection ids by appID, name and tag, when several connections
+// are matched, one of them is picked randomly in proportion to their weights. The
+// connections declared a max payload size smaller than size are routed around.
+func (c *connector) GetConnIDs(appID string, name string, tag byte, size int) []string {
+	return c.GetConnIDsByKey(appID, name, tag, size, "")
+}
+
+// GetConnIDsByKey gets the connection ids like GetConnIDs, the connection is picked by the
+// hash of the key in proportion to the weights if the key is not empty.
+func (c *connector) GetConnIDsByKey(appID string, name string, tag byte, size int, key string) []string {
+	connIDs, skipped := c.pick(appID, name, &tag, size, key)
+	if skipped {
+		atomic.AddInt64(&c.skipped, 1)
+		logger.Warnf("%sconnector skip [%s], carriage size %d exceeds the max payload size", ServerLogPrefix, name, size)
+	}
+	return connIDs
+}
+
+// Candidates gets the connection ids like GetConnIDsByKey without counting the skipped frames.
+func (c *connector) Candidates(appID string, name string, tag byte, size int, key string) []string {
+	connIDs, _ := c.pick(appID, name, &tag, size, key)
+	return connIDs
+}
+
+// GetConnIDsByName gets the connection ids like GetConnIDsByKey whatever the data tags they
+// observe.
+func (c *connector) GetConnIDsByName(appID string, name string, size int, key string) []string {
+	connIDs, _ := c.pick(appID, name, nil, size, key)
+	return connIDs
+}
+
+// pick picks the connection ids observing the tag, nil tag matches any connection. skipped is
+// true if the connections are matched but none of them can handle the carriage of size.
+func (c *connector) pick(appID string, name string, tag *byte, size int, key string) ([]string, bool) {
 	connIDs := make([]string, 0)
+	weights := make([]int, 0)
+	total := 0
+	matched := false
+	// the instances whose windows are full, they're picked only if all the others are full too,
+	// the key pins the instance regardless
+	var fullIDs []string
+	var fullWeights []int
+	fullTotal := 0
 
-	c.apps.Range(func(key interface{}, val interface{}) bool {
+	c.apps.Range(func(connID interface{}, val interface{}) bool {
 		app := val.(*app)
-		if app.id == appID && app.name == name {
-			for _, v := range app.observed {
-				if v == tag {
-					connIDs = append(connIDs, key.(string))
-					break
+		// the observers observe the data tags too, but only the stream functions are routed
+		if app.clientType == ClientTypeStreamFunction && app.id == appID && MatchName(name, app.name) && !app.Draining() {
+			sub := app.subscription()
+			if tag == nil || sub.observes(*tag) {
+				matched = true
+				if app.maxPayload == 0 || size <= int(app.maxPayload) {
+					if key == "" && c.full(connID.(string)) {
+						fullIDs = append(fullIDs, connID.(string))
+						fullWeights = append(fullWeights, int(sub.Weight()))
+						fullTotal += int(sub.Weight())
+					} else {
+						connIDs = append(connIDs, connID.(string))
+						weights = append(weights, int(sub.Weight()))
+						total += int(sub.Weight())
+					}
 				}
 			}
 		}
 		return true
 	})
 
-	if n := len(connIDs); n > 1 {
-		index := rand.Intn(n)
-		return connIDs[index : index+1]
+	if len(connIDs) == 0 {
+		connIDs, weights, total = fullIDs, fullWeights, fullTotal
 	}
+	skipped := matched && len(connIDs) == 0
 
-	return connIDs
+	if len(connIDs) > 1 {
+		var n int
+		if key == "" {
+			n = rand.Intn(total)
+		} else {
+			// the order of the map is random, the candidates are sorted for the key
+			sort.Sort(byConnID{connIDs, weights})
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			// the total may overflow the 32-bit weights
+			n = int(uint64(h.Sum32()) % uint64(total))
+		}
+		for i, w := range weights {
+			if n < w {
+				return connIDs[i : i+1], false
+			}
+			n -= w
+		}
+	}
+
+	return connIDs, skipped
+}
+
+// byConnID sorts the candidate connections and their weights together.
+type byConnID struct {
+	connIDs []string
+	weights []int
 }
 
-// Write a DataFrame to a connection.
+func (b byConnID) Len() int           { return len(b.connIDs) }
+func (b byConnID) Less(i, j int) bool { return b.connIDs[i] < b.connIDs[j] }
+func (b byConnID) Swap(i, j int) {
+	b.connIDs[i], b.connIDs[j] = b.connIDs[j], b.connIDs[i]
+	b.weights[i], b.weights[j] = b.weights[j], b.weights[i]
+}
+
+// Write a DataFrame to a connection, the frame is pushed into the target's send queue.
 func (c *connector) Write(f *frame.DataFrame, toID string) error {
-	targetStream := c.Get(toID)
-	if targetStream == nil {
+	q, ok := c.queues.Load(toID)
+	if !ok {
+		atomic.AddInt64(&c.noStream, 1)
+		logger.Warnf("%swill write to: [%s], target stream is nil", ServerLogPrefix, toID)
+		return fmt.Errorf("target[%s] stream is nil", toID)
+	}
+	if err := q.(*sendQueue).Push(f); err != nil {
+		return err
+	}
+	if a, ok := c.apps.Load(toID); ok {
+		atomic.AddInt64(&a.(*app).frames, 1)
+	}
+	return nil
+}
+
+// Flushed returns a channel closed once the frames queued to the connection are written, or
+// it's removed. Unlike Drain it keeps the connection in rotation.
+func (c *connector) Flushed(connID string) <-chan struct{} {
+	q, ok := c.queues.Load(connID)
+	if !ok {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return q.(*sendQueue).Drained()
+}
+
+// Drain takes a connection out of rotation, GetConnIDsByKey skips it so no more DataFrames are
+// routed to it, returns a channel closed once the frames queued to it are written, or it's
+// removed. It returns false if there's no such connection.
+func (c *connector) Drain(connID string) (<-chan struct{}, bool) {
+	a, ok := c.apps.Load(connID)
+	if !ok {
+		return nil, false
+	}
+	atomic.StoreInt32(&a.(*app).draining, 1)
+	q, ok := c.queues.Load(connID)
+	if !ok {
+		// the nil stream never has frames queued
+		done := make(chan struct{})
+		close(done)
+		return done, true
+	}
+	return q.(*sendQueue).Drained(), true
+}
+
+// WriteStream writes a DataFrame to a connection with its carriage of size bytes copied from
+// the reader, the frame is pushed into the target's send queue and it blocks until the frame
+// is written, so the reader can be the stream the frame is being read from.
+func (c *connector) WriteStream(f *frame.DataFrame, carriage io.Reader, size int, toID string) error {
+	q, ok := c.queues.Load(toID)
+	if !ok {
+		atomic.AddInt64(&c.noStream, 1)
 		logger.Warnf("%swill write to: [%s], target stream is nil", ServerLogPrefix, toID)
 		return fmt.Errorf("target[%s] stream is nil", toID)
 	}
-	c.mu.Lock()
-	_, err := targetStream.Write(f.Encode())
-	c.mu.Unlock()
-	return err
+	done, err := q.(*sendQueue).PushStream(f, carriage, size)
+	if err != nil {
+		return err
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	if a, ok := c.apps.Load(toID); ok {
+		atomic.AddInt64(&a.(*app).frames, 1)
+	}
+	return nil
+}
+
+// WriteControl writes a control frame to a connection, e.g. PingFrame, the frame is pushed
+// into the target's send queue ahead of the queued DataFrames.
+func (c *connector) WriteControl(f frame.Frame, toID string) error {
+	if q, ok := c.controls.Load(toID); ok {
+		return q.(*sendQueue).PushControl(f)
+	}
+	q, ok := c.queues.Load(toID)
+	if !ok {
+		return fmt.Errorf("target[%s] stream is nil", toID)
+	}
+	return q.(*sendQueue).PushControl(f)
+}
+
+// AddControl adds the control stream of a connection, it has its own send queue, so the control
+// frames aren't blocked behind the DataFrames being written to the stream of the connection.
+func (c *connector) AddControl(connID string, stream io.ReadWriteCloser) {
+	if isNilStream(stream) {
+		return
+	}
+	q := newSendQueue(c.clock)
+	if old, loaded := c.controls.LoadOrStore(connID, q); loaded {
+		old.(*sendQueue).Close()
+		c.controls.Store(connID, q)
+	}
+	go c.drain(connID, stream, q)
+}
+
+// SetQueueSize sets the max number of the DataFrames queued to every connection added later.
+func (c *connector) SetQueueSize(size int) {
+	c.queueSize = size
+}
+
+// OnBroken sets the handler invoked once writing to the stream of a connection fails.
+func (c *connector) OnBroken(handler func(connID string, err error)) {
+	c.onBroken = handler
+}
+
+// TrackTransactions references the DataFrames queued to the connections added later in the
+// open transactions.
+func (c *connector) TrackTransactions(transactions *openTransactions) {
+	c.transactions = transactions
+}
+
+// RemoveControl removes the control stream of a connection.
+func (c *connector) RemoveControl(connID string) {
+	if q, ok := c.controls.LoadAndDelete(connID); ok {
+		q.(*sendQueue).Close()
+	}
+}
+
+// SetWindow sets the max number of the DataFrames in flight to a connection.
+func (c *connector) SetWindow(connID string, window int) {
+	if q, ok := c.queues.Load(connID); ok {
+		q.(*sendQueue).SetWindow(window)
+	}
+}
+
+// WindowFull reports whether the windows of all the stream functions of the name observing
+// the tag are full.
+func (c *connector) WindowFull(appID string, name string, tag byte) bool {
+	matched, full := 0, 0
+	c.apps.Range(func(key interface{}, val interface{}) bool {
+		app := val.(*app)
+		if app.clientType == ClientTypeStreamFunction && app.id == appID && MatchName(name, app.name) &&
+			!app.Draining() && app.subscription().observes(tag) {
+			matched++
+			if c.full(key.(string)) {
+				full++
+			}
+		}
+		return true
+	})
+	return matched > 0 && full == matched
+}
+
+// full reports whether the window of the connection is full.
+func (c *connector) full(connID string) bool {
+	q, ok := c.queues.Load(connID)
+	return ok && q.(*sendQueue).Full()
+}
+
+// InFlight gets the number of the DataFrames in flight to the stream functions per name.
+func (c *connector) InFlight() map[string]int {
+	result := make(map[string]int)
+	c.apps.Range(func(key interface{}, val interface{}) bool {
+		app := val.(*app)
+		if app.clientType != ClientTypeStreamFunction {
+			return true
+		}
+		if q, ok := c.queues.Load(key); ok {
+			result[app.name] += q.(*sendQueue).InFlight()
+		}
+		return true
+	})
+	return result
+}
+
+// Instances gets the weight and the number of the frames written to every stream function
+// instance, sorted by the name and the connection id.
+func (c *connector) Instances() []InstanceStat {
+	result := make([]InstanceStat, 0)
+	c.apps.Range(func(key interface{}, val interface{}) bool {
+		app := val.(*app)
+		if app.clientType == ClientTypeStreamFunction {
+			result = append(result, InstanceStat{
+				ConnID: key.(string),
+				Name:   app.name,
+				Weight: app.Weight(),
+				Frames: atomic.LoadInt64(&app.frames),
+			})
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].ConnID < result[j].ConnID
+	})
+	return result
+}
+
+// Buffering gets the frames buffered for a connection.
+func (c *connector) Buffering(connID string) (BufferStat, bool) {
+	val, ok := c.queues.Load(connID)
+	if !ok {
+		return BufferStat{}, false
+	}
+	q := val.(*sendQueue)
+	q.mu.Lock()
+	stat := BufferStat{Queued: q.size, QueuedBytes: q.bytes}
+	q.mu.Unlock()
+	stat.PendingWrite = atomic.LoadInt64(&q.writing)
+	return stat, true
+}
+
+// Depth gets the number of the DataFrames queued to a connection.
+func (c *connector) Depth(connID string) int {
+	if q, ok := c.queues.Load(connID); ok {
+		return q.(*sendQueue).Depth()
+	}
+	return 0
+}
+
+// Pending gets the number of the DataFrames queued to a connection or being written to it.
+func (c *connector) Pending(connID string) int {
+	if q, ok := c.queues.Load(connID); ok {
+		return q.(*sendQueue).InFlight()
+	}
+	return 0
+}
+
+// Broken reports whether writing to the stream of a connection failed.
+func (c *connector) Broken(connID string) bool {
+	if q, ok := c.queues.Load(connID); ok {
+		return q.(*sendQueue).Broken()
+	}
+	return false
+}
+
+// QueueWaitStats gets how long the frames waited in the send queues per priority.
+func (c *connector) QueueWaitStats() map[frame.Priority]QueueWaitStat {
+	return c.waitStats.snapshot()
+}
+
+// CapacitySkipped gets how many times the frames are skipped by the max payload size.
+func (c *connector) CapacitySkipped() int64 {
+	return atomic.LoadInt64(&c.skipped)
+}
+
+// Expired gets how many frames are dropped from the send queues for their expiry.
+func (c *connector) Expired() int64 {
+	return atomic.LoadInt64(&c.expired)
+}
+
+// NoStream gets how many DataFrames are dropped since the target has no stream.
+func (c *connector) NoStream() int64 {
+	return atomic.LoadInt64(&c.noStream)
+}
+
+// Overflowed gets how many DataFrames are dropped from the full send queues.
+func (c *connector) Overflowed() int64 {
+	return atomic.LoadInt64(&c.overflow)
+}
+
+// ResetStats zeroes the cumulative counters of the connector.
+func (c *connector) ResetStats() {
+	atomic.StoreInt64(&c.skipped, 0)
+	atomic.StoreInt64(&c.expired, 0)
+	atomic.StoreInt64(&c.noStream, 0)
+	atomic.StoreInt64(&c.overflow, 0)
+	c.apps.Range(func(key interface{}, val interface{}) bool {
+		atomic.StoreInt64(&val.(*app).frames, 0)
+		return true
+	})
+	c.waitStats.reset()
 }
 
 // GetSnapshot gets the snapshot of all connections.
@@ -172,10 +903,47 @@ func (c *connector) GetSnapshot() map[string]io.ReadWriteCloser {
 	return result
 }
 
+// Live reports whether the stream of a connection is usable: it's registered, no write to it
+// has failed and, for the streams which carry a context like quic.Stream, the context isn't
+// canceled, which happens once the stream or its connection is closed.
+func (c *connector) Live(connID string) bool {
+	stream := c.Get(connID)
+	if stream == nil {
+		return false
+	}
+	if q, ok := c.queues.Load(connID); ok && q.(*sendQueue).Broken() {
+		return false
+	}
+	if s, ok := stream.(interface{ Context() context.Context }); ok && s.Context().Err() != nil {
+		return false
+	}
+	return true
+}
+
 // LinkApp links the app and connection.
-func (c *connector) LinkApp(connID string, appID string, name string, observed []byte) {
-	logger.Debugf("%sconnector link application: connID[%s] --> app[%s::%s]", ServerLogPrefix, connID, appID, name)
-	c.apps.Store(connID, &app{appID, name, observed})
+func (c *connector) LinkApp(connID string, appID string, name string, clientType ClientType, observed []byte, maxPayload uint32, weight uint32) {
+	if logger.IsDebug() {
+		logger.Debugf("%sconnector link application: connID[%s] --> app[%s::%s]", ServerLogPrefix, connID, appID, name)
+	}
+	a := &app{
+		id:         appID,
+		name:       name,
+		clientType: clientType,
+		maxPayload: maxPayload,
+	}
+	a.sub.Store(newSubscription(observed, weight))
+	c.apps.Store(connID, a)
+}
+
+// UpdateSubscription replaces the data tags and the weight of the app by connID, the frames
+// routed afterwards are matched by the new subscription.
+func (c *connector) UpdateSubscription(connID string, observed []byte, weight uint32) bool {
+	a, ok := c.App(connID)
+	if !ok {
+		return false
+	}
+	a.sub.Store(newSubscription(observed, weight))
+	return true
 }
 
 // UnlinkApp removes the app by connID.
@@ -203,7 +971,28 @@ func (c *connector) UnlinkApp(connID string, appID string, name string) {
 // }
 
 // Clean the connector.
+// The maps are cleared in place, the sessions still being torn down read them meanwhile.
 func (c *connector) Clean() {
-	c.conns = sync.Map{}
-	c.apps = sync.Map{}
+	for _, queues := range []*sync.Map{&c.queues, &c.controls} {
+		queues.Range(func(key interface{}, val interface{}) bool {
+			queues.Delete(key)
+			val.(*sendQueue).Close()
+			return true
+		})
+	}
+	for _, m := range []*sync.Map{&c.conns, &c.apps} {
+		m.Range(func(key interface{}, val interface{}) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+}
+
+// isNilStream reports whether the stream is nil, including a nil pointer in the interface.
+func isNilStream(stream io.ReadWriteCloser) bool {
+	if stream == nil {
+		return true
+	}
+	v := reflect.ValueOf(stream)
+	return v.Kind() == reflect.Ptr && v.IsNil()
 }
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// countingWriter records the writes.
+type countingWriter struct {
+	mu     sync.Mutex
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func (w *countingWriter) Writes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestConnectorDrainCoalesce(t *testing.T) {
+	c := newConnector(clock.New(), 4096, nil).(*connector)
+	q := newSendQueue(clock.New())
+	expected := &bytes.Buffer{}
+	for i := 0; i < 10; i++ {
+		f := newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33)
+		expected.Write(f.Encode())
+		assert.NoError(t, q.Push(f))
+	}
+
+	w := &countingWriter{}
+	go c.drain("conn", w, q)
+	defer q.Close()
+
+	assert.True(t, waitFor(func() bool { return w.Writes() == 1 }))
+	w.mu.Lock()
+	assert.Equal(t, expected.Bytes(), w.buf.Bytes())
+	w.mu.Unlock()
+}
+
+func TestConnectorControl(t *testing.T) {
+	c := newConnector(clock.New(), 0, nil).(*connector)
+	data := &gateWriter{gate: make(chan struct{})}
+	c.Add("conn", &mockStream{w: data})
+	defer c.Remove("conn")
+	// the data stream is stuck
+	assert.NoError(t, c.Write(newDataFrame("tid-1", "source", 0x33), "conn"))
+
+	control := &syncBuffer{}
+	c.AddControl("conn", &mockStream{w: control})
+	assert.NoError(t, c.WriteControl(frame.NewPingFrame(), "conn"))
+	assert.True(t, waitFor(func() bool { return len(control.Frames()) == 1 }))
+	assert.Equal(t, frame.TagOfPingFrame, control.Frames()[0].Type())
+
+	// the control frames fall back to the data stream
+	c.RemoveControl("conn")
+	assert.NoError(t, c.WriteControl(frame.NewPingFrame(), "conn"))
+	close(data.gate)
+	assert.True(t, waitFor(func() bool { return len(data.Frames()) == 2 }))
+	assert.Len(t, control.Frames(), 1)
+}
+
+// devNullWriter writes to os.DevNull, notifies when n bytes have been written.
+type devNullWriter struct {
+	f       *os.File
+	n       int
+	written int
+	done    chan struct{}
+}
+
+func (w *devNullWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.written += n
+	if w.written >= w.n {
+		close(w.done)
+	}
+	return n, err
+}
+
+func BenchmarkConnectorDrain(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, size := range []int{64, 1024, 64 * 1024} {
+		for _, bufSize := range []int{0, 32 * 1024} {
+			b.Run(fmt.Sprintf("payload=%d/buffer=%d", size, bufSize), func(b *testing.B) {
+				f := frame.NewDataFrame()
+				f.SetCarriage(0x33, make([]byte, size))
+				c := newConnector(clock.New(), bufSize, nil).(*connector)
+				q := newSendQueue(clock.New())
+				for i := 0; i < b.N; i++ {
+					q.Push(f)
+				}
+				w := &devNullWriter{f: devNull, n: b.N * len(f.Encode()), done: make(chan struct{})}
+
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				go c.drain("conn", w, q)
+				<-w.done
+				b.StopTimer()
+				q.Close()
+			})
+		}
+	}
+}
+
+func TestConnectorBuffering(t *testing.T) {
+	c := newConnector(clock.New(), 0, nil).(*connector)
+	w := &gateWriter{gate: make(chan struct{})}
+	c.Add("conn", &mockStream{r: bytes.NewReader(nil), w: w})
+	defer c.Remove("conn")
+
+	frames := []*frame.DataFrame{
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+		newDataFrame("tid-3", "source", 0x33),
+	}
+	for _, f := range frames {
+		assert.NoError(t, c.Write(f, "conn"))
+	}
+
+	// tid-1 is being written, the others are queued
+	expected := BufferStat{
+		Queued:       2,
+		QueuedBytes:  frameSize(frames[1]) + frameSize(frames[2]),
+		PendingWrite: int64(len(frames[0].Encode())),
+	}
+	assert.True(t, waitFor(func() bool {
+		stat, _ := c.Buffering("conn")
+		return stat == expected
+	}))
+
+	close(w.gate)
+	assert.True(t, waitFor(func() bool {
+		stat, _ := c.Buffering("conn")
+		return stat == BufferStat{}
+	}))
+	_, ok := c.Buffering("unknown")
+	assert.False(t, ok)
+}
+
+func TestConnectorPickByKeyHeavyWeights(t *testing.T) {
+	c := newConnector(clock.New(), 0, nil).(*connector)
+	// the weights would add up to 1<<32
+	c.LinkApp("conn-1", "app", "sfn-1", ClientTypeStreamFunction, []byte{0x33}, 0, 1<<31)
+	c.LinkApp("conn-2", "app", "sfn-1", ClientTypeStreamFunction, []byte{0x33}, 0, 1<<31)
+
+	connIDs := c.GetConnIDsByKey("app", "sfn-1", 0x33, 0, "alice")
+	assert.Len(t, connIDs, 1)
+	assert.Equal(t, connIDs, c.GetConnIDsByKey("app", "sfn-1", 0x33, 0, "alice"))
+	assert.Len(t, c.GetConnIDsByKey("app", "sfn-1", 0x33, 0, ""), 1)
+
+	// the weights are capped
+	a, _ := c.App("conn-1")
+	assert.EqualValues(t, MaxWeight, a.subscription().Weight())
+	c.UpdateSubscription("conn-2", []byte{0x33}, 0xFFFFFFFF)
+	a, _ = c.App("conn-2")
+	assert.EqualValues(t, MaxWeight, a.subscription().Weight())
+}
@@ -1,12 +1,14 @@
 package core
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/core/log"
 	"github.com/yomorun/yomo/pkg/logger"
 )
 
@@ -21,7 +23,11 @@ type Context struct {
 	// Keys store the key/value pairs in context.
 	Keys map[string]interface{}
 
-	mu sync.RWMutex
+	ctx    context.Context
+	logger log.Logger
+	mu     sync.RWMutex
+	// uni is true if the stream is unidirectional, see Server.serveUniStreams.
+	uni bool
 }
 
 func newContext(connID string, stream quic.Stream) *Context {
@@ -32,6 +38,29 @@ func newContext(connID string, stream quic.Stream) *Context {
 	}
 }
 
+// Logger returns the logger of the session, which adds the request id to the log lines.
+func (c *Context) Logger() log.Logger {
+	if c.logger == nil {
+		return logger.With()
+	}
+	return c.logger
+}
+
+// Context returns the context.Context of the connection, it carries the values set by the
+// authentication, e.g. the tenant id, to the handlers of the frames.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// WithContext sets the context.Context of the connection.
+func (c *Context) WithContext(ctx context.Context) *Context {
+	c.ctx = ctx
+	return c
+}
+
 // WithFrame sets a frame to context.
 func (c *Context) WithFrame(f frame.Frame) *Context {
 	c.Frame = f
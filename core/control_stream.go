@@ -0,0 +1,183 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/core/log"
+)
+
+// controlStreamTimeout is how long the server waits for the client to open the control stream
+// accepted by the handshake, and the client waits to open it.
+const controlStreamTimeout = 5 * time.Second
+
+// acceptControlStream serves the control stream requested by the handshake of the connection,
+// returns false if the connection can't have one, e.g. it's not a QUIC connection.
+func (s *Server) acceptControlStream(c *Context) bool {
+	sess := s.session(c.ConnID)
+	if sess == nil || sess.conn == nil {
+		return false
+	}
+	go s.serveControlStream(c.Context(), sess.conn, c.ConnID)
+	return true
+}
+
+// serveControlStream accepts the control stream opened by the client after the handshake, and
+// handles its frames apart from the DataFrames until the stream or the connection ends. The
+// control frames to the client are written to it meanwhile.
+func (s *Server) serveControlStream(ctx context.Context, conn quic.Connection, connID string) {
+	sess := s.session(connID)
+	acceptCtx, cancel := context.WithTimeout(ctx, controlStreamTimeout)
+	stream, err := conn.AcceptStream(acceptCtx)
+	cancel()
+	if err != nil {
+		sess.Logger().Warnf("%s(%s) the control stream isn't opened: %v", ServerLogPrefix, connID, err)
+		return
+	}
+	if isCompressed(conn) {
+		stream = compressStream(stream)
+	}
+	defer stream.Close()
+	s.connector.AddControl(connID, stream)
+	defer s.connector.RemoveControl(connID)
+	sess.Logger().Infof("%s[stream:%d] control stream created, connID=%s", ServerLogPrefix, stream.StreamID(), connID)
+
+	counting := &countingStream{ReadWriter: stream}
+	fs := NewFrameStream(counting)
+	// the control frames are small
+	fs.SetMaxFrameSize(s.opts.MaxHandshakeFrameSize)
+	c := newContext(connID, stream)
+	c.WithContext(ctx)
+	c.logger = sess.logger
+	defer c.Clean()
+	for {
+		f, err := fs.ReadFrame()
+		if err != nil {
+			c.Logger().Debugf("%s(%s) control stream is closed: %v", ServerLogPrefix, connID, err)
+			return
+		}
+		n := counting.take()
+		s.frameStats.observe(f.Type(), n)
+		sess.observe(n, s.opts.Clock.Now())
+		s.keepAlive(connID)
+		if err := s.handleControlFrame(c.WithFrame(f)); err != nil {
+			c.Logger().Warnf("%s(%s) %v, close the control stream", ServerLogPrefix, connID, err)
+			return
+		}
+	}
+}
+
+// handleControlFrame handles a frame of the control stream, an error is returned if it isn't a
+// control frame the client is allowed to send.
+func (s *Server) handleControlFrame(c *Context) error {
+	frameType := c.Frame.Type()
+	role := s.clientType(c.ConnID)
+	if !role.CanSend(frameType) {
+		atomic.AddInt64(&s.counterOfViolation, 1)
+		err := fmt.Errorf("protocol violation, %s can not send %s", role, frameType)
+		log.With(c.Logger(), "conn_id", c.ConnID, "frame_type", frameType.String()).Warnf("%s(%s) %v", ServerLogPrefix, c.ConnID, err)
+		return err
+	}
+	switch frameType {
+	case frame.TagOfPingFrame:
+		s.handlePingFrame(c)
+	case frame.TagOfPongFrame:
+		s.pinger.pong(c.ConnID)
+	case frame.TagOfSubscriptionFrame:
+		s.handleSubscriptionFrame(c)
+	case frame.TagOfCapabilitiesRequestFrame:
+		s.handleCapabilitiesRequestFrame(c)
+	default:
+		return fmt.Errorf("%s is not a control frame", frameType)
+	}
+	return nil
+}
+
+// keepAlive extends the read deadline of the stream of the connection, which doesn't receive
+// the heartbeats sent to the control stream.
+func (s *Server) keepAlive(connID string) {
+	timeout := s.readTimeout(connID)
+	if timeout <= 0 {
+		return
+	}
+	if stream := s.connector.Get(connID); stream != nil {
+		setReadDeadline(stream, time.Now().Add(timeout))
+	}
+}
+
+// openControlStream opens the control stream accepted by the server, the control frames are
+// written to it since then.
+func (c *Client) openControlStream(conn quic.Connection) {
+	ctx, cancel := context.WithTimeout(conn.Context(), controlStreamTimeout)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		c.logger.Warnf("%s[%s] open the control stream error: %v", ClientLogPrefix, c.name, err)
+		return
+	}
+	if isCompressed(conn) {
+		stream = compressStream(stream)
+	}
+	// the server accepts the stream once it's written, the ping opens it
+	if _, err := stream.Write(frame.NewPingFrame().Encode()); err != nil {
+		c.logger.Warnf("%s[%s] open the control stream error: %v", ClientLogPrefix, c.name, err)
+		stream.Close()
+		return
+	}
+	c.controlMu.Lock()
+	c.control = stream
+	c.controlMu.Unlock()
+	c.logger.Debugf("%s[%s] control stream is opened", ClientLogPrefix, c.name)
+	go c.handleControlFrame(stream)
+}
+
+// handleControlFrame handles the frames received from the control stream until it's closed,
+// the control frames are written to the stream of the DataFrames again after that.
+func (c *Client) handleControlFrame(stream quic.Stream) {
+	fs := NewFrameStream(stream)
+	for {
+		f, err := fs.ReadFrame()
+		if err != nil {
+			c.logger.Debugf("%s[%s] control stream is closed: %v", ClientLogPrefix, c.name, err)
+			c.controlMu.Lock()
+			if c.control == stream {
+				c.control = nil
+			}
+			c.controlMu.Unlock()
+			return
+		}
+		c.handle(f)
+	}
+}
+
+// writeControl writes a control frame to the control stream, or to the stream of the
+// DataFrames if there's no control stream.
+func (c *Client) writeControl(frm frame.Frame) error {
+	c.controlMu.Lock()
+	if c.control == nil {
+		c.controlMu.Unlock()
+		return c.writeFrame(frm)
+	}
+	_, err := c.control.Write(frm.Encode())
+	c.controlMu.Unlock()
+	if err != nil {
+		c.logger.Warnf("%s[%s] write %s to the control stream error: %v", ClientLogPrefix, c.name, frm.Type(), err)
+		c.closeControl()
+		return c.writeFrame(frm)
+	}
+	return nil
+}
+
+// closeControl closes the control stream if there's one.
+func (c *Client) closeControl() {
+	c.controlMu.Lock()
+	defer c.controlMu.Unlock()
+	if c.control != nil {
+		c.control.Close()
+		c.control = nil
+	}
+}
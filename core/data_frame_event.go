@@ -0,0 +1,21 @@
+package core
+
+// DataFrameEvent describes a DataFrame routed by the server.
+type DataFrameEvent struct {
+	// TransactionID is the transaction id of the frame.
+	TransactionID string
+	// Issuer is the name of the app issued the frame.
+	Issuer string
+	// Tag is the data tag of the frame.
+	Tag byte
+	// Size is the length of the carriage.
+	Size int
+	// Targets are the connection ids the frame is written to.
+	Targets []string
+	// Err is the *FanoutError of the targets failed, which are evicted, nil if none fails.
+	Err error
+}
+
+// DataFrameObserver is invoked for every DataFrame routed by the server, it runs on the
+// data path, so it must be fast or dispatch the event to its own goroutine or queue.
+type DataFrameObserver func(e DataFrameEvent)
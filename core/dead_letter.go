@@ -0,0 +1,24 @@
+package core
+
+import (
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// isDeadLetter reports whether the name is the dead-letter stream function.
+func (s *Server) isDeadLetter(name string) bool {
+	return s.opts.DeadLetter != "" && MatchName(s.opts.DeadLetter, name)
+}
+
+// deadLetters returns the instance of the dead-letter stream function the unroutable DataFrame
+// is forwarded to, empty if it isn't connected.
+func (s *Server) deadLetters(appID string, f *frame.DataFrame, size int) []string {
+	return s.connector.GetConnIDsByName(appID, s.opts.DeadLetter, size, s.routingKey(f))
+}
+
+// StatsDeadLettered returns how many DataFrames are forwarded to the dead-letter stream
+// function, see WithDeadLetter.
+func (s *Server) StatsDeadLettered() int64 {
+	return atomic.LoadInt64(&s.counterOfDeadLettered)
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestHandleDataFrameDeadLetter(t *testing.T) {
+	s := NewServer("test-zipper", WithDeadLetter("dead-letter"))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	// the dead-letter stream function isn't in the workflow, nor observes the tag
+	deadLetter := connectSfn(s, "dead-letter-conn", "dead-letter")
+	assert.NotNil(t, s.connector.Get("dead-letter-conn"))
+
+	targets, reason := s.DryRunRoute(newDataFrame("tid-0", "source", 0x34))
+	assert.Equal(t, []string{"dead-letter"}, targets)
+	assert.Empty(t, reason)
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 && len(deadLetter.Frames()) == 1 }))
+	assert.Equal(t, "tid-1", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "tid-2", deadLetter.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.EqualValues(t, 1, s.StatsDeadLettered())
+	assert.EqualValues(t, 1, s.Stats().DeadLettered)
+
+	// the frames of the dead-letter stream function are never dead-lettered again
+	s.handleConnection(context.Background(), &Context{ConnID: "dead-letter-conn", Stream: &mockStream{r: bytes.NewReader(newDataFrame("tid-3", "dead-letter", 0x35).Encode()), w: ioutil.Discard}})
+	assert.Len(t, deadLetter.Frames(), 1)
+	assert.EqualValues(t, 1, s.StatsDeadLettered())
+}
+
+func TestHandleDataFrameNoDeadLetter(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	// the unknown stream function is rejected
+	handshake := frame.NewHandshakeFrame("dead-letter", byte(ClientTypeStreamFunction), nil, "", 0, nil)
+	s.handleConnection(context.Background(), &Context{ConnID: "dead-letter-conn", Stream: &mockStream{r: bytes.NewReader(handshake.Encode()), w: ioutil.Discard}})
+	assert.Nil(t, s.connector.Get("dead-letter-conn"))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Empty(t, sfn.Frames())
+	assert.Zero(t, s.StatsDeadLettered())
+}
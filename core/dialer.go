@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Dialer dials the QUIC connection to the zipper at addr, e.g. through a proxy or on a
+// specific egress interface. It must abort the dial once the ctx is done.
+type Dialer func(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (quic.Connection, error)
+
+// WithDialer sets the dialer of the client, it takes precedence over WithLocalAddr and
+// WithPacketConn.
+func WithDialer(dialer Dialer) ClientOption {
+	return func(o *ClientOptions) {
+		o.Dialer = dialer
+	}
+}
+
+// WithLocalAddr binds the UDP socket of the client to the local address, e.g. "10.0.0.2:0".
+func WithLocalAddr(addr string) ClientOption {
+	return func(o *ClientOptions) {
+		o.LocalAddr = addr
+	}
+}
+
+// WithPacketConn dials over the pre-dialed packet conn instead of a new UDP socket, the
+// caller owns it and closes it after the client is closed. It's reused on reconnect.
+func WithPacketConn(conn net.PacketConn) ClientOption {
+	return func(o *ClientOptions) {
+		o.PacketConn = conn
+	}
+}
+
+// dial creates the QUIC connection to addr by the dial options of the client.
+func (c *Client) dial(ctx context.Context, addr string) (quic.Connection, error) {
+	if c.opts.Dialer != nil {
+		return c.opts.Dialer(ctx, addr, c.opts.TLSConfig, c.quicConfig())
+	}
+	if c.opts.PacketConn == nil && c.opts.LocalAddr == "" {
+		return quic.DialAddrContext(ctx, addr, c.opts.TLSConfig, c.quicConfig())
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	pconn := c.opts.PacketConn
+	owned := pconn == nil
+	if owned {
+		laddr, err := net.ResolveUDPAddr("udp", c.opts.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		if pconn, err = net.ListenUDP("udp", laddr); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := quic.DialContext(ctx, pconn, raddr, host, c.opts.TLSConfig, c.quicConfig())
+	if err != nil {
+		if owned {
+			pconn.Close()
+		}
+		return nil, err
+	}
+	if owned {
+		// quic-go doesn't close the packet conn passed to it
+		go func() {
+			<-conn.Context().Done()
+			pconn.Close()
+		}()
+	}
+	return conn, nil
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// DisconnectCause describes why a connection ended.
+type DisconnectCause byte
+
+const (
+	// DisconnectUnknown is an unexpected error, e.g. the client crashed.
+	DisconnectUnknown DisconnectCause = iota
+	// DisconnectClientClose means the client closed the connection normally.
+	DisconnectClientClose
+	// DisconnectIdleTimeout means the connection was idle for too long.
+	DisconnectIdleTimeout
+	// DisconnectClosed means the underlying connection has been closed, i.e. net.ErrClosed.
+	DisconnectClosed
+	// DisconnectParseError means the client sent a malformed frame.
+	DisconnectParseError
+	// DisconnectServerClose means the server closed the connection, e.g. a frame handler failed.
+	DisconnectServerClose
+	// DisconnectPingTimeout means the stream function didn't respond the ping of the server.
+	DisconnectPingTimeout
+	// DisconnectReadTimeout means no frame was received within the read timeout of the server.
+	DisconnectReadTimeout
+	// DisconnectWriteError means writing to the stream failed, e.g. it's reset by the peer.
+	DisconnectWriteError
+)
+
+func (c DisconnectCause) String() string {
+	switch c {
+	case DisconnectClientClose:
+		return "ClientClose"
+	case DisconnectIdleTimeout:
+		return "IdleTimeout"
+	case DisconnectClosed:
+		return "Closed"
+	case DisconnectParseError:
+		return "ParseError"
+	case DisconnectServerClose:
+		return "ServerClose"
+	case DisconnectPingTimeout:
+		return "PingTimeout"
+	case DisconnectReadTimeout:
+		return "ReadTimeout"
+	case DisconnectWriteError:
+		return "WriteError"
+	default:
+		return "Unknown"
+	}
+}
+
+// DisconnectReason is the reason why a connection ended.
+type DisconnectReason struct {
+	// Cause is the category of the reason.
+	Cause DisconnectCause
+	// Err is the error broke the session, nil if the connection was closed normally.
+	Err error
+}
+
+func (r DisconnectReason) String() string {
+	if r.Err == nil {
+		return r.Cause.String()
+	}
+	return r.Cause.String() + ": " + r.Err.Error()
+}
+
+// ConnectHandler is invoked when the handshake of an app succeeded, the info includes the
+// negotiated TLS parameters of the connection, e.g. to record them for the audit.
+type ConnectHandler func(info SessionInfo)
+
+// DisconnectHandler is invoked when a connection of an app ended.
+type DisconnectHandler func(connID string, name string, reason DisconnectReason)
+
+// disconnectReason derives the reason from the error broke the session loop.
+func disconnectReason(err error) DisconnectReason {
+	var (
+		appErr   *quic.ApplicationError
+		idleErr  *quic.IdleTimeoutError
+		parseErr *ParseError
+		netErr   net.Error
+	)
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		return DisconnectReason{Cause: DisconnectClientClose}
+	case errors.As(err, &appErr):
+		// the client closed the connection with an error code
+		if appErr.ErrorCode == 0x00 {
+			return DisconnectReason{Cause: DisconnectClientClose}
+		}
+		return DisconnectReason{Cause: DisconnectUnknown, Err: err}
+	case errors.As(err, &idleErr):
+		return DisconnectReason{Cause: DisconnectIdleTimeout, Err: err}
+	case errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		// the read deadline of the stream is exceeded
+		return DisconnectReason{Cause: DisconnectReadTimeout, Err: err}
+	case errors.Is(err, net.ErrClosed):
+		return DisconnectReason{Cause: DisconnectClosed, Err: err}
+	case errors.As(err, &parseErr):
+		return DisconnectReason{Cause: DisconnectParseError, Err: err}
+	default:
+		return DisconnectReason{Cause: DisconnectUnknown, Err: err}
+	}
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	cases := map[DisconnectCause][]error{
+		DisconnectClientClose: {nil, io.EOF, &quic.ApplicationError{ErrorCode: 0x00}},
+		DisconnectIdleTimeout: {&quic.IdleTimeoutError{}, fmt.Errorf("read: %w", &quic.IdleTimeoutError{})},
+		DisconnectClosed:      {net.ErrClosed},
+		DisconnectReadTimeout: {os.ErrDeadlineExceeded, fmt.Errorf("read: %w", os.ErrDeadlineExceeded)},
+		DisconnectParseError:  {&ParseError{Err: errors.New("unknown frame type")}},
+		DisconnectUnknown:     {errors.New("crashed"), &quic.ApplicationError{ErrorCode: 0xCC}},
+	}
+	for cause, errs := range cases {
+		for _, err := range errs {
+			assert.Equal(t, cause, disconnectReason(err).Cause, "err: %v", err)
+		}
+	}
+	assert.Equal(t, "ClientClose", disconnectReason(io.EOF).String())
+	assert.Equal(t, "Closed: use of closed network connection", disconnectReason(net.ErrClosed).String())
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// ErrInstanceNotFound is returned by DrainFunction when the token has no such instance.
+var ErrInstanceNotFound = errors.New("stream function instance not found")
+
+// DrainFunction takes the instance of the stream function out of rotation for rolling upgrades,
+// the new DataFrames are routed to the other instances of the token while the frames in-flight
+// to it are written, then done is called, so it can be disconnected without losing frames.
+// The stream functions don't ack the frames, so it's drained once it also sends no DataFrame
+// for the drain quiet period after the frames are written, see WithDrainQuietPeriod. done is
+// also called if it's disconnected before. The instance is identified by its connection id,
+// it stays out of rotation until it reconnects.
+func (s *Server) DrainFunction(token string, instanceID string, done func()) error {
+	a, ok := s.connector.App(instanceID)
+	if !ok || a.ClientType() != ClientTypeStreamFunction || !MatchName(token, a.Name()) {
+		return fmt.Errorf("%w: [%s](%s)", ErrInstanceNotFound, token, instanceID)
+	}
+	if _, ok := s.connector.Drain(instanceID); !ok {
+		return fmt.Errorf("%w: [%s](%s)", ErrInstanceNotFound, token, instanceID)
+	}
+	logger.Infof("%sdraining [%s](%s)", ServerLogPrefix, a.Name(), instanceID)
+	go func() {
+		// the frames routed to it before it's out of rotation are queued first
+		s.routing.wait()
+		<-s.connector.Flushed(instanceID)
+		s.waitQuiet(context.Background(), func() bool { return false }, func(connID string) bool { return connID == instanceID })
+		logger.Infof("%s[%s](%s) is drained", ServerLogPrefix, a.Name(), instanceID)
+		if done != nil {
+			done()
+		}
+	}()
+	return nil
+}
+
+// waitQuiet waits until the stream functions matched by their connection ids send no DataFrame
+// for the drain quiet period while busy reports false, or until the ctx is done. It returns as
+// soon as none of them is connected.
+func (s *Server) waitQuiet(ctx context.Context, busy func() bool, match func(connID string) bool) error {
+	var since time.Time
+	return s.waitDrained(ctx, func() bool {
+		now := s.opts.Clock.Now()
+		if busy() {
+			since = time.Time{}
+			return false
+		}
+		if since.IsZero() {
+			since = now
+		}
+		connected := false
+		for connID := range s.connector.GetSnapshot() {
+			a, ok := s.connector.App(connID)
+			if !ok || a.ClientType() != ClientTypeStreamFunction || !match(connID) {
+				continue
+			}
+			connected = true
+			if last := a.lastDataAt(); last.After(since) {
+				since = last
+			}
+		}
+		return !connected || now.Sub(since) >= s.opts.DrainQuietPeriod
+	})
+}
+
+// inflight tracks the calls in a section by two generations, so waiting for the calls entered
+// before doesn't block the new ones.
+type inflight struct {
+	waiting sync.Mutex // serializes wait
+	mu      sync.Mutex
+	gen     int
+	count   [2]int
+	idle    [2]chan struct{}
+}
+
+// enter the section, it returns the generation passed to leave.
+func (i *inflight) enter() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	gen := i.gen
+	i.count[gen]++
+	return gen
+}
+
+// leave the section entered by the generation.
+func (i *inflight) leave(gen int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.count[gen]--; i.count[gen] == 0 && i.idle[gen] != nil {
+		close(i.idle[gen])
+		i.idle[gen] = nil
+	}
+}
+
+// wait until the calls entered before it leave the section.
+func (i *inflight) wait() {
+	i.waiting.Lock()
+	defer i.waiting.Unlock()
+	i.mu.Lock()
+	gen := i.gen
+	i.gen = 1 - gen
+	if i.count[gen] == 0 {
+		i.mu.Unlock()
+		return
+	}
+	idle := make(chan struct{})
+	i.idle[gen] = idle
+	i.mu.Unlock()
+	<-idle
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerDrainFunction(t *testing.T) {
+	s := newTestServer("sfn-1")
+	send := func(frames ...frame.Frame) {
+		s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frames...)), w: ioutil.Discard}})
+	}
+	send(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil))
+
+	// tid-1 is in-flight to instance a
+	a := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "a-conn", Stream: &mockStream{r: r, w: a}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("a-conn") != nil }))
+	send(newDataFrame("tid-1", "source", 0x33))
+	b := connectSfn(s, "b-conn", "sfn-1", 0x33)
+
+	assert.ErrorIs(t, s.DrainFunction("sfn-2", "a-conn", nil), ErrInstanceNotFound)
+	assert.ErrorIs(t, s.DrainFunction("sfn-1", "c-conn", nil), ErrInstanceNotFound)
+	drained := make(chan struct{})
+	assert.NoError(t, s.DrainFunction("sfn-1", "a-conn", func() { close(drained) }))
+	assert.True(t, s.DumpRoutingTable()[0].Next[0].Instances[0].Draining)
+
+	// the new frames go to the other instance
+	send(newDataFrame("tid-2", "source", 0x33), newDataFrame("tid-3", "source", 0x33))
+	assert.True(t, waitFor(func() bool { return len(b.Frames()) == 2 }))
+	select {
+	case <-drained:
+		t.Fatal("drained before the in-flight frame is written")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(a.gate)
+	<-drained
+	frames := a.Frames()
+	assert.Len(t, frames, 1)
+	assert.Equal(t, "tid-1", frames[0].(*frame.DataFrame).TransactionID())
+}
+
+func TestServerDrainFunctionQuiet(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake), WithDrainQuietPeriod(time.Second))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	connectSfn(s, "a-conn", "sfn-1", 0x33)
+	drained := make(chan struct{})
+	assert.NoError(t, s.DrainFunction("sfn-1", "a-conn", func() { close(drained) }))
+	isDrained := func() bool {
+		select {
+		case <-drained:
+			return true
+		default:
+			return false
+		}
+	}
+	advance := func(d time.Duration) {
+		waitFor(func() bool { return fake.Waiters() > 0 })
+		fake.Advance(d)
+	}
+
+	advance(500 * time.Millisecond)
+	// the instance still sends the output of the frames it's processing
+	assert.NoError(t, s.mainFrameHandler(&Context{ConnID: "a-conn", Frame: newDataFrame("tid-1", "sfn-1", 0x34)}))
+	advance(800 * time.Millisecond)
+	assert.False(t, waitFor(isDrained))
+
+	// it's quiet for a second since the last DataFrame
+	advance(200 * time.Millisecond)
+	assert.True(t, waitFor(isDrained))
+}
+
+func TestInflight(t *testing.T) {
+	var i inflight
+	before := i.enter()
+	waited := make(chan struct{})
+	go func() {
+		i.wait()
+		close(waited)
+	}()
+	assert.True(t, waitFor(func() bool {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		return i.gen != before
+	}))
+
+	// the calls entered after aren't waited
+	after := i.enter()
+	select {
+	case <-waited:
+		t.Fatal("waited before the call entered before leaves")
+	case <-time.After(20 * time.Millisecond):
+	}
+	i.leave(before)
+	<-waited
+	i.leave(after)
+	i.wait()
+}
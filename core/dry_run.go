@@ -0,0 +1,137 @@
+package core
+
+import (
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// The reasons reported by DryRunRoute why the DataFrame would be dropped.
+const (
+	DropReasonInvalidFrame         = "invalid_frame"
+	DropReasonPaused               = "paused"
+	DropReasonInvalidTransactionID = "invalid_transaction_id"
+	DropReasonHopsExceeded         = "hops_exceeded"
+	DropReasonExpired              = "expired"
+	DropReasonFiltered             = "filtered"
+	DropReasonTransformed          = "transformed"
+	DropReasonNoRoute              = "no_route"
+	DropReasonNoFirstStage         = "no_first_stage"
+	DropReasonNoTerminalSink       = "no_terminal_sink"
+	DropReasonNoInstance           = "no_instance"
+)
+
+// TerminalSinkTarget is the target reported by DryRunRoute for the output of the terminal
+// stage which is written to the TerminalSink.
+const TerminalSinkTarget = "<terminal-sink>"
+
+// DryRunRoute reports where the DataFrame would be routed without writing it, e.g. to
+// validate the routing config against the sample frames in the CI. It runs the routing
+// decision of the frames received from the issuer: the hops, the expiry, the filter, the
+// transformer, the route of the issuer's app and the instances ready to receive the data
+// tag. The targets are the workflow tokens of the stages, which have an instance connected,
+// TerminalSinkTarget, or the dead-letter stream function, see WithDeadLetter. The dropReason
+// is one of the DropReason constants if there's no target.
+//
+// The issuer is looked up among the connected clients by its name, it's taken as a source
+// of the default app otherwise, so the config can be validated before the clients connect.
+// The sampled stages are reported as targets, the frame filter and the transformer are
+// invoked on a copy of the frame, nothing is counted by the stats.
+func (s *Server) DryRunRoute(f *frame.DataFrame) (targets []string, dropReason string) {
+	f, err := frame.DecodeToDataFrame(f.Encode())
+	if err != nil {
+		return nil, DropReasonInvalidFrame
+	}
+	from := f.Issuer()
+	appID, clientType := "", ClientTypeSource
+	if a, ok := s.issuerApp(from); ok {
+		appID, clientType = a.ID(), a.ClientType()
+	}
+
+	if clientType == ClientTypeSource && atomic.LoadInt32(&s.paused) == 1 {
+		return nil, DropReasonPaused
+	}
+	if validate := s.opts.TransactionIDValidator; validate != nil {
+		if err := validate(f.TransactionID()); err != nil {
+			return nil, DropReasonInvalidTransactionID
+		}
+	}
+	if f.Hops() >= s.opts.MaxHops {
+		return nil, DropReasonHopsExceeded
+	}
+	f.SetHops(f.Hops() + 1)
+	if f.Expired(s.opts.Clock.Now()) {
+		return nil, DropReasonExpired
+	}
+	if s.frameFilter != nil && !s.frameFilter(f) {
+		return nil, DropReasonFiltered
+	}
+	if s.frameTransformer != nil {
+		transformed, err := s.frameTransformer(f)
+		if err != nil || transformed == nil {
+			return nil, DropReasonTransformed
+		}
+		f = transformed
+	}
+
+	route := s.dryRunRouteOf(appID)
+	if isNilRoute(route) {
+		return nil, DropReasonNoRoute
+	}
+	routes := forwardRoutes(route, from, clientType)
+	if len(routes) == 0 && clientType == ClientTypeStreamFunction {
+		if s.opts.TerminalSink == nil {
+			return nil, DropReasonNoTerminalSink
+		}
+		return []string{TerminalSinkTarget}, ""
+	}
+	if len(routes) == 0 {
+		return nil, DropReasonNoFirstStage
+	}
+	size := len(f.GetCarriage())
+	for _, to := range routes {
+		if len(s.connector.Candidates(appID, to, f.GetDataTag(), size, s.routingKey(f))) > 0 {
+			targets = append(targets, to)
+		}
+	}
+	if len(targets) == 0 && s.opts.DeadLetter != "" && !s.isDeadLetter(from) {
+		if len(s.deadLetters(appID, f, size)) > 0 {
+			return []string{s.opts.DeadLetter}, ""
+		}
+	}
+	if len(targets) == 0 {
+		return nil, DropReasonNoInstance
+	}
+	return targets, ""
+}
+
+// issuerApp returns the connected app of the issuer, the sources and the stream functions
+// are preferred to the others of the same name.
+func (s *Server) issuerApp(name string) (*app, bool) {
+	var found *app
+	for connID := range s.connector.GetSnapshot() {
+		a, ok := s.connector.App(connID)
+		if !ok || a.Name() != name {
+			continue
+		}
+		if a.ClientType() == ClientTypeSource || a.ClientType() == ClientTypeStreamFunction {
+			return a, true
+		}
+		found = a
+	}
+	return found, found != nil
+}
+
+// dryRunRouteOf returns the route of the app stored at the handshake, or the route of the
+// router if no client of the app has connected.
+func (s *Server) dryRunRouteOf(appID string) Route {
+	if cached, ok := s.opts.Store.Get(appID); ok {
+		if route, ok := cached.(Route); ok {
+			return route
+		}
+	}
+	if s.router == nil {
+		return nil
+	}
+	return s.router.Route(appID)
+}
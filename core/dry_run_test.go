@@ -0,0 +1,66 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerDryRunRoute(t *testing.T) {
+	s := NewServer("test-zipper", WithMaxHops(3))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+
+	// the source isn't connected, it's routed by the default route
+	targets, reason := s.DryRunRoute(newDataFrame("tid-1", "source", 0x33))
+	assert.Empty(t, targets)
+	assert.Equal(t, DropReasonNoInstance, reason)
+
+	sfn1 := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	connectSfn(s, "sfn-2-conn", "sfn-2", 0x34)
+	f := newDataFrame("tid-1", "source", 0x33)
+	targets, reason = s.DryRunRoute(f)
+	assert.Equal(t, []string{"sfn-1"}, targets)
+	assert.Empty(t, reason)
+	// nothing is written, the frame is intact
+	assert.Empty(t, sfn1.Frames())
+	assert.Equal(t, uint32(0), f.Hops())
+	assert.Equal(t, int64(0), s.StatsFrames()[frame.TagOfDataFrame].Count)
+
+	// the output of a stage goes to the next stage, the terminal output has no sink
+	targets, _ = s.DryRunRoute(newDataFrame("tid-1", "sfn-1", 0x34))
+	assert.Equal(t, []string{"sfn-2"}, targets)
+	_, reason = s.DryRunRoute(newDataFrame("tid-1", "sfn-1", 0x35))
+	assert.Equal(t, DropReasonNoInstance, reason)
+	_, reason = s.DryRunRoute(newDataFrame("tid-1", "sfn-2", 0x35))
+	assert.Equal(t, DropReasonNoTerminalSink, reason)
+	s.opts.TerminalSink = &mockSink{}
+	targets, _ = s.DryRunRoute(newDataFrame("tid-1", "sfn-2", 0x35))
+	assert.Equal(t, []string{TerminalSinkTarget}, targets)
+
+	hops := newDataFrame("tid-1", "source", 0x33)
+	hops.SetHops(3)
+	_, reason = s.DryRunRoute(hops)
+	assert.Equal(t, DropReasonHopsExceeded, reason)
+
+	expired := newDataFrame("tid-1", "source", 0x33)
+	expired.SetExpiresAt(time.Now().Add(-time.Second))
+	_, reason = s.DryRunRoute(expired)
+	assert.Equal(t, DropReasonExpired, reason)
+
+	s.SetFrameFilter(func(f *frame.DataFrame) bool { return f.TransactionID() != "filtered" })
+	_, reason = s.DryRunRoute(newDataFrame("filtered", "source", 0x33))
+	assert.Equal(t, DropReasonFiltered, reason)
+	assert.Equal(t, int64(0), s.StatsFiltered())
+
+	s.Pause()
+	_, reason = s.DryRunRoute(newDataFrame("tid-1", "source", 0x33))
+	assert.Equal(t, DropReasonPaused, reason)
+}
+
+func TestServerDryRunRouteNoRouter(t *testing.T) {
+	s := NewServer("test-zipper")
+	_, reason := s.DryRunRoute(newDataFrame("tid-1", "source", 0x33))
+	assert.Equal(t, DropReasonNoRoute, reason)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// FanoutError is the aggregate of the targets failed while a DataFrame is routed to several
+// targets, e.g. a stream reset by the peer. The DataFrame is still delivered to the others,
+// the targets whose streams are broken are evicted.
+type FanoutError struct {
+	// TransactionID is the transaction id of the DataFrame.
+	TransactionID string
+	// Errors are the errors of the failed targets by their connection ids.
+	Errors map[string]error
+}
+
+func (e *FanoutError) Error() string {
+	connIDs := make([]string, 0, len(e.Errors))
+	for connID := range e.Errors {
+		connIDs = append(connIDs, connID)
+	}
+	sort.Strings(connIDs)
+	details := make([]string, 0, len(connIDs))
+	for _, connID := range connIDs {
+		details = append(details, fmt.Sprintf("%s: %v", connID, e.Errors[connID]))
+	}
+	return fmt.Sprintf("tid=%s, %d targets failed: %s", e.TransactionID, len(connIDs), strings.Join(details, "; "))
+}
+
+// add the error of a target.
+func (e *FanoutError) add(connID string, err error) {
+	if e.Errors == nil {
+		e.Errors = make(map[string]error)
+	}
+	e.Errors[connID] = err
+}
+
+// evictFailed evicts the targets whose streams are broken by the fan-out, the frames are no
+// longer routed to them. The others are kept, e.g. the target has been removed meanwhile.
+func (s *Server) evictFailed(e *FanoutError) {
+	for connID, err := range e.Errors {
+		if s.connector.Broken(connID) {
+			s.evictBroken(connID, err)
+		}
+	}
+}
+
+// evictBroken deregisters the target whose stream is broken by the write error and closes it.
+func (s *Server) evictBroken(connID string, err error) {
+	stream := s.connector.Get(connID)
+	if stream == nil {
+		// it's gone already, e.g. it's deregistered meanwhile
+		return
+	}
+	s.session(connID).Logger().Warnf("%s[%s] write error: %v, evict it", ServerLogPrefix, connID, err)
+	s.deregister(connID, DisconnectReason{Cause: DisconnectWriteError, Err: err})
+	if qs, ok := stream.(quic.Stream); ok {
+		qs.CancelRead(0xC5)
+	}
+	stream.Close()
+}
+
+// brokenStream evicts the target once a write of its send queue fails, so it's disconnected
+// even if no more frames are routed to it.
+func (s *Server) brokenStream(connID string, err error) {
+	atomic.AddInt64(&s.counterOfBrokenStreams, 1)
+	s.evictBroken(connID, err)
+}
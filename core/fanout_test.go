@@ -0,0 +1,94 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// resetWriter fails every write as the stream reset by the peer.
+type resetWriter struct{}
+
+func (resetWriter) Write(p []byte) (int, error) {
+	return 0, &quic.StreamError{StreamID: 4, ErrorCode: 0x01}
+}
+
+func TestHandleDataFrameFanoutPartialFailure(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2", "sfn-3")
+	reasons := make(chan DisconnectReason, 1)
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) { reasons <- reason })
+	events := make(chan DataFrameEvent, 2)
+	s.OnDataFrame(func(e DataFrameEvent) { events <- e })
+	// the broken stream isn't evicted by the drain, so tid-2 is pushed to it
+	s.connector.OnBroken(nil)
+
+	sfn1 := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-2-conn", Stream: &mockStream{r: r, w: resetWriter{}}})
+	w.Write(frame.NewHandshakeFrame("sfn-2", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-2-conn") != nil }))
+	sfn3 := connectSfn(s, "sfn-3-conn", "sfn-3", 0x33)
+
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))), w: ioutil.Discard}})
+	assert.Nil(t, (<-events).Err)
+	// the stream of sfn-2 is reset while tid-1 is written to it
+	assert.True(t, waitFor(func() bool { return !s.connector.Live("sfn-2-conn") }))
+
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(newDataFrame("tid-2", "source", 0x33).Encode()), w: ioutil.Discard}})
+	e := <-events
+	assert.ElementsMatch(t, []string{"sfn-1-conn", "sfn-3-conn"}, e.Targets)
+	var fanoutErr *FanoutError
+	if assert.True(t, errors.As(e.Err, &fanoutErr)) {
+		assert.Equal(t, "tid-2", fanoutErr.TransactionID)
+		assert.Len(t, fanoutErr.Errors, 1)
+		var streamErr *quic.StreamError
+		assert.True(t, errors.As(fanoutErr.Errors["sfn-2-conn"], &streamErr))
+	}
+	assert.EqualValues(t, 1, s.Stats().Dropped.WriteFailed)
+
+	// the healthy targets receive all the frames, the reset one is evicted
+	for _, sfn := range []*syncBuffer{sfn1, sfn3} {
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	}
+	_, ok := s.connector.App("sfn-2-conn")
+	assert.False(t, ok)
+	assert.Equal(t, DisconnectWriteError, (<-reasons).Cause)
+}
+
+func TestServerEvictBrokenStream(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	reasons := make(chan DisconnectReason, 1)
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) { reasons <- reason })
+	sfn1 := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-2-conn", Stream: &mockStream{r: r, w: resetWriter{}}})
+	w.Write(frame.NewHandshakeFrame("sfn-2", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-2-conn") != nil }))
+
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))), w: ioutil.Discard}})
+
+	// the target is disconnected once the write fails, without waiting for the next frame
+	assert.Equal(t, DisconnectWriteError, (<-reasons).Cause)
+	_, ok := s.connector.App("sfn-2-conn")
+	assert.False(t, ok)
+	assert.EqualValues(t, 1, s.Stats().BrokenStreams)
+	assert.True(t, waitFor(func() bool { return len(sfn1.Frames()) == 1 }))
+}
+
+func TestFanoutErrorMessage(t *testing.T) {
+	e := &FanoutError{TransactionID: "tid-1"}
+	e.add("conn-b", io.ErrClosedPipe)
+	e.add("conn-a", errors.New("reset"))
+	assert.Equal(t, "tid=tid-1, 2 targets failed: conn-a: reset; conn-b: io: read/write on closed pipe", e.Error())
+}
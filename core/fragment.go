@@ -0,0 +1,285 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// MaxFragments is the max number of the fragments of a message, the messages declare more
+// are dropped by the Reassembler.
+const MaxFragments = 4096
+
+const (
+	// DefaultMaxPartialMessages is the default max number of the incomplete messages held by
+	// the Reassembler, the oldest is evicted beyond it.
+	DefaultMaxPartialMessages = 1024
+	// DefaultMaxPartialMessagesPerConn is the default max number of the incomplete messages
+	// of a connection held by the Reassembler, the oldest of the connection is evicted beyond
+	// it, so a single sender can't take all the room.
+	DefaultMaxPartialMessagesPerConn = 64
+)
+
+// SplitDataFrame splits the carriage of the DataFrame into the fragments of at most size bytes,
+// the transaction id of the DataFrame is the message id of the fragments. The DataFrame is
+// returned as it is if the carriage fits in size.
+func SplitDataFrame(f *frame.DataFrame, size int) []*frame.DataFrame {
+	carriage := f.GetCarriage()
+	if size <= 0 || len(carriage) <= size {
+		return []*frame.DataFrame{f}
+	}
+	total := (len(carriage) + size - 1) / size
+	fragments := make([]*frame.DataFrame, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(carriage) {
+			end = len(carriage)
+		}
+		fragment := frame.NewDataFrame()
+		fragment.SetTransactionID(fmt.Sprintf("%s/%d", f.TransactionID(), i))
+		fragment.SetIssuer(f.Issuer())
+		fragment.SetPriority(f.Priority())
+		fragment.SetHops(f.Hops())
+		fragment.SetCreatedAt(f.CreatedAt())
+		fragment.SetMetadata(f.Metadata())
+		fragment.SetSequence(f.Sequence())
+		fragment.SetExpiresAt(f.ExpiresAt())
+		fragment.SetFragment(&frame.Fragment{MessageID: f.TransactionID(), Index: uint32(i), Total: uint32(total)})
+		fragment.SetCarriage(f.GetDataTag(), carriage[i*size:end])
+		fragments = append(fragments, fragment)
+	}
+	return fragments
+}
+
+// Reassembler buffers the fragments until all the fragments of a message are received, the
+// incomplete messages are dropped after the timeout since their first fragment. It's used
+// by the zipper or the terminal stream function. It holds at most DefaultMaxPartialMessages
+// incomplete messages, at most DefaultMaxPartialMessagesPerConn of them per connection, the
+// oldest are evicted beyond them, see SetLimits.
+type Reassembler struct {
+	timeout     time.Duration
+	mu          sync.Mutex
+	messages    map[string]*partialMessage // issuer + message id -> fragments
+	owners      map[string]int             // the number of the incomplete messages per connection
+	maxMessages int                        // 0 means unlimited
+	maxPerConn  int                        // 0 means unlimited
+	lastSweep   time.Time
+	expired     int64
+	evicted     int64
+	budget      *memoryBudget // the incomplete messages are evicted once it's exceeded
+}
+
+type partialMessage struct {
+	owner     string                      // the connection sends the fragments
+	fragments map[uint32]*frame.DataFrame // index -> fragment, it's filled as they're received
+	total     uint32
+	startedAt time.Time
+	size      int64 // accounted by the memory budget
+}
+
+// NewReassembler creates a Reassembler drops the incomplete messages after the timeout.
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		timeout:     timeout,
+		messages:    make(map[string]*partialMessage),
+		owners:      make(map[string]int),
+		maxMessages: DefaultMaxPartialMessages,
+		maxPerConn:  DefaultMaxPartialMessagesPerConn,
+	}
+}
+
+// SetLimits sets the max number of the incomplete messages in total and per connection, the
+// oldest are evicted beyond them. 0 means the default limit, a negative limit means unlimited.
+func (r *Reassembler) SetLimits(total int, perConn int) {
+	r.mu.Lock()
+	r.maxMessages = limitOrDefault(total, DefaultMaxPartialMessages)
+	r.maxPerConn = limitOrDefault(perConn, DefaultMaxPartialMessagesPerConn)
+	r.mu.Unlock()
+}
+
+func limitOrDefault(limit int, def int) int {
+	switch {
+	case limit < 0:
+		return 0
+	case limit == 0:
+		return def
+	}
+	return limit
+}
+
+// Add a DataFrame received at now, it returns the reassembled DataFrame and true once the
+// last fragment of the message is added. The DataFrame which isn't a fragment is returned
+// as it is, the invalid fragment is dropped, e.g. the total doesn't match the others. The
+// fragments are counted to the connection of their issuer.
+func (r *Reassembler) Add(f *frame.DataFrame, now time.Time) (*frame.DataFrame, bool) {
+	return r.add(f.Issuer(), f, now)
+}
+
+// add is Add whose fragments are counted to the owner, i.e. the connection received them.
+func (r *Reassembler) add(owner string, f *frame.DataFrame, now time.Time) (*frame.DataFrame, bool) {
+	fragment := f.Fragment()
+	if fragment == nil {
+		return f, true
+	}
+	if fragment.Total == 0 || fragment.Total > MaxFragments || fragment.Index >= fragment.Total {
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(now)
+
+	key := f.Issuer() + "\x00" + fragment.MessageID
+	m, ok := r.messages[key]
+	if !ok {
+		// the room of the new message
+		for r.maxPerConn > 0 && r.owners[owner] >= r.maxPerConn && r.evictLocked(owner, key) {
+		}
+		for r.maxMessages > 0 && len(r.messages) >= r.maxMessages && r.evictLocked("", key) {
+		}
+		m = &partialMessage{owner: owner, fragments: make(map[uint32]*frame.DataFrame), total: fragment.Total, startedAt: now}
+		r.messages[key] = m
+		r.owners[owner]++
+	}
+	if m.total != fragment.Total {
+		return nil, false
+	}
+	if old, ok := m.fragments[fragment.Index]; ok {
+		m.size -= frameSize(old)
+		r.budget.release(frameSize(old))
+	}
+	m.fragments[fragment.Index] = f
+	m.size += frameSize(f)
+	r.budget.add(frameSize(f))
+	if len(m.fragments) < int(m.total) {
+		for r.budget.over() && r.evictOldestLocked(key) {
+		}
+		return nil, false
+	}
+	r.remove(key, m)
+	r.budget.release(m.size)
+	fragments := make([]*frame.DataFrame, m.total)
+	for i, f := range m.fragments {
+		fragments[i] = f
+	}
+	return reassemble(fragment.MessageID, fragments), true
+}
+
+// remove the incomplete message of the key.
+func (r *Reassembler) remove(key string, m *partialMessage) {
+	delete(r.messages, key)
+	if r.owners[m.owner]--; r.owners[m.owner] <= 0 {
+		delete(r.owners, m.owner)
+	}
+}
+
+// evictLocked drops the oldest incomplete message of the owner, or of all the owners if it's
+// empty, except the one of the key for the limits, returns false if there's none.
+func (r *Reassembler) evictLocked(owner string, except string) bool {
+	oldest := ""
+	var startedAt time.Time
+	for key, m := range r.messages {
+		if key != except && (owner == "" || m.owner == owner) && (oldest == "" || m.startedAt.Before(startedAt)) {
+			oldest, startedAt = key, m.startedAt
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	m := r.messages[oldest]
+	r.remove(oldest, m)
+	r.budget.release(m.size)
+	r.evicted++
+	return true
+}
+
+// evictOldest drops the oldest incomplete message for the memory budget, returns false if
+// there's none.
+func (r *Reassembler) evictOldest() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evictOldestLocked("")
+}
+
+// evictOldestLocked drops the oldest incomplete message except the one of the key.
+func (r *Reassembler) evictOldestLocked(except string) bool {
+	oldest := ""
+	var startedAt time.Time
+	for key, m := range r.messages {
+		if key != except && (oldest == "" || m.startedAt.Before(startedAt)) {
+			oldest, startedAt = key, m.startedAt
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	m := r.messages[oldest]
+	r.remove(oldest, m)
+	r.budget.drop(m.size)
+	return true
+}
+
+// sweep drops the messages older than the timeout, at most once per half of the timeout.
+func (r *Reassembler) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.timeout/2 {
+		return
+	}
+	r.lastSweep = now
+	for key, m := range r.messages {
+		if now.Sub(m.startedAt) >= r.timeout {
+			r.remove(key, m)
+			r.budget.release(m.size)
+			r.expired++
+		}
+	}
+}
+
+// Pending returns the number of the incomplete messages.
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+// Expired returns how many incomplete messages are dropped after the timeout.
+func (r *Reassembler) Expired() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expired
+}
+
+// Evicted returns how many incomplete messages are dropped for the limits, see SetLimits.
+func (r *Reassembler) Evicted() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.evicted
+}
+
+// resetExpired zeroes the expired and the evicted messages.
+func (r *Reassembler) resetExpired() {
+	r.mu.Lock()
+	r.expired = 0
+	r.evicted = 0
+	r.mu.Unlock()
+}
+
+// reassemble concatenates the carriages of the fragments into the first fragment, whose
+// transaction id becomes the message id.
+func reassemble(messageID string, fragments []*frame.DataFrame) *frame.DataFrame {
+	size := 0
+	for _, f := range fragments {
+		size += len(f.GetCarriage())
+	}
+	carriage := make([]byte, 0, size)
+	for _, f := range fragments {
+		carriage = append(carriage, f.GetCarriage()...)
+	}
+	last := fragments[len(fragments)-1]
+	f := fragments[0]
+	f.SetTransactionID(messageID)
+	f.SetFragment(nil)
+	f.SetHops(last.Hops())
+	f.SetCarriage(f.GetDataTag(), carriage)
+	return f
+}
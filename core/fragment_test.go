@@ -0,0 +1,97 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestSplitDataFrame(t *testing.T) {
+	f := newDataFrame("msg-1", "source", 0x33)
+	f.SetCarriage(0x33, []byte("0123456789"))
+	f.SetMetadata([]byte("meta"))
+
+	fragments := SplitDataFrame(f, 4)
+	assert.Len(t, fragments, 3)
+	for i, fragment := range fragments {
+		assert.Equal(t, &frame.Fragment{MessageID: "msg-1", Index: uint32(i), Total: 3}, fragment.Fragment())
+		assert.Equal(t, "source", fragment.Issuer())
+		assert.Equal(t, []byte("meta"), fragment.Metadata())
+		assert.EqualValues(t, 0x33, fragment.GetDataTag())
+	}
+	assert.Equal(t, "msg-1/2", fragments[2].TransactionID())
+	assert.Equal(t, []byte("89"), fragments[2].GetCarriage())
+
+	// the small carriage is not split
+	assert.Equal(t, []*frame.DataFrame{f}, SplitDataFrame(f, 10))
+}
+
+func TestReassembler(t *testing.T) {
+	r := NewReassembler(time.Second)
+	now := time.Unix(0, 0)
+	f := newDataFrame("msg-1", "source", 0x33)
+	f.SetCarriage(0x33, []byte("0123456789"))
+	fragments := SplitDataFrame(f, 4)
+
+	// out of order and duplicated
+	for _, i := range []int{2, 0, 2} {
+		_, ok := r.Add(fragments[i], now)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, r.Pending())
+	whole, ok := r.Add(fragments[1], now)
+	assert.True(t, ok)
+	assert.Equal(t, "msg-1", whole.TransactionID())
+	assert.Nil(t, whole.Fragment())
+	assert.Equal(t, []byte("0123456789"), whole.GetCarriage())
+	assert.Equal(t, 0, r.Pending())
+
+	// the whole message is passed through
+	plain := newDataFrame("tid", "source", 0x33)
+	got, ok := r.Add(plain, now)
+	assert.True(t, ok)
+	assert.Equal(t, plain, got)
+
+	// the incomplete message expires
+	fragments = SplitDataFrame(f, 4)
+	r.Add(fragments[0], now)
+	r.Add(fragments[1], now.Add(2*time.Second))
+	assert.EqualValues(t, 1, r.Expired())
+	assert.Equal(t, 1, r.Pending())
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	r := NewReassembler(time.Minute)
+	r.SetLimits(3, 2)
+	now := time.Unix(0, 0)
+	fragmentOf := func(tid string) *frame.DataFrame {
+		f := newDataFrame(tid, "source", 0x33)
+		f.SetCarriage(0x33, []byte("0123456789"))
+		return SplitDataFrame(f, 4)[0]
+	}
+
+	// the oldest message of the connection is evicted beyond its limit
+	for i, tid := range []string{"a-1", "a-2", "a-3"} {
+		r.add("conn-a", fragmentOf(tid), now.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, 2, r.Pending())
+	assert.EqualValues(t, 1, r.Evicted())
+
+	// the oldest message overall is evicted beyond the total limit
+	r.add("conn-b", fragmentOf("b-1"), now.Add(3*time.Second))
+	r.add("conn-c", fragmentOf("c-1"), now.Add(4*time.Second))
+	assert.Equal(t, 3, r.Pending())
+	assert.EqualValues(t, 2, r.Evicted())
+	assert.Equal(t, map[string]int{"conn-b": 1, "conn-c": 1, "conn-a": 1}, r.owners)
+
+	// the fragments of the buffered message don't evict anything
+	f := newDataFrame("c-1", "source", 0x33)
+	f.SetCarriage(0x33, []byte("0123456789"))
+	r.add("conn-c", SplitDataFrame(f, 4)[1], now.Add(5*time.Second))
+	assert.EqualValues(t, 2, r.Evicted())
+
+	r.resetExpired()
+	assert.EqualValues(t, 0, r.Evicted())
+}
@@ -3,7 +3,14 @@ package frame
 import "github.com/yomorun/y3"
 
 // AcceptedFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_ACCEPTED_FRAME
-type AcceptedFrame struct{}
+type AcceptedFrame struct {
+	// Heartbeat is the interval in milliseconds granted by the server for the heartbeat
+	// requested by the handshake, 0 means it isn't negotiated.
+	Heartbeat uint32
+	// ControlStream reports whether the server accepts the control stream requested by the
+	// handshake, the client keeps the single stream if it's false, e.g. an older server.
+	ControlStream bool
+}
 
 // NewAcceptedFrame creates a new AcceptedFrame with a given TagID of user's data
 func NewAcceptedFrame() *AcceptedFrame {
@@ -18,7 +25,20 @@ func (m *AcceptedFrame) Type() Type {
 // Encode to Y3 encoded bytes.
 func (m *AcceptedFrame) Encode() []byte {
 	accepted := y3.NewNodePacketEncoder(byte(m.Type()))
-	accepted.AddBytes(nil)
+	if m.Heartbeat == 0 && !m.ControlStream {
+		accepted.AddBytes(nil)
+		return accepted.Encode()
+	}
+	if m.Heartbeat > 0 {
+		heartbeat := y3.NewPrimitivePacketEncoder(byte(TagOfAcceptedHeartbeat))
+		heartbeat.SetUInt32Value(m.Heartbeat)
+		accepted.AddPrimitivePacket(heartbeat)
+	}
+	if m.ControlStream {
+		controlStream := y3.NewPrimitivePacketEncoder(byte(TagOfAcceptedControlStream))
+		controlStream.SetBoolValue(true)
+		accepted.AddPrimitivePacket(controlStream)
+	}
 
 	return accepted.Encode()
 }
@@ -30,5 +50,20 @@ func DecodeToAcceptedFrame(buf []byte) (*AcceptedFrame, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &AcceptedFrame{}, nil
+	accepted := &AcceptedFrame{}
+	if heartbeatBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfAcceptedHeartbeat)]; ok {
+		heartbeat, err := heartbeatBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		accepted.Heartbeat = heartbeat
+	}
+	if controlStreamBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfAcceptedControlStream)]; ok {
+		controlStream, err := controlStreamBlock.ToBool()
+		if err != nil {
+			return nil, err
+		}
+		accepted.ControlStream = controlStream
+	}
+	return accepted, nil
 }
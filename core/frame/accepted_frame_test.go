@@ -17,3 +17,18 @@ func TestAcceptedFrameDecode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte{0x80 | byte(TagOfAcceptedFrame), 0x00}, ping.Encode())
 }
+
+func TestAcceptedFrameHeartbeat(t *testing.T) {
+	f := &AcceptedFrame{Heartbeat: 60000}
+	accepted, err := DecodeToAcceptedFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 60000, accepted.Heartbeat)
+}
+
+func TestAcceptedFrameControlStream(t *testing.T) {
+	f := &AcceptedFrame{ControlStream: true}
+	accepted, err := DecodeToAcceptedFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.True(t, accepted.ControlStream)
+	assert.Zero(t, accepted.Heartbeat)
+}
@@ -0,0 +1,73 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/yomorun/y3"
+)
+
+// errMalformedAck is returned when the transaction ids of the AckFrame are truncated.
+var errMalformedAck = errors.New("frame: malformed transaction ids of AckFrame")
+
+// AckFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_ACK_FRAME, it acknowledges
+// the DataFrames received by their transaction ids, the ids not in it are retransmitted.
+type AckFrame struct {
+	transactionIDs []string
+}
+
+// NewAckFrame creates a new AckFrame acknowledging the transaction ids.
+func NewAckFrame(transactionIDs ...string) *AckFrame {
+	return &AckFrame{transactionIDs: transactionIDs}
+}
+
+// Type gets the type of Frame.
+func (m *AckFrame) Type() Type {
+	return TagOfAckFrame
+}
+
+// TransactionIDs returns the acknowledged transaction ids.
+func (m *AckFrame) TransactionIDs() []string {
+	return m.transactionIDs
+}
+
+// Encode to Y3 encoded bytes, the transaction ids are length-prefixed by uvarint in one packet.
+func (m *AckFrame) Encode() []byte {
+	buf := make([]byte, 0, len(m.transactionIDs)*37)
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, tid := range m.transactionIDs {
+		n := binary.PutUvarint(size, uint64(len(tid)))
+		buf = append(buf, size[:n]...)
+		buf = append(buf, tid...)
+	}
+	tids := y3.NewPrimitivePacketEncoder(byte(TagOfAckTransactionIDs))
+	tids.SetBytesValue(buf)
+
+	ack := y3.NewNodePacketEncoder(byte(m.Type()))
+	ack.AddPrimitivePacket(tids)
+
+	return ack.Encode()
+}
+
+// DecodeToAckFrame decodes Y3 encoded bytes to AckFrame.
+func DecodeToAckFrame(buf []byte) (*AckFrame, error) {
+	nodeBlock := y3.NodePacket{}
+	_, err := y3.DecodeToNodePacket(buf, &nodeBlock)
+	if err != nil {
+		return nil, err
+	}
+	ack := &AckFrame{}
+	if tidsBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfAckTransactionIDs)]; ok {
+		data := tidsBlock.ToBytes()
+		for len(data) > 0 {
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, errMalformedAck
+			}
+			data = data[n:]
+			ack.transactionIDs = append(ack.transactionIDs, string(data[:size]))
+			data = data[size:]
+		}
+	}
+	return ack, nil
+}
@@ -0,0 +1,26 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckFrame(t *testing.T) {
+	f := NewAckFrame("tid-1", "", "tid-3")
+	ack, err := DecodeToAckFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tid-1", "", "tid-3"}, ack.TransactionIDs())
+	assert.Equal(t, f.Encode(), ack.Encode())
+
+	ack, err = DecodeToAckFrame(NewAckFrame().Encode())
+	assert.NoError(t, err)
+	assert.Empty(t, ack.TransactionIDs())
+}
+
+func TestAckFrameMalformed(t *testing.T) {
+	// the id claims 5 bytes, only 2 are present
+	buf := []byte{0x80 | byte(TagOfAckFrame), 0x05, byte(TagOfAckTransactionIDs), 0x03, 0x05, 't', 'i'}
+	_, err := DecodeToAckFrame(buf)
+	assert.Error(t, err)
+}
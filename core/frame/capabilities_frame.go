@@ -0,0 +1,162 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/yomorun/y3"
+)
+
+// errMalformedVersions is returned when the versions of the CapabilitiesFrame are truncated.
+var errMalformedVersions = errors.New("frame: malformed versions of CapabilitiesFrame")
+
+// CapabilitiesRequestFrame is a Y3 encoded bytes, Tag is a fixed value
+// TYPE_ID_CAPABILITIES_REQUEST_FRAME, the client sends it to discover what the server supports,
+// it's allowed before the handshake. The server responds a CapabilitiesFrame.
+type CapabilitiesRequestFrame struct{}
+
+// NewCapabilitiesRequestFrame creates a new CapabilitiesRequestFrame.
+func NewCapabilitiesRequestFrame() *CapabilitiesRequestFrame {
+	return &CapabilitiesRequestFrame{}
+}
+
+// Type gets the type of Frame.
+func (m *CapabilitiesRequestFrame) Type() Type {
+	return TagOfCapabilitiesRequestFrame
+}
+
+// Encode to Y3 encoded bytes.
+func (m *CapabilitiesRequestFrame) Encode() []byte {
+	request := y3.NewNodePacketEncoder(byte(m.Type()))
+	request.AddBytes(nil)
+
+	return request.Encode()
+}
+
+// DecodeToCapabilitiesRequestFrame decodes Y3 encoded bytes to CapabilitiesRequestFrame.
+func DecodeToCapabilitiesRequestFrame(buf []byte) (*CapabilitiesRequestFrame, error) {
+	nodeBlock := y3.NodePacket{}
+	_, err := y3.DecodeToNodePacket(buf, &nodeBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &CapabilitiesRequestFrame{}, nil
+}
+
+// CapabilitiesFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_CAPABILITIES_FRAME,
+// it's the capability set of the server responding the CapabilitiesRequestFrame. The unknown
+// fields are ignored when decoding, so a capability can be added without breaking the
+// older clients.
+type CapabilitiesFrame struct {
+	// Compression are the codecs of the compressed streams, e.g. "deflate".
+	Compression []string
+	// Datagram reports whether the server receives the QUIC datagrams.
+	Datagram bool
+	// MaxFrameSize is the max size of the frames read after the handshake, 0 means no limit.
+	MaxFrameSize uint32
+	// Versions are the QUIC versions the server supports.
+	Versions []uint32
+	// AuthRequired reports whether the handshake must carry a credential.
+	AuthRequired bool
+	// ControlStream reports whether the server accepts the control stream.
+	ControlStream bool
+}
+
+// Type gets the type of Frame.
+func (m *CapabilitiesFrame) Type() Type {
+	return TagOfCapabilitiesFrame
+}
+
+// Encode to Y3 encoded bytes, the compression codecs are length-prefixed by uvarint and the
+// versions are big-endian uint32 in one packet.
+func (m *CapabilitiesFrame) Encode() []byte {
+	codecs := make([]byte, 0, 16)
+	for _, codec := range m.Compression {
+		codecs = appendString(codecs, codec)
+	}
+	compression := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesCompression))
+	compression.SetBytesValue(codecs)
+	datagram := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesDatagram))
+	datagram.SetBoolValue(m.Datagram)
+	maxFrameSize := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesMaxFrameSize))
+	maxFrameSize.SetUInt32Value(m.MaxFrameSize)
+	buf := make([]byte, 4*len(m.Versions))
+	for i, v := range m.Versions {
+		binary.BigEndian.PutUint32(buf[4*i:], v)
+	}
+	versions := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesVersions))
+	versions.SetBytesValue(buf)
+	authRequired := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesAuthRequired))
+	authRequired.SetBoolValue(m.AuthRequired)
+	controlStream := y3.NewPrimitivePacketEncoder(byte(TagOfCapabilitiesControlStream))
+	controlStream.SetBoolValue(m.ControlStream)
+
+	capabilities := y3.NewNodePacketEncoder(byte(m.Type()))
+	capabilities.AddPrimitivePacket(compression)
+	capabilities.AddPrimitivePacket(datagram)
+	capabilities.AddPrimitivePacket(maxFrameSize)
+	capabilities.AddPrimitivePacket(versions)
+	capabilities.AddPrimitivePacket(authRequired)
+	capabilities.AddPrimitivePacket(controlStream)
+
+	return capabilities.Encode()
+}
+
+// DecodeToCapabilitiesFrame decodes Y3 encoded bytes to CapabilitiesFrame.
+func DecodeToCapabilitiesFrame(buf []byte) (*CapabilitiesFrame, error) {
+	nodeBlock := y3.NodePacket{}
+	_, err := y3.DecodeToNodePacket(buf, &nodeBlock)
+	if err != nil {
+		return nil, err
+	}
+	capabilities := &CapabilitiesFrame{}
+	if compressionBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesCompression)]; ok {
+		codecs := compressionBlock.ToBytes()
+		for len(codecs) > 0 {
+			codec, rest, err := readString(codecs)
+			if err != nil {
+				return nil, err
+			}
+			capabilities.Compression = append(capabilities.Compression, codec)
+			codecs = rest
+		}
+	}
+	if datagramBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesDatagram)]; ok {
+		datagram, err := datagramBlock.ToBool()
+		if err != nil {
+			return nil, err
+		}
+		capabilities.Datagram = datagram
+	}
+	if maxFrameSizeBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesMaxFrameSize)]; ok {
+		maxFrameSize, err := maxFrameSizeBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		capabilities.MaxFrameSize = maxFrameSize
+	}
+	if versionsBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesVersions)]; ok {
+		buf := versionsBlock.ToBytes()
+		if len(buf)%4 != 0 {
+			return nil, errMalformedVersions
+		}
+		for i := 0; i < len(buf); i += 4 {
+			capabilities.Versions = append(capabilities.Versions, binary.BigEndian.Uint32(buf[i:]))
+		}
+	}
+	if authRequiredBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesAuthRequired)]; ok {
+		authRequired, err := authRequiredBlock.ToBool()
+		if err != nil {
+			return nil, err
+		}
+		capabilities.AuthRequired = authRequired
+	}
+	if controlStreamBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCapabilitiesControlStream)]; ok {
+		controlStream, err := controlStreamBlock.ToBool()
+		if err != nil {
+			return nil, err
+		}
+		capabilities.ControlStream = controlStream
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,36 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesRequestFrame(t *testing.T) {
+	f := NewCapabilitiesRequestFrame()
+	assert.Equal(t, []byte{0x80 | byte(TagOfCapabilitiesRequestFrame), 0x00}, f.Encode())
+	request, err := DecodeToCapabilitiesRequestFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, f.Encode(), request.Encode())
+}
+
+func TestCapabilitiesFrame(t *testing.T) {
+	f := &CapabilitiesFrame{
+		Compression:   []string{"deflate"},
+		Datagram:      true,
+		MaxFrameSize:  1 << 20,
+		Versions:      []uint32{0x1, 0xff00001d},
+		AuthRequired:  true,
+		ControlStream: true,
+	}
+	capabilities, err := DecodeToCapabilitiesFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, f, capabilities)
+	assert.Equal(t, f.Encode(), capabilities.Encode())
+
+	capabilities, err = DecodeToCapabilitiesFrame((&CapabilitiesFrame{}).Encode())
+	assert.NoError(t, err)
+	assert.Empty(t, capabilities.Compression)
+	assert.Empty(t, capabilities.Versions)
+	assert.False(t, capabilities.AuthRequired)
+}
@@ -1,6 +1,9 @@
 package frame
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/yomorun/y3"
 )
 
@@ -15,7 +18,8 @@ type DataFrame struct {
 // consider change transactionID to UUID type later
 func NewDataFrame() *DataFrame {
 	data := &DataFrame{
-		metaFrame: NewMetaFrame(),
+		metaFrame:    NewMetaFrame(),
+		payloadFrame: NewPayloadFrame(0),
 	}
 	return data
 }
@@ -50,6 +54,94 @@ func (d *DataFrame) SetTransactionID(transactionID string) {
 	d.metaFrame.SetTransactionID(transactionID)
 }
 
+// Issuer returns the name of the client which sent this DataFrame.
+func (d *DataFrame) Issuer() string {
+	return d.metaFrame.Issuer()
+}
+
+// SetIssuer sets the name of the client which sent this DataFrame.
+func (d *DataFrame) SetIssuer(issuer string) {
+	d.metaFrame.SetIssuer(issuer)
+}
+
+// Hops returns the number of times this DataFrame has been forwarded.
+func (d *DataFrame) Hops() uint32 {
+	return d.metaFrame.Hops()
+}
+
+// SetHops sets the number of times this DataFrame has been forwarded.
+func (d *DataFrame) SetHops(hops uint32) {
+	d.metaFrame.SetHops(hops)
+}
+
+// CreatedAt returns the time the source created this DataFrame, zero if it's not set.
+func (d *DataFrame) CreatedAt() time.Time {
+	return d.metaFrame.CreatedAt()
+}
+
+// SetCreatedAt sets the time the source created this DataFrame, it's never rewritten
+// when the DataFrame is forwarded.
+func (d *DataFrame) SetCreatedAt(t time.Time) {
+	d.metaFrame.SetCreatedAt(t)
+}
+
+// Priority returns the priority of this DataFrame.
+func (d *DataFrame) Priority() Priority {
+	return d.metaFrame.Priority()
+}
+
+// SetPriority sets the priority of this DataFrame.
+func (d *DataFrame) SetPriority(priority Priority) {
+	d.metaFrame.SetPriority(priority)
+}
+
+// Metadata returns the metadata of this DataFrame.
+func (d *DataFrame) Metadata() []byte {
+	return d.metaFrame.Metadata()
+}
+
+// SetMetadata sets the metadata of this DataFrame.
+func (d *DataFrame) SetMetadata(metadata []byte) {
+	d.metaFrame.SetMetadata(metadata)
+}
+
+// Fragment returns the fragment header of this DataFrame, nil if it carries a whole message.
+func (d *DataFrame) Fragment() *Fragment {
+	return d.metaFrame.Fragment()
+}
+
+// SetFragment sets the fragment header of this DataFrame.
+func (d *DataFrame) SetFragment(fragment *Fragment) {
+	d.metaFrame.SetFragment(fragment)
+}
+
+// Sequence returns the sequence number of this DataFrame set by the source, 0 if it's not set.
+func (d *DataFrame) Sequence() uint64 {
+	return d.metaFrame.Sequence()
+}
+
+// SetSequence sets the sequence number of this DataFrame, it's never rewritten when the
+// DataFrame is forwarded.
+func (d *DataFrame) SetSequence(sequence uint64) {
+	d.metaFrame.SetSequence(sequence)
+}
+
+// ExpiresAt returns the time after which this DataFrame is stale, zero if it never expires.
+func (d *DataFrame) ExpiresAt() time.Time {
+	return d.metaFrame.ExpiresAt()
+}
+
+// SetExpiresAt sets the time after which this DataFrame is stale, the YoMo-Zipper drops
+// it instead of delivering it late.
+func (d *DataFrame) SetExpiresAt(t time.Time) {
+	d.metaFrame.SetExpiresAt(t)
+}
+
+// Expired reports whether this DataFrame is stale at now.
+func (d *DataFrame) Expired(now time.Time) bool {
+	return d.metaFrame.Expired(now)
+}
+
 // GetMetaFrame return MetaFrame.
 func (d *DataFrame) GetMetaFrame() *MetaFrame {
 	return d.metaFrame
@@ -71,15 +163,23 @@ func (d *DataFrame) Encode() []byte {
 	return data.Encode()
 }
 
-// DecodeToDataFrame decode Y3 encoded bytes to `DataFrame`
+// DecodeToDataFrame decode Y3 encoded bytes to `DataFrame`, the absent MetaFrame or
+// PayloadFrame is decoded as an empty one, so the decoded frame is always encodable.
+// A LengthError is returned if the declared lengths don't match the buffer.
 func DecodeToDataFrame(buf []byte) (*DataFrame, error) {
+	if err := checkLength(buf); err != nil {
+		return nil, err
+	}
 	packet := y3.NodePacket{}
 	_, err := y3.DecodeToNodePacket(buf, &packet)
 	if err != nil {
 		return nil, err
 	}
 
-	data := &DataFrame{}
+	data := &DataFrame{
+		metaFrame:    &MetaFrame{priority: PriorityNormal},
+		payloadFrame: &PayloadFrame{},
+	}
 
 	if metaBlock, ok := packet.NodePackets[byte(TagOfMetaFrame)]; ok {
 		meta, err := DecodeToMetaFrame(metaBlock.GetRawBytes())
@@ -99,3 +199,76 @@ func DecodeToDataFrame(buf []byte) (*DataFrame, error) {
 
 	return data, nil
 }
+
+// EncodeHead encodes the DataFrame carrying size bytes except the carriage itself, i.e. the
+// head followed by the carriage is the encoded DataFrame, so the carriage can be copied from
+// a stream without being buffered.
+func (d *DataFrame) EncodeHead(size int) []byte {
+	meta := d.metaFrame.Encode()
+	// the carriage packet of the empty payload is the last 2 bytes, its tag and zero length
+	empty := NewPayloadFrame(d.payloadFrame.Tag).Encode()
+	carriage := encodePacketHead(empty[len(empty)-2], size)
+	payload := append(encodePacketHead(empty[0], len(carriage)+size), carriage...)
+	head := encodePacketHead(0x80|byte(d.Type()), len(meta)+len(payload)+size)
+	head = append(head, meta...)
+	return append(head, payload...)
+}
+
+// DecodeToDataFrameHead decodes the head encoded by EncodeHead, returns the DataFrame with an
+// empty carriage and the size of the carriage following the head.
+// A LengthError is returned if the declared lengths don't match each other.
+func DecodeToDataFrameHead(buf []byte) (*DataFrame, int, error) {
+	tag, length, pos, err := decodePacketHead(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if tag != 0x80|byte(TagOfDataFrame) {
+		return nil, 0, fmt.Errorf("frame: unexpected packet %#x, want DataFrame", tag)
+	}
+	tag, metaLength, n, err := decodePacketHead(buf[pos:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if tag != 0x80|byte(TagOfMetaFrame) {
+		return nil, 0, fmt.Errorf("frame: unexpected packet %#x, want MetaFrame", tag)
+	}
+	metaEnd := pos + n + metaLength
+	if metaEnd > len(buf) {
+		return nil, 0, &LengthError{Tag: tag, Declared: metaLength, Actual: len(buf) - pos - n}
+	}
+	if err := checkLength(buf[pos:metaEnd]); err != nil {
+		return nil, 0, err
+	}
+	meta, err := DecodeToMetaFrame(buf[pos:metaEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+	tag, payloadLength, n, err := decodePacketHead(buf[metaEnd:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if tag != 0x80|byte(TagOfPayloadFrame) {
+		return nil, 0, fmt.Errorf("frame: unexpected packet %#x, want PayloadFrame", tag)
+	}
+	carriageTag, size, m, err := decodePacketHead(buf[metaEnd+n:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if carriageTag&0x80 == 0x80 {
+		return nil, 0, ErrNotPrimitivePacket
+	}
+	if end := metaEnd + n + m; end != len(buf) {
+		return nil, 0, &LengthError{Tag: carriageTag, Declared: end, Actual: len(buf)}
+	}
+	if payloadLength != m+size {
+		return nil, 0, &LengthError{Tag: tag, Declared: payloadLength, Actual: m + size}
+	}
+	if actual := metaEnd - pos + n + payloadLength; length != actual {
+		return nil, 0, &LengthError{Tag: buf[0], Declared: length, Actual: actual}
+	}
+	data := &DataFrame{
+		metaFrame:    meta,
+		payloadFrame: &PayloadFrame{Tag: carriageTag},
+	}
+	return data, size, nil
+}
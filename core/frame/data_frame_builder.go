@@ -0,0 +1,129 @@
+package frame
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoTransactionID is returned by DataFrameBuilder.Build without the transaction id.
+	ErrNoTransactionID = errors.New("frame: the transaction id of DataFrame is required")
+	// ErrNoTag is returned by DataFrameBuilder.Build without the tag of the carriage.
+	ErrNoTag = errors.New("frame: the tag of DataFrame is required")
+	// ErrExpiredBeforeCreated is returned by DataFrameBuilder.Build when the DataFrame
+	// expires before it's created.
+	ErrExpiredBeforeCreated = errors.New("frame: DataFrame expires before it's created")
+)
+
+// DataFrameBuilder builds a DataFrame by the chainable setters, Build validates the fields,
+// so the optional ones are set consistently wherever the frames are constructed.
+type DataFrameBuilder struct {
+	tid       string
+	issuer    string
+	tag       byte
+	hasTag    bool
+	carriage  []byte
+	metadata  []byte
+	createdAt time.Time
+	expiresAt time.Time
+	sequence  uint64
+	priority  Priority
+}
+
+// NewDataFrameBuilder creates a DataFrameBuilder of the transaction id.
+func NewDataFrameBuilder(transactionID string) *DataFrameBuilder {
+	return &DataFrameBuilder{tid: transactionID, priority: PriorityNormal}
+}
+
+// WithIssuer sets the name of the client which sends the DataFrame, the client sets it
+// to its own name when writing the frame if it's empty.
+func (b *DataFrameBuilder) WithIssuer(issuer string) *DataFrameBuilder {
+	b.issuer = issuer
+	return b
+}
+
+// WithTag sets the tag of the carriage, it's required.
+func (b *DataFrameBuilder) WithTag(tag byte) *DataFrameBuilder {
+	b.tag = tag
+	b.hasTag = true
+	return b
+}
+
+// WithCarriage sets the tag and the carriage.
+func (b *DataFrameBuilder) WithCarriage(tag byte, carriage []byte) *DataFrameBuilder {
+	b.carriage = carriage
+	return b.WithTag(tag)
+}
+
+// WithMetadata sets the metadata.
+func (b *DataFrameBuilder) WithMetadata(metadata []byte) *DataFrameBuilder {
+	b.metadata = metadata
+	return b
+}
+
+// WithCreatedAt sets the time the source creates the DataFrame.
+func (b *DataFrameBuilder) WithCreatedAt(t time.Time) *DataFrameBuilder {
+	b.createdAt = t
+	return b
+}
+
+// WithExpiresAt sets the time after which the DataFrame is stale.
+func (b *DataFrameBuilder) WithExpiresAt(t time.Time) *DataFrameBuilder {
+	b.expiresAt = t
+	return b
+}
+
+// WithSequence sets the sequence number of the DataFrame.
+func (b *DataFrameBuilder) WithSequence(sequence uint64) *DataFrameBuilder {
+	b.sequence = sequence
+	return b
+}
+
+// WithPriority sets the priority of the DataFrame, default is PriorityNormal.
+func (b *DataFrameBuilder) WithPriority(priority Priority) *DataFrameBuilder {
+	b.priority = priority
+	return b
+}
+
+// Build validates the fields and returns the DataFrame, the transaction id and the tag are
+// required, and the expiry can't be before the creation.
+func (b *DataFrameBuilder) Build() (*DataFrame, error) {
+	if b.tid == "" {
+		return nil, ErrNoTransactionID
+	}
+	if !b.hasTag {
+		return nil, ErrNoTag
+	}
+	if !b.createdAt.IsZero() && !b.expiresAt.IsZero() && b.expiresAt.Before(b.createdAt) {
+		return nil, ErrExpiredBeforeCreated
+	}
+	f := NewDataFrame()
+	f.SetTransactionID(b.tid)
+	if b.issuer != "" {
+		f.SetIssuer(b.issuer)
+	}
+	if !b.createdAt.IsZero() {
+		f.SetCreatedAt(b.createdAt)
+	}
+	if !b.expiresAt.IsZero() {
+		f.SetExpiresAt(b.expiresAt)
+	}
+	if b.sequence > 0 {
+		f.SetSequence(b.sequence)
+	}
+	if len(b.metadata) > 0 {
+		f.SetMetadata(b.metadata)
+	}
+	f.SetPriority(b.priority)
+	f.SetCarriage(b.tag, b.carriage)
+	return f, nil
+}
+
+// Encode builds the DataFrame and encodes it.
+func (b *DataFrameBuilder) Encode() ([]byte, error) {
+	f, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return f.Encode(), nil
+}
@@ -0,0 +1,50 @@
+package frame
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFrameBuilder(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	buf, err := NewDataFrameBuilder("tid-1").
+		WithIssuer("source").
+		WithCarriage(0x33, []byte("yomo")).
+		WithMetadata([]byte("meta")).
+		WithCreatedAt(now).
+		WithExpiresAt(now.Add(time.Second)).
+		WithSequence(7).
+		WithPriority(PriorityHigh).
+		Encode()
+	assert.NoError(t, err)
+
+	f, err := DecodeToDataFrame(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "tid-1", f.TransactionID())
+	assert.Equal(t, "source", f.Issuer())
+	assert.EqualValues(t, 0x33, f.Tag())
+	assert.Equal(t, []byte("yomo"), f.GetCarriage())
+	assert.Equal(t, []byte("meta"), f.Metadata())
+	assert.True(t, now.Equal(f.CreatedAt()))
+	assert.True(t, now.Add(time.Second).Equal(f.ExpiresAt()))
+	assert.EqualValues(t, 7, f.Sequence())
+	assert.Equal(t, PriorityHigh, f.Priority())
+
+	// the empty carriage is allowed with the tag
+	f, err = NewDataFrameBuilder("tid-2").WithTag(0x34).Build()
+	assert.NoError(t, err)
+	assert.Empty(t, f.GetCarriage())
+	assert.Equal(t, PriorityNormal, f.Priority())
+}
+
+func TestDataFrameBuilderValidate(t *testing.T) {
+	now := time.Now()
+	_, err := NewDataFrameBuilder("").WithTag(0x33).Build()
+	assert.Equal(t, ErrNoTransactionID, err)
+	_, err = NewDataFrameBuilder("tid").WithMetadata([]byte("meta")).Build()
+	assert.Equal(t, ErrNoTag, err)
+	_, err = NewDataFrameBuilder("tid").WithTag(0x33).WithCreatedAt(now).WithExpiresAt(now.Add(-time.Second)).Encode()
+	assert.Equal(t, ErrExpiredBeforeCreated, err)
+}
@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +37,105 @@ func TestDataFrameDecode(t *testing.T) {
 	assert.EqualValues(t, userDataTag, data.GetDataTag())
 	assert.EqualValues(t, []byte("yomo"), data.GetCarriage())
 }
+
+func TestDataFrameDecodeLengthMismatch(t *testing.T) {
+	var userDataTag byte = 0x15
+	valid := []byte{
+		0x80 | byte(TagOfDataFrame), 0x10,
+		0x80 | byte(TagOfMetaFrame), 0x06,
+		byte(TagOfTransactionID), 0x04, 0x31, 0x32, 0x33, 0x34,
+		0x80 | byte(TagOfPayloadFrame), 0x06,
+		userDataTag, 0x04, 0x79, 0x6F, 0x6D, 0x6F}
+
+	cases := map[string]func(buf []byte) []byte{
+		"frame longer than buffer": func(buf []byte) []byte {
+			buf[1] = 0x20
+			return buf
+		},
+		"frame shorter than buffer": func(buf []byte) []byte {
+			return append(buf, 0x00, 0x00)
+		},
+		"carriage longer than payload": func(buf []byte) []byte {
+			buf[13] = 0x08
+			return buf
+		},
+		"carriage shorter than payload": func(buf []byte) []byte {
+			buf[13] = 0x02
+			return buf
+		},
+		"meta longer than frame": func(buf []byte) []byte {
+			buf[3] = 0x0F
+			return buf
+		},
+		"truncated": func(buf []byte) []byte {
+			return buf[:len(buf)-2]
+		},
+	}
+	for name, corrupt := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := corrupt(append([]byte{}, valid...))
+			_, err := DecodeToDataFrame(buf)
+			assert.ErrorIs(t, err, ErrLengthMismatch)
+			var lengthErr *LengthError
+			assert.ErrorAs(t, err, &lengthErr)
+		})
+	}
+}
+
+func TestDataFrameEmptyCarriage(t *testing.T) {
+	d := NewDataFrame()
+	d.SetCarriage(0x15, nil)
+	data, err := DecodeToDataFrame(d.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x15, data.GetDataTag())
+	assert.Empty(t, data.GetCarriage())
+	assert.Equal(t, d.Encode(), data.Encode())
+}
+
+func TestDataFrameEncodeHead(t *testing.T) {
+	// the lengths are encoded in a varint of several bytes
+	for _, size := range []int{0, 4, 200, 70000} {
+		carriage := bytes.Repeat([]byte{0x79}, size)
+		d := NewDataFrame()
+		d.SetTransactionID("1234")
+		d.SetMetadata([]byte("metadata"))
+		d.SetCarriage(0x15, carriage)
+
+		head := d.EncodeHead(size)
+		assert.Equal(t, d.Encode(), append(head, carriage...))
+
+		data, n, err := DecodeToDataFrameHead(head)
+		assert.NoError(t, err)
+		assert.Equal(t, size, n)
+		assert.Equal(t, "1234", data.TransactionID())
+		assert.Equal(t, []byte("metadata"), data.Metadata())
+		assert.EqualValues(t, 0x15, data.GetDataTag())
+		assert.Empty(t, data.GetCarriage())
+	}
+}
+
+func TestDataFrameDecodeHeadMismatch(t *testing.T) {
+	d := NewDataFrame()
+	d.SetTransactionID("1234")
+	valid := d.EncodeHead(4)
+
+	// the head followed by a byte of the carriage
+	_, _, err := DecodeToDataFrameHead(append(append([]byte{}, valid...), 0x79))
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+
+	// the frame declares more bytes than the payload
+	buf := append([]byte{}, valid...)
+	buf[1]++
+	_, _, err = DecodeToDataFrameHead(buf)
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+
+	// the payload declares more bytes than the carriage
+	buf = append([]byte{}, valid...)
+	buf[len(buf)-3]++
+	_, _, err = DecodeToDataFrameHead(buf)
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+
+	// the head of a whole frame is a DataFrame without a carriage
+	_, _, err = DecodeToDataFrameHead(NewPingFrame().Encode())
+	assert.Error(t, err)
+}
@@ -1,12 +1,15 @@
 package frame
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"sync/atomic"
 )
 
-// debugFrameSize print frame data size on debug mode
-var debugFrameSize = 16
+// debugFrameSize is the max bytes of a frame printed on debug mode, 0 disables printing
+// the bytes, accessed atomically.
+var debugFrameSize int64 = 16
 
 // Kinds of frames transferable within YoMo
 const (
@@ -17,6 +20,12 @@ const (
 	TagOfMetadata      Type = 0x03
 	TagOfTransactionID Type = 0x01
 	TagOfIssuer        Type = 0x02
+	TagOfPriority      Type = 0x04
+	TagOfHops          Type = 0x05
+	TagOfCreatedAt     Type = 0x06
+	TagOfFragment      Type = 0x07
+	TagOfSequence      Type = 0x08
+	TagOfExpiresAt     Type = 0x09
 	// PayloadFrame of DataFrame
 	TagOfPayloadFrame Type = 0x2E
 
@@ -29,11 +38,40 @@ const (
 	TagOfHandshakeAuthType        Type = 0x04
 	TagOfHandshakeAuthPayload     Type = 0x05
 	TagOfHandshakeObserveDataTags Type = 0x06
+	TagOfHandshakeMaxPayloadSize  Type = 0x07
+	TagOfHandshakeWeight          Type = 0x08
+	TagOfHandshakeAckWindow       Type = 0x09
+	TagOfHandshakeHeartbeat       Type = 0x0A
+	TagOfHandshakeControlStream   Type = 0x0B
+	TagOfHandshakeClientID        Type = 0x0C
 
 	TagOfPingFrame     Type = 0x3C
 	TagOfPongFrame     Type = 0x3B
 	TagOfAcceptedFrame Type = 0x3A
 	TagOfRejectedFrame Type = 0x39
+
+	// AcceptedFrame
+	TagOfAcceptedHeartbeat     Type = 0x01
+	TagOfAcceptedControlStream Type = 0x02
+	// RejectedFrame
+	TagOfRejectedMessage Type = 0x01
+	// AckFrame
+	TagOfAckFrame          Type = 0x38
+	TagOfAckTransactionIDs Type = 0x01
+	// SubscriptionFrame
+	TagOfSubscriptionFrame           Type = 0x37
+	TagOfSubscriptionObserveDataTags Type = 0x01
+	TagOfSubscriptionWeight          Type = 0x02
+	// CapabilitiesRequestFrame
+	TagOfCapabilitiesRequestFrame Type = 0x36
+	// CapabilitiesFrame
+	TagOfCapabilitiesFrame         Type = 0x35
+	TagOfCapabilitiesCompression   Type = 0x01
+	TagOfCapabilitiesDatagram      Type = 0x02
+	TagOfCapabilitiesMaxFrameSize  Type = 0x03
+	TagOfCapabilitiesVersions      Type = 0x04
+	TagOfCapabilitiesAuthRequired  Type = 0x05
+	TagOfCapabilitiesControlStream Type = 0x06
 )
 
 // Type represents the type of frame.
@@ -64,6 +102,14 @@ func (f Type) String() string {
 		return "AcceptedFrame"
 	case TagOfRejectedFrame:
 		return "RejectedFrame"
+	case TagOfAckFrame:
+		return "AckFrame"
+	case TagOfSubscriptionFrame:
+		return "SubscriptionFrame"
+	case TagOfCapabilitiesRequestFrame:
+		return "CapabilitiesRequestFrame"
+	case TagOfCapabilitiesFrame:
+		return "CapabilitiesFrame"
 	case TagOfMetaFrame:
 		return "MetaFrame"
 	case TagOfPayloadFrame:
@@ -79,18 +125,47 @@ func (f Type) String() string {
 	}
 }
 
+// SetDebugFrameSize sets the max bytes of a frame printed on debug mode, the larger frames
+// are cut, 0 disables printing the bytes of the frames. It's YOMO_DEBUG_FRAME_SIZE by default.
+func SetDebugFrameSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt64(&debugFrameSize, int64(size))
+}
+
+// DebugFrameSize returns the max bytes of a frame printed on debug mode.
+func DebugFrameSize() int {
+	return int(atomic.LoadInt64(&debugFrameSize))
+}
+
 // Shortly reduce data size for easy viewing
 func Shortly(data []byte) []byte {
-	if len(data) > debugFrameSize {
-		return data[:debugFrameSize]
+	if size := DebugFrameSize(); len(data) > size {
+		return data[:size]
 	}
 	return data
 }
 
+// Dump formats the data in hex for the debug logs, the data larger than DebugFrameSize is
+// formatted by its head and tail, it's empty if printing the bytes is disabled.
+func Dump(data []byte) string {
+	size := DebugFrameSize()
+	if size == 0 {
+		return ""
+	}
+	if len(data) <= size {
+		return fmt.Sprintf("[% x]", data)
+	}
+	head := (size + 1) / 2
+	tail := size - head
+	return fmt.Sprintf("head %d bytes: [% x], tail %d bytes: [% x]", head, data[:head], tail, data[len(data)-tail:])
+}
+
 func init() {
 	if envFrameSize := os.Getenv("YOMO_DEBUG_FRAME_SIZE"); envFrameSize != "" {
 		if val, err := strconv.Atoi(envFrameSize); err == nil {
-			debugFrameSize = val
+			SetDebugFrameSize(val)
 		}
 	}
 }
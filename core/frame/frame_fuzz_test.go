@@ -0,0 +1,70 @@
+//go:build go1.18
+// +build go1.18
+
+package frame
+
+import (
+	"testing"
+)
+
+// FuzzFrameRoundTrip asserts that every decodable buffer is re-encoded stably,
+// i.e. decode(encode(decode(buf))) encodes to the same bytes.
+func FuzzFrameRoundTrip(f *testing.F) {
+	for _, frm := range roundTripFrames() {
+		f.Add(frm.Encode())
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		if len(buf) == 0 {
+			return
+		}
+		decode, ok := decoders[Type(buf[0]&0x7F)]
+		if !ok {
+			return
+		}
+		decoded, err := decode(buf)
+		if err != nil {
+			return
+		}
+		encoded := decoded.Encode()
+		redecoded, err := decode(encoded)
+		if err != nil {
+			t.Fatalf("re-decode %# x: %v", encoded, err)
+		}
+		if reencoded := redecoded.Encode(); string(reencoded) != string(encoded) {
+			t.Fatalf("unstable encoding: %# x != %# x", reencoded, encoded)
+		}
+	})
+}
+
+// FuzzDecodeToHandshakeFrame asserts that the decoder of the handshake neither panics on the
+// malformed bytes nor decodes a handshake it can't decode again once encoded.
+func FuzzDecodeToHandshakeFrame(f *testing.F) {
+	handshake := NewHandshakeFrame("sfn", 0x5D, []byte{0x01, 0x02}, "app", 0x1, []byte("token"))
+	handshake.Weight = 3
+	handshake.AckWindow = 8
+	handshake.Heartbeat = 30000
+	handshake.ControlStream = true
+	handshake.ClientID = "client-1"
+	for _, valid := range [][]byte{handshake.Encode(), NewHandshakeFrame("source", 0x5F, nil, "", 0x0, nil).Encode()} {
+		f.Add(valid)
+		// truncated
+		for _, n := range []int{1, 2, len(valid) / 2, len(valid) - 1} {
+			f.Add(valid[:n])
+		}
+		// corrupted, e.g. the lengths and the tags of the packets
+		for _, i := range []int{1, 2, 3, len(valid) / 2, len(valid) - 1} {
+			buf := append([]byte{}, valid...)
+			buf[i] ^= 0xFF
+			f.Add(buf)
+		}
+	}
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		decoded, err := DecodeToHandshakeFrame(buf)
+		if err != nil {
+			return
+		}
+		if _, err := DecodeToHandshakeFrame(decoded.Encode()); err != nil {
+			t.Fatalf("re-decode %# x: %v", decoded.Encode(), err)
+		}
+	})
+}
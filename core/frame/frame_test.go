@@ -0,0 +1,80 @@
+package frame
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decoders decode the frames by their types.
+var decoders = map[Type]func([]byte) (Frame, error){
+	TagOfDataFrame:      func(buf []byte) (Frame, error) { return DecodeToDataFrame(buf) },
+	TagOfHandshakeFrame: func(buf []byte) (Frame, error) { return DecodeToHandshakeFrame(buf) },
+	TagOfPingFrame:      func(buf []byte) (Frame, error) { return DecodeToPingFrame(buf) },
+	TagOfPongFrame:      func(buf []byte) (Frame, error) { return DecodeToPongFrame(buf) },
+	TagOfAcceptedFrame:  func(buf []byte) (Frame, error) { return DecodeToAcceptedFrame(buf) },
+	TagOfRejectedFrame:  func(buf []byte) (Frame, error) { return DecodeToRejectedFrame(buf) },
+}
+
+func roundTripFrames() map[string]Frame {
+	data := NewDataFrame()
+	data.SetTransactionID("1234")
+	data.SetCarriage(0x33, []byte("yomo"))
+
+	dataWithMeta := NewDataFrame()
+	dataWithMeta.SetTransactionID("5678")
+	dataWithMeta.SetIssuer("source")
+	dataWithMeta.SetPriority(PriorityHigh)
+	dataWithMeta.SetHops(3)
+	dataWithMeta.SetCreatedAt(time.Unix(1640995200, 123456789))
+	dataWithMeta.SetMetadata([]byte{0x01, 0x02, 0x03})
+	dataWithMeta.SetCarriage(0x34, []byte("yomo"))
+
+	handshakeWithMaxPayload := NewHandshakeFrame("sfn", 0x5D, []byte{0x33}, "", 0, nil)
+	handshakeWithMaxPayload.MaxPayloadSize = 1024
+
+	return map[string]Frame{
+		"handshake":             NewHandshakeFrame("sfn", 0x5D, []byte{0x33, 0x34}, "app", 0x1, []byte("secret")),
+		"handshake-empty":       NewHandshakeFrame("", 0, nil, "", 0, nil),
+		"handshake-max-payload": handshakeWithMaxPayload,
+		"data":                  data,
+		"data-with-metadata":    dataWithMeta,
+		"data-empty":            NewDataFrame(),
+		"ping":                  NewPingFrame(),
+		"pong":                  NewPongFrame(),
+		"accepted":              NewAcceptedFrame(),
+		"rejected":              NewRejectedFrame(""),
+		"rejected-message":      NewRejectedFrame("no workflow configured"),
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for name, f := range roundTripFrames() {
+		t.Run(name, func(t *testing.T) {
+			buf := f.Encode()
+			decoded, err := decoders[f.Type()](buf)
+			assert.NoError(t, err)
+			assert.Equal(t, f.Type(), decoded.Type())
+			assert.Equal(t, buf, decoded.Encode())
+		})
+	}
+}
+
+func TestDump(t *testing.T) {
+	defer SetDebugFrameSize(DebugFrameSize())
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+
+	SetDebugFrameSize(8)
+	assert.Equal(t, "[01 02 03 04 05 06]", Dump(data))
+	assert.Equal(t, data, Shortly(data))
+
+	SetDebugFrameSize(4)
+	assert.Equal(t, "head 2 bytes: [01 02], tail 2 bytes: [05 06]", Dump(data))
+	assert.Equal(t, data[:4], Shortly(data))
+
+	// disabled
+	SetDebugFrameSize(0)
+	assert.Empty(t, Dump(data))
+	assert.Empty(t, Shortly(data))
+}
@@ -12,6 +12,23 @@ type HandshakeFrame struct {
 	ClientType byte
 	// ObserveDataTags are the client data tag list.
 	ObserveDataTags []byte
+	// MaxPayloadSize is the max carriage length the client can handle, 0 means unlimited.
+	MaxPayloadSize uint32
+	// Weight is the share of the frames the client receives among the instances of the same
+	// name, 0 means the default weight.
+	Weight uint32
+	// AckWindow is the max number of the unacknowledged DataFrames of the client, the server
+	// acknowledges the DataFrames of the client by AckFrame if it's not 0.
+	AckWindow uint32
+	// Heartbeat is the interval in milliseconds the client pings the server on its own, the
+	// server tolerates the longer idle periods of the connection, 0 means it isn't negotiated.
+	Heartbeat uint32
+	// ControlStream reports whether the client opens a dedicated stream for the control
+	// frames once the server accepts it, so they aren't blocked behind the DataFrames.
+	ControlStream bool
+	// ClientID identifies the client across its connections, e.g. the retransmissions after
+	// reconnecting are recognized by it, empty means the client isn't identified.
+	ClientID string
 	// auth
 	authType    byte
 	authPayload []byte
@@ -63,12 +80,52 @@ func (h *HandshakeFrame) Encode() []byte {
 	handshake.AddPrimitivePacket(appIDBlock)
 	handshake.AddPrimitivePacket(authTypeBlock)
 	handshake.AddPrimitivePacket(authPayloadBlock)
+	// max payload size
+	if h.MaxPayloadSize > 0 {
+		maxPayloadSizeBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeMaxPayloadSize))
+		maxPayloadSizeBlock.SetUInt32Value(h.MaxPayloadSize)
+		handshake.AddPrimitivePacket(maxPayloadSizeBlock)
+	}
+	// weight
+	if h.Weight > 0 {
+		weightBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeWeight))
+		weightBlock.SetUInt32Value(h.Weight)
+		handshake.AddPrimitivePacket(weightBlock)
+	}
+	// ack window
+	if h.AckWindow > 0 {
+		ackWindowBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeAckWindow))
+		ackWindowBlock.SetUInt32Value(h.AckWindow)
+		handshake.AddPrimitivePacket(ackWindowBlock)
+	}
+	// heartbeat
+	if h.Heartbeat > 0 {
+		heartbeatBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeHeartbeat))
+		heartbeatBlock.SetUInt32Value(h.Heartbeat)
+		handshake.AddPrimitivePacket(heartbeatBlock)
+	}
+	// control stream
+	if h.ControlStream {
+		controlStreamBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeControlStream))
+		controlStreamBlock.SetBoolValue(true)
+		handshake.AddPrimitivePacket(controlStreamBlock)
+	}
+	// client id
+	if h.ClientID != "" {
+		clientIDBlock := y3.NewPrimitivePacketEncoder(byte(TagOfHandshakeClientID))
+		clientIDBlock.SetStringValue(h.ClientID)
+		handshake.AddPrimitivePacket(clientIDBlock)
+	}
 
 	return handshake.Encode()
 }
 
-// DecodeToHandshakeFrame decodes Y3 encoded bytes to HandshakeFrame.
+// DecodeToHandshakeFrame decodes Y3 encoded bytes to HandshakeFrame, a LengthError is
+// returned if the declared lengths don't match the buffer, e.g. a bogus name length.
 func DecodeToHandshakeFrame(buf []byte) (*HandshakeFrame, error) {
+	if err := checkLength(buf); err != nil {
+		return nil, err
+	}
 	node := y3.NodePacket{}
 	_, err := y3.DecodeToNodePacket(buf, &node)
 	if err != nil {
@@ -86,8 +143,9 @@ func DecodeToHandshakeFrame(buf []byte) (*HandshakeFrame, error) {
 	}
 	// type
 	if typeBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeType)]; ok {
-		clientType := typeBlock.ToBytes()
-		handshake.ClientType = clientType[0]
+		if clientType := typeBlock.ToBytes(); len(clientType) > 0 {
+			handshake.ClientType = clientType[0]
+		}
 	}
 	// observe data tag list
 	if observeDataTagsBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeObserveDataTags)]; ok {
@@ -103,14 +161,63 @@ func DecodeToHandshakeFrame(buf []byte) (*HandshakeFrame, error) {
 	}
 	// auth type
 	if authTypeBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeAuthType)]; ok {
-		authType := authTypeBlock.ToBytes()
-		handshake.authType = authType[0]
+		if authType := authTypeBlock.ToBytes(); len(authType) > 0 {
+			handshake.authType = authType[0]
+		}
 	}
 	// auth payload
 	if authPayloadBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeAuthPayload)]; ok {
 		authPayload := authPayloadBlock.ToBytes()
 		handshake.authPayload = authPayload
 	}
+	// max payload size
+	if maxPayloadSizeBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeMaxPayloadSize)]; ok {
+		maxPayloadSize, err := maxPayloadSizeBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		handshake.MaxPayloadSize = maxPayloadSize
+	}
+	// weight
+	if weightBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeWeight)]; ok {
+		weight, err := weightBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		handshake.Weight = weight
+	}
+	// ack window
+	if ackWindowBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeAckWindow)]; ok {
+		ackWindow, err := ackWindowBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		handshake.AckWindow = ackWindow
+	}
+	// heartbeat
+	if heartbeatBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeHeartbeat)]; ok {
+		heartbeat, err := heartbeatBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		handshake.Heartbeat = heartbeat
+	}
+	// control stream
+	if controlStreamBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeControlStream)]; ok {
+		controlStream, err := controlStreamBlock.ToBool()
+		if err != nil {
+			return nil, err
+		}
+		handshake.ControlStream = controlStream
+	}
+	// client id
+	if clientIDBlock, ok := node.PrimitivePackets[byte(TagOfHandshakeClientID)]; ok {
+		clientID, err := clientIDBlock.ToUTF8String()
+		if err != nil {
+			return nil, err
+		}
+		handshake.ClientID = clientID
+	}
 
 	return handshake, nil
 }
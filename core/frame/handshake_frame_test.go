@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,3 +28,94 @@ func TestHandshakeFrameEncode(t *testing.T) {
 	assert.EqualValues(t, expectedName, Handshake.Name)
 	assert.EqualValues(t, expectedType, Handshake.ClientType)
 }
+
+func TestHandshakeFrameWeight(t *testing.T) {
+	m := NewHandshakeFrame("sfn", 0x5D, []byte{0x01}, "", 0x0, nil)
+	m.Weight = 3
+	handshake, err := DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, handshake.Weight)
+
+	// the weight is omitted by default
+	m.Weight = 0
+	handshake, err = DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, handshake.Weight)
+}
+
+func TestHandshakeFrameAckWindow(t *testing.T) {
+	m := NewHandshakeFrame("source", 0x5F, nil, "", 0x0, nil)
+	m.AckWindow = 64
+	handshake, err := DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 64, handshake.AckWindow)
+}
+
+func TestHandshakeFrameBogusNameLength(t *testing.T) {
+	buf := NewHandshakeFrame("1234", 0xD3, []byte{0x01, 0x02}, "", 0x0, nil).Encode()
+	buf[3] = 0x7F // the name claims more bytes than the frame holds
+	_, err := DecodeToHandshakeFrame(buf)
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+	var lengthErr *LengthError
+	assert.ErrorAs(t, err, &lengthErr)
+
+	buf[0] = byte(TagOfHandshakeFrame)
+	buf[3] = 0x04
+	_, err = DecodeToHandshakeFrame(buf)
+	assert.ErrorIs(t, err, ErrNotNodePacket)
+}
+
+func TestHandshakeFrameDecodeRandomBytes(t *testing.T) {
+	valid := NewHandshakeFrame("sfn", 0x5D, []byte{0x01, 0x02}, "app", 0x1, []byte("token")).Encode()
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		var buf []byte
+		if i%2 == 0 {
+			buf = make([]byte, rnd.Intn(64))
+			rnd.Read(buf)
+			if len(buf) > 0 && rnd.Intn(2) == 0 {
+				buf[0] = 0x80 | byte(TagOfHandshakeFrame)
+			}
+		} else {
+			// corrupt and truncate a valid handshake
+			buf = append([]byte{}, valid...)
+			for n := 1 + rnd.Intn(4); n > 0; n-- {
+				buf[rnd.Intn(len(buf))] = byte(rnd.Intn(256))
+			}
+			buf = buf[:rnd.Intn(len(buf)+1)]
+		}
+		assert.NotPanics(t, func() { DecodeToHandshakeFrame(buf) }, "%x", buf)
+	}
+}
+
+func TestHandshakeFrameHeartbeat(t *testing.T) {
+	m := NewHandshakeFrame("sfn", 0x5D, []byte{0x01}, "", 0x0, nil)
+	m.Heartbeat = 30000
+	handshake, err := DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30000, handshake.Heartbeat)
+}
+
+func TestHandshakeFrameControlStream(t *testing.T) {
+	m := NewHandshakeFrame("sfn", 0x5D, []byte{0x01}, "", 0x0, nil)
+	handshake, err := DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.False(t, handshake.ControlStream)
+
+	m.ControlStream = true
+	handshake, err = DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.True(t, handshake.ControlStream)
+}
+
+func TestHandshakeFrameClientID(t *testing.T) {
+	m := NewHandshakeFrame("source", 0x5F, nil, "", 0x0, nil)
+	handshake, err := DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.Empty(t, handshake.ClientID)
+
+	m.ClientID = "a1b2c3d4"
+	handshake, err = DecodeToHandshakeFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, "a1b2c3d4", handshake.ClientID)
+}
@@ -0,0 +1,106 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yomorun/y3/encoding"
+)
+
+// ErrLengthMismatch is matched by the LengthError by errors.Is.
+var ErrLengthMismatch = errors.New("frame: declared length mismatch")
+
+// LengthError is returned when the length declared by a packet doesn't match the bytes
+// it actually holds, e.g. the length claims more bytes than the buffer contains, or the
+// children of a node don't fill up its value exactly.
+type LengthError struct {
+	Tag      byte // the tag of the packet
+	Declared int  // the declared length of the value
+	Actual   int  // the bytes actually available for the value
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("frame: packet %#x declares %d bytes, got %d", e.Tag, e.Declared, e.Actual)
+}
+
+// Is reports whether the target is ErrLengthMismatch.
+func (e *LengthError) Is(target error) bool {
+	return target == ErrLengthMismatch
+}
+
+// ErrNotNodePacket is returned when decoding a frame from a primitive packet, the frames
+// are all node packets.
+var ErrNotNodePacket = errors.New("frame: not a node packet")
+
+// ErrNotPrimitivePacket is returned when the carriage of a DataFrame isn't a primitive packet.
+var ErrNotPrimitivePacket = errors.New("frame: not a primitive packet")
+
+// checkLength checks the declared lengths of the y3 node packet in buf and its descendants,
+// the packet must occupy the whole buf.
+func checkLength(buf []byte) error {
+	n, err := checkPacket(buf)
+	if err != nil {
+		return err
+	}
+	if buf[0]&0x80 != 0x80 {
+		return ErrNotNodePacket
+	}
+	if n != len(buf) {
+		return &LengthError{Tag: buf[0], Declared: n, Actual: len(buf)}
+	}
+	return nil
+}
+
+// checkPacket checks the packet at the head of buf, returns the number of its bytes.
+func checkPacket(buf []byte) (int, error) {
+	_, length, pos, err := decodePacketHead(buf)
+	if err != nil {
+		return 0, err
+	}
+	end := pos + length
+	if end > len(buf) {
+		return 0, &LengthError{Tag: buf[0], Declared: length, Actual: len(buf) - pos}
+	}
+	// the children of a node packet must fill up its value
+	if buf[0]&0x80 == 0x80 {
+		for p := pos; p < end; {
+			n, err := checkPacket(buf[p:end])
+			if err != nil {
+				return 0, err
+			}
+			p += n
+		}
+	}
+	return end, nil
+}
+
+func tagOf(buf []byte) byte {
+	if len(buf) == 0 {
+		return 0
+	}
+	return buf[0]
+}
+
+// encodePacketHead encodes the tag and the length of a packet.
+func encodePacketHead(tag byte, length int) []byte {
+	size := encoding.SizeOfPVarInt32(int32(length))
+	buf := make([]byte, 1+size)
+	buf[0] = tag
+	codec := encoding.VarCodec{Size: size}
+	_ = codec.EncodePVarInt32(buf[1:], int32(length))
+	return buf
+}
+
+// decodePacketHead decodes the tag and the length of the packet at the head of buf, returns
+// them and the number of the bytes they occupy.
+func decodePacketHead(buf []byte) (byte, int, int, error) {
+	if len(buf) < 2 {
+		return 0, 0, 0, &LengthError{Tag: tagOf(buf), Declared: 2, Actual: len(buf)}
+	}
+	var length int32
+	codec := encoding.VarCodec{}
+	if err := codec.DecodePVarInt32(buf[1:], &length); err != nil || length < 0 {
+		return 0, 0, 0, &LengthError{Tag: buf[0], Declared: int(length), Actual: len(buf) - 1}
+	}
+	return buf[0], int(length), 1 + codec.Size, nil
+}
@@ -1,22 +1,72 @@
 package frame
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
 	"strconv"
 	"time"
 
 	"github.com/yomorun/y3"
 )
 
+// errMalformedFragment is returned when the fragment header of the MetaFrame is invalid.
+var errMalformedFragment = errors.New("frame: malformed fragment header")
+
+// Priority represents the priority of a DataFrame, frames with higher priority
+// will be drained first when the target's send queue is backed up.
+type Priority byte
+
+const (
+	// PriorityLow is used by bulk data which can wait.
+	PriorityLow Priority = 0x00
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = 0x01
+	// PriorityHigh is used by control/urgent data.
+	PriorityHigh Priority = 0x02
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "Low"
+	case PriorityHigh:
+		return "High"
+	default:
+		return "Normal"
+	}
+}
+
 // MetaFrame is a Y3 encoded bytes, SeqID is a fixed value of TYPE_ID_TRANSACTION.
 // used for describes metadata for a DataFrame.
 type MetaFrame struct {
-	tid string
+	tid       string
+	issuer    string
+	priority  Priority
+	hops      uint32
+	createdAt int64 // unix nano
+	metadata  []byte
+	fragment  *Fragment
+	sequence  uint64
+	expiresAt int64 // unix nano
+}
+
+// Fragment describes a DataFrame which carries a part of a large message, the message is
+// reassembled by concatenating the carriages of its fragments in the order of Index.
+type Fragment struct {
+	// MessageID identifies the message among the fragments of the same issuer.
+	MessageID string
+	// Index is the position of the fragment, from 0 to Total-1.
+	Index uint32
+	// Total is the number of the fragments of the message.
+	Total uint32
 }
 
 // NewMetaFrame creates a new MetaFrame instance.
 func NewMetaFrame() *MetaFrame {
 	return &MetaFrame{
-		tid: strconv.FormatInt(time.Now().Unix(), 10),
+		tid:      strconv.FormatInt(time.Now().Unix(), 10),
+		priority: PriorityNormal,
 	}
 }
 
@@ -30,14 +80,162 @@ func (m *MetaFrame) TransactionID() string {
 	return m.tid
 }
 
+// SetIssuer set the issuer, which is the name of the client sent the DataFrame.
+func (m *MetaFrame) SetIssuer(issuer string) {
+	m.issuer = issuer
+}
+
+// Issuer returns the issuer.
+func (m *MetaFrame) Issuer() string {
+	return m.issuer
+}
+
+// SetPriority set the priority.
+func (m *MetaFrame) SetPriority(priority Priority) {
+	m.priority = priority
+}
+
+// Priority returns the priority, default is PriorityNormal.
+func (m *MetaFrame) Priority() Priority {
+	return m.priority
+}
+
+// SetHops set the number of times the DataFrame has been forwarded.
+func (m *MetaFrame) SetHops(hops uint32) {
+	m.hops = hops
+}
+
+// Hops returns the number of times the DataFrame has been forwarded.
+func (m *MetaFrame) Hops() uint32 {
+	return m.hops
+}
+
+// SetCreatedAt set the time the source created the DataFrame.
+func (m *MetaFrame) SetCreatedAt(t time.Time) {
+	if t.IsZero() {
+		m.createdAt = 0
+		return
+	}
+	m.createdAt = t.UnixNano()
+}
+
+// CreatedAt returns the time the source created the DataFrame, zero if it's not set.
+func (m *MetaFrame) CreatedAt() time.Time {
+	if m.createdAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.createdAt)
+}
+
+// SetMetadata set the metadata, it is opaque to YoMo-Zipper.
+func (m *MetaFrame) SetMetadata(metadata []byte) {
+	m.metadata = metadata
+}
+
+// Metadata returns the metadata.
+func (m *MetaFrame) Metadata() []byte {
+	return m.metadata
+}
+
+// SetFragment set the fragment header, nil means the DataFrame carries a whole message.
+func (m *MetaFrame) SetFragment(fragment *Fragment) {
+	m.fragment = fragment
+}
+
+// Fragment returns the fragment header, nil if the DataFrame carries a whole message.
+func (m *MetaFrame) Fragment() *Fragment {
+	return m.fragment
+}
+
+// SetSequence set the sequence number of the issuer, 0 means it's not set.
+func (m *MetaFrame) SetSequence(sequence uint64) {
+	m.sequence = sequence
+}
+
+// Sequence returns the sequence number of the issuer, 0 if it's not set.
+func (m *MetaFrame) Sequence() uint64 {
+	return m.sequence
+}
+
+// SetExpiresAt set the time after which the DataFrame is stale and dropped, zero means never.
+func (m *MetaFrame) SetExpiresAt(t time.Time) {
+	if t.IsZero() {
+		m.expiresAt = 0
+		return
+	}
+	m.expiresAt = t.UnixNano()
+}
+
+// ExpiresAt returns the time after which the DataFrame is stale, zero if it never expires.
+func (m *MetaFrame) ExpiresAt() time.Time {
+	if m.expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.expiresAt)
+}
+
+// Expired reports whether the DataFrame is stale at now.
+func (m *MetaFrame) Expired(now time.Time) bool {
+	return m.expiresAt != 0 && now.UnixNano() > m.expiresAt
+}
+
 // Encode implements Frame.Encode method.
 func (m *MetaFrame) Encode() []byte {
 	meta := y3.NewNodePacketEncoder(byte(TagOfMetaFrame))
 
 	transactionID := y3.NewPrimitivePacketEncoder(byte(TagOfTransactionID))
 	transactionID.SetStringValue(m.tid)
-
 	meta.AddPrimitivePacket(transactionID)
+
+	// issuer
+	if m.issuer != "" {
+		issuer := y3.NewPrimitivePacketEncoder(byte(TagOfIssuer))
+		issuer.SetStringValue(m.issuer)
+		meta.AddPrimitivePacket(issuer)
+	}
+	// normal priority is omitted to keep the frame compact
+	if m.priority != PriorityNormal {
+		priority := y3.NewPrimitivePacketEncoder(byte(TagOfPriority))
+		priority.SetBytesValue([]byte{byte(m.priority)})
+		meta.AddPrimitivePacket(priority)
+	}
+	// hops
+	if m.hops > 0 {
+		hops := y3.NewPrimitivePacketEncoder(byte(TagOfHops))
+		hops.SetUInt32Value(m.hops)
+		meta.AddPrimitivePacket(hops)
+	}
+	// created at
+	if m.createdAt != 0 {
+		createdAt := y3.NewPrimitivePacketEncoder(byte(TagOfCreatedAt))
+		createdAt.SetInt64Value(m.createdAt)
+		meta.AddPrimitivePacket(createdAt)
+	}
+	// metadata
+	if len(m.metadata) > 0 {
+		metadata := y3.NewPrimitivePacketEncoder(byte(TagOfMetadata))
+		metadata.SetBytesValue(m.metadata)
+		meta.AddPrimitivePacket(metadata)
+	}
+	// fragment
+	if m.fragment != nil {
+		fragment := y3.NewPrimitivePacketEncoder(byte(TagOfFragment))
+		fragment.SetBytesValue(encodeFragment(m.fragment))
+		meta.AddPrimitivePacket(fragment)
+	}
+	// sequence
+	if m.sequence > 0 {
+		sequence := y3.NewPrimitivePacketEncoder(byte(TagOfSequence))
+		sequence.SetUInt64Value(m.sequence)
+		meta.AddPrimitivePacket(sequence)
+	}
+	// expires at
+	if m.expiresAt != 0 {
+		expiresAt := y3.NewPrimitivePacketEncoder(byte(TagOfExpiresAt))
+		expiresAt.SetInt64Value(m.expiresAt)
+		meta.AddPrimitivePacket(expiresAt)
+	}
+
 	return meta.Encode()
 }
 
@@ -49,12 +247,85 @@ func DecodeToMetaFrame(buf []byte) (*MetaFrame, error) {
 		return nil, err
 	}
 
-	meta := &MetaFrame{}
-	for _, v := range nodeBlock.PrimitivePackets {
-		val, _ := v.ToUTF8String()
+	meta := &MetaFrame{priority: PriorityNormal}
+	if tidBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfTransactionID)]; ok {
+		val, err := tidBlock.ToUTF8String()
+		if err != nil {
+			return nil, err
+		}
 		meta.tid = val
-		break
+	}
+	if issuerBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfIssuer)]; ok {
+		val, err := issuerBlock.ToUTF8String()
+		if err != nil {
+			return nil, err
+		}
+		meta.issuer = val
+	}
+	if priorityBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfPriority)]; ok {
+		if p := priorityBlock.ToBytes(); len(p) > 0 {
+			meta.priority = Priority(p[0])
+		}
+	}
+	if hopsBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfHops)]; ok {
+		val, err := hopsBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		meta.hops = val
+	}
+	if createdAtBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfCreatedAt)]; ok {
+		val, err := createdAtBlock.ToInt64()
+		if err != nil {
+			return nil, err
+		}
+		meta.createdAt = val
+	}
+	if metadataBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfMetadata)]; ok {
+		meta.metadata = metadataBlock.ToBytes()
+	}
+	if fragmentBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfFragment)]; ok {
+		fragment, err := decodeFragment(fragmentBlock.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		meta.fragment = fragment
+	}
+	if sequenceBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfSequence)]; ok {
+		val, err := sequenceBlock.ToUInt64()
+		if err != nil {
+			return nil, err
+		}
+		meta.sequence = val
+	}
+	if expiresAtBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfExpiresAt)]; ok {
+		val, err := expiresAtBlock.ToInt64()
+		if err != nil {
+			return nil, err
+		}
+		meta.expiresAt = val
 	}
 
 	return meta, nil
 }
+
+// encodeFragment encodes the index and the total by uvarint, followed by the message id.
+func encodeFragment(f *Fragment) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen32, 2*binary.MaxVarintLen32+len(f.MessageID))
+	n := binary.PutUvarint(buf, uint64(f.Index))
+	n += binary.PutUvarint(buf[n:], uint64(f.Total))
+	return append(buf[:n], f.MessageID...)
+}
+
+func decodeFragment(buf []byte) (*Fragment, error) {
+	index, n := binary.Uvarint(buf)
+	if n <= 0 || index > math.MaxUint32 {
+		return nil, errMalformedFragment
+	}
+	buf = buf[n:]
+	total, n := binary.Uvarint(buf)
+	if n <= 0 || total > math.MaxUint32 || index >= total {
+		return nil, errMalformedFragment
+	}
+	return &Fragment{MessageID: string(buf[n:]), Index: uint32(index), Total: uint32(total)}, nil
+}
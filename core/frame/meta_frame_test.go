@@ -2,6 +2,7 @@ package frame
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -20,3 +21,65 @@ func TestMetaFrameDecode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, "1234", meta.TransactionID())
 }
+
+func TestMetaFramePriority(t *testing.T) {
+	m := NewMetaFrame()
+	assert.Equal(t, PriorityNormal, m.Priority())
+
+	m.SetTransactionID("1234")
+	m.SetPriority(PriorityHigh)
+	meta, err := DecodeToMetaFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, "1234", meta.TransactionID())
+	assert.Equal(t, PriorityHigh, meta.Priority())
+}
+
+func TestMetaFrameCreatedAt(t *testing.T) {
+	m := NewMetaFrame()
+	assert.True(t, m.CreatedAt().IsZero())
+
+	createdAt := time.Unix(1640995200, 123456789)
+	m.SetCreatedAt(createdAt)
+	meta, err := DecodeToMetaFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.True(t, createdAt.Equal(meta.CreatedAt()))
+}
+
+func TestMetaFrameFragment(t *testing.T) {
+	m := NewMetaFrame()
+	assert.Nil(t, m.Fragment())
+	m.SetFragment(&Fragment{MessageID: "msg-1", Index: 2, Total: 3})
+	meta, err := DecodeToMetaFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, &Fragment{MessageID: "msg-1", Index: 2, Total: 3}, meta.Fragment())
+
+	// the index must be less than the total
+	m.SetFragment(&Fragment{MessageID: "msg-1", Index: 3, Total: 3})
+	_, err = DecodeToMetaFrame(m.Encode())
+	assert.Error(t, err)
+}
+
+func TestMetaFrameSequence(t *testing.T) {
+	m := NewMetaFrame()
+	m.SetSequence(42)
+	meta, err := DecodeToMetaFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, meta.Sequence())
+
+	meta, err = DecodeToMetaFrame(NewMetaFrame().Encode())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, meta.Sequence())
+}
+
+func TestMetaFrameExpiresAt(t *testing.T) {
+	m := NewMetaFrame()
+	now := time.Now()
+	assert.False(t, m.Expired(now))
+
+	m.SetExpiresAt(now)
+	meta, err := DecodeToMetaFrame(m.Encode())
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(meta.ExpiresAt()))
+	assert.False(t, meta.Expired(now))
+	assert.True(t, meta.Expired(now.Add(time.Nanosecond)))
+}
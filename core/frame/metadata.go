@@ -0,0 +1,78 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// ErrInvalidMetadata is returned when decoding the malformed metadata.
+var ErrInvalidMetadata = errors.New("frame: invalid metadata")
+
+// Metadata is the key/value pairs carried by a DataFrame, see DataFrame.SetMetadata. It's
+// encoded by a MetadataCodec, YoMo-Zipper forwards the encoded bytes as they are.
+type Metadata map[string]string
+
+// MetadataCodec serializes the Metadata, so the sources and the terminals can use their
+// own encoding, e.g. protobuf or msgpack.
+type MetadataCodec interface {
+	// Encode the metadata.
+	Encode(md Metadata) ([]byte, error)
+	// Decode the metadata, the empty bytes are decoded to the empty metadata.
+	Decode(buf []byte) (Metadata, error)
+}
+
+// DefaultMetadataCodec is the compact built-in codec, every pair is encoded as the uvarint
+// length of the key, the key, the uvarint length of the value and the value, sorted by the key.
+var DefaultMetadataCodec MetadataCodec = compactMetadataCodec{}
+
+type compactMetadataCodec struct{}
+
+func (compactMetadataCodec) Encode(md Metadata) ([]byte, error) {
+	if len(md) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf := make([]byte, 0, 64)
+	for _, k := range keys {
+		buf = appendString(buf, k)
+		buf = appendString(buf, md[k])
+	}
+	return buf, nil
+}
+
+func (compactMetadataCodec) Decode(buf []byte) (Metadata, error) {
+	md := make(Metadata)
+	for len(buf) > 0 {
+		k, rest, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		v, rest, err := readString(rest)
+		if err != nil {
+			return nil, err
+		}
+		md[k] = v
+		buf = rest
+	}
+	return md, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+	return append(buf, s...)
+}
+
+func readString(buf []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || size > uint64(len(buf)-n) {
+		return "", nil, ErrInvalidMetadata
+	}
+	end := n + int(size)
+	return string(buf[n:end]), buf[end:], nil
+}
@@ -0,0 +1,35 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultMetadataCodec(t *testing.T) {
+	md := Metadata{"trace-id": "abc", "user": "", "": "empty-key"}
+	buf, err := DefaultMetadataCodec.Encode(md)
+	assert.NoError(t, err)
+	// the pairs are sorted by the key, so the encoding is deterministic
+	again, _ := DefaultMetadataCodec.Encode(Metadata{"user": "", "": "empty-key", "trace-id": "abc"})
+	assert.Equal(t, buf, again)
+
+	decoded, err := DefaultMetadataCodec.Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, md, decoded)
+
+	buf, err = DefaultMetadataCodec.Encode(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, buf)
+	decoded, err = DefaultMetadataCodec.Decode(buf)
+	assert.NoError(t, err)
+	assert.Empty(t, decoded)
+}
+
+func TestDefaultMetadataCodecInvalid(t *testing.T) {
+	buf, _ := DefaultMetadataCodec.Encode(Metadata{"key": "value"})
+	for _, invalid := range [][]byte{buf[:len(buf)-1], buf[:4], {0x80}, {0x05, 'k'}} {
+		_, err := DefaultMetadataCodec.Decode(invalid)
+		assert.Equal(t, ErrInvalidMetadata, err, "%# x", invalid)
+	}
+}
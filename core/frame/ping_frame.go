@@ -0,0 +1,34 @@
+package frame
+
+import "github.com/yomorun/y3"
+
+// PingFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_PING_FRAME
+type PingFrame struct{}
+
+// NewPingFrame creates a new PingFrame.
+func NewPingFrame() *PingFrame {
+	return &PingFrame{}
+}
+
+// Type gets the type of Frame.
+func (m *PingFrame) Type() Type {
+	return TagOfPingFrame
+}
+
+// Encode to Y3 encoded bytes.
+func (m *PingFrame) Encode() []byte {
+	ping := y3.NewNodePacketEncoder(byte(m.Type()))
+	ping.AddBytes(nil)
+
+	return ping.Encode()
+}
+
+// DecodeToPingFrame decodes Y3 encoded bytes to PingFrame.
+func DecodeToPingFrame(buf []byte) (*PingFrame, error) {
+	nodeBlock := y3.NodePacket{}
+	_, err := y3.DecodeToNodePacket(buf, &nodeBlock)
+	if err != nil {
+		return nil, err
+	}
+	return &PingFrame{}, nil
+}
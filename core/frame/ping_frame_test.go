@@ -0,0 +1,19 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingFrameEncode(t *testing.T) {
+	f := NewPingFrame()
+	assert.Equal(t, []byte{0x80 | byte(TagOfPingFrame), 0x00}, f.Encode())
+}
+
+func TestPingFrameDecode(t *testing.T) {
+	buf := []byte{0x80 | byte(TagOfPingFrame), 0x00}
+	ping, err := DecodeToPingFrame(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x80 | byte(TagOfPingFrame), 0x00}, ping.Encode())
+}
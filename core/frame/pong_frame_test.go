@@ -0,0 +1,19 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPongFrameEncode(t *testing.T) {
+	f := NewPongFrame()
+	assert.Equal(t, []byte{0x80 | byte(TagOfPongFrame), 0x00}, f.Encode())
+}
+
+func TestPongFrameDecode(t *testing.T) {
+	buf := []byte{0x80 | byte(TagOfPongFrame), 0x00}
+	pong, err := DecodeToPongFrame(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x80 | byte(TagOfPongFrame), 0x00}, pong.Encode())
+}
@@ -2,12 +2,30 @@ package frame
 
 import "github.com/yomorun/y3"
 
+// RejectedMessagePaused is the message of the RejectedFrame of a DataFrame rejected while the
+// YoMo-Zipper is paused, the client keeps the connection on it.
+const RejectedMessagePaused = "paused"
+
+// RejectedMessageTooManyTransactions is the message of the RejectedFrame of a DataFrame rejected
+// since its source has too many open transactions, the connection is kept.
+const RejectedMessageTooManyTransactions = "too_many_transactions"
+
+// RejectedMessageStageFull is the message of the RejectedFrame of a DataFrame rejected since the
+// windows of its first stage are full, the connection is kept.
+const RejectedMessageStageFull = "stage_full"
+
+// RejectedMessageInvalidTransactionID is the message of the RejectedFrame of a DataFrame rejected
+// since its transaction id fails the validation of the YoMo-Zipper, the connection is kept.
+const RejectedMessageInvalidTransactionID = "invalid_transaction_id"
+
 // RejectedFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_REJECTED_FRAME
-type RejectedFrame struct{}
+type RejectedFrame struct {
+	message string
+}
 
-// NewRejectedFrame creates a new RejectedFrame with a given TagID of user's data
-func NewRejectedFrame() *RejectedFrame {
-	return &RejectedFrame{}
+// NewRejectedFrame creates a new RejectedFrame with the reason of rejection.
+func NewRejectedFrame(msg string) *RejectedFrame {
+	return &RejectedFrame{message: msg}
 }
 
 // Type gets the type of Frame.
@@ -15,10 +33,21 @@ func (m *RejectedFrame) Type() Type {
 	return TagOfRejectedFrame
 }
 
+// Message returns the reason of rejection.
+func (m *RejectedFrame) Message() string {
+	return m.message
+}
+
 // Encode to Y3 encoded bytes
 func (m *RejectedFrame) Encode() []byte {
 	rejected := y3.NewNodePacketEncoder(byte(m.Type()))
-	rejected.AddBytes(nil)
+	if m.message == "" {
+		rejected.AddBytes(nil)
+		return rejected.Encode()
+	}
+	message := y3.NewPrimitivePacketEncoder(byte(TagOfRejectedMessage))
+	message.SetStringValue(m.message)
+	rejected.AddPrimitivePacket(message)
 
 	return rejected.Encode()
 }
@@ -30,5 +59,13 @@ func DecodeToRejectedFrame(buf []byte) (*RejectedFrame, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &RejectedFrame{}, nil
+	rejected := &RejectedFrame{}
+	if messageBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfRejectedMessage)]; ok {
+		message, err := messageBlock.ToUTF8String()
+		if err != nil {
+			return nil, err
+		}
+		rejected.message = message
+	}
+	return rejected, nil
 }
@@ -7,7 +7,7 @@ import (
 )
 
 func TestRejectedFrameEncode(t *testing.T) {
-	f := NewRejectedFrame()
+	f := NewRejectedFrame("")
 	assert.Equal(t, []byte{0x80 | byte(TagOfRejectedFrame), 0x00}, f.Encode())
 }
 
@@ -17,3 +17,11 @@ func TestRejectedFrameDecode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte{0x80 | byte(TagOfRejectedFrame), 0x00}, ping.Encode())
 }
+
+func TestRejectedFrameMessage(t *testing.T) {
+	f := NewRejectedFrame("no workflow configured")
+	rejected, err := DecodeToRejectedFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, "no workflow configured", rejected.Message())
+	assert.Equal(t, f.Encode(), rejected.Encode())
+}
@@ -0,0 +1,58 @@
+package frame
+
+import "github.com/yomorun/y3"
+
+// SubscriptionFrame is a Y3 encoded bytes, Tag is a fixed value TYPE_ID_SUBSCRIPTION_FRAME,
+// the stream function sends it to replace the data tags and the weight declared by its
+// handshake without reconnecting.
+type SubscriptionFrame struct {
+	// ObserveDataTags are the data tags the stream function observes from now on.
+	ObserveDataTags []byte
+	// Weight is the share of the frames the stream function receives, 0 means the default weight.
+	Weight uint32
+}
+
+// NewSubscriptionFrame creates a new SubscriptionFrame.
+func NewSubscriptionFrame(observeDataTags []byte, weight uint32) *SubscriptionFrame {
+	return &SubscriptionFrame{ObserveDataTags: observeDataTags, Weight: weight}
+}
+
+// Type gets the type of Frame.
+func (m *SubscriptionFrame) Type() Type {
+	return TagOfSubscriptionFrame
+}
+
+// Encode to Y3 encoded bytes.
+func (m *SubscriptionFrame) Encode() []byte {
+	tags := y3.NewPrimitivePacketEncoder(byte(TagOfSubscriptionObserveDataTags))
+	tags.SetBytesValue(m.ObserveDataTags)
+	weight := y3.NewPrimitivePacketEncoder(byte(TagOfSubscriptionWeight))
+	weight.SetUInt32Value(m.Weight)
+
+	subscription := y3.NewNodePacketEncoder(byte(m.Type()))
+	subscription.AddPrimitivePacket(tags)
+	subscription.AddPrimitivePacket(weight)
+
+	return subscription.Encode()
+}
+
+// DecodeToSubscriptionFrame decodes Y3 encoded bytes to SubscriptionFrame.
+func DecodeToSubscriptionFrame(buf []byte) (*SubscriptionFrame, error) {
+	nodeBlock := y3.NodePacket{}
+	_, err := y3.DecodeToNodePacket(buf, &nodeBlock)
+	if err != nil {
+		return nil, err
+	}
+	subscription := &SubscriptionFrame{}
+	if tagsBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfSubscriptionObserveDataTags)]; ok {
+		subscription.ObserveDataTags = tagsBlock.ToBytes()
+	}
+	if weightBlock, ok := nodeBlock.PrimitivePackets[byte(TagOfSubscriptionWeight)]; ok {
+		weight, err := weightBlock.ToUInt32()
+		if err != nil {
+			return nil, err
+		}
+		subscription.Weight = weight
+	}
+	return subscription, nil
+}
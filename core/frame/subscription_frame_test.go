@@ -0,0 +1,16 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionFrame(t *testing.T) {
+	f := NewSubscriptionFrame([]byte{0x33, 0x34}, 3)
+	subscription, err := DecodeToSubscriptionFrame(f.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x33, 0x34}, subscription.ObserveDataTags)
+	assert.EqualValues(t, 3, subscription.Weight)
+	assert.Equal(t, f.Encode(), subscription.Encode())
+}
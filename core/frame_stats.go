@@ -0,0 +1,72 @@
+package core
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// FrameStat describes how many frames of a type have been received.
+type FrameStat struct {
+	// Count is the number of frames.
+	Count int64
+	// Bytes is the accumulated size of the frames.
+	Bytes int64
+}
+
+// frameStats accumulates the received frames per type.
+type frameStats struct {
+	counts [256]int64
+	bytes  [256]int64
+}
+
+func (s *frameStats) observe(t frame.Type, n int64) {
+	atomic.AddInt64(&s.counts[t], 1)
+	atomic.AddInt64(&s.bytes[t], n)
+}
+
+func (s *frameStats) snapshot() map[frame.Type]FrameStat {
+	result := make(map[frame.Type]FrameStat)
+	for t := range s.counts {
+		if count := atomic.LoadInt64(&s.counts[t]); count > 0 {
+			result[frame.Type(t)] = FrameStat{
+				Count: count,
+				Bytes: atomic.LoadInt64(&s.bytes[t]),
+			}
+		}
+	}
+	return result
+}
+
+func (s *frameStats) reset() {
+	for t := range s.counts {
+		atomic.StoreInt64(&s.counts[t], 0)
+		atomic.StoreInt64(&s.bytes[t], 0)
+	}
+}
+
+// countingStream counts the bytes read from the stream.
+type countingStream struct {
+	io.ReadWriter
+	n int64
+}
+
+func (s *countingStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriter.Read(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// expect makes the next n bytes read not counted, as they're counted in advance, returns n.
+func (s *countingStream) expect(n int64) int64 {
+	s.n -= n
+	return n
+}
+
+// take returns the bytes read since the last take.
+func (s *countingStream) take() int64 {
+	n := s.n
+	s.n = 0
+	return n
+}
@@ -13,6 +13,14 @@ type FrameStream struct {
 	// Stream is a QUIC stream.
 	stream io.ReadWriter
 	mu     sync.Mutex
+	// peeked is the packet read by Peek, not consumed by ReadFrame yet.
+	peeked []byte
+	// maxSize is the max size of the frames read, 0 means unlimited.
+	maxSize int
+	// streamThreshold is the size of the DataFrames read as StreamingDataFrame, 0 means never.
+	streamThreshold int
+	// streaming is the last StreamingDataFrame read, its carriage is discarded before the next read.
+	streaming *StreamingDataFrame
 }
 
 // NewFrameStream creates a new FrameStream.
@@ -23,12 +31,107 @@ func NewFrameStream(s io.ReadWriter) *FrameStream {
 	}
 }
 
-// ReadFrame reads next frame from QUIC stream.
+// SetMaxFrameSize limits the size of the next frames read, the frames exceed it fail with
+// a ParseError wrapping ErrFrameTooLarge before they're read into memory. 0 means unlimited.
+func (fs *FrameStream) SetMaxFrameSize(size int) {
+	fs.maxSize = size
+}
+
+// SetStreamThreshold makes the DataFrames larger than size read as StreamingDataFrame, their
+// carriages are read from the stream on demand rather than buffered. 0 means never.
+// The frames peeked by Peek are buffered anyway.
+func (fs *FrameStream) SetStreamThreshold(size int) {
+	fs.streamThreshold = size
+}
+
+// ReadFrame reads next frame from QUIC stream, or the frame peeked by Peek.
 func (fs *FrameStream) ReadFrame() (frame.Frame, error) {
 	if fs.stream == nil {
 		return nil, errors.New("core.ReadStream: stream can not be nil")
 	}
-	return ParseFrame(fs.stream)
+	if err := fs.discardStreaming(); err != nil {
+		return nil, err
+	}
+	buf := fs.peeked
+	fs.peeked = nil
+	if buf == nil && fs.streamThreshold > 0 {
+		return fs.readStreaming()
+	}
+	if buf == nil {
+		var err error
+		if buf, err = readPacket(fs.stream, fs.maxSize); err != nil {
+			return nil, err
+		}
+	}
+	f, err := decodeFrame(buf)
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	return f, nil
+}
+
+// readStreaming reads the next frame, the DataFrame larger than the stream threshold is read
+// as StreamingDataFrame.
+func (fs *FrameStream) readStreaming() (frame.Frame, error) {
+	header, length, err := readPacketHead(fs.stream)
+	if err != nil {
+		return nil, err
+	}
+	size := len(header) + length
+	if err := checkFrameSize(size, fs.maxSize); err != nil {
+		return nil, err
+	}
+	if header[0] == 0x80|byte(frame.TagOfDataFrame) && size > fs.streamThreshold {
+		f, err := readStreamingDataFrame(fs.stream, header, length)
+		if sf, ok := f.(*StreamingDataFrame); ok {
+			fs.streaming = sf
+		}
+		return f, err
+	}
+	buf, err := readPacketValue(fs.stream, header, length)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decodeFrame(buf)
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	return f, nil
+}
+
+// discardStreaming drops the unread carriage of the last StreamingDataFrame.
+func (fs *FrameStream) discardStreaming() error {
+	if fs.streaming == nil {
+		return nil
+	}
+	err := fs.streaming.discard()
+	fs.streaming = nil
+	return err
+}
+
+// Peek reads the type and the length of the next frame without decoding it, the frame
+// is still delivered by the next ReadFrame, unless it's dropped by Discard.
+func (fs *FrameStream) Peek() (frame.Type, int, error) {
+	if fs.stream == nil {
+		return 0, 0, errors.New("core.Peek: stream can not be nil")
+	}
+	if fs.peeked == nil {
+		if err := fs.discardStreaming(); err != nil {
+			return 0, 0, err
+		}
+		buf, err := readPacket(fs.stream, fs.maxSize)
+		if err != nil {
+			return 0, 0, err
+		}
+		fs.peeked = buf
+	}
+	// the highest 2 bits of the tag are the flags of y3
+	return frame.Type(fs.peeked[0] & 0x3F), len(fs.peeked), nil
+}
+
+// Discard drops the peeked frame.
+func (fs *FrameStream) Discard() {
+	fs.peeked = nil
 }
 
 // WriteFrame writes a frame into QUIC stream.
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/y3"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestFrameStreamPeek(t *testing.T) {
+	data := newDataFrame("tid", "source", 0x33)
+	buf := encodeFrames(frame.NewPingFrame(), data, frame.NewPongFrame())
+	fs := NewFrameStream(&mockStream{r: bytes.NewReader(buf)})
+
+	// discard the ping
+	typ, n, err := fs.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfPingFrame, typ)
+	assert.Equal(t, len(frame.NewPingFrame().Encode()), n)
+	fs.Discard()
+
+	// peek twice, then read the data frame
+	typ, n, err = fs.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfDataFrame, typ)
+	assert.Equal(t, len(data.Encode()), n)
+	typ, _, err = fs.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfDataFrame, typ)
+	f, err := fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "tid", f.(*frame.DataFrame).TransactionID())
+
+	// read without peek
+	f, err = fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfPongFrame, f.Type())
+}
+
+func TestFrameStreamMaxFrameSize(t *testing.T) {
+	data := newDataFrame("tid", "source", 0x33)
+	size := len(data.Encode())
+	buf := encodeFrames(data, data, data)
+	fs := NewFrameStream(&mockStream{r: bytes.NewReader(buf)})
+
+	fs.SetMaxFrameSize(size)
+	f, err := fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "tid", f.(*frame.DataFrame).TransactionID())
+
+	fs.SetMaxFrameSize(0)
+	_, n, err := fs.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, size, n)
+	fs.Discard()
+
+	fs.SetMaxFrameSize(size - 1)
+	_, err = fs.ReadFrame()
+	var pe *ParseError
+	assert.True(t, errors.As(err, &pe))
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+}
+
+func TestReadPacketMalformedLength(t *testing.T) {
+	_, err := readPacket(bytes.NewReader([]byte{0x81, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}), 1024)
+	assert.True(t, errors.Is(err, y3.ErrMalformed))
+
+	_, err = readPacket(bytes.NewReader([]byte{0x81, 0x05, 0x01}), 1024)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -4,7 +4,8 @@ import (
 	"github.com/yomorun/yomo/core/frame"
 )
 
-// AsyncHandler is the request-response mode (asnyc)
+// AsyncHandler is the request-response mode (asnyc), the returned data is sent to the zipper
+// with the tag unless it's nil, so an empty data is a signal carried by the tag only.
 type AsyncHandler func([]byte) (byte, []byte)
 
 // PipeHandler is the bidirectional stream mode (blocking).
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/yomorun/yomo/core/clock"
+)
+
+// handshakeTimeout bounds how long the start of a connection is tracked, the connections
+// which don't complete the handshake by then are forgotten.
+const handshakeTimeout = time.Minute
+
+// handshakeTracer records when the connections start, so the duration of the handshake is
+// known once a connection is accepted. It traces nothing else.
+type handshakeTracer struct {
+	clock     clock.Clock
+	mu        sync.Mutex
+	startedAt map[uint64]time.Time // by the tracing id of the connection
+	purgedAt  time.Time
+}
+
+var _ logging.Tracer = (*handshakeTracer)(nil)
+
+func newHandshakeTracer(clock clock.Clock) *handshakeTracer {
+	return &handshakeTracer{
+		clock:     clock,
+		startedAt: make(map[uint64]time.Time),
+	}
+}
+
+// TracerForConnection records the start of the connection, the connection itself isn't traced.
+func (t *handshakeTracer) TracerForConnection(ctx context.Context, p logging.Perspective, _ logging.ConnectionID) logging.ConnectionTracer {
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok || p != logging.PerspectiveServer {
+		return nil
+	}
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.startedAt[id] = now
+	if now.Sub(t.purgedAt) > handshakeTimeout {
+		for id, startedAt := range t.startedAt {
+			if now.Sub(startedAt) > handshakeTimeout {
+				delete(t.startedAt, id)
+			}
+		}
+		t.purgedAt = now
+	}
+	return nil
+}
+
+// SentPacket is not traced.
+func (t *handshakeTracer) SentPacket(net.Addr, *logging.Header, logging.ByteCount, []logging.Frame) {}
+
+// DroppedPacket is not traced.
+func (t *handshakeTracer) DroppedPacket(net.Addr, logging.PacketType, logging.ByteCount, logging.PacketDropReason) {
+}
+
+// took returns how long the handshake of the accepted connection took, 0 if its start isn't known.
+func (t *handshakeTracer) took(conn quic.Connection, now time.Time) time.Duration {
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	startedAt, ok := t.startedAt[id]
+	if !ok {
+		return 0
+	}
+	delete(t.startedAt, id)
+	return now.Sub(startedAt)
+}
+
+// apply returns a copy of the quic config traced by the tracer as well, nil means the default.
+func (t *handshakeTracer) apply(c *quic.Config) *quic.Config {
+	if c == nil {
+		c = defaultServerQuicConfig()
+	}
+	c = c.Clone()
+	if c.Tracer != nil {
+		c.Tracer = logging.NewMultiplexedTracer(c.Tracer, t)
+	} else {
+		c.Tracer = t
+	}
+	return c
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// heartbeatTolerance is the number of the heartbeats a connection can miss before it's
+// considered idle, i.e. the read timeout of the connection is heartbeatTolerance heartbeats.
+const heartbeatTolerance = 3
+
+// applyHeartbeat returns a copy of the config for the peers pinging every interval, the QUIC
+// keep-alive is disabled and the idle timeout covers heartbeatTolerance intervals.
+func applyHeartbeat(c *quic.Config, interval time.Duration) *quic.Config {
+	c = c.Clone()
+	c.KeepAlive = false
+	if idle := heartbeatTolerance * interval; c.MaxIdleTimeout < idle {
+		c.MaxIdleTimeout = idle
+	}
+	return c
+}
+
+// negotiateHeartbeat returns the heartbeat interval granted for the interval in milliseconds
+// requested by the handshake, it's bounded by the server. 0 means it isn't negotiated.
+func (s *Server) negotiateHeartbeat(requested uint32) time.Duration {
+	if requested == 0 || s.opts.HeartbeatMax <= 0 {
+		return 0
+	}
+	interval := time.Duration(requested) * time.Millisecond
+	if interval < s.opts.HeartbeatMin {
+		interval = s.opts.HeartbeatMin
+	}
+	if interval > s.opts.HeartbeatMax {
+		interval = s.opts.HeartbeatMax
+	}
+	return interval
+}
+
+// readTimeout returns the read timeout of the connection, it's overridden by the heartbeat
+// negotiated by the connection.
+func (s *Server) readTimeout(connID string) time.Duration {
+	if v, ok := s.heartbeats.Load(connID); ok {
+		return heartbeatTolerance * v.(time.Duration)
+	}
+	return s.opts.ReadTimeout
+}
+
+// handlePingFrame responds the heartbeat of the client.
+func (s *Server) handlePingFrame(c *Context) {
+	if err := s.connector.WriteControl(frame.NewPongFrame(), c.ConnID); err != nil {
+		c.Logger().Debugf("%spong [%s] err=%v", ServerLogPrefix, c.ConnID, err)
+	}
+}
+
+// quicConfig returns the config to dial the server. The QUIC keep-alive of a connection can't
+// be turned off once it's dialed, so it's kept until the server confirms the heartbeat, the
+// connections dialed after it rely on the heartbeat instead, see acceptHeartbeat.
+func (c *Client) quicConfig() *quic.Config {
+	if interval := atomic.LoadInt64(&c.heartbeatInterval); interval > 0 {
+		return applyHeartbeat(c.opts.QuicConfig, time.Duration(interval))
+	}
+	return c.opts.QuicConfig
+}
+
+// acceptHeartbeat applies the heartbeat interval confirmed by the AcceptedFrame, 0 means the
+// server doesn't confirm it, then the next connections keep the QUIC keep-alive.
+func (c *Client) acceptHeartbeat(conn quic.Connection, confirmed time.Duration) {
+	if confirmed > c.opts.Heartbeat {
+		confirmed = c.opts.Heartbeat
+	}
+	atomic.StoreInt64(&c.heartbeatInterval, int64(confirmed))
+	if confirmed > 0 && atomic.CompareAndSwapInt32(&c.heartbeating, 0, 1) {
+		go c.heartbeat(conn)
+	}
+}
+
+// heartbeat pings the server every interval confirmed until the connection is closed. The
+// connection dialed without the QUIC keep-alive is pinged at the interval requested if the
+// server doesn't confirm it anymore, so it isn't closed for idle.
+func (c *Client) heartbeat(conn quic.Connection) {
+	for {
+		interval := time.Duration(atomic.LoadInt64(&c.heartbeatInterval))
+		if interval <= 0 {
+			interval = c.opts.Heartbeat
+		}
+		select {
+		case <-conn.Context().Done():
+			return
+		case <-c.opts.Clock.After(interval):
+		}
+		if err := c.writeControl(frame.NewPingFrame()); err != nil {
+			c.logger.Warnf("%s[%s] heartbeat error: %v", ClientLogPrefix, c.name, err)
+		}
+	}
+}
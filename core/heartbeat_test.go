@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerHeartbeat(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper",
+		WithServerClock(fake),
+		WithPing(time.Second, time.Second),
+		WithReadTimeout(5*time.Second),
+		WithHeartbeatBounds(10*time.Second, time.Minute),
+	)
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2", "sfn-3"}})
+	connect := func(connID string, name string, heartbeat time.Duration) *syncBuffer {
+		handshake := frame.NewHandshakeFrame(name, byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+		handshake.Heartbeat = uint32(heartbeat / time.Millisecond)
+		return connectHandshake(s, connID, handshake)
+	}
+	slow := connect("sfn-1-conn", "sfn-1", 5*time.Minute)
+	chatty := connect("sfn-2-conn", "sfn-2", time.Second)
+	plain := connect("sfn-3-conn", "sfn-3", 0)
+
+	// the requested intervals are bounded by the server
+	for sfn, interval := range map[*syncBuffer]time.Duration{slow: time.Minute, chatty: 10 * time.Second} {
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+		accepted, ok := sfn.Frames()[0].(*frame.AcceptedFrame)
+		assert.True(t, ok)
+		assert.EqualValues(t, interval/time.Millisecond, accepted.Heartbeat)
+	}
+	assert.Equal(t, 3*time.Minute, s.readTimeout("sfn-1-conn"))
+	assert.Equal(t, 30*time.Second, s.readTimeout("sfn-2-conn"))
+	assert.Equal(t, 5*time.Second, s.readTimeout("sfn-3-conn"))
+
+	// only the stream function without the heartbeat is pinged by the server
+	s.pingOnce()
+	assert.True(t, waitFor(func() bool { return len(plain.Frames()) == 1 }))
+	assert.Equal(t, frame.TagOfPingFrame, plain.Frames()[0].Type())
+	assert.Len(t, slow.Frames(), 1)
+
+	// the heartbeat is responded
+	assert.NoError(t, s.mainFrameHandler(&Context{ConnID: "sfn-1-conn", Frame: frame.NewPingFrame()}))
+	assert.True(t, waitFor(func() bool { return len(slow.Frames()) == 2 }))
+	assert.Equal(t, frame.TagOfPongFrame, slow.Frames()[1].Type())
+}
+
+func TestServerHeartbeatDisabled(t *testing.T) {
+	s := newTestServer("sfn-1")
+	handshake := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	handshake.Heartbeat = 60000
+	sfn := connectHandshake(s, "sfn-1-conn", handshake)
+
+	// the client keeps the QUIC keep-alive without the AcceptedFrame
+	assert.NoError(t, s.mainFrameHandler(&Context{ConnID: "sfn-1-conn", Frame: frame.NewPingFrame()}))
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	assert.Equal(t, frame.TagOfPongFrame, sfn.Frames()[0].Type())
+	assert.Zero(t, s.readTimeout("sfn-1-conn"))
+}
+
+func TestHeartbeatQuicConfig(t *testing.T) {
+	s := NewServer("test-zipper", WithHeartbeatBounds(time.Second, time.Minute))
+	qc, err := s.quicConfig()
+	assert.NoError(t, err)
+	assert.False(t, qc.KeepAlive)
+	assert.Equal(t, 3*time.Minute, qc.MaxIdleTimeout)
+
+	// the client keeps the QUIC keep-alive until the server confirms the heartbeat
+	c := NewClient("source", ClientTypeSource, WithHeartbeat(30*time.Second))
+	assert.True(t, c.quicConfig().KeepAlive)
+	c.acceptHeartbeat(nil, 0)
+	assert.True(t, c.quicConfig().KeepAlive)
+	atomic.StoreInt64(&c.heartbeatInterval, int64(30*time.Second))
+	assert.False(t, c.quicConfig().KeepAlive)
+	assert.Equal(t, 90*time.Second, c.quicConfig().MaxIdleTimeout)
+	// the default config isn't changed
+	assert.True(t, c.opts.QuicConfig.KeepAlive)
+	assert.True(t, defaultClientQuicConfig().KeepAlive)
+}
+
+func TestClientHeartbeatConfirmed(t *testing.T) {
+	connect := func(s *Server) *Client {
+		addr := freeAddr(t)
+		go s.ListenAndServe(context.Background(), addr)
+		assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+		c := NewClient("source", ClientTypeSource, WithHeartbeat(time.Second), WithReconnectBackoff(10*time.Millisecond, time.Second, 0))
+		assert.NoError(t, c.Connect(context.Background(), addr))
+		assert.True(t, waitFor(func() bool { return len(s.ListSessions()) == 1 }))
+		return c
+	}
+	reconnect := func(s *Server, c *Client) {
+		id := s.ListSessions()[0].ID
+		assert.NoError(t, s.CloseSession(id))
+		// the client reconnects after a second
+		assert.Eventually(t, func() bool {
+			sessions := s.ListSessions()
+			return len(sessions) == 1 && sessions[0].ID != id
+		}, 3*time.Second, 10*time.Millisecond)
+	}
+
+	// the server without the heartbeat never confirms it, the keep-alive is kept
+	s := newTestServer("sfn-1")
+	defer s.Close()
+	c := connect(s)
+	defer c.Close()
+	reconnect(s, c)
+	assert.Zero(t, atomic.LoadInt64(&c.heartbeatInterval))
+	assert.True(t, c.quicConfig().KeepAlive)
+
+	// the keep-alive is disabled for the connections after the confirmation
+	hs := NewServer("test-zipper", WithHeartbeatBounds(time.Second, time.Minute))
+	hs.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	defer hs.Close()
+	hc := connect(hs)
+	defer hc.Close()
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt64(&hc.heartbeatInterval) == int64(time.Second) }))
+	assert.False(t, hc.quicConfig().KeepAlive)
+	reconnect(hs, hc)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&hc.heartbeating))
+}
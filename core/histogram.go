@@ -0,0 +1,98 @@
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds of the latency histogram buckets.
+var defaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// processingLatencyBuckets are the upper bounds of the buckets of the time the server takes
+// to route and forward a DataFrame, which is far shorter than the delivery.
+var processingLatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+}
+
+// Histogram is a snapshot of the observed durations.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets.
+	Buckets []time.Duration
+	// Counts are the number of the durations in each bucket, the last one counts the
+	// durations larger than all the buckets.
+	Counts []int64
+	// Count is the number of the observed durations.
+	Count int64
+	// Sum is the accumulated observed durations.
+	Sum time.Duration
+}
+
+// Avg returns the average duration.
+func (h Histogram) Avg() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// histogram counts the durations in the buckets atomically.
+type histogram struct {
+	buckets []time.Duration
+	counts  []int64
+	count   int64
+	sum     int64
+}
+
+func newHistogram(buckets []time.Duration) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.buckets) && d > h.buckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return Histogram{
+		Buckets: h.buckets,
+		Counts:  counts,
+		Count:   atomic.LoadInt64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+}
+
+func (h *histogram) reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.count, 0)
+	atomic.StoreInt64(&h.sum, 0)
+}
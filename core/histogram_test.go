@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.observe(time.Microsecond)
+	h.observe(time.Millisecond)
+	h.observe(500 * time.Millisecond)
+	h.observe(time.Minute)
+
+	snapshot := h.snapshot()
+	assert.Equal(t, []int64{2, 1, 1}, snapshot.Counts)
+	assert.EqualValues(t, 4, snapshot.Count)
+	assert.Equal(t, (time.Microsecond+time.Millisecond+500*time.Millisecond+time.Minute)/4, snapshot.Avg())
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrListen matches the errors of binding the endpoint, e.g. the address is already in use,
+// check it with errors.Is(err, ErrListen).
+var ErrListen = errors.New("listen failed")
+
+// ErrServerClosed is returned by ListenAndServe and Serve after the server is closed by Close.
+var ErrServerClosed = errors.New("server is closed")
+
+// ListenError is returned when the server fails to bind the endpoint.
+type ListenError struct {
+	// Addr is the endpoint attempted.
+	Addr string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ListenError) Error() string {
+	return "listen on " + e.Addr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrListen.
+func (e *ListenError) Is(target error) bool {
+	return target == ErrListen
+}
+
+// listenUDP binds the udp endpoint, the error is a *ListenError.
+func listenUDP(addr string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, &ListenError{Addr: addr, Err: err}
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, &ListenError{Addr: addr, Err: err}
+	}
+	return conn, nil
+}
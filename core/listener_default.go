@@ -3,7 +3,6 @@ package core
 import (
 	"crypto/tls"
 	"net"
-	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/pkg/logger"
@@ -39,18 +38,7 @@ func (l *defaultListener) Listen(conn net.PacketConn, tlsConfig *tls.Config, qui
 	// quic config
 	var c *quic.Config = quicConfig
 	if c == nil {
-		c = &quic.Config{
-			Versions:                       []quic.VersionNumber{quic.Version1, quic.VersionDraft29},
-			MaxIdleTimeout:                 time.Second * 5,
-			KeepAlive:                      true,
-			MaxIncomingStreams:             1000,
-			MaxIncomingUniStreams:          1000,
-			HandshakeIdleTimeout:           time.Second * 3,
-			InitialStreamReceiveWindow:     1024 * 1024 * 2,
-			InitialConnectionReceiveWindow: 1024 * 1024 * 2,
-			DisablePathMTUDiscovery:        true,
-			// Tracer:                         getQlogConfig("server"),
-		}
+		c = defaultServerQuicConfig()
 	}
 	l.c = c
 
@@ -40,6 +40,37 @@ type Logger interface {
 	ErrorOutput(file string)
 }
 
+// FieldLogger is the Logger supports the structured fields, e.g. in the JSON format.
+type FieldLogger interface {
+	Logger
+	// With returns a child logger with the key/value pairs added to every message, the
+	// pretty format may ignore them.
+	With(keysAndValues ...interface{}) Logger
+}
+
+// LevelEnabler is the Logger which tells whether it logs the messages of a level, so the
+// messages it discards aren't built.
+type LevelEnabler interface {
+	Enabled(level Level) bool
+}
+
+// IsDebug reports whether l logs the messages at DebugLevel, true if l can't tell.
+func IsDebug(l Logger) bool {
+	if le, ok := l.(LevelEnabler); ok {
+		return le.Enabled(DebugLevel)
+	}
+	return true
+}
+
+// With returns a child logger of l with the key/value pairs if it's a FieldLogger,
+// otherwise l itself.
+func With(l Logger, keysAndValues ...interface{}) Logger {
+	if fl, ok := l.(FieldLogger); ok {
+		return fl.With(keysAndValues...)
+	}
+	return l
+}
+
 // String the logger level
 func (l Level) String() string {
 	switch l {
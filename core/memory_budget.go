@@ -0,0 +1,114 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// frameOverhead approximates the memory of a buffered DataFrame beyond its variable fields.
+const frameOverhead = 64
+
+// frameSize returns the approximate memory of the DataFrame.
+func frameSize(f *frame.DataFrame) int64 {
+	return int64(len(f.GetCarriage()) + len(f.Metadata()) + len(f.TransactionID()) + len(f.Issuer()) + frameOverhead)
+}
+
+// memoryBudget tracks the approximate bytes of the frames held by the buffers of the server,
+// i.e. the send queues, the replay buffer and the reassembler. A frame held by several buffers
+// is counted by each of them. Once it's exceeded, the oldest frames of the caches are evicted
+// first, then the oldest frames of the send queue being pushed to. A nil budget tracks nothing.
+type memoryBudget struct {
+	limit    int64 // 0 means unlimited, the bytes are tracked only
+	held     int64 // accessed atomically
+	dropped  int64 // accessed atomically
+	mu       sync.Mutex
+	evictors []func() bool // evict the oldest frame of a cache, false if it's empty
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit < 0 {
+		limit = 0
+	}
+	return &memoryBudget{limit: limit}
+}
+
+// add the bytes of the frames buffered.
+func (b *memoryBudget) add(n int64) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.held, n)
+}
+
+// release the bytes of the frames no longer buffered.
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.held, -n)
+}
+
+// drop releases the bytes of the frame evicted for the budget.
+func (b *memoryBudget) drop(n int64) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.held, -n)
+	atomic.AddInt64(&b.dropped, 1)
+}
+
+// over reports whether the budget is exceeded.
+func (b *memoryBudget) over() bool {
+	return b != nil && b.limit > 0 && atomic.LoadInt64(&b.held) > b.limit
+}
+
+// addEvictor registers a cache whose frames are evicted by reclaim, in the order they're added.
+func (b *memoryBudget) addEvictor(evict func() bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.evictors = append(b.evictors, evict)
+	b.mu.Unlock()
+}
+
+// reclaim evicts the oldest frames of the caches until the budget isn't exceeded, it must not
+// be called with the lock of a cache held.
+func (b *memoryBudget) reclaim() {
+	if !b.over() {
+		return
+	}
+	b.mu.Lock()
+	evictors := b.evictors
+	b.mu.Unlock()
+	for _, evict := range evictors {
+		for b.over() && evict() {
+		}
+	}
+}
+
+// Held returns the approximate bytes of the buffered frames.
+func (b *memoryBudget) Held() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.held)
+}
+
+// Dropped returns how many frames are evicted for the budget.
+func (b *memoryBudget) Dropped() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// resetDropped zeroes the dropped frames, the held bytes are kept.
+func (b *memoryBudget) resetDropped() {
+	if b == nil {
+		return
+	}
+	atomic.StoreInt64(&b.dropped, 0)
+}
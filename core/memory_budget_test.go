@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestSendQueueMemoryBudget(t *testing.T) {
+	size := frameSize(newPriorityFrame("1", frame.PriorityNormal))
+	budget := newMemoryBudget(3 * size)
+	q := newSendQueue(clock.New())
+	q.budget = budget
+
+	assert.NoError(t, q.Push(newPriorityFrame("1", frame.PriorityLow)))
+	for _, tid := range []string{"2", "3", "4", "5"} {
+		assert.NoError(t, q.Push(newPriorityFrame(tid, frame.PriorityNormal)))
+	}
+	// the low priority frame is dropped first, then the oldest ones
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, 3*size, budget.Held())
+	assert.EqualValues(t, 2, budget.Dropped())
+	item, _ := q.Pop()
+	assert.Equal(t, "3", item.frame.TransactionID())
+	assert.Equal(t, 2*size, budget.Held())
+
+	q.Close()
+	assert.Zero(t, budget.Held())
+}
+
+func TestReplayMemoryBudget(t *testing.T) {
+	size := frameSize(newDataFrame("1", "source", 0x33))
+	budget := newMemoryBudget(3 * size)
+	b := newReplayBuffer(4, budget)
+	for _, tid := range []string{"1", "2", "3", "4"} {
+		b.record("app", "sfn-1", newDataFrame(tid, "source", 0x33))
+	}
+	assert.Equal(t, 3*size, budget.Held())
+
+	// the replay buffer is evicted before the frames to deliver
+	q := newSendQueue(clock.New())
+	q.budget = budget
+	assert.NoError(t, q.Push(newDataFrame("5", "source", 0x33)))
+	assert.Equal(t, 1, q.Len())
+	tids := []string{}
+	for _, f := range b.frames("app", "sfn-1") {
+		tids = append(tids, f.TransactionID())
+	}
+	assert.Equal(t, []string{"3", "4"}, tids)
+
+	b.record("app", "sfn-1", newDataFrame("6", "source", 0x33))
+	assert.Equal(t, 3*size, budget.Held())
+	assert.EqualValues(t, 3, budget.Dropped())
+	assert.Len(t, b.frames("app", "sfn-1"), 2)
+}
+
+func TestReassemblerMemoryBudget(t *testing.T) {
+	f := newDataFrame("msg-1", "source", 0x33)
+	f.SetCarriage(0x33, make([]byte, 100))
+	fragments := SplitDataFrame(f, 10)
+	budget := newMemoryBudget(12 * frameSize(fragments[0]))
+	r := NewReassembler(time.Minute)
+	r.budget = budget
+
+	for _, fragment := range fragments[:9] {
+		r.Add(fragment, time.Now())
+	}
+	other := newDataFrame("msg-2", "source", 0x33)
+	other.SetCarriage(0x33, make([]byte, 100))
+	for _, fragment := range SplitDataFrame(other, 10)[:4] {
+		r.Add(fragment, time.Now())
+	}
+	// the oldest incomplete message is evicted
+	assert.Equal(t, 1, r.Pending())
+	assert.EqualValues(t, 1, budget.Dropped())
+	assert.Equal(t, 4*frameSize(fragments[0]), budget.Held())
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler serves the stats of the server in the Prometheus text exposition format, so
+// it's scraped without another dependency, e.g. http.Handle("/metrics", s.MetricsHandler()).
+// The metrics are read from Stats on every scrape.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		w.Write(encodeMetrics(s.Stats()))
+	})
+}
+
+// encodeMetrics encodes the stats in the Prometheus text exposition format.
+func encodeMetrics(stats ServerStats) []byte {
+	var buf bytes.Buffer
+	m := metricsWriter{&buf}
+
+	m.family("yomo_zipper_data_frames_total", "counter", "The number of the received DataFrames.")
+	m.sample("yomo_zipper_data_frames_total", nil, stats.DataFrames)
+
+	m.family("yomo_zipper_dropped_frames_total", "counter", "The number of the dropped DataFrames per reason.")
+	dropped := stats.Dropped
+	for _, d := range []struct {
+		reason string
+		value  int64
+	}{
+		{"hops_exceeded", dropped.HopsExceeded},
+		{"filtered", dropped.Filtered},
+		{"protocol_violation", dropped.ProtocolViolation},
+		{"capacity_skipped", dropped.CapacitySkipped},
+		{"over_budget", dropped.OverBudget},
+		{"no_first_stage", dropped.NoFirstStage},
+		{"transformed", dropped.Transformed},
+		{"expired", dropped.Expired},
+		{"paused", dropped.Paused},
+		{"too_many_transactions", dropped.TooManyTransactions},
+		{"invalid_transaction_id", dropped.InvalidTransactionID},
+		{"write_failed", dropped.WriteFailed},
+		{"carriage_failed", dropped.CarriageFailed},
+		{"no_stream", dropped.NoStream},
+		{"queue_overflow", dropped.QueueOverflow},
+		{"stage_full", dropped.StageFull},
+	} {
+		m.sample("yomo_zipper_dropped_frames_total", []string{"reason", d.reason}, d.value)
+	}
+
+	m.family("yomo_zipper_sessions", "gauge", "The number of the open sessions.")
+	m.sample("yomo_zipper_sessions", nil, stats.Sessions)
+
+	// the connection churn per client type
+	types := make([]ClientType, 0, len(stats.Churn))
+	for t := range stats.Churn {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	m.family("yomo_zipper_connects_total", "counter", "The number of the connections registered by the handshake per client type.")
+	for _, t := range types {
+		m.sample("yomo_zipper_connects_total", []string{"client_type", t.String()}, stats.Churn[t].Connects)
+	}
+	m.family("yomo_zipper_disconnects_total", "counter", "The number of the registered connections closed or deregistered per client type.")
+	for _, t := range types {
+		m.sample("yomo_zipper_disconnects_total", []string{"client_type", t.String()}, stats.Churn[t].Disconnects)
+	}
+	m.family("yomo_zipper_connections", "gauge", "The number of the registered connections per client type.")
+	for _, t := range types {
+		m.sample("yomo_zipper_connections", []string{"client_type", t.String()}, stats.Churn[t].Active)
+	}
+
+	// the backlog per workflow stage
+	tokens := make([]string, 0, len(stats.QueueDepths))
+	for token := range stats.QueueDepths {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	m.family("yomo_zipper_queue_depth", "gauge", "The number of the DataFrames queued to the workflow stage.")
+	for _, token := range tokens {
+		m.sample("yomo_zipper_queue_depth", []string{"token", token}, int64(stats.QueueDepths[token]))
+	}
+
+	m.family("yomo_zipper_buffered_bytes", "gauge", "The approximate bytes of the frames held by the buffers.")
+	m.sample("yomo_zipper_buffered_bytes", nil, stats.BufferedBytes)
+	return buf.Bytes()
+}
+
+type metricsWriter struct {
+	buf *bytes.Buffer
+}
+
+func (m metricsWriter) family(name string, typ string, help string) {
+	fmt.Fprintf(m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample of the metric, the labels are the pairs of the name and the value.
+func (m metricsWriter) sample(name string, labels []string, value int64) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			fmt.Fprintf(m.buf, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		m.buf.WriteByte('}')
+	}
+	fmt.Fprintf(m.buf, " %d\n", value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	s := newTestServer("sfn-1")
+	connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	s.deregister("sfn-conn", DisconnectReason{Cause: DisconnectServerClose})
+	connectSfn(s, "sfn-conn-2", "sfn-1", 0x33)
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, metricsContentType, rec.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(rec.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "# TYPE yomo_zipper_connects_total counter\n")
+	assert.Contains(t, string(body), "yomo_zipper_connects_total{client_type=\"Stream Function\"} 2\n")
+	assert.Contains(t, string(body), "yomo_zipper_disconnects_total{client_type=\"Stream Function\"} 1\n")
+	assert.Contains(t, string(body), "yomo_zipper_connections{client_type=\"Stream Function\"} 1\n")
+	assert.Contains(t, string(body), "yomo_zipper_queue_depth{token=\"sfn-1\"} 0\n")
+	assert.Contains(t, string(body), "yomo_zipper_dropped_frames_total{reason=\"expired\"} 0\n")
+}
+
+func TestMetricsLabelEscape(t *testing.T) {
+	var m metricsWriter
+	m.buf = new(bytes.Buffer)
+	m.sample("m", []string{"token", "a\"b\\c\nd"}, 1)
+	assert.Equal(t, "m{token=\"a\\\"b\\\\c\\nd\"} 1\n", m.buf.String())
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// mirror writes the DataFrame to the observers of its app which observe its data tag, the
+// observer declaring no data tags observes all of them.
+func (s *Server) mirror(appID string, f *frame.DataFrame) {
+	tag := f.GetDataTag()
+	s.observers.Range(func(key interface{}, _ interface{}) bool {
+		connID := key.(string)
+		a, ok := s.connector.App(connID)
+		if !ok || a.ID() != appID || !observes(a.Observed(), tag) {
+			return true
+		}
+		if a.maxPayload > 0 && len(f.GetCarriage()) > int(a.maxPayload) {
+			return true
+		}
+		if err := s.connector.Write(f, connID); err != nil {
+			logger.Warnf("%smirror to observer [%s](%s) err=%v", ServerLogPrefix, a.Name(), connID, err)
+		}
+		return true
+	})
+}
+
+// hasObservers reports whether any observer is connected.
+func (s *Server) hasObservers() bool {
+	found := false
+	s.observers.Range(func(_ interface{}, _ interface{}) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// observes reports whether the data tags include the tag, empty tags include all of them.
+func observes(tags []byte, tag byte) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerObserver(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	// the observer named like the stage isn't routed to
+	tagged := connectHandshake(s, "observer-1-conn", frame.NewHandshakeFrame("sfn-1", byte(ClientTypeObserver), []byte{0x33}, "", 0, nil))
+	all := connectHandshake(s, "observer-2-conn", frame.NewHandshakeFrame("admin", byte(ClientTypeObserver), nil, "", 0, nil))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 && len(tagged.Frames()) == 1 && len(all.Frames()) == 2 }))
+	assert.Equal(t, "tid-1", tagged.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, ClientTypeObserver, s.clientType("observer-1-conn"))
+
+	// the observer doesn't issue any DataFrame
+	err := s.mainFrameHandler(&Context{ConnID: "observer-2-conn", Frame: newDataFrame("tid-3", "admin", 0x33)})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, s.StatsProtocolViolation())
+	assert.Len(t, sfn.Frames(), 1)
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// openTransactions tracks the transactions of the sources held by the zipper, a transaction is
+// open from its first DataFrame received until none of its DataFrames is being routed or queued
+// to a target. The fragments of a message are one transaction. A source can't open more than
+// limit transactions at once, its DataFrames of the new transactions are rejected until some
+// complete. The sources are identified by their registered names, which are the issuers of
+// their DataFrames once they're routed, the issuers they claim aren't trusted.
+type openTransactions struct {
+	limit  int
+	mu     sync.Mutex
+	refs   map[transactionKey]int // the DataFrames being routed or queued
+	counts map[string]int         // source -> open transactions
+}
+
+type transactionKey struct {
+	source string
+	tid    string
+}
+
+func newOpenTransactions(limit int) *openTransactions {
+	return &openTransactions{
+		limit:  limit,
+		refs:   make(map[transactionKey]int),
+		counts: make(map[string]int),
+	}
+}
+
+func transactionKeyOf(source string, f *frame.DataFrame) transactionKey {
+	if fragment := f.Fragment(); fragment != nil {
+		return transactionKey{source: source, tid: fragment.MessageID}
+	}
+	return transactionKey{source: source, tid: f.TransactionID()}
+}
+
+// open the transaction of the DataFrame received from the source by its registered name,
+// false if the source has reached the limit. The DataFrame is referenced until the key is
+// released once it's routed.
+func (t *openTransactions) open(source string, f *frame.DataFrame) (transactionKey, bool) {
+	key := transactionKeyOf(source, f)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.refs[key]; !ok {
+		if t.counts[key.source] >= t.limit {
+			return key, false
+		}
+		t.counts[key.source]++
+	}
+	t.refs[key]++
+	return key, true
+}
+
+// ref the DataFrame queued to a target, it's ignored unless its transaction is open. The
+// issuer of the routed DataFrame is the registered name of its source.
+func (t *openTransactions) ref(f *frame.DataFrame) {
+	if t == nil {
+		return
+	}
+	key := transactionKeyOf(f.Issuer(), f)
+	t.mu.Lock()
+	if _, ok := t.refs[key]; ok {
+		t.refs[key]++
+	}
+	t.mu.Unlock()
+}
+
+// done releases the DataFrame which is no longer queued.
+func (t *openTransactions) done(f *frame.DataFrame) {
+	if t == nil {
+		return
+	}
+	t.release(transactionKeyOf(f.Issuer(), f))
+}
+
+// release a reference of the transaction, it completes once no DataFrame of it is referenced.
+func (t *openTransactions) release(key transactionKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.refs[key]
+	if !ok {
+		return
+	}
+	if n > 1 {
+		t.refs[key] = n - 1
+		return
+	}
+	delete(t.refs, key)
+	if t.counts[key.source]--; t.counts[key.source] <= 0 {
+		delete(t.counts, key.source)
+	}
+}
+
+// snapshot returns the number of the open transactions per source.
+func (t *openTransactions) snapshot() map[string]int {
+	result := make(map[string]int)
+	if t == nil {
+		return result
+	}
+	t.mu.Lock()
+	for source, n := range t.counts {
+		result[source] = n
+	}
+	t.mu.Unlock()
+	return result
+}
+
+// openTransaction opens the transaction of the DataFrame received from a source, it's
+// rejected if the source has reached MaxOpenTransactions. The rejection is before the ack, so
+// the source with the ack window retransmits it once some transactions complete. The returned
+// release is called once the DataFrame is routed.
+func (s *Server) openTransaction(c *Context) (release func(), ok bool) {
+	if s.transactions == nil || s.clientType(c.ConnID) != ClientTypeSource {
+		return func() {}, true
+	}
+	name, ok := s.connector.AppName(c.ConnID)
+	if !ok {
+		return func() {}, true
+	}
+	f := dataFrame(c)
+	key, ok := s.transactions.open(name, f)
+	if ok {
+		return func() { s.transactions.release(key) }, true
+	}
+	atomic.AddInt64(&s.counterOfTooManyTransactions, 1)
+	c.Logger().Debugf("%s(%s) reject the DataFrame, too many open transactions, tid=%s", ServerLogPrefix, c.ConnID, f.TransactionID())
+	s.reject(c, frame.RejectedMessageTooManyTransactions)
+	return nil, false
+}
+
+// OpenTransactions returns the number of the open transactions per source, it's empty unless
+// WithMaxOpenTransactions is set.
+func (s *Server) OpenTransactions() map[string]int {
+	return s.transactions.snapshot()
+}
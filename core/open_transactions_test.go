@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerMaxOpenTransactions(t *testing.T) {
+	s := NewServer("test-zipper", WithMaxOpenTransactions(2))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	// the fragments of a message are one transaction
+	fragments := SplitDataFrame(newDataFrame("tid-2", "source", 0x33), 2)
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+			frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+			newDataFrame("tid-1", "source", 0x33),
+			fragments[0],
+			fragments[1],
+			newDataFrame("tid-3", "source", 0x33),
+			fragments[2],
+		)), w: source},
+	})
+	assert.Equal(t, map[string]int{"source": 2}, s.OpenTransactions())
+	// the rejection is written by the send queue of the source
+	assert.True(t, waitFor(func() bool { return len(source.Frames()) == 1 }))
+	frames := source.Frames()
+	if assert.Len(t, frames, 1) {
+		assert.Equal(t, frame.RejectedMessageTooManyTransactions, frames[0].(*frame.RejectedFrame).Message())
+	}
+	stats := s.Stats()
+	assert.EqualValues(t, 1, stats.Dropped.TooManyTransactions)
+	assert.Equal(t, map[string]int{"source": 2}, stats.OpenTransactions)
+
+	// the transactions complete once their frames are written
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 4 }))
+	assert.True(t, waitFor(func() bool { return len(s.OpenTransactions()) == 0 }))
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(newDataFrame("tid-3", "source", 0x33))), w: source},
+	})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 5 }))
+	assert.Len(t, source.Frames(), 1)
+}
+
+func TestOpenTransactionsDropped(t *testing.T) {
+	transactions := newOpenTransactions(1)
+	q := newSendQueue(clock.New())
+	q.transactions = transactions
+	f := newDataFrame("tid-1", "source", 0x33)
+	key, ok := transactions.open("source", f)
+	assert.True(t, ok)
+	assert.NoError(t, q.Push(f))
+	transactions.release(key)
+	_, ok = transactions.open("source", newDataFrame("tid-2", "source", 0x33))
+	assert.False(t, ok)
+
+	// the frames discarded by the queue complete the transaction
+	q.Close()
+	assert.Empty(t, transactions.snapshot())
+	_, ok = transactions.open("source", newDataFrame("tid-2", "source", 0x33))
+	assert.True(t, ok)
+}
+
+func TestServerOpenTransactionsByName(t *testing.T) {
+	s := NewServer("test-zipper", WithMaxOpenTransactions(1))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	// the issuer isn't set by the source, the transaction is open until its frame is written
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+			frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+			newDataFrame("tid-1", "", 0x33),
+		)), w: &syncBuffer{}},
+	})
+	assert.Equal(t, map[string]int{"source": 1}, s.OpenTransactions())
+
+	// the forged issuer takes the slot of its own source rather than the one it claims
+	mallory := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "mallory-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+			frame.NewHandshakeFrame("mallory", byte(ClientTypeSource), nil, "", 0, nil),
+			newDataFrame("tid-2", "source", 0x33),
+		)), w: mallory},
+	})
+	assert.Empty(t, mallory.Frames())
+	assert.Equal(t, map[string]int{"source": 1}, s.OpenTransactions())
+
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return len(s.OpenTransactions()) == 0 }))
+	assert.Len(t, sfn.Frames(), 1)
+}
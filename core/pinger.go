@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// errPingTimeout is the error of the DisconnectReason of the evicted stream functions.
+var errPingTimeout = errors.New("no pong received in time")
+
+// pinger pings the registered stream functions periodically, the ones don't respond a
+// PongFrame within the timeout are evicted, e.g. the process is alive but stuck.
+type pinger struct {
+	interval time.Duration
+	timeout  time.Duration
+	mu       sync.Mutex
+	pending  map[string]time.Time // connID -> the time the outstanding ping was sent
+}
+
+func newPinger(interval time.Duration, timeout time.Duration) *pinger {
+	if interval <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	return &pinger{
+		interval: interval,
+		timeout:  timeout,
+		pending:  make(map[string]time.Time),
+	}
+}
+
+// pong marks the outstanding ping of the connection is responded.
+func (p *pinger) pong(connID string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	delete(p.pending, connID)
+	p.mu.Unlock()
+}
+
+// ping the stream functions every interval until the context is done.
+func (s *Server) ping(ctx context.Context) {
+	p := s.pinger
+	t := s.opts.Clock.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-t.C():
+		}
+		s.pingOnce()
+	}
+}
+
+// pingOnce evicts the stream functions whose ping timed out, pings the others.
+func (s *Server) pingOnce() {
+	p := s.pinger
+	now := s.opts.Clock.Now()
+	conns := s.connector.GetSnapshot()
+
+	p.mu.Lock()
+	// forget the removed connections
+	for connID := range p.pending {
+		if _, ok := conns[connID]; !ok {
+			delete(p.pending, connID)
+		}
+	}
+	evicted := make([]string, 0)
+	for connID := range conns {
+		if s.clientType(connID) != ClientTypeStreamFunction {
+			continue
+		}
+		// the stream function pings the server by the negotiated heartbeat
+		if _, ok := s.heartbeats.Load(connID); ok {
+			continue
+		}
+		if sentAt, ok := p.pending[connID]; ok {
+			if now.Sub(sentAt) >= p.timeout {
+				delete(p.pending, connID)
+				evicted = append(evicted, connID)
+			}
+			continue
+		}
+		if err := s.connector.WriteControl(frame.NewPingFrame(), connID); err != nil {
+			logger.Warnf("%sping [%s] err=%v", ServerLogPrefix, connID, err)
+			continue
+		}
+		p.pending[connID] = now
+	}
+	p.mu.Unlock()
+
+	for _, connID := range evicted {
+		s.evict(connID, conns[connID])
+	}
+}
+
+// evict the unresponsive stream function, the frames are no longer routed to it.
+func (s *Server) evict(connID string, stream io.ReadWriteCloser) {
+	logger.Warnf("%s[%s] ping timeout, evict it", ServerLogPrefix, connID)
+	s.deregister(connID, DisconnectReason{Cause: DisconnectPingTimeout, Err: errPingTimeout})
+	if isNilStream(stream) {
+		return
+	}
+	if qs, ok := stream.(quic.Stream); ok {
+		qs.CancelRead(0xC2)
+	}
+	stream.Close()
+}
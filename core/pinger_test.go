@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerPing(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake), WithPing(time.Second, 3*time.Second))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+	reasons := make(map[string]DisconnectReason)
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) {
+		reasons[name] = reason
+	})
+	alive := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	stuck := connectSfn(s, "sfn-2-conn", "sfn-2", 0x33)
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{
+		r: bytes.NewReader(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode()),
+		w: source,
+	}})
+
+	s.pingOnce()
+	for _, sfn := range []*syncBuffer{alive, stuck} {
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+		assert.Equal(t, frame.TagOfPingFrame, sfn.Frames()[0].Type())
+	}
+	// the sources are not pinged
+	assert.Len(t, source.Frames(), 0)
+
+	// sfn-1 responds, sfn-2 doesn't
+	assert.NoError(t, s.mainFrameHandler(&Context{ConnID: "sfn-1-conn", Frame: frame.NewPongFrame()}))
+	fake.Advance(time.Second)
+	s.pingOnce()
+	assert.True(t, waitFor(func() bool { return len(alive.Frames()) == 2 }))
+	assert.Len(t, stuck.Frames(), 1)
+
+	fake.Advance(2 * time.Second)
+	s.pingOnce()
+	assert.Nil(t, s.connector.Get("sfn-2-conn"))
+	assert.NotNil(t, s.connector.Get("sfn-1-conn"))
+	assert.Equal(t, DisconnectPingTimeout, reasons["sfn-2"].Cause)
+	assert.NotContains(t, reasons, "sfn-1")
+}
+
+func TestServerPingDisabled(t *testing.T) {
+	s := NewServer("test-zipper")
+	assert.Nil(t, s.pinger)
+	// a pong from the client is ignored
+	s.pinger.pong("conn")
+}
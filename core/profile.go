@@ -0,0 +1,95 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// Profile is a preset of the QUIC stream limits and receive windows of a deployment,
+// the zero fields keep the values of the quic.Config it's applied to.
+type Profile struct {
+	// MaxIncomingStreams is the max number of the concurrent bidirectional streams a peer can open.
+	MaxIncomingStreams int64
+	// MaxIncomingUniStreams is the max number of the concurrent unidirectional streams a peer can open.
+	MaxIncomingUniStreams int64
+	// InitialStreamReceiveWindow is the initial flow control window of every stream.
+	InitialStreamReceiveWindow uint64
+	// InitialConnectionReceiveWindow is the initial flow control window of the connection.
+	InitialConnectionReceiveWindow uint64
+}
+
+var (
+	// ProfileEdge is for the devices with limited memory.
+	ProfileEdge = Profile{
+		MaxIncomingStreams:             64,
+		MaxIncomingUniStreams:          64,
+		InitialStreamReceiveWindow:     256 * 1024,
+		InitialConnectionReceiveWindow: 512 * 1024,
+	}
+	// ProfileCloud is the default profile.
+	ProfileCloud = Profile{
+		MaxIncomingStreams:             1000,
+		MaxIncomingUniStreams:          1000,
+		InitialStreamReceiveWindow:     1024 * 1024 * 2,
+		InitialConnectionReceiveWindow: 1024 * 1024 * 2,
+	}
+)
+
+// Apply returns a copy of the config with the non-zero fields of the profile.
+func (p Profile) Apply(c *quic.Config) *quic.Config {
+	c = c.Clone()
+	if p.MaxIncomingStreams != 0 {
+		c.MaxIncomingStreams = p.MaxIncomingStreams
+	}
+	if p.MaxIncomingUniStreams != 0 {
+		c.MaxIncomingUniStreams = p.MaxIncomingUniStreams
+	}
+	if p.InitialStreamReceiveWindow != 0 {
+		c.InitialStreamReceiveWindow = p.InitialStreamReceiveWindow
+	}
+	if p.InitialConnectionReceiveWindow != 0 {
+		c.InitialConnectionReceiveWindow = p.InitialConnectionReceiveWindow
+	}
+	return c
+}
+
+// ErrNoQuicVersions is returned when the QUIC version list is set but empty.
+var ErrNoQuicVersions = errors.New("the quic version list is empty")
+
+// applyVersions returns a copy of the config advertising the versions, nil versions keep
+// the versions of the config.
+func applyVersions(c *quic.Config, versions []quic.VersionNumber) (*quic.Config, error) {
+	if versions == nil {
+		return c, nil
+	}
+	if len(versions) == 0 {
+		return nil, ErrNoQuicVersions
+	}
+	c = c.Clone()
+	c.Versions = append([]quic.VersionNumber(nil), versions...)
+	return c, nil
+}
+
+func defaultServerQuicConfig() *quic.Config {
+	return ProfileCloud.Apply(&quic.Config{
+		Versions:                []quic.VersionNumber{quic.Version1, quic.VersionDraft29},
+		MaxIdleTimeout:          time.Second * 5,
+		KeepAlive:               true,
+		HandshakeIdleTimeout:    time.Second * 3,
+		DisablePathMTUDiscovery: true,
+		// Tracer:                  getQlogConfig("server"),
+	})
+}
+
+func defaultClientQuicConfig() *quic.Config {
+	return ProfileCloud.Apply(&quic.Config{
+		Versions:                []quic.VersionNumber{quic.Version1, quic.VersionDraft29},
+		MaxIdleTimeout:          time.Second * 40,
+		KeepAlive:               true,
+		HandshakeIdleTimeout:    time.Second * 3,
+		TokenStore:              quic.NewLRUTokenStore(10, 5),
+		DisablePathMTUDiscovery: true,
+	})
+}
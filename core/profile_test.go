@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileApply(t *testing.T) {
+	base := defaultServerQuicConfig()
+	assert.Equal(t, int64(1000), base.MaxIncomingStreams)
+
+	edge := ProfileEdge.Apply(base)
+	assert.Equal(t, ProfileEdge.MaxIncomingStreams, edge.MaxIncomingStreams)
+	assert.Equal(t, ProfileEdge.InitialConnectionReceiveWindow, edge.InitialConnectionReceiveWindow)
+	assert.Equal(t, base.MaxIdleTimeout, edge.MaxIdleTimeout)
+	// the base config is untouched
+	assert.Equal(t, int64(1000), base.MaxIncomingStreams)
+
+	custom := Profile{MaxIncomingStreams: 10}.Apply(edge)
+	assert.Equal(t, int64(10), custom.MaxIncomingStreams)
+	assert.Equal(t, ProfileEdge.MaxIncomingUniStreams, custom.MaxIncomingUniStreams)
+}
+
+func TestServerQuicConfigProfile(t *testing.T) {
+	s := NewServer("test")
+	qc, err := s.quicConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, qc)
+
+	s = NewServer("test", WithServerProfile(ProfileEdge))
+	qc, err = s.quicConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, ProfileEdge.MaxIncomingStreams, qc.MaxIncomingStreams)
+}
+
+func TestServerQuicConfigVersions(t *testing.T) {
+	s := NewServer("test", WithServerVersions(quic.VersionDraft29))
+	qc, err := s.quicConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, []quic.VersionNumber{quic.VersionDraft29}, qc.Versions)
+	// the default config is untouched
+	assert.Equal(t, []quic.VersionNumber{quic.Version1, quic.VersionDraft29}, defaultServerQuicConfig().Versions)
+
+	s = NewServer("test", WithServerVersions())
+	_, err = s.quicConfig()
+	assert.ErrorIs(t, err, ErrNoQuicVersions)
+	assert.ErrorIs(t, s.ListenAndServe(context.Background(), "127.0.0.1:0"), ErrNoQuicVersions)
+
+	c := NewClient("test", ClientTypeSource, WithClientVersions())
+	assert.ErrorIs(t, c.Connect(context.Background(), "127.0.0.1:9"), ErrNoQuicVersions)
+}
+
+func TestQuicVersionsHandshake(t *testing.T) {
+	s := newTestServer("sfn-1")
+	s.opts.Versions = []quic.VersionNumber{quic.VersionDraft29}
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	// the client agreeing on the non-default version completes the handshake
+	source := NewClient("source", ClientTypeSource, WithClientVersions(quic.VersionDraft29))
+	assert.NoError(t, source.Connect(ctx, addr))
+	defer source.Close()
+	assert.True(t, waitFor(func() bool { return len(s.connector.GetSnapshot()) == 1 }))
+
+	// the client without a shared version fails
+	dialCtx, dialCancel := context.WithTimeout(ctx, 3*time.Second)
+	defer dialCancel()
+	other := NewClient("other", ClientTypeSource, WithClientVersions(quic.Version1))
+	assert.Error(t, other.Connect(dialCtx, addr))
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// registryQueueSize is the max number of the registry updates waiting for the backend, the
+// updates are dropped once it's full, e.g. the backend is unreachable.
+const registryQueueSize = 1024
+
+// RegistryMeta describes a stream function instance registered into the Registry.
+type RegistryMeta struct {
+	// AppID is the app the instance belongs to.
+	AppID string
+	// Name is the name of the stream function.
+	Name string
+	// ObserveDataTags is the data tags the instance observes.
+	ObserveDataTags []byte
+	// Weight is the share of the frames the instance receives, 0 means DefaultWeight.
+	Weight uint32
+}
+
+// Registry mirrors the stream function instances connected to the zipper into a service
+// discovery backend, e.g. Consul or etcd, so the live topology is visible outside. The id
+// is the connection id of the instance, Register is called again with the same id once the
+// instance updates its subscription. The errors are logged only, they never affect the
+// connections.
+type Registry interface {
+	// Register the instance, or update its meta.
+	Register(id string, meta RegistryMeta) error
+	// Deregister the instance.
+	Deregister(id string) error
+}
+
+type nopRegistry struct{}
+
+func (nopRegistry) Register(string, RegistryMeta) error { return nil }
+
+func (nopRegistry) Deregister(string) error { return nil }
+
+type registryUpdate struct {
+	id         string
+	meta       RegistryMeta
+	deregister bool
+}
+
+// registrySync applies the updates to the registry one by one off the handshake path, so a
+// slow backend doesn't block the connections and the updates of an instance keep their order.
+type registrySync struct {
+	registry Registry
+	updates  chan registryUpdate
+	once     sync.Once
+}
+
+func newRegistrySync(registry Registry) *registrySync {
+	return &registrySync{
+		registry: registry,
+		updates:  make(chan registryUpdate, registryQueueSize),
+	}
+}
+
+func (r *registrySync) register(id string, meta RegistryMeta) {
+	r.push(registryUpdate{id: id, meta: meta})
+}
+
+func (r *registrySync) deregister(id string) {
+	r.push(registryUpdate{id: id, deregister: true})
+}
+
+func (r *registrySync) push(u registryUpdate) {
+	if _, ok := r.registry.(nopRegistry); ok {
+		return
+	}
+	r.once.Do(func() { go r.run() })
+	select {
+	case r.updates <- u:
+	default:
+		logger.Warnf("%sregistry is backed up, drop the update of (%s)", ServerLogPrefix, u.id)
+	}
+}
+
+func (r *registrySync) run() {
+	for u := range r.updates {
+		if u.deregister {
+			if err := r.registry.Deregister(u.id); err != nil {
+				logger.Errorf("%sregistry deregister (%s) err=%v", ServerLogPrefix, u.id, err)
+			}
+			continue
+		}
+		if err := r.registry.Register(u.id, u.meta); err != nil {
+			logger.Errorf("%sregistry register [%s](%s) err=%v", ServerLogPrefix, u.meta.Name, u.id, err)
+		}
+	}
+}
+
+// registerApp registers the stream function of the connection with its current subscription.
+func (s *Server) registerApp(connID string) {
+	app, ok := s.connector.App(connID)
+	if !ok || app.ClientType() != ClientTypeStreamFunction {
+		return
+	}
+	s.discovery.register(connID, RegistryMeta{
+		AppID:           app.ID(),
+		Name:            app.Name(),
+		ObserveDataTags: app.Observed(),
+		Weight:          app.Weight(),
+	})
+}
+
+// deregisterApp deregisters the stream function of the connection.
+func (s *Server) deregisterApp(connID string, app *app) {
+	if app.ClientType() != ClientTypeStreamFunction {
+		return
+	}
+	s.discovery.deregister(connID)
+}
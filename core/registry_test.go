@@ -0,0 +1,58 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+type recordingRegistry struct {
+	mu      sync.Mutex
+	updates []string
+}
+
+func (r *recordingRegistry) Register(id string, meta RegistryMeta) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, "register:"+id+":"+meta.Name)
+	// the failures don't affect the connections
+	return errors.New("unreachable")
+}
+
+func (r *recordingRegistry) Deregister(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, "deregister:"+id)
+	return nil
+}
+
+func (r *recordingRegistry) Updates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.updates...)
+}
+
+func TestServerRegistry(t *testing.T) {
+	registry := &recordingRegistry{}
+	s := NewServer("test-zipper", WithRegistry(registry))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+
+	connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	// the sources aren't registered
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil))), w: ioutil.Discard},
+	})
+	assert.True(t, waitFor(func() bool { return len(registry.Updates()) == 1 }))
+	assert.NotNil(t, s.connector.Get("sfn-conn"))
+
+	s.deregister("sfn-conn", DisconnectReason{Cause: DisconnectServerClose})
+	assert.True(t, waitFor(func() bool { return len(registry.Updates()) == 2 }))
+	assert.Equal(t, []string{"register:sfn-conn:sfn-1", "deregister:sfn-conn"}, registry.Updates())
+}
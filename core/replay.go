@@ -0,0 +1,119 @@
+package core
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// replayBuffer retains the last frames routed to every stage, so they can be replayed
+// to a (re)connected stream function. A nil buffer retains nothing.
+type replayBuffer struct {
+	size   int
+	mu     sync.Mutex
+	stages map[replayKey]*replayRing
+	budget *memoryBudget // the retained frames are evicted first once it's exceeded
+}
+
+type replayKey struct {
+	appID string
+	stage string
+}
+
+// replayRing is a ring buffer of frames.
+type replayRing struct {
+	frames []*frame.DataFrame
+	next   int
+}
+
+func newReplayBuffer(size int, budget *memoryBudget) *replayBuffer {
+	if size <= 0 {
+		return nil
+	}
+	b := &replayBuffer{
+		size:   size,
+		stages: make(map[replayKey]*replayRing),
+		budget: budget,
+	}
+	budget.addEvictor(b.evictOldest)
+	return b
+}
+
+// record a frame routed to the stage.
+func (b *replayBuffer) record(appID string, stage string, f *frame.DataFrame) {
+	if b == nil {
+		return
+	}
+	key := replayKey{appID, stage}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.stages[key]
+	if !ok {
+		r = &replayRing{frames: make([]*frame.DataFrame, 0, b.size)}
+		b.stages[key] = r
+	}
+	b.budget.add(frameSize(f))
+	defer func() {
+		for b.budget.over() && b.evictOldestLocked() {
+		}
+	}()
+	if len(r.frames) < b.size {
+		r.frames = append(r.frames, f)
+		return
+	}
+	b.budget.release(frameSize(r.frames[r.next]))
+	r.frames[r.next] = f
+	r.next = (r.next + 1) % b.size
+}
+
+// evictOldest evicts the oldest frame of a stage for the memory budget, returns false if
+// there's no frame retained.
+func (b *replayBuffer) evictOldest() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.evictOldestLocked()
+}
+
+func (b *replayBuffer) evictOldestLocked() bool {
+	for key, r := range b.stages {
+		if len(r.frames) == 0 {
+			delete(b.stages, key)
+			continue
+		}
+		// the frames are reordered from the oldest, the ring isn't full anymore
+		frames := make([]*frame.DataFrame, 0, b.size)
+		frames = append(frames, r.frames[r.next:]...)
+		frames = append(frames, r.frames[:r.next]...)
+		b.budget.drop(frameSize(frames[0]))
+		r.frames = frames[1:]
+		r.next = 0
+		return true
+	}
+	return false
+}
+
+// frames returns the retained frames of the stages the sfn name matches, the frames of
+// a stage are in their original order.
+func (b *replayBuffer) frames(appID string, name string) []*frame.DataFrame {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]replayKey, 0)
+	for key := range b.stages {
+		if key.appID == appID && MatchName(key.stage, name) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].stage < keys[j].stage })
+
+	result := make([]*frame.DataFrame, 0)
+	for _, key := range keys {
+		r := b.stages[key]
+		result = append(result, r.frames[r.next:]...)
+		result = append(result, r.frames[:r.next]...)
+	}
+	return result
+}
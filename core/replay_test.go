@@ -0,0 +1,25 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	assert.Nil(t, newReplayBuffer(0, nil).frames("app", "sfn-1"))
+
+	b := newReplayBuffer(3, nil)
+	for i := 0; i < 5; i++ {
+		b.record("app", "sfn-*", newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33))
+	}
+	b.record("app", "sfn-2", newDataFrame("other-stage", "source", 0x33))
+	b.record("other-app", "sfn-1", newDataFrame("other-app", "source", 0x33))
+
+	tids := []string{}
+	for _, f := range b.frames("app", "sfn-1") {
+		tids = append(tids, f.TransactionID())
+	}
+	assert.Equal(t, []string{"tid-2", "tid-3", "tid-4"}, tids)
+}
@@ -1,5 +1,10 @@
 package core
 
+import (
+	"path"
+	"strings"
+)
+
 // Router is the interface to manage the routes for applications.
 type Router interface {
 	// Route gets the route by appID.
@@ -12,8 +17,48 @@ type Router interface {
 type Route interface {
 	// Add a route.
 	Add(index int, name string)
-	// GetForwardRoutes returns all the forward routes from current node.
+	// GetForwardRoutes returns all the forward routes from current node in the order of the
+	// workflow. current is the token of a stage matched exactly, the other names, e.g. the
+	// sources or "", are ahead of the first stage.
 	GetForwardRoutes(current string) []string
-	// Exists indicates whether the route exists or not.
+	// Exists indicates whether the route exists or not, the name is matched by MatchName.
 	Exists(name string) bool
 }
+
+// MatchName reports whether the sfn name matches the workflow token. A token containing
+// any of the glob characters `*?[` is a pattern, e.g. `detector-*` matches all the sfns
+// with the prefix `detector-`, otherwise it requires an exact match.
+func MatchName(token string, name string) bool {
+	if !strings.ContainsAny(token, "*?[") {
+		return token == name
+	}
+	matched, err := path.Match(token, name)
+	return err == nil && matched
+}
+
+// forwardRoutes returns the stages the DataFrames issued by the name are forwarded to. A stream
+// function is at the stage of its exact token, or of the first pattern matching its name, the
+// other issuers, e.g. the sources, are looked up by their names, see Route.GetForwardRoutes.
+func forwardRoutes(route Route, name string, clientType ClientType) []string {
+	if clientType == ClientTypeStreamFunction {
+		if token, ok := stageOf(route, name); ok {
+			return route.GetForwardRoutes(token)
+		}
+	}
+	return route.GetForwardRoutes(name)
+}
+
+// stageOf returns the token of the stage of the stream function name, the exact token is
+// preferred to the patterns, which are tried in the order of the workflow.
+func stageOf(route Route, name string) (string, bool) {
+	tokens := stageTokens(route, name)
+	for _, token := range tokens {
+		if token == name {
+			return token, true
+		}
+	}
+	if len(tokens) > 0 {
+		return tokens[0], true
+	}
+	return "", false
+}
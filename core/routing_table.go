@@ -0,0 +1,168 @@
+package core
+
+import "sort"
+
+// RouteEntry is the routing of the DataFrames issued by a client, as computed by the server
+// for every DataFrame, see DumpRoutingTable.
+type RouteEntry struct {
+	// AppID is the app id of the workflow.
+	AppID string
+	// Issuer is the name of the client issuing the DataFrames, or the workflow token of a
+	// stage without any connected stream function.
+	Issuer string
+	// ClientType is the role of the issuer.
+	ClientType ClientType
+	// Connected reports whether the issuer has a connection.
+	Connected bool
+	// Next are the stages the DataFrames are routed to, empty means the DataFrames of a stream
+	// function are the output of the workflow, and the ones of a source are dropped.
+	Next []RouteHop
+}
+
+// RouteHop is a stage the DataFrames are routed to.
+type RouteHop struct {
+	// Token is the workflow token of the stage, it may be a pattern matched by MatchName.
+	Token string
+	// SampleRate forwards 1 in every SampleRate DataFrames to the stage, 0 means all of them.
+	SampleRate int
+	// Instances are the connected stream functions of the stage, one of the instances which
+	// observe the data tag of a DataFrame receives it, the DataFrame is dropped if there's none.
+	Instances []RouteInstance
+}
+
+// RouteInstance is a connected stream function instance of a stage.
+type RouteInstance struct {
+	ConnID string
+	Name   string
+	// ObserveDataTags are the data tags the instance receives.
+	ObserveDataTags []int
+	Weight          uint32
+	// MaxPayloadSize is the largest carriage routed to the instance, 0 means unlimited.
+	MaxPayloadSize uint32
+	// Queued is the number of the frames in the send queue of the instance.
+	Queued int
+	// Draining is true if the instance is taken out of rotation by DrainFunction.
+	Draining bool
+}
+
+// routedApp is a connected app with its connection id.
+type routedApp struct {
+	connID string
+	*app
+}
+
+// DumpRoutingTable returns the routing of the workflows of the connected apps, i.e. where
+// handleDataFrame routes the DataFrames of every issuer after applying the workflow tokens,
+// the sampling and the connected instances. It lists the sources and the upstream zippers,
+// then the stages in the workflow order, sorted by the app id.
+func (s *Server) DumpRoutingTable() []RouteEntry {
+	apps := make(map[string][]routedApp)
+	for connID := range s.connector.GetSnapshot() {
+		if a, ok := s.connector.App(connID); ok {
+			apps[a.ID()] = append(apps[a.ID()], routedApp{connID: connID, app: a})
+		}
+	}
+	appIDs := make([]string, 0, len(apps))
+	for appID, list := range apps {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name() != list[j].Name() {
+				return list[i].Name() < list[j].Name()
+			}
+			return list[i].connID < list[j].connID
+		})
+		appIDs = append(appIDs, appID)
+	}
+	sort.Strings(appIDs)
+
+	result := make([]RouteEntry, 0)
+	for _, appID := range appIDs {
+		v, ok := s.opts.Store.Get(appID)
+		if !ok {
+			continue
+		}
+		route, ok := v.(Route)
+		if !ok || isNilRoute(route) {
+			continue
+		}
+		list := apps[appID]
+		// the sources and the upstream zippers issue the frames into the workflow
+		for _, issuer := range issuerNames(list, func(a routedApp) bool {
+			return a.ClientType() == ClientTypeSource || a.ClientType() == ClientTypeUpstreamZipper
+		}) {
+			result = append(result, s.routeEntry(route, list, issuer))
+		}
+		for _, token := range route.GetForwardRoutes("") {
+			names := issuerNames(list, func(a routedApp) bool {
+				return a.ClientType() == ClientTypeStreamFunction && MatchName(token, a.Name())
+			})
+			if len(names) == 0 {
+				entry := s.routeEntry(route, list, routedIssuer{name: token, clientType: ClientTypeStreamFunction})
+				result = append(result, entry)
+				continue
+			}
+			for _, issuer := range names {
+				result = append(result, s.routeEntry(route, list, issuer))
+			}
+		}
+	}
+	return result
+}
+
+// routedIssuer is a distinct issuer name of the connected apps.
+type routedIssuer struct {
+	name       string
+	clientType ClientType
+	connected  bool
+}
+
+// issuerNames returns the distinct names of the sorted apps matched by the filter.
+func issuerNames(apps []routedApp, filter func(routedApp) bool) []routedIssuer {
+	result := make([]routedIssuer, 0)
+	for _, a := range apps {
+		if !filter(a) || (len(result) > 0 && result[len(result)-1].name == a.Name()) {
+			continue
+		}
+		result = append(result, routedIssuer{name: a.Name(), clientType: a.ClientType(), connected: true})
+	}
+	return result
+}
+
+// routeEntry computes the stages the DataFrames of the issuer are routed to.
+func (s *Server) routeEntry(route Route, apps []routedApp, issuer routedIssuer) RouteEntry {
+	entry := RouteEntry{
+		AppID:      apps[0].ID(),
+		Issuer:     issuer.name,
+		ClientType: issuer.clientType,
+		Connected:  issuer.connected,
+		Next:       make([]RouteHop, 0),
+	}
+	for _, token := range forwardRoutes(route, issuer.name, issuer.clientType) {
+		hop := RouteHop{Token: token, Instances: make([]RouteInstance, 0)}
+		if sm, ok := s.samplers[token]; ok {
+			hop.SampleRate = int(sm.n)
+		}
+		for _, a := range apps {
+			if a.ClientType() != ClientTypeStreamFunction || !MatchName(token, a.Name()) {
+				continue
+			}
+			tags := make([]int, 0, len(a.Observed()))
+			for _, tag := range a.Observed() {
+				tags = append(tags, int(tag))
+			}
+			instance := RouteInstance{
+				ConnID:          a.connID,
+				Name:            a.Name(),
+				ObserveDataTags: tags,
+				Weight:          a.Weight(),
+				MaxPayloadSize:  a.maxPayload,
+				Draining:        a.Draining(),
+			}
+			if stat, ok := s.connector.Buffering(a.connID); ok {
+				instance.Queued = stat.Queued
+			}
+			hop.Instances = append(hop.Instances, instance)
+		}
+		entry.Next = append(entry.Next, hop)
+	}
+	return entry
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerDumpRoutingTable(t *testing.T) {
+	s := NewServer("test-zipper", WithStageSampling("sfn-2", 10))
+	s.ConfigRouter(&testRouter{names: []string{"detector-*", "sfn-2"}})
+	source := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source.Encode()), w: ioutil.Discard}})
+	connectSfn(s, "detector-a-conn", "detector-a", 0x33)
+	connectSfn(s, "detector-b-conn", "detector-b", 0x33, 0x34)
+
+	table := s.DumpRoutingTable()
+	issuers := make([]string, 0)
+	for _, entry := range table {
+		issuers = append(issuers, entry.Issuer)
+	}
+	assert.Equal(t, []string{"source", "detector-a", "detector-b", "sfn-2"}, issuers)
+
+	// the source reaches the detectors, and sfn-2 which is disconnected
+	assert.True(t, table[0].Connected)
+	assert.Equal(t, ClientTypeSource, table[0].ClientType)
+	assert.Len(t, table[0].Next, 2)
+	detectors := table[0].Next[0]
+	assert.Equal(t, "detector-*", detectors.Token)
+	assert.Equal(t, []RouteInstance{
+		{ConnID: "detector-a-conn", Name: "detector-a", ObserveDataTags: []int{0x33}, Weight: DefaultWeight},
+		{ConnID: "detector-b-conn", Name: "detector-b", ObserveDataTags: []int{0x33, 0x34}, Weight: DefaultWeight},
+	}, detectors.Instances)
+	assert.Equal(t, "sfn-2", table[0].Next[1].Token)
+	assert.Equal(t, 10, table[0].Next[1].SampleRate)
+	assert.Empty(t, table[0].Next[1].Instances)
+
+	// the detectors forward to sfn-2, whose output leaves the workflow
+	assert.Equal(t, "sfn-2", table[1].Next[0].Token)
+	assert.False(t, table[3].Connected)
+	assert.Empty(t, table[3].Next)
+
+	_, err := json.Marshal(table)
+	assert.NoError(t, err)
+}
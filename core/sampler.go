@@ -0,0 +1,55 @@
+package core
+
+import "sync/atomic"
+
+// SamplingStat is the number of the DataFrames forwarded and dropped by the sampler of a stage.
+type SamplingStat struct {
+	// Sampled is forwarded to the stage.
+	Sampled int64
+	// Dropped is not forwarded to the stage by sampling.
+	Dropped int64
+}
+
+// sampler forwards 1 in every n DataFrames to a stage, it's deterministic, i.e. the 1st,
+// the (n+1)th, the (2n+1)th... frames are sampled, so the sample is evenly spread.
+type sampler struct {
+	seen    int64
+	sampled int64
+	dropped int64
+	n       int64
+}
+
+// newSamplers creates the samplers of the stages, the rate <= 1 samples every frame,
+// so there's no sampler for it.
+func newSamplers(rates map[string]int) map[string]*sampler {
+	samplers := make(map[string]*sampler, len(rates))
+	for stage, n := range rates {
+		if n > 1 {
+			samplers[stage] = &sampler{n: int64(n)}
+		}
+	}
+	return samplers
+}
+
+// sample reports whether the frame is forwarded.
+func (s *sampler) sample() bool {
+	if (atomic.AddInt64(&s.seen, 1)-1)%s.n == 0 {
+		atomic.AddInt64(&s.sampled, 1)
+		return true
+	}
+	atomic.AddInt64(&s.dropped, 1)
+	return false
+}
+
+func (s *sampler) stat() SamplingStat {
+	return SamplingStat{
+		Sampled: atomic.LoadInt64(&s.sampled),
+		Dropped: atomic.LoadInt64(&s.dropped),
+	}
+}
+
+// reset the stat, the frames keep being sampled evenly.
+func (s *sampler) reset() {
+	atomic.StoreInt64(&s.sampled, 0)
+	atomic.StoreInt64(&s.dropped, 0)
+}
@@ -0,0 +1,400 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// priorities are drained from the highest to the lowest.
+var priorities = []frame.Priority{frame.PriorityHigh, frame.PriorityNormal, frame.PriorityLow}
+
+// errSendQueueClosed is returned when pushing to a closed send queue.
+var errSendQueueClosed = errors.New("send queue is closed")
+
+// errFrameDropped is reported to the sender of the streamed DataFrame which is dropped by
+// the memory budget.
+var errFrameDropped = errors.New("the frame is dropped by the memory budget")
+
+// errFrameOverflowed is reported to the sender of the streamed DataFrame which is dropped
+// since the send queue is full.
+var errFrameOverflowed = errors.New("the frame is dropped by the full send queue")
+
+// errCarriageRead is reported to the sender of the streamed DataFrame whose carriage can't be
+// read from the source, the rest of the carriage is padded with zeros.
+var errCarriageRead = errors.New("the carriage can't be read from the source")
+
+// errWindowFull is returned when pushing a DataFrame to a send queue whose window is full,
+// see WithStageWindow.
+var errWindowFull = errors.New("the window of the target is full")
+
+// errFrameExpired is reported to the sender of the streamed DataFrame which got stale in the
+// send queue.
+var errFrameExpired = errors.New("the frame expired in the send queue")
+
+// QueueWaitStat describes how long the frames of a priority waited in the send queues.
+type QueueWaitStat struct {
+	// Count is the number of frames which have been drained.
+	Count int64
+	// Total is the accumulated waiting time of the drained frames.
+	Total time.Duration
+}
+
+// Avg returns the average waiting time.
+func (s QueueWaitStat) Avg() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// queueWaitStats accumulates the waiting time per priority, shared by all send queues.
+type queueWaitStats struct {
+	counts [256]int64
+	totals [256]int64
+}
+
+func (s *queueWaitStats) observe(p frame.Priority, d time.Duration) {
+	atomic.AddInt64(&s.counts[p], 1)
+	atomic.AddInt64(&s.totals[p], int64(d))
+}
+
+func (s *queueWaitStats) snapshot() map[frame.Priority]QueueWaitStat {
+	result := make(map[frame.Priority]QueueWaitStat, len(priorities))
+	for _, p := range priorities {
+		result[p] = QueueWaitStat{
+			Count: atomic.LoadInt64(&s.counts[p]),
+			Total: time.Duration(atomic.LoadInt64(&s.totals[p])),
+		}
+	}
+	return result
+}
+
+func (s *queueWaitStats) reset() {
+	for p := range s.counts {
+		atomic.StoreInt64(&s.counts[p], 0)
+		atomic.StoreInt64(&s.totals[p], 0)
+	}
+}
+
+type queuedFrame struct {
+	frame      *frame.DataFrame
+	control    frame.Frame // the control frame, e.g. PingFrame, frame is nil if it's set
+	enqueuedAt time.Time
+	size       int64 // accounted by the memory budget
+	// carriage is copied to the stream while the frame is drained, see PushStream
+	carriage     io.Reader
+	carriageSize int
+	done         chan error // receives the result of the streamed frame
+}
+
+// finish reports the result of the streamed DataFrame to its sender.
+func (item *queuedFrame) finish(err error) {
+	if item.done != nil {
+		item.done <- err
+	}
+}
+
+// sendQueue buffers the DataFrames which will be written to a target stream,
+// frames with higher priority are drained first, frames with the same priority
+// keep FIFO order. The control frames are drained ahead of all the DataFrames.
+// Push refuses the DataFrame while window DataFrames are in flight, it never blocks the sender.
+type sendQueue struct {
+	clock   clock.Clock
+	mu      sync.Mutex
+	cond    *sync.Cond
+	window  int // max number of the in-flight DataFrames, 0 means unlimited
+	limit   int // max number of the queued DataFrames, the oldest are dropped beyond it, 0 means unlimited
+	control []*queuedFrame
+	items   map[frame.Priority][]*queuedFrame
+	size    int
+	depth   int64 // number of the queued DataFrames, mirrors items for the lock-free reads, accessed atomically
+	bytes   int64 // size of the queued DataFrames
+	closed  bool
+	budget  *memoryBudget // nil means the bytes aren't tracked
+	writing int64         // bytes popped by the drain and not written to the stream yet, accessed atomically
+	busy    bool          // the frame popped last is being written
+	data    bool          // the frame popped last is a DataFrame
+	drained []chan struct{}
+	broken  atomic.Value // brokenError, set once writing to the target stream fails
+	// transactions references the queued DataFrames of the open transactions, nil means untracked
+	transactions *openTransactions
+	// overflowed counts the DataFrames dropped beyond the limit, nil means uncounted
+	overflowed *int64
+}
+
+// brokenError is the error of the write broke the target stream.
+type brokenError struct{ err error }
+
+func newSendQueue(clock clock.Clock) *sendQueue {
+	q := &sendQueue{
+		clock: clock,
+		items: make(map[frame.Priority][]*queuedFrame),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// SetWindow sets the max number of the in-flight DataFrames, i.e. queued or being written,
+// 0 means unlimited. The control frames aren't counted.
+func (q *sendQueue) SetWindow(window int) {
+	q.mu.Lock()
+	q.window = window
+	q.mu.Unlock()
+}
+
+// InFlight returns the number of the DataFrames queued or being written.
+func (q *sendQueue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight()
+}
+
+func (q *sendQueue) inFlight() int {
+	n := int(atomic.LoadInt64(&q.depth))
+	if q.busy && q.data {
+		n++
+	}
+	return n
+}
+
+// Full reports whether the window is full, the DataFrames pushed are refused.
+func (q *sendQueue) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.window > 0 && q.inFlight() >= q.window
+}
+
+// Push a frame into the queue, errWindowFull is returned while the window is full.
+func (q *sendQueue) Push(f *frame.DataFrame) error {
+	return q.push(&queuedFrame{frame: f, size: frameSize(f)})
+}
+
+// PushStream pushes a DataFrame whose carriage of size bytes is copied from the reader when
+// it's drained, errWindowFull is returned while the window is full. The returned channel
+// receives the result once the frame is written or dropped.
+func (q *sendQueue) PushStream(f *frame.DataFrame, carriage io.Reader, size int) (<-chan error, error) {
+	item := &queuedFrame{frame: f, size: frameSize(f), carriage: carriage, carriageSize: size, done: make(chan error, 1)}
+	if err := q.push(item); err != nil {
+		return nil, err
+	}
+	return item.done, nil
+}
+
+func (q *sendQueue) push(item *queuedFrame) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errSendQueueClosed
+	}
+	// the frames pushed to the broken stream would be lost silently
+	if err := q.brokenErr(); err != nil {
+		return fmt.Errorf("target stream is broken: %w", err)
+	}
+	if q.window > 0 && q.inFlight() >= q.window {
+		return errWindowFull
+	}
+	p := item.frame.Priority()
+	item.enqueuedAt = q.clock.Now()
+	q.items[p] = append(q.items[p], item)
+	q.size++
+	atomic.AddInt64(&q.depth, 1)
+	q.bytes += item.size
+	q.budget.add(item.size)
+	q.transactions.ref(item.frame)
+	for q.limit > 0 && int(atomic.LoadInt64(&q.depth)) > q.limit && q.dropOldest(item, false) {
+	}
+	if q.budget.over() {
+		q.budget.reclaim()
+		for q.budget.over() && q.dropOldest(item, true) {
+		}
+	}
+	q.cond.Signal()
+	return nil
+}
+
+// dropOldest drops the oldest DataFrame of the lowest priority except the frame being pushed,
+// for the memory budget or the limit of the queue, returns false if there's nothing to drop.
+func (q *sendQueue) dropOldest(except *queuedFrame, overBudget bool) bool {
+	for i := len(priorities) - 1; i >= 0; i-- {
+		p := priorities[i]
+		items := q.items[p]
+		if len(items) == 0 || items[0] == except {
+			continue
+		}
+		q.bytes -= items[0].size
+		if overBudget {
+			q.budget.drop(items[0].size)
+			items[0].finish(errFrameDropped)
+		} else {
+			q.budget.release(items[0].size)
+			if q.overflowed != nil {
+				atomic.AddInt64(q.overflowed, 1)
+			}
+			items[0].finish(errFrameOverflowed)
+		}
+		q.transactions.done(items[0].frame)
+		items[0] = nil
+		q.items[p] = items[1:]
+		q.size--
+		atomic.AddInt64(&q.depth, -1)
+		return true
+	}
+	return false
+}
+
+// PushControl pushes a control frame into the queue, which is drained ahead of the DataFrames.
+func (q *sendQueue) PushControl(f frame.Frame) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errSendQueueClosed
+	}
+	q.control = append(q.control, &queuedFrame{control: f, enqueuedAt: q.clock.Now()})
+	q.size++
+	q.cond.Signal()
+	return nil
+}
+
+// Drained returns a channel closed once the queue is empty and the frame popped last is
+// written, i.e. the next Pop is called, or the queue is closed.
+func (q *sendQueue) Drained() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	done := make(chan struct{})
+	q.drained = append(q.drained, done)
+	if q.closed || (q.size == 0 && !q.busy) {
+		q.notifyDrained()
+	}
+	return done
+}
+
+func (q *sendQueue) notifyDrained() {
+	for _, done := range q.drained {
+		close(done)
+	}
+	q.drained = nil
+}
+
+// Pop blocks until a frame is available, returns false if the queue is closed.
+func (q *sendQueue) Pop() (*queuedFrame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// the frame popped last has been written
+	q.busy = false
+	if q.size == 0 {
+		q.notifyDrained()
+	}
+	for q.size == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	q.busy = true
+	q.data = len(q.control) == 0
+	if len(q.control) > 0 {
+		item := q.control[0]
+		q.control[0] = nil
+		q.control = q.control[1:]
+		q.size--
+		return item, true
+	}
+	for _, p := range priorities {
+		if items := q.items[p]; len(items) > 0 {
+			item := items[0]
+			items[0] = nil
+			q.items[p] = items[1:]
+			q.size--
+			atomic.AddInt64(&q.depth, -1)
+			q.bytes -= item.size
+			q.budget.release(item.size)
+			return item, true
+		}
+	}
+	// unknown priorities are drained last
+	for p, items := range q.items {
+		if len(items) > 0 {
+			item := items[0]
+			items[0] = nil
+			q.items[p] = items[1:]
+			q.size--
+			atomic.AddInt64(&q.depth, -1)
+			q.bytes -= item.size
+			q.budget.release(item.size)
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of frames in the queue.
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Depth returns the number of the DataFrames in the queue, it doesn't take the lock so it's
+// cheap to poll.
+func (q *sendQueue) Depth() int {
+	return int(atomic.LoadInt64(&q.depth))
+}
+
+// markBroken records that writing to the target stream failed by the err, returns true if
+// it's the first failure.
+func (q *sendQueue) markBroken(err error) bool {
+	if q.Broken() {
+		return false
+	}
+	q.broken.Store(brokenError{err: err})
+	return true
+}
+
+// Broken reports whether writing to the target stream has failed, a stream is useless once a
+// write fails, so it isn't reset.
+func (q *sendQueue) Broken() bool {
+	return q.brokenErr() != nil
+}
+
+// brokenErr returns the error of the write broke the target stream, nil if it's not broken.
+func (q *sendQueue) brokenErr() error {
+	if v, ok := q.broken.Load().(brokenError); ok {
+		return v.err
+	}
+	return nil
+}
+
+// Bytes returns the size of the DataFrames in the queue.
+func (q *sendQueue) Bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Close the queue, the pending frames are discarded.
+func (q *sendQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	for _, items := range q.items {
+		for _, item := range items {
+			q.budget.release(item.size)
+			q.transactions.done(item.frame)
+			item.finish(errSendQueueClosed)
+		}
+	}
+	q.control = nil
+	q.items = make(map[frame.Priority][]*queuedFrame)
+	q.size = 0
+	atomic.StoreInt64(&q.depth, 0)
+	q.bytes = 0
+	q.notifyDrained()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func newPriorityFrame(tid string, p frame.Priority) *frame.DataFrame {
+	f := frame.NewDataFrame()
+	f.SetTransactionID(tid)
+	f.SetPriority(p)
+	f.SetCarriage(0x33, []byte(tid))
+	return f
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	q := newSendQueue(clock.New())
+	assert.NoError(t, q.Push(newPriorityFrame("low", frame.PriorityLow)))
+	assert.NoError(t, q.Push(newPriorityFrame("normal-1", frame.PriorityNormal)))
+	assert.NoError(t, q.Push(newPriorityFrame("high", frame.PriorityHigh)))
+	assert.NoError(t, q.Push(newPriorityFrame("normal-2", frame.PriorityNormal)))
+	assert.Equal(t, 4, q.Len())
+
+	for _, expected := range []string{"high", "normal-1", "normal-2", "low"} {
+		item, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, expected, item.frame.TransactionID())
+	}
+
+	q.Close()
+	_, ok := q.Pop()
+	assert.False(t, ok)
+	assert.Equal(t, errSendQueueClosed, q.Push(newPriorityFrame("closed", frame.PriorityNormal)))
+}
+
+func TestSendQueueControl(t *testing.T) {
+	q := newSendQueue(clock.New())
+	assert.NoError(t, q.Push(newPriorityFrame("high", frame.PriorityHigh)))
+	assert.NoError(t, q.PushControl(frame.NewPingFrame()))
+	assert.Equal(t, 2, q.Len())
+	// the control frames aren't the backlog of the DataFrames
+	assert.Equal(t, 1, q.Depth())
+
+	item, _ := q.Pop()
+	assert.Nil(t, item.frame)
+	assert.Equal(t, frame.TagOfPingFrame, item.control.Type())
+	item, _ = q.Pop()
+	assert.Equal(t, "high", item.frame.TransactionID())
+
+	q.Close()
+	assert.Equal(t, errSendQueueClosed, q.PushControl(frame.NewPingFrame()))
+}
+
+func TestSendQueueWindow(t *testing.T) {
+	q := newSendQueue(clock.New())
+	q.SetWindow(2)
+	// the control frames aren't in flight
+	assert.NoError(t, q.PushControl(frame.NewPingFrame()))
+	assert.NoError(t, q.Push(newPriorityFrame("1", frame.PriorityNormal)))
+	assert.NoError(t, q.Push(newPriorityFrame("2", frame.PriorityNormal)))
+	assert.True(t, q.Full())
+	assert.Equal(t, errWindowFull, q.Push(newPriorityFrame("3", frame.PriorityNormal)))
+	q.Pop()
+	assert.Equal(t, 2, q.InFlight())
+	assert.Equal(t, errWindowFull, q.Push(newPriorityFrame("3", frame.PriorityNormal)))
+
+	// the DataFrame being written is in flight until the next Pop
+	q.Pop()
+	assert.Equal(t, 2, q.InFlight())
+	assert.True(t, q.Full())
+	q.Pop()
+	assert.Equal(t, 1, q.InFlight())
+	assert.NoError(t, q.Push(newPriorityFrame("3", frame.PriorityNormal)))
+	q.Close()
+}
+
+func TestSendQueueStreamClosed(t *testing.T) {
+	q := newSendQueue(clock.New())
+	done, err := q.PushStream(newPriorityFrame("blob", frame.PriorityNormal), nil, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, q.Len())
+
+	// the sender waiting for the result is released
+	q.Close()
+	assert.Equal(t, errSendQueueClosed, <-done)
+	_, err = q.PushStream(newPriorityFrame("blob", frame.PriorityNormal), nil, 4)
+	assert.Equal(t, errSendQueueClosed, err)
+}
+
+func TestSendQueueLimit(t *testing.T) {
+	var overflowed int64
+	q := newSendQueue(clock.New())
+	q.limit = 2
+	q.overflowed = &overflowed
+	assert.NoError(t, q.Push(newPriorityFrame("normal-1", frame.PriorityNormal)))
+	assert.NoError(t, q.Push(newPriorityFrame("low", frame.PriorityLow)))
+	assert.NoError(t, q.Push(newPriorityFrame("normal-2", frame.PriorityNormal)))
+	// the oldest frame of the lowest priority is dropped
+	assert.Equal(t, 2, q.Depth())
+	assert.EqualValues(t, 1, overflowed)
+	assert.NoError(t, q.Push(newPriorityFrame("high", frame.PriorityHigh)))
+	assert.EqualValues(t, 2, overflowed)
+
+	for _, expected := range []string{"high", "normal-2"} {
+		item, ok := q.Pop()
+		assert.True(t, ok)
+		assert.Equal(t, expected, item.frame.TransactionID())
+	}
+}
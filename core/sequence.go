@@ -0,0 +1,53 @@
+package core
+
+import "sync"
+
+// SequenceGapHandler is invoked when the DataFrames of the issuer between expected and got
+// (exclusive) are missing, the DataFrames are numbered per tag.
+type SequenceGapHandler func(issuer string, expected, got uint64)
+
+// SequenceTracker detects the gaps of the sequence numbers of the DataFrames per issuer and
+// tag, the sources number the DataFrames of every tag on its own, so a stream function
+// observes a part of the tags of a source doesn't see the others as gaps. The frames without
+// a sequence number are ignored, a number not greater than the last one is considered a
+// reordered or retransmitted frame, or a restart of the issuer, it's not a gap.
+type SequenceTracker struct {
+	mu   sync.Mutex
+	last map[sequenceKey]uint64 // issuer and tag -> last sequence number
+	gaps int64
+}
+
+type sequenceKey struct {
+	issuer string
+	tag    byte
+}
+
+// NewSequenceTracker creates a SequenceTracker.
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{last: make(map[sequenceKey]uint64)}
+}
+
+// Observe the sequence number of the tag of the issuer, it returns the number of the missing
+// frames before it, 0 if there's no gap.
+func (t *SequenceTracker) Observe(issuer string, tag byte, sequence uint64) uint64 {
+	if sequence == 0 {
+		return 0
+	}
+	key := sequenceKey{issuer: issuer, tag: tag}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.last[key]
+	t.last[key] = sequence
+	if !ok || sequence <= last+1 {
+		return 0
+	}
+	t.gaps++
+	return sequence - last - 1
+}
+
+// Gaps returns how many gaps are detected.
+func (t *SequenceTracker) Gaps() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gaps
+}
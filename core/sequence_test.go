@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	tracker := NewSequenceTracker()
+
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 1))
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 2))
+	assert.EqualValues(t, 2, tracker.Observe("source", 0x33, 5))
+	// the issuers are tracked separately
+	assert.EqualValues(t, 0, tracker.Observe("other", 0x33, 10))
+	// the frames without a sequence number are ignored
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 0))
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 6))
+	// the restart of the issuer isn't a gap
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 1))
+	assert.EqualValues(t, 1, tracker.Observe("source", 0x33, 3))
+	// the tags are tracked separately
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x34, 1))
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x33, 4))
+	assert.EqualValues(t, 0, tracker.Observe("source", 0x34, 2))
+
+	assert.EqualValues(t, 2, tracker.Gaps())
+}
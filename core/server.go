@@ -1,7 +1,9 @@
 package core
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -9,10 +11,13 @@ import (
 	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/clock"
 	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/core/log"
 	"github.com/yomorun/yomo/core/store"
 	"github.com/yomorun/yomo/pkg/logger"
 	pkgtls "github.com/yomorun/yomo/pkg/tls"
@@ -20,6 +25,20 @@ import (
 
 const (
 	DefaultListenAddr = "0.0.0.0:9000"
+	// DefaultMaxHops is the default max number of times a DataFrame can be forwarded.
+	DefaultMaxHops = 16
+	// DefaultMaxHandshakeFrameSize is the default max size of the frames read before the
+	// connection is authenticated.
+	DefaultMaxHandshakeFrameSize = 16 * 1024
+	// DefaultSendQueueSize is the default max number of the DataFrames queued per connection.
+	DefaultSendQueueSize = 4096
+	// DefaultDrainQuietPeriod is the default duration a drained stream function sends nothing
+	// for before it's considered done with the frames it's processing.
+	DefaultDrainQuietPeriod = 500 * time.Millisecond
+	// DefaultMaxAckWindow is the default max ack window the clients request at the handshake.
+	DefaultMaxAckWindow = 1024
+	// rejectTimeout is the duration waiting for the stream to write RejectedFrame on.
+	rejectTimeout = time.Second
 )
 
 type ServerOption func(*ServerOptions)
@@ -31,25 +50,111 @@ type FrameHandler func(c *Context) error
 type Server struct {
 	name string
 	// stream             quic.Stream
-	state              string
-	connector          Connector
-	router             Router
-	counterOfDataFrame int64
-	downstreams        map[string]*Client
-	mu                 sync.Mutex
-	opts               ServerOptions
-	beforeHandlers     []FrameHandler
-	afterHandlers      []FrameHandler
+	state                         ServerState
+	stateMu                       sync.Mutex
+	stateHandler                  StateChangeHandler
+	connector                     Connector
+	router                        Router
+	counterOfDataFrame            int64
+	counterOfHopsExceeded         int64
+	counterOfFiltered             int64
+	counterOfReplayed             int64
+	counterOfViolation            int64
+	counterOfTooManyTransactions  int64
+	counterOfInvalidTransactionID int64
+	counterOfBrokenStreams        int64 // target streams broken by a write error
+	counterOfWriteFailed          int64
+	counterOfCarriageFailed       int64 // streamed frames whose carriages can't be read
+	counterOfDeadLettered         int64
+	counterOfAcceptErrors         int64
+	echo                          int32 // 1 means the echo mode
+	paused                        int32 // 1 means the sources are paused
+	counterOfPaused               int64
+	counterOfStageFull            int64 // frames refused by the full windows of the stages
+	counterOfExpired              int64
+	counterOfTransformed          int64 // frames dropped by the transformer
+	counterOfNoFirstStage         int64
+	counterOfAccepted             int64 // sessions accepted
+	counterOfResumed              int64 // sessions accepted by a resumed TLS session
+	activeSessions                int64
+	startedAt                     int64 // unix nano
+	downstreams                   map[string]*Client
+	mu                            sync.Mutex
+	listeners                     map[quic.Listener]struct{} // guarded by mu
+	closed                        bool                       // Close is called, guarded by mu
+	opts                          ServerOptions
+	beforeHandlers                []FrameHandler
+	afterHandlers                 []FrameHandler
+	disconnectHandler             DisconnectHandler
+	connectHandler                ConnectHandler
+	frameFilter                   func(f *frame.DataFrame) bool
+	frameTransformer              FrameTransformer
+	dataFrameObserver             DataFrameObserver
+	sessions                      *sessionPool
+	replay                        *replayBuffer
+	samplers                      map[string]*sampler // stage -> sampler
+	deliveryAge                   *histogram
+	processingLatency             *histogram // from receiving a DataFrame to forwarding it
+	frameStats                    frameStats
+	pinger                        *pinger
+	registry                      sync.Map // connID -> *session
+	ipSessions                    ipCounter
+	stages                        *stageWatcher
+	reassembler                   *Reassembler // nil if the fragments are routed as they are
+	routing                       inflight     // the calls picking the targets and writing to them, see DrainFunction
+	ackReceivers                  sync.Map     // connID -> *ackReceiver
+	ackClients                    sync.Map     // the identity of the client -> *ackReceiver, see ackReceiverOf
+	heartbeats                    sync.Map     // connID -> the negotiated heartbeat interval
+	observers                     sync.Map     // connID -> struct{}, the connections of ClientTypeObserver
+	certificate                   certificateHolder
+	pingerOnce                    sync.Once
+	functionStats                 functionCounters
+	connStats                     connectionCounters
+	budget                        *memoryBudget
+	transactions                  *openTransactions // nil if the open transactions aren't limited
+	discovery                     *registrySync     // mirrors the stream functions into the Registry
+	handshakes                    *handshakeTracer
+	shutdown                      ShutdownProgress // guarded by shutdownMu
+	shutdownMu                    sync.Mutex
+	done                          chan struct{}
+	doneOnce                      sync.Once
+	err                           error
 }
 
 // NewServer create a Server instance.
 func NewServer(name string, opts ...ServerOption) *Server {
 	s := &Server{
 		name:        name,
-		connector:   newConnector(),
+		state:       ServerStateReady,
 		downstreams: make(map[string]*Client),
+		done:        make(chan struct{}),
 	}
 	s.Init(opts...)
+	s.budget = newMemoryBudget(s.opts.MemoryBudget)
+	s.connector = newConnector(s.opts.Clock, s.opts.StreamWriteBufferSize, s.budget)
+	if s.opts.SendQueueSize > 0 {
+		s.connector.SetQueueSize(s.opts.SendQueueSize)
+	}
+	s.connector.OnBroken(s.brokenStream)
+	s.sessions = newSessionPool(s.opts.MaxSessions, s.opts.SessionPoolPolicy)
+	s.replay = newReplayBuffer(s.opts.ReplaySize, s.budget)
+	s.samplers = newSamplers(s.opts.SampleRates)
+	s.deliveryAge = newHistogram(defaultLatencyBuckets)
+	s.processingLatency = newHistogram(processingLatencyBuckets)
+	s.pinger = newPinger(s.opts.PingInterval, s.opts.PingTimeout)
+	s.stages = newStageWatcher()
+	s.discovery = newRegistrySync(s.opts.Registry)
+	s.handshakes = newHandshakeTracer(s.opts.Clock)
+	if s.opts.FragmentTimeout > 0 {
+		s.reassembler = NewReassembler(s.opts.FragmentTimeout)
+		s.reassembler.SetLimits(s.opts.MaxPartialMessages, s.opts.MaxPartialMessagesPerConn)
+		s.reassembler.budget = s.budget
+		s.budget.addEvictor(s.reassembler.evictOldest)
+	}
+	if s.opts.MaxOpenTransactions > 0 {
+		s.transactions = newOpenTransactions(s.opts.MaxOpenTransactions)
+		s.connector.TrackTransactions(s.transactions)
+	}
 
 	return s
 }
@@ -70,85 +175,379 @@ func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
 	if addr == "" {
 		addr = DefaultListenAddr
 	}
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	s.setState(ServerStateStarting)
+	conn, err := listenUDP(addr)
 	if err != nil {
-		return nil
+		logger.Errorf("%s%v", ServerLogPrefix, err)
+		return s.finish(err)
 	}
-	conn, err := net.ListenUDP("udp", udpAddr)
+	// the listener doesn't close the conn it's handed
+	defer conn.Close()
+	return s.Serve(ctx, conn)
+}
+
+// ListenAndServeMulti starts the server on several endpoints, every endpoint has its own
+// listener but shares the workflow and the connections. The server stops serving all of
+// the endpoints once any one of them fails.
+func (s *Server) ListenAndServeMulti(ctx context.Context, addrs ...string) error {
+	if len(addrs) == 0 {
+		addrs = []string{DefaultListenAddr}
+	}
+	s.setState(ServerStateStarting)
+	conns := make([]net.PacketConn, 0, len(addrs))
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	hosts := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := listenUDP(addr)
+		if err != nil {
+			logger.Errorf("%s%v", ServerLogPrefix, err)
+			return s.finish(err)
+		}
+		conns = append(conns, conn)
+		hosts = append(hosts, conn.LocalAddr().String())
+	}
+	// the certificate covers all the endpoints
+	tc, err := s.tlsConfig(hosts...)
 	if err != nil {
-		return err
+		logger.Errorf("%sCreateServerTLSConfig: %v", ServerLogPrefix, err)
+		return s.finish(err)
 	}
-	return s.Serve(ctx, conn)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			errc <- s.servePacketConn(ctx, conn, tc)
+		}(conn)
+	}
+	err = <-errc
+	cancel()
+	for i := 1; i < len(conns); i++ {
+		<-errc
+	}
+	return err
 }
 
 // Serve the server with a net.PacketConn.
 func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	s.setState(ServerStateStarting)
+	tc, err := s.tlsConfig(conn.LocalAddr().String())
+	if err != nil {
+		logger.Errorf("%sCreateServerTLSConfig: %v", ServerLogPrefix, err)
+		return s.finish(err)
+	}
+	return s.servePacketConn(ctx, conn, tc)
+}
+
+// quicConfig returns the quic config with the profile and the versions applied, nil means
+// the default.
+func (s *Server) quicConfig() (*quic.Config, error) {
+	if s.opts.Profile == nil && s.opts.Versions == nil && s.opts.HeartbeatMax <= 0 {
+		return s.opts.QuicConfig, nil
+	}
+	c := s.opts.QuicConfig
+	if c == nil {
+		c = defaultServerQuicConfig()
+	}
+	if s.opts.Profile != nil {
+		c = s.opts.Profile.Apply(c)
+	}
+	if s.opts.HeartbeatMax > 0 {
+		c = applyHeartbeat(c, s.opts.HeartbeatMax)
+	}
+	return applyVersions(c, s.opts.Versions)
+}
+
+// servePacketConn listens on the conn with the tls config and serves it.
+func (s *Server) servePacketConn(ctx context.Context, conn net.PacketConn, tc *tls.Config) error {
+	qc, err := s.quicConfig()
+	if err != nil {
+		logger.Errorf("%squic config: err=%v", ServerLogPrefix, err)
+		return s.finish(err)
+	}
+	// the handshake durations of the sessions are traced
+	qc = s.handshakes.apply(qc)
 	listener := newListener()
 	// listen the address
-	err := listener.Listen(conn, s.opts.TLSConfig, s.opts.QuicConfig)
+	err = listener.Listen(conn, tc, qc)
 	if err != nil {
 		logger.Errorf("%slistener.Listen: err=%v", ServerLogPrefix, err)
-		return err
+		return s.finish(&ListenError{Addr: conn.LocalAddr().String(), Err: err})
 	}
 	defer listener.Close()
+	if !s.trackListener(listener) {
+		return s.finish(ErrServerClosed)
+	}
+	defer s.untrackListener(listener)
 	logger.Printf("%s✅ [%s] Listening on: %s, MODE: %s, QUIC: %v, AUTH: %s", ServerLogPrefix, s.name, listener.Addr(), mode(), listener.Versions(), s.authNames())
 
-	s.state = ConnStateConnected
+	s.setState(ServerStateListening)
+	atomic.StoreInt64(&s.startedAt, s.opts.Clock.Now().UnixNano())
+	if s.pinger != nil {
+		// the endpoints share the pinger
+		s.pingerOnce.Do(func() { go s.ping(ctx) })
+	}
+	return s.serve(ctx, listener)
+}
+
+// tlsConfig returns the tls config of the server with the custom certificate verification
+// and the certificate reloaded by ReloadCertificate, the default one is created for the
+// hosts if there's no tls config configured.
+func (s *Server) tlsConfig(hosts ...string) (*tls.Config, error) {
+	tc := s.opts.TLSConfig
+	if tc == nil {
+		for i, host := range hosts {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				hosts[i] = h
+			}
+		}
+		var err error
+		tc, err = pkgtls.CreateServerTLSConfigWithOptions(s.opts.CertOptions, hosts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tc = tc.Clone()
+	tc.GetCertificate = s.certificate.getCertificate(tc)
+	if s.opts.VerifyPeerCertificate != nil {
+		tc.VerifyPeerCertificate = s.opts.VerifyPeerCertificate
+	}
+	if len(s.opts.SessionTicketKeys) > 0 {
+		tc.SetSessionTicketKeys(s.opts.SessionTicketKeys)
+	}
+	if s.opts.Compression {
+		tc = withCompressionALPN(tc)
+	}
+	if len(s.opts.SNITenants) > 0 {
+		tc.GetConfigForClient = s.sniConfigForClient(tc.GetConfigForClient)
+	}
+	return tc, nil
+}
+
+// trackListener registers the listener so Close can close it, returns false if the server
+// has been closed.
+func (s *Server) trackListener(listener quic.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[quic.Listener]struct{})
+	}
+	s.listeners[listener] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(listener quic.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, listener)
+	s.mu.Unlock()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// serve accepts the connections from listener until the listener is closed or the ctx is
+// done, the other accept errors are retried after a backoff.
+func (s *Server) serve(ctx context.Context, listener quic.Listener) error {
+	var delay time.Duration
 	for {
 		// create a new connection when new yomo-client connected
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			if s.isClosed() {
+				return s.finish(ErrServerClosed)
+			}
+			if ctx.Err() != nil || isListenerClosed(err) {
+				logger.Errorf("%screate connection error: %v", ServerLogPrefix, err)
+				return s.finish(err)
+			}
+			atomic.AddInt64(&s.counterOfAcceptErrors, 1)
+			if delay == 0 {
+				delay = minAcceptDelay
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			logger.Warnf("%saccept error: %v, retrying in %v", ServerLogPrefix, err, delay)
+			select {
+			case <-ctx.Done():
+				return s.finish(ctx.Err())
+			case <-s.opts.Clock.After(delay):
+			}
+			continue
+		}
+		delay = 0
+
+		connID := newConnID()
+		now := s.opts.Clock.Now()
+		sess := newSession(connID, conn, now)
+		sess.handshake = s.handshakes.took(conn, now)
+		atomic.AddInt64(&s.counterOfAccepted, 1)
+		if sess.resumed {
+			atomic.AddInt64(&s.counterOfResumed, 1)
+		}
+		sess.logger.Infof("%s❤️1/ new connection: %s(%s), resumed=%v, handshake=%s", ServerLogPrefix, connID, conn.RemoteAddr(), sess.resumed, sess.handshake)
+
+		if !s.ipSessions.acquire(sess.ip, s.opts.MaxSessionsPerIP) {
+			sess.logger.Warnf("%s[%s] too many sessions from %s, reject the connection", ServerLogPrefix, connID, sess.ip)
+			go s.rejectConn(ctx, conn, connID, "too many sessions from the address")
+			continue
+		}
+
+		if !s.sessions.acquire(ctx) {
+			s.ipSessions.release(sess.ip)
+			sess.logger.Warnf("%s[%s] too many sessions, reject the connection", ServerLogPrefix, connID)
+			go s.rejectConn(ctx, conn, connID, "too many sessions")
+			continue
+		}
+
 		sctx, cancel := context.WithCancel(ctx)
-		defer cancel()
+		go func(ctx context.Context, conn quic.Connection, connID string, sess *session) {
+			defer cancel()
+			defer s.sessions.release()
+			defer s.ipSessions.release(sess.ip)
+			atomic.AddInt64(&s.activeSessions, 1)
+			defer atomic.AddInt64(&s.activeSessions, -1)
+			s.registry.Store(connID, sess)
+			defer s.registry.Delete(connID)
+			s.serveConn(ctx, conn, connID)
+		}(sctx, conn, connID, sess)
+	}
+}
+
+const (
+	// minAcceptDelay is the first delay to retry accepting after a transient error.
+	minAcceptDelay = 5 * time.Millisecond
+	// maxAcceptDelay caps the delay of the consecutive accept retries.
+	maxAcceptDelay = time.Second
+)
 
-		conn, err := listener.Accept(sctx)
+// isListenerClosed reports whether the accept error means the listener is closed, quic-go
+// returns the "server closed" error which is not exported.
+func isListenerClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || err.Error() == "server closed"
+}
+
+// serveConn handles the streams of a connection, the connection is registered by the
+// connID got when it's accepted, so it keeps the registration when the remote address
+// changes, e.g. the client moves to another network.
+func (s *Server) serveConn(ctx context.Context, conn quic.Connection, connID string) {
+	// the reason of the last stream, prior to the error of AcceptStream
+	var reason *DisconnectReason
+	sessLogger := s.session(connID).Logger()
+	uniCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.serveUniStreams(uniCtx, conn, connID)
+	for {
+		sessLogger.Infof("%s❤️2/ waiting for new stream", ServerLogPrefix)
+		stream, err := conn.AcceptStream(ctx)
 		if err != nil {
-			logger.Errorf("%screate connection error: %v", ServerLogPrefix, err)
-			return err
+			// if client close the connection, then we should close the connection
+			// @CC: when Source close the connection, it won't affect connectors
+			if reason == nil {
+				r := disconnectReason(err)
+				reason = &r
+			}
+			// it's deregistered already if the server evicted or closed it
+			if !s.deregister(connID, *reason) {
+				sessLogger.Debugf("%s❤️3/ [unknown](%s) on stream %v", ServerLogPrefix, connID, err)
+			}
+			break
+		}
+		if isCompressed(conn) {
+			stream = compressStream(stream)
 		}
+		// TODO: 确实执行了吗？
+		defer stream.Close()
 
-		connID := GetConnID(conn)
-		logger.Infof("%s❤️1/ new connection: %s", ServerLogPrefix, connID)
-
-		go func(ctx context.Context, conn quic.Connection) {
-			for {
-				logger.Infof("%s❤️2/ waiting for new stream", ServerLogPrefix)
-				stream, err := conn.AcceptStream(ctx)
-				if err != nil {
-					// if client close the connection, then we should close the connection
-					// @CC: when Source close the connection, it won't affect connectors
-					app, ok := s.connector.App(connID)
-					if ok {
-						// connector
-						s.connector.Remove(connID)
-						// store
-						// when remove store by appID? let me think...
-						logger.Printf("%s💔 [%s::%s](%s) close the connection", ServerLogPrefix, app.ID(), app.Name(), connID)
-					} else {
-						logger.Errorf("%s❤️3/ [unknown](%s) on stream %v", ServerLogPrefix, connID, err)
-					}
-					break
-				}
-				// TODO: 确实执行了吗？
-				defer stream.Close()
-
-				logger.Infof("%s❤️4/ [stream:%d] created, connID=%s", ServerLogPrefix, stream.StreamID(), connID)
-				// process frames on stream
-				c := newContext(connID, stream)
-				defer c.Clean()
-				s.handleConnection(c)
-				logger.Infof("%s❤️5/ [stream:%d] handleConnection DONE", ServerLogPrefix, stream.StreamID())
-			}
-		}(sctx, conn)
+		sessLogger.Infof("%s❤️4/ [stream:%d] created, connID=%s", ServerLogPrefix, stream.StreamID(), connID)
+		// process frames on stream
+		c := newContext(connID, stream)
+		defer c.Clean()
+		r := s.handleConnection(ctx, c)
+		reason = &r
+		sessLogger.Infof("%s❤️5/ [stream:%d] handleConnection DONE", ServerLogPrefix, stream.StreamID())
+	}
+}
+
+// unblockRead makes the pending read of the stream return, by the read deadline if the stream
+// supports it, e.g. quic.Stream, otherwise by closing the stream.
+func unblockRead(stream io.ReadWriteCloser) {
+	if setReadDeadline(stream, time.Now()) {
+		return
 	}
+	stream.Close()
 }
 
-// Close will shutdown the server.
+// setReadDeadline sets the read deadline of the stream, returns false if it's not supported.
+func setReadDeadline(stream io.ReadWriteCloser, t time.Time) bool {
+	d, ok := stream.(interface{ SetReadDeadline(t time.Time) error })
+	if !ok {
+		return false
+	}
+	d.SetReadDeadline(t)
+	return true
+}
+
+// rejectConn writes a RejectedFrame to the first stream of the connection then closes it.
+func (s *Server) rejectConn(ctx context.Context, conn quic.Connection, connID string, msg string) {
+	ctx, cancel := context.WithTimeout(ctx, rejectTimeout)
+	defer cancel()
+	if stream, err := conn.AcceptStream(ctx); err == nil {
+		s.reject(&Context{ConnID: connID, Stream: stream}, msg)
+		stream.Close()
+	}
+	conn.CloseWithError(0xCE, msg)
+}
+
+// finish marks the server as stopped with the terminal error, it returns the error as it is.
+func (s *Server) finish(err error) error {
+	s.doneOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+		s.setState(ServerStateClosed)
+		close(s.done)
+	})
+	return err
+}
+
+// Done returns a channel which is closed when the server stops serving,
+// it is safe to call before the server starts.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the terminal error after the server stops, it returns nil
+// if the server is still running or has not started yet.
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close will shutdown the server, the listeners are closed so ListenAndServe returns
+// ErrServerClosed.
 func (s *Server) Close() error {
-	// if s.stream != nil {
-	// 	if err := s.stream.Close(); err != nil {
-	// 		logger.Errorf("%sClose(): %v", ServerLogPrefix, err)
-	// 		return err
-	// 	}
-	// }
+	s.mu.Lock()
+	s.closed = true
+	for listener := range s.listeners {
+		if err := listener.Close(); err != nil {
+			logger.Errorf("%sClose(): listener err=%v", ServerLogPrefix, err)
+		}
+	}
+	s.mu.Unlock()
+	s.setState(ServerStateDraining)
+	defer s.setState(ServerStateClosed)
 	// router
 	if s.router != nil {
 		s.router.Clean()
@@ -161,68 +560,132 @@ func (s *Server) Close() error {
 	if s.opts.Store != nil {
 		s.opts.Store.Clean()
 	}
+	// terminal sink
+	if s.opts.TerminalSink != nil {
+		if err := s.opts.TerminalSink.Close(); err != nil {
+			logger.Errorf("%sClose(): terminal sink err=%v", ServerLogPrefix, err)
+		}
+	}
 	return nil
 }
 
-// handle streams on a connection
-func (s *Server) handleConnection(c *Context) {
-	fs := NewFrameStream(c.Stream)
+// handleConnection handles the frames on the stream until the stream ends or the context is
+// done, returns the reason why it ended.
+func (s *Server) handleConnection(ctx context.Context, c *Context) DisconnectReason {
+	c.WithContext(ctx)
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func(stream io.ReadWriteCloser) {
+			select {
+			case <-ctx.Done():
+				unblockRead(stream)
+			case <-done:
+			}
+		}(c.Stream)
+	}
+	stream := &countingStream{ReadWriter: c.Stream}
+	fs := NewFrameStream(stream)
+	sess := s.session(c.ConnID)
+	if sess != nil {
+		c.logger = sess.logger
+	}
+	// the smaller limit applies until the connection is authenticated
+	fs.SetMaxFrameSize(s.opts.MaxHandshakeFrameSize)
+	authenticated := false
 	// check update for stream
 	for {
-		logger.Debugf("%shandleConnection 💚 waiting read next...", ServerLogPrefix)
+		if !authenticated && s.clientType(c.ConnID) != ClientTypeNone {
+			authenticated = true
+			fs.SetMaxFrameSize(s.opts.MaxFrameSize)
+			fs.SetStreamThreshold(s.opts.StreamThreshold)
+		}
+		c.Logger().Debugf("%shandleConnection 💚 waiting read next...", ServerLogPrefix)
+		readTimeout := s.readTimeout(c.ConnID)
+		if readTimeout > 0 && setReadDeadline(c.Stream, time.Now().Add(readTimeout)) {
+			// the deadline may override the one set by unblockRead
+			if ctx.Err() != nil {
+				c.Logger().Infof("%s(%s) stop reading the stream: %v", ServerLogPrefix, c.ConnID, ctx.Err())
+				return DisconnectReason{Cause: DisconnectServerClose, Err: ctx.Err()}
+			}
+		}
 		f, err := fs.ReadFrame()
+		if err == nil {
+			n := stream.take()
+			if sf, ok := f.(*StreamingDataFrame); ok {
+				// the carriage is read later, it's counted by this frame
+				n += stream.expect(int64(sf.Size()))
+				// and it may take longer than the read timeout
+				if readTimeout > 0 {
+					setReadDeadline(c.Stream, time.Time{})
+				}
+			}
+			s.frameStats.observe(f.Type(), n)
+			sess.observe(n, s.opts.Clock.Now())
+		}
 		if err != nil {
+			// the server is shutting down
+			if ctx.Err() != nil {
+				c.Logger().Infof("%s(%s) stop reading the stream: %v", ServerLogPrefix, c.ConnID, ctx.Err())
+				return DisconnectReason{Cause: DisconnectServerClose, Err: ctx.Err()}
+			}
 			// if client close connection, will get ApplicationError with code = 0x00
 			if e, ok := err.(*quic.ApplicationError); ok {
 				if e.ErrorCode == 0x00 {
 					// client abort
-					logger.Infof("%sclient close the connection", ServerLogPrefix)
-					break
+					c.Logger().Infof("%sclient close the connection", ServerLogPrefix)
+					return disconnectReason(err)
 				}
 			} else if err == io.EOF {
-				break
+				return disconnectReason(err)
+			}
+			if reason := disconnectReason(err); reason.Cause == DisconnectReadTimeout {
+				c.Logger().Warnf("%s(%s) no frame received in %s, close the stream", ServerLogPrefix, c.ConnID, readTimeout)
+				c.CloseWithError(0xC2, "read timeout")
+				return reason
 			}
-			logger.Errorf("%s [ERR] %v", ServerLogPrefix, err)
+			log.With(c.Logger(), "conn_id", c.ConnID).Errorf("%s [ERR] %v", ServerLogPrefix, err)
 			if errors.Is(err, net.ErrClosed) {
 				// if client close the connection, net.ErrClosed will be raise
 				// by quic-go IdleTimeoutError after connection's KeepAlive config.
-				logger.Warnf("%s [ERR] net.ErrClosed on [handleConnection] %v", ServerLogPrefix, net.ErrClosed)
+				c.Logger().Warnf("%s [ERR] net.ErrClosed on [handleConnection] %v", ServerLogPrefix, net.ErrClosed)
 				c.CloseWithError(0xC1, "net.ErrClosed")
-				break
+				return disconnectReason(err)
 			}
 			// any error occurred, we should close the stream
 			// after this, conn.AcceptStream() will raise the error
 			c.CloseWithError(0xC0, err.Error())
-			logger.Warnf("%sconnection.Close()", ServerLogPrefix)
-			break
+			c.Logger().Warnf("%sconnection.Close()", ServerLogPrefix)
+			return disconnectReason(err)
 		}
 
-		frameType := f.Type()
-		data := f.Encode()
-		logger.Debugf("%stype=%s, frame[%d]=%# x", ServerLogPrefix, frameType, len(data), frame.Shortly(data))
+		if _, ok := f.(*StreamingDataFrame); !ok && log.IsDebug(c.Logger()) {
+			data := f.Encode()
+			c.Logger().Debugf("%stype=%s, frame[%d]=%# x", ServerLogPrefix, f.Type(), len(data), frame.Shortly(data))
+		}
 		// add frame to context
 		c := c.WithFrame(f)
 
 		// before frame handlers
 		for _, handler := range s.beforeHandlers {
 			if err := handler(c); err != nil {
-				logger.Errorf("%safterFrameHandler err: %s", ServerLogPrefix, err)
+				c.Logger().Errorf("%safterFrameHandler err: %s", ServerLogPrefix, err)
 				c.CloseWithError(0xCC, err.Error())
-				return
+				return DisconnectReason{Cause: DisconnectServerClose, Err: err}
 			}
 		}
 		// main handler
 		if err := s.mainFrameHandler(c); err != nil {
-			logger.Errorf("%smainFrameHandler err: %s", ServerLogPrefix, err)
+			c.Logger().Errorf("%smainFrameHandler err: %s", ServerLogPrefix, err)
 			c.CloseWithError(0xCC, err.Error())
-			return
+			return DisconnectReason{Cause: DisconnectServerClose, Err: err}
 		}
 		// after frame handler
 		for _, handler := range s.afterHandlers {
 			if err := handler(c); err != nil {
-				logger.Errorf("%safterFrameHandler err: %s", ServerLogPrefix, err)
+				c.Logger().Errorf("%safterFrameHandler err: %s", ServerLogPrefix, err)
 				c.CloseWithError(0xCC, err.Error())
-				return
+				return DisconnectReason{Cause: DisconnectServerClose, Err: err}
 			}
 		}
 	}
@@ -232,23 +695,76 @@ func (s *Server) mainFrameHandler(c *Context) error {
 	var err error
 	frameType := c.Frame.Type()
 
+	// role based validation
+	role := s.clientType(c.ConnID)
+	if !role.CanSend(frameType) {
+		atomic.AddInt64(&s.counterOfViolation, 1)
+		err = fmt.Errorf("protocol violation, %s can not send %s", role, frameType)
+		log.With(c.Logger(), "conn_id", c.ConnID, "frame_type", frameType.String()).Warnf("%s(%s) %v", ServerLogPrefix, c.ConnID, err)
+		if s.opts.CloseOnProtocolViolation {
+			return err
+		}
+		return nil
+	}
+	// nothing can be replied on the unidirectional stream
+	if c.uni && frameType != frame.TagOfDataFrame {
+		atomic.AddInt64(&s.counterOfViolation, 1)
+		err = fmt.Errorf("protocol violation, %s can not be sent on the unidirectional stream", frameType)
+		log.With(c.Logger(), "conn_id", c.ConnID, "frame_type", frameType.String()).Warnf("%s(%s) %v", ServerLogPrefix, c.ConnID, err)
+		if s.opts.CloseOnProtocolViolation {
+			return err
+		}
+		return nil
+	}
+
 	switch frameType {
 	case frame.TagOfHandshakeFrame:
 		if err := s.handleHandshakeFrame(c); err != nil {
-			logger.Errorf("%shandleHandshakeFrame err: %s", ServerLogPrefix, err)
+			c.Logger().Errorf("%shandleHandshakeFrame err: %s", ServerLogPrefix, err)
 			c.CloseWithError(0xCC, err.Error())
 			// break
 		}
-	// case frame.TagOfPingFrame:
-	// 	s.handlePingFrame(mainStream, connection, f.(*frame.PingFrame))
+	case frame.TagOfPingFrame:
+		s.handlePingFrame(c)
+	case frame.TagOfPongFrame:
+		s.pinger.pong(c.ConnID)
+	case frame.TagOfSubscriptionFrame:
+		s.handleSubscriptionFrame(c)
+	case frame.TagOfCapabilitiesRequestFrame:
+		s.handleCapabilitiesRequestFrame(c)
 	case frame.TagOfDataFrame:
+		// rejected before the ack, so the source with the ack window retransmits it after Resume
+		if s.rejectPaused(c) {
+			break
+		}
+		release, ok := s.openTransaction(c)
+		if !ok {
+			break
+		}
+		defer release()
+		if s.rejectStageFull(c) {
+			break
+		}
+		if !s.acknowledge(c) {
+			break
+		}
+		// the echo mode and the downstream zippers need the whole frame
+		if atomic.LoadInt32(&s.echo) == 1 || len(s.downstreams) > 0 {
+			if _, err := bufferCarriage(c); err != nil {
+				return err
+			}
+		}
+		if atomic.LoadInt32(&s.echo) == 1 {
+			s.handleEchoFrame(c)
+			break
+		}
 		if err := s.handleDataFrame(c); err != nil {
 			c.CloseWithError(0xCC, "处理DataFrame出错")
 		} else {
-			s.dispatchToDownstreams(c.Frame.(*frame.DataFrame))
+			s.dispatchToDownstreams(dataFrame(c))
 		}
 	default:
-		logger.Errorf("%serr=%v, frame=%v", ServerLogPrefix, err, c.Frame.Encode())
+		c.Logger().Errorf("%serr=%v, frame=%v", ServerLogPrefix, err, c.Frame.Encode())
 	}
 	return nil
 }
@@ -257,23 +773,36 @@ func (s *Server) mainFrameHandler(c *Context) error {
 func (s *Server) handleHandshakeFrame(c *Context) error {
 	f := c.Frame.(*frame.HandshakeFrame)
 
-	logger.Debugf("%sGOT ❤️ HandshakeFrame : %# x", ServerLogPrefix, f)
-	// credential
-	logger.Infof("%sClientType=%# x is %s, CredentialType=%s", ServerLogPrefix, f.ClientType, ClientType(f.ClientType), auth.AuthType(f.AuthType()))
-	// authenticate
-	if !s.authenticate(f) {
+	if log.IsDebug(c.Logger()) {
+		c.Logger().Debugf("%sGOT ❤️ HandshakeFrame : %# x", ServerLogPrefix, f)
+		// credential
+		c.Logger().Debugf("%sClientType=%# x is %s, CredentialType=%s", ServerLogPrefix, f.ClientType, ClientType(f.ClientType), auth.AuthType(f.AuthType()))
+	}
+	// authenticate, the credential is checked against the tenant of the server name
+	ctx := c.Context()
+	if tenant, _, ok := s.sniTenant(c.ConnID); ok {
+		ctx = auth.WithTenant(ctx, tenant)
+	}
+	ctx, ok := s.authenticate(ctx, f)
+	if !ok {
 		err := fmt.Errorf("handshake authentication fails, client credential type is %s", auth.AuthType(f.AuthType()))
 		return err
 	}
+	// the following frames of the connection carry the values set by the authentication
+	c.WithContext(ctx)
 
 	// route
-	appID := f.AppID()
+	appID, err := s.tenantOf(c.ConnID, f.AppID())
+	if err != nil {
+		s.reject(c, err.Error())
+		return err
+	}
 	if err := s.validateRouter(); err != nil {
 		return err
 	}
 	connID := c.ConnID
 	route := s.router.Route(appID)
-	if reflect.ValueOf(route).IsNil() {
+	if isNilRoute(route) {
 		err := errors.New("handleHandshakeFrame route is nil")
 		return err
 	}
@@ -286,12 +815,19 @@ func (s *Server) handleHandshakeFrame(c *Context) error {
 	stream := c.Stream
 	switch clientType {
 	case ClientTypeSource:
+		// a source can not send data anywhere if the workflow is empty, reject it
+		// instead of black-holing its data.
+		if len(route.GetForwardRoutes("")) == 0 {
+			err := fmt.Errorf("handshake rejected [%s], no workflow configured", name)
+			s.reject(c, "no workflow configured")
+			return err
+		}
 		s.connector.Add(connID, stream)
-		s.connector.LinkApp(connID, appID, name, nil)
+		s.connector.LinkApp(connID, appID, name, clientType, nil, 0, 0)
 	case ClientTypeStreamFunction:
 		// when sfn connect, it will provide its name to the server. server will check if this client
 		// has permission connected to.
-		if !route.Exists(name) {
+		if !route.Exists(name) && !s.isDeadLetter(name) {
 			// unexpected client connected, close the connection
 			s.connector.Remove(connID)
 			// SFN: stream function
@@ -303,80 +839,643 @@ func (s *Server) handleHandshakeFrame(c *Context) error {
 
 		s.connector.Add(connID, stream)
 		// link connection to stream function
-		s.connector.LinkApp(connID, appID, name, f.ObserveDataTags)
+		s.connector.LinkApp(connID, appID, name, clientType, f.ObserveDataTags, f.MaxPayloadSize, f.Weight)
+		s.connector.SetWindow(connID, s.opts.StageWindows[name])
+		s.stages.link(connID, stageTokens(route, name))
+		s.replayFrames(connID, appID, name, f.ObserveDataTags)
 	case ClientTypeUpstreamZipper:
 		s.connector.Add(connID, stream)
-		s.connector.LinkApp(connID, appID, name, nil)
+		s.connector.LinkApp(connID, appID, name, clientType, nil, 0, 0)
+	case ClientTypeObserver:
+		// it receives the mirror of the DataFrames of the app, but it isn't routed to
+		s.connector.Add(connID, stream)
+		s.connector.LinkApp(connID, appID, name, clientType, f.ObserveDataTags, f.MaxPayloadSize, 0)
+		s.observers.Store(connID, struct{}{})
 	default:
 		// unknown client type
 		s.connector.Remove(connID)
-		logger.Errorf("%sClientType=%# x, ilegal!", ServerLogPrefix, f.ClientType)
+		c.Logger().Errorf("%sClientType=%# x, ilegal!", ServerLogPrefix, f.ClientType)
 		c.CloseWithError(0xCD, "Unknown ClientType, illegal!")
 		return errors.New("core.server: Unknown ClientType, illegal")
 	}
-	logger.Printf("%s❤️  <%s> [%s::%s](%s) is connected!", ServerLogPrefix, clientType, appID, name, connID)
+	s.releaseAckReceiver(connID)
+	if f.AckWindow > 0 {
+		s.ackReceivers.Store(connID, s.ackReceiverOf(appID, f))
+	}
+	interval := s.negotiateHeartbeat(f.Heartbeat)
+	if interval > 0 {
+		s.heartbeats.Store(connID, interval)
+	} else {
+		s.heartbeats.Delete(connID)
+	}
+	// the older clients don't request the control stream, nor understand the AcceptedFrame
+	// without the heartbeat
+	control := f.ControlStream && s.acceptControlStream(c)
+	if interval > 0 || control {
+		accepted := &frame.AcceptedFrame{Heartbeat: uint32(interval / time.Millisecond), ControlStream: control}
+		if err := s.connector.WriteControl(accepted, connID); err != nil {
+			c.Logger().Errorf("%saccept [%s] err=%v", ServerLogPrefix, connID, err)
+		}
+	}
+	s.connStats.connect(clientType)
+	s.registerApp(connID)
+	log.With(c.Logger(), "conn_id", connID, "name", name, "client_type", clientType.String()).
+		Printf("%s❤️  <%s> [%s::%s](%s) is connected!", ServerLogPrefix, clientType, appID, name, connID)
+	s.connected(connID)
 	return nil
 }
 
-// will reuse quic-go's keep-alive feature
-// func (s *Server) handlePingFrame(stream quic.Stream, conn quic.Connection, f *frame.PingFrame) error {
-// 	logger.Infof("%s------> GOT ❤️ PingFrame : %# x", ServerLogPrefix, f)
-// 	return nil
-// }
+// handleSubscriptionFrame replaces the data tags and the weight of the stream function.
+func (s *Server) handleSubscriptionFrame(c *Context) {
+	f := c.Frame.(*frame.SubscriptionFrame)
+	if !s.connector.UpdateSubscription(c.ConnID, f.ObserveDataTags, f.Weight) {
+		return
+	}
+	s.registerApp(c.ConnID)
+	log.With(c.Logger(), "conn_id", c.ConnID).
+		Printf("%s(%s) subscription is updated, tags=%# x, weight=%d", ServerLogPrefix, c.ConnID, f.ObserveDataTags, f.Weight)
+}
+
+// acknowledge the DataFrame if the client enables the ack window, returns false if the
+// frame is a retransmission which has been received, it's acknowledged again but not routed.
+func (s *Server) acknowledge(c *Context) bool {
+	v, ok := s.ackReceivers.Load(c.ConnID)
+	if !ok {
+		return true
+	}
+	tid := dataFrame(c).TransactionID()
+	first := v.(*ackReceiver).receive(tid)
+	if err := s.connector.WriteControl(frame.NewAckFrame(tid), c.ConnID); err != nil {
+		c.Logger().Errorf("%sack [%s] tid=%s err=%v", ServerLogPrefix, c.ConnID, tid, err)
+	}
+	if !first {
+		c.Logger().Debugf("%s(%s) drop the retransmitted DataFrame, tid=%s", ServerLogPrefix, c.ConnID, tid)
+	}
+	return first
+}
+
+// rejectPaused rejects the DataFrame of the source while the server is paused, the frames
+// of the stream functions are the in-flight frames accepted before, they keep flowing.
+func (s *Server) rejectPaused(c *Context) bool {
+	if atomic.LoadInt32(&s.paused) == 0 || s.clientType(c.ConnID) != ClientTypeSource {
+		return false
+	}
+	atomic.AddInt64(&s.counterOfPaused, 1)
+	c.Logger().Debugf("%s(%s) reject the DataFrame, the server is paused, tid=%s", ServerLogPrefix, c.ConnID, dataFrame(c).TransactionID())
+	s.reject(c, frame.RejectedMessagePaused)
+	return true
+}
+
+// rejectStageFull rejects the DataFrame of the source if the windows of all the instances of
+// a first stage are full, see WithStageWindow. The rejection is before the ack, so the source
+// with the ack window retransmits it later.
+func (s *Server) rejectStageFull(c *Context) bool {
+	if len(s.opts.StageWindows) == 0 || s.clientType(c.ConnID) != ClientTypeSource {
+		return false
+	}
+	f := dataFrame(c)
+	appID, _ := s.connector.AppID(c.ConnID)
+	cacheRoute, ok := s.opts.Store.Get(appID)
+	if !ok || cacheRoute.(Route) == nil {
+		return false
+	}
+	from, _ := s.connector.AppName(c.ConnID)
+	for _, to := range forwardRoutes(cacheRoute.(Route), from, ClientTypeSource) {
+		if s.connector.WindowFull(appID, to, f.GetDataTag()) {
+			atomic.AddInt64(&s.counterOfStageFull, 1)
+			c.Logger().Debugf("%s(%s) reject the DataFrame, the window of [%s] is full, tid=%s", ServerLogPrefix, c.ConnID, to, f.TransactionID())
+			s.reject(c, frame.RejectedMessageStageFull)
+			return true
+		}
+	}
+	return false
+}
+
+// reject writes a RejectedFrame with the reason to the client.
+func (s *Server) reject(c *Context, msg string) {
+	// the stream of the registered connection is written by its send queue, the frames would
+	// interleave with the concurrent writes
+	if s.connector.Get(c.ConnID) != nil {
+		if err := s.connector.WriteControl(frame.NewRejectedFrame(msg), c.ConnID); err != nil {
+			c.Logger().Errorf("%sreject [%s] err=%v", ServerLogPrefix, c.ConnID, err)
+		}
+		return
+	}
+	if c.Stream == nil {
+		return
+	}
+	if _, err := c.Stream.Write(frame.NewRejectedFrame(msg).Encode()); err != nil {
+		c.Logger().Errorf("%sreject [%s] err=%v", ServerLogPrefix, c.ConnID, err)
+	}
+}
+
+// clientType returns the role of the connection, ClientTypeNone if it hasn't registered.
+func (s *Server) clientType(connID string) ClientType {
+	if s.connector.Get(connID) == nil {
+		return ClientTypeNone
+	}
+	if app, ok := s.connector.App(connID); ok {
+		return app.ClientType()
+	}
+	return ClientTypeNone
+}
+
+// replayFrames replays the retained frames observed by the stream function.
+func (s *Server) replayFrames(connID string, appID string, name string, observed []byte) {
+	for _, f := range s.replay.frames(appID, name) {
+		if bytes.IndexByte(observed, f.GetDataTag()) < 0 {
+			continue
+		}
+		if err := s.connector.Write(f, connID); err != nil {
+			logger.Errorf("%sreplay data: --> [%s](%s), err=%v", ServerLogPrefix, name, connID, err)
+			return
+		}
+		atomic.AddInt64(&s.counterOfReplayed, 1)
+	}
+}
 
 func (s *Server) handleDataFrame(c *Context) error {
+	receivedAt := s.opts.Clock.Now()
 	// counter +1
 	atomic.AddInt64(&s.counterOfDataFrame, 1)
 	// currentIssuer := f.GetIssuer()
 	fromID := c.ConnID
-	from, ok := s.connector.AppName(fromID)
+	fromApp, ok := s.connector.App(fromID)
 	if !ok {
-		logger.Warnf("%shandleDataFrame have connection[%s], but not have function", ServerLogPrefix, fromID)
+		c.Logger().Warnf("%shandleDataFrame have connection[%s], but not have function", ServerLogPrefix, fromID)
 		return nil
 	}
+	from := fromApp.Name()
+	fromApp.receivedData(receivedAt)
 
-	f := c.Frame.(*frame.DataFrame)
+	f := dataFrame(c)
+	// the carriage of the streaming frame is copied to the target unless it's buffered by
+	// the features need the whole frame
+	streaming := streamingFrame(c)
+	buffer := func() error {
+		if streaming == nil {
+			return nil
+		}
+		streaming = nil
+		_, err := bufferCarriage(c)
+		return err
+	}
 
-	// route
+	// issuer, the upstream zipper forwards the frames issued by others
+	if fromApp.ClientType() != ClientTypeUpstreamZipper {
+		if issuer := f.Issuer(); issuer == "" {
+			f.SetIssuer(from)
+		} else if issuer != from {
+			c.Logger().Warnf("%shandleDataFrame drop frame from [%s](%s), forged issuer: %s", ServerLogPrefix, from, fromID, issuer)
+			return nil
+		}
+	}
+
+	// transaction id
+	if validate := s.opts.TransactionIDValidator; validate != nil {
+		if err := validate(f.TransactionID()); err != nil {
+			atomic.AddInt64(&s.counterOfInvalidTransactionID, 1)
+			c.Logger().Warnf("%shandleDataFrame drop frame from [%s](%s), invalid transaction id: %v", ServerLogPrefix, from, fromID, err)
+			s.reject(c, frame.RejectedMessageInvalidTransactionID)
+			return nil
+		}
+	}
+
+	// hops, prevent the frame from being routed in a cycle forever
+	if hops := f.Hops(); hops >= s.opts.MaxHops {
+		atomic.AddInt64(&s.counterOfHopsExceeded, 1)
+		c.Logger().Warnf("%shandleDataFrame drop frame from [%s](%s), tid=%s, hops %d exceed the limit %d", ServerLogPrefix, from, fromID, f.TransactionID(), hops, s.opts.MaxHops)
+		return nil
+	}
+	f.SetHops(f.Hops() + 1)
+
+	// expiry, the stale frame is useless to the stream functions
+	if f.Expired(s.opts.Clock.Now()) {
+		atomic.AddInt64(&s.counterOfExpired, 1)
+		c.Logger().Debugf("%shandleDataFrame drop frame from [%s](%s), tid=%s, expired at %v", ServerLogPrefix, from, fromID, f.TransactionID(), f.ExpiresAt())
+		return nil
+	}
+
+	// fragments, the message is routed once it's reassembled
+	if s.reassembler != nil && f.Fragment() != nil {
+		if err := buffer(); err != nil {
+			return err
+		}
+		whole, ok := s.reassembler.add(c.ConnID, f, s.opts.Clock.Now())
+		if !ok {
+			return nil
+		}
+		f = whole
+	}
+
+	if s.frameFilter != nil || s.frameTransformer != nil || s.opts.ReplaySize > 0 || s.hasObservers() {
+		if err := buffer(); err != nil {
+			return err
+		}
+	}
+
+	// filter, once per frame regardless of the fan-out
+	if s.frameFilter != nil && !s.frameFilter(f) {
+		atomic.AddInt64(&s.counterOfFiltered, 1)
+		c.Logger().Debugf("%shandleDataFrame drop frame from [%s](%s), tid=%s, filtered", ServerLogPrefix, from, fromID, f.TransactionID())
+		return nil
+	}
+
+	// transform, the transformed frame is routed instead
+	if s.frameTransformer != nil {
+		tid := f.TransactionID()
+		transformed, err := s.frameTransformer(f)
+		if err != nil {
+			atomic.AddInt64(&s.counterOfTransformed, 1)
+			c.Logger().Errorf("%shandleDataFrame drop frame from [%s](%s), tid=%s, transform err=%v", ServerLogPrefix, from, fromID, tid, err)
+			return nil
+		}
+		if transformed == nil {
+			atomic.AddInt64(&s.counterOfTransformed, 1)
+			c.Logger().Debugf("%shandleDataFrame drop frame from [%s](%s), tid=%s, dropped by the transformer", ServerLogPrefix, from, fromID, tid)
+			return nil
+		}
+		f = transformed
+	}
+
+	// mirror, the observers receive the frames entering the routing
 	appID, _ := s.connector.AppID(fromID)
+	s.mirror(appID, f)
+
+	// route
 	cacheRoute, ok := s.opts.Store.Get(appID)
 	if !ok {
 		err := fmt.Errorf("get route failure, appID=%s, connID=%s", appID, fromID)
-		logger.Errorf("%shandleDataFrame %s", ServerLogPrefix, err.Error())
+		c.Logger().Errorf("%shandleDataFrame %s", ServerLogPrefix, err.Error())
 		return err
 	}
 	route := cacheRoute.(Route)
 	if route == nil {
-		logger.Warnf("%shandleDataFrame route is nil", ServerLogPrefix)
+		c.Logger().Warnf("%shandleDataFrame route is nil", ServerLogPrefix)
 		return fmt.Errorf("handleDataFrame route is nil")
 	}
 	// get stream function names from route
-	routes := route.GetForwardRoutes(from)
-	for _, to := range routes {
-		toIDs := s.connector.GetConnIDs(appID, to, f.GetDataTag())
-		for _, toID := range toIDs {
-			logger.Debugf("%shandleDataFrame tag=%#x tid=%s, counter=%d, from=[%s](%s), to=[%s](%s)", ServerLogPrefix, f.Tag(), f.TransactionID(), s.counterOfDataFrame, from, fromID, to, toID)
-
-			// write data frame to stream
-			logger.Infof("%swrite data: [%s](%s) --> [%s](%s)", ServerLogPrefix, from, fromID, to, toID)
-			if err := s.connector.Write(f, toID); err != nil {
-				logger.Errorf("%swrite data: [%s](%s) --> [%s](%s), err=%v", ServerLogPrefix, from, fromID, to, toID, err)
-				continue
+	routes := forwardRoutes(route, from, fromApp.ClientType())
+	// the output of the terminal stage
+	if len(routes) == 0 && fromApp.ClientType() == ClientTypeStreamFunction {
+		if createdAt := f.CreatedAt(); !createdAt.IsZero() {
+			s.deliveryAge.observe(s.opts.Clock.Now().Sub(createdAt))
+		}
+		if s.opts.TerminalSink != nil {
+			if err := buffer(); err != nil {
+				return err
 			}
+			if err := s.opts.TerminalSink.Write(f); err != nil {
+				c.Logger().Errorf("%swrite data: [%s](%s) --> terminal sink, err=%v", ServerLogPrefix, from, fromID, err)
+			}
+		}
+		return nil
+	}
+	// the workflow has no first stage for the source, e.g. it's reconfigured after the source
+	// connected, which is a misconfiguration rather than a disconnected stream function
+	if len(routes) == 0 {
+		atomic.AddInt64(&s.counterOfNoFirstStage, 1)
+		c.Logger().Warnf("%shandleDataFrame drop frame from [%s](%s), tid=%s, no first stage configured", ServerLogPrefix, from, fromID, f.TransactionID())
+		return nil
+	}
+	routedAt := s.opts.Clock.Now()
+	// the instances being drained wait until the frames routed to them are queued
+	gen := s.routing.enter()
+	size := len(f.GetCarriage())
+	if streaming != nil {
+		size = streaming.Size()
+	}
+	type target struct{ name, connID string }
+	var writes []target
+	sampled := false
+	for _, to := range routes {
+		if sm, ok := s.samplers[to]; ok && !sm.sample() {
+			sampled = true
+			continue
+		}
+		s.replay.record(appID, to, f)
+		for _, toID := range s.connector.GetConnIDsByKey(appID, to, f.GetDataTag(), size, s.routingKey(f)) {
+			writes = append(writes, target{name: to, connID: toID})
+		}
+	}
+	// no stage handles the frame, the sampled frames aren't unroutable
+	if len(writes) == 0 && !sampled && s.opts.DeadLetter != "" && !s.isDeadLetter(from) {
+		for _, toID := range s.deadLetters(appID, f, size) {
+			writes = append(writes, target{name: s.opts.DeadLetter, connID: toID})
+		}
+		if len(writes) > 0 {
+			atomic.AddInt64(&s.counterOfDeadLettered, 1)
+		} else {
+			c.Logger().Warnf("%shandleDataFrame drop frame from [%s](%s), tid=%s, no dead-letter stream function connected", ServerLogPrefix, from, fromID, f.TransactionID())
+		}
+	}
+	// the carriage is streamed to a single target, it's buffered to fan out
+	if len(writes) > 1 {
+		if err := buffer(); err != nil {
+			s.routing.leave(gen)
+			return err
+		}
+	}
+	var targets []string
+	failed := &FanoutError{TransactionID: f.TransactionID()}
+	for _, w := range writes {
+		to, toID := w.name, w.connID
+		c.Logger().Debugf("%shandleDataFrame tag=%#x tid=%s, counter=%d, from=[%s](%s), to=[%s](%s)", ServerLogPrefix, f.Tag(), f.TransactionID(), s.counterOfDataFrame, from, fromID, to, toID)
+
+		// write data frame to stream
+		c.Logger().Debugf("%swrite data: [%s](%s) --> [%s](%s)", ServerLogPrefix, from, fromID, to, toID)
+		var err error
+		if streaming != nil {
+			err = s.connector.WriteStream(f, streaming.Carriage(), size, toID)
+		} else {
+			err = s.connector.Write(f, toID)
+		}
+		if err == errWindowFull {
+			// dropped, the read loop of the sender isn't blocked by the stage
+			atomic.AddInt64(&s.counterOfStageFull, 1)
+			c.Logger().Debugf("%swrite data: [%s](%s) --> [%s](%s), dropped, the window is full", ServerLogPrefix, from, fromID, to, toID)
+			continue
 		}
+		if errors.Is(err, errFrameDropped) || errors.Is(err, errFrameOverflowed) || errors.Is(err, errFrameExpired) {
+			// counted by the send queue, the target is healthy
+			c.Logger().Debugf("%swrite data: [%s](%s) --> [%s](%s), dropped, %v", ServerLogPrefix, from, fromID, to, toID, err)
+			continue
+		}
+		if errors.Is(err, errCarriageRead) {
+			// the source failed, the target is healthy
+			atomic.AddInt64(&s.counterOfCarriageFailed, 1)
+			c.Logger().Warnf("%swrite data: [%s](%s) --> [%s](%s), dropped, %v", ServerLogPrefix, from, fromID, to, toID, err)
+			continue
+		}
+		if err != nil {
+			// the others are delivered anyway
+			c.Logger().Errorf("%swrite data: [%s](%s) --> [%s](%s), err=%v", ServerLogPrefix, from, fromID, to, toID, err)
+			failed.add(toID, err)
+			continue
+		}
+		s.functionStats.inc(to)
+		if s.dataFrameObserver != nil {
+			targets = append(targets, toID)
+		}
+	}
+	s.routing.leave(gen)
+	var fanoutErr error
+	if len(failed.Errors) > 0 {
+		fanoutErr = failed
+		atomic.AddInt64(&s.counterOfWriteFailed, int64(len(failed.Errors)))
+		c.Logger().Warnf("%shandleDataFrame from [%s](%s), %v", ServerLogPrefix, from, fromID, failed)
+		s.evictFailed(failed)
+	}
+	forwardedAt := s.opts.Clock.Now()
+	s.processingLatency.observe(forwardedAt.Sub(receivedAt))
+	if log.IsDebug(c.Logger()) {
+		c.Logger().Debugf("%shandleDataFrame tid=%s, received at %s, routed in %s, forwarded in %s", ServerLogPrefix, f.TransactionID(),
+			receivedAt.Format(time.RFC3339Nano), routedAt.Sub(receivedAt), forwardedAt.Sub(routedAt))
+	}
+	if s.dataFrameObserver != nil && (len(targets) > 0 || fanoutErr != nil) {
+		s.dataFrameObserver(DataFrameEvent{
+			TransactionID: f.TransactionID(),
+			Issuer:        f.Issuer(),
+			Tag:           f.GetDataTag(),
+			Size:          size,
+			Targets:       targets,
+			Err:           fanoutErr,
+		})
 	}
 	return nil
 }
 
-// StatsFunctions returns the sfn stats of server.
+// routingKey returns the key the target instance is picked by, the fragments of a message
+// go to the same instance, so do the frames of the same value of the RoutingMetadataKey, the
+// other frames are spread randomly.
+func (s *Server) routingKey(f *frame.DataFrame) string {
+	if fragment := f.Fragment(); fragment != nil {
+		return f.Issuer() + "\x00" + fragment.MessageID
+	}
+	if s.opts.RoutingMetadataKey == "" || len(f.Metadata()) == 0 {
+		return ""
+	}
+	md, err := s.Metadata(f)
+	if err != nil {
+		logger.Warnf("%sdecode the metadata error: %v, tid=%s", ServerLogPrefix, err, f.TransactionID())
+		return ""
+	}
+	return md[s.opts.RoutingMetadataKey]
+}
+
+// Metadata decodes the metadata of the DataFrame by the MetadataCodec, e.g. in the
+// TerminalSink.
+func (s *Server) Metadata(f *frame.DataFrame) (frame.Metadata, error) {
+	return s.opts.MetadataCodec.Decode(f.Metadata())
+}
+
+// StatsFragmentsExpired returns how many incomplete messages are dropped after the fragment
+// timeout, see WithFragmentReassembly.
+func (s *Server) StatsFragmentsExpired() int64 {
+	if s.reassembler == nil {
+		return 0
+	}
+	return s.reassembler.Expired()
+}
+
+// StatsFragmentsEvicted returns how many incomplete messages are dropped for the limits of
+// the reassembler, see WithFragmentLimits.
+func (s *Server) StatsFragmentsEvicted() int64 {
+	if s.reassembler == nil {
+		return 0
+	}
+	return s.reassembler.Evicted()
+}
+
+// handleEchoFrame writes the DataFrame back to the stream it's received from.
+func (s *Server) handleEchoFrame(c *Context) {
+	f := dataFrame(c)
+	c.Logger().Debugf("%secho tid=%s to (%s)", ServerLogPrefix, f.TransactionID(), c.ConnID)
+	if err := s.connector.Write(f, c.ConnID); err != nil {
+		c.Logger().Errorf("%secho tid=%s to (%s), err=%v", ServerLogPrefix, f.TransactionID(), c.ConnID, err)
+	}
+}
+
+// StatsFunctions returns the sfn stats of server. The snapshot includes the streams which are
+// registered but may be closed already, e.g. while the disconnect is being cleaned up, use
+// LiveFunctions for the readiness decisions.
 // func (s *Server) StatsFunctions() map[string][]*quic.Stream {
 func (s *Server) StatsFunctions() map[string]io.ReadWriteCloser {
 	return s.connector.GetSnapshot()
 }
 
+// LiveFunctions returns the snapshot of the streams which are usable when it's taken. A stream
+// is dropped from it as soon as a write to it fails or, for the QUIC streams, the stream or its
+// connection is closed, before the connection is deregistered. A live stream may still fail the
+// next write, the liveness only reflects what has been observed.
+func (s *Server) LiveFunctions() map[string]io.ReadWriteCloser {
+	result := s.connector.GetSnapshot()
+	for connID := range result {
+		if !s.connector.Live(connID) {
+			delete(result, connID)
+		}
+	}
+	return result
+}
+
 // StatsCounter returns how many DataFrames pass through server.
 func (s *Server) StatsCounter() int64 {
-	return s.counterOfDataFrame
+	return atomic.LoadInt64(&s.counterOfDataFrame)
+}
+
+// StatsHopsExceeded returns how many DataFrames are dropped for exceeding the max hops.
+func (s *Server) StatsHopsExceeded() int64 {
+	return atomic.LoadInt64(&s.counterOfHopsExceeded)
+}
+
+// StatsFrames returns the count and the size of the received frames per frame type.
+func (s *Server) StatsFrames() map[frame.Type]FrameStat {
+	return s.frameStats.snapshot()
+}
+
+// StatsProtocolViolation returns how many frames are sent by the clients which are not
+// allowed to send them.
+func (s *Server) StatsProtocolViolation() int64 {
+	return atomic.LoadInt64(&s.counterOfViolation)
+}
+
+// StatsAcceptErrors returns how many times accepting a connection failed transiently and
+// was retried.
+func (s *Server) StatsAcceptErrors() int64 {
+	return atomic.LoadInt64(&s.counterOfAcceptErrors)
+}
+
+// StatsDeliveryAge returns the histogram of the DataFrames' age when they're emitted by the
+// terminal stage, i.e. the end-to-end latency since the source created them.
+func (s *Server) StatsDeliveryAge() Histogram {
+	return s.deliveryAge.snapshot()
+}
+
+// StatsProcessingLatency returns the histogram of the time from receiving a DataFrame to
+// forwarding it to the stream functions, i.e. the latency induced by the server, the frames
+// dropped or not forwarded, e.g. the output of the terminal stage, are not observed.
+func (s *Server) StatsProcessingLatency() Histogram {
+	return s.processingLatency.snapshot()
+}
+
+// StatsReplayed returns how many DataFrames are replayed to the (re)connected stream functions.
+func (s *Server) StatsReplayed() int64 {
+	return atomic.LoadInt64(&s.counterOfReplayed)
+}
+
+// StatsCapacitySkipped returns how many times the DataFrames are not sent to a stream function
+// because the carriage exceeds the max payload size of all its instances.
+func (s *Server) StatsCapacitySkipped() int64 {
+	return s.connector.CapacitySkipped()
+}
+
+// StatsExpired returns how many DataFrames are dropped for their expiry, either when they're
+// received or while they're queued to the stream functions.
+func (s *Server) StatsExpired() int64 {
+	return atomic.LoadInt64(&s.counterOfExpired) + s.connector.Expired()
+}
+
+// StatsTransformDropped returns how many DataFrames are dropped by the frame transformer,
+// either it returns nil or an error.
+func (s *Server) StatsTransformDropped() int64 {
+	return atomic.LoadInt64(&s.counterOfTransformed)
+}
+
+// StatsConnectionChurn returns the number of the connects and the disconnects per client type.
+func (s *Server) StatsConnectionChurn() map[ClientType]ConnectionStat {
+	return s.connStats.snapshot()
+}
+
+// StatsNoFirstStage returns how many DataFrames of the sources are dropped because the workflow
+// has no first stage configured.
+func (s *Server) StatsNoFirstStage() int64 {
+	return atomic.LoadInt64(&s.counterOfNoFirstStage)
+}
+
+// StatsNoStream returns how many DataFrames are dropped because the target stream function
+// has no stream, e.g. it's disconnecting while the frame is being routed.
+func (s *Server) StatsNoStream() int64 {
+	return s.connector.NoStream()
+}
+
+// StatsBufferedBytes returns the approximate bytes of the frames held by the send queues, the
+// replay buffer and the reassembler.
+func (s *Server) StatsBufferedBytes() int64 {
+	return s.budget.Held()
+}
+
+// StatsOverBudget returns how many frames, or incomplete messages of the reassembler, are
+// evicted for exceeding the memory budget.
+func (s *Server) StatsOverBudget() int64 {
+	return s.budget.Dropped()
+}
+
+// StatsPaused returns how many DataFrames of the sources are rejected while the server is paused.
+func (s *Server) StatsPaused() int64 {
+	return atomic.LoadInt64(&s.counterOfPaused)
+}
+
+// StatsFiltered returns how many DataFrames are dropped by the frame filter.
+func (s *Server) StatsFiltered() int64 {
+	return atomic.LoadInt64(&s.counterOfFiltered)
+}
+
+// StartedAt returns the time the server started listening, zero if it hasn't started yet.
+func (s *Server) StartedAt() time.Time {
+	startedAt := atomic.LoadInt64(&s.startedAt)
+	if startedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, startedAt)
+}
+
+// Uptime returns how long the server has been listening, zero if it hasn't started yet.
+func (s *Server) Uptime() time.Duration {
+	startedAt := s.StartedAt()
+	if startedAt.IsZero() {
+		return 0
+	}
+	return s.opts.Clock.Now().Sub(startedAt)
+}
+
+// StatsInFlight returns the number of the DataFrames queued or being written to every stage,
+// see WithStageWindow.
+func (s *Server) StatsInFlight() map[string]int {
+	return s.connector.InFlight()
+}
+
+// StatsInstances returns the weight and the number of the DataFrames written to every
+// stream function instance, to verify the frames are distributed by the weights.
+func (s *Server) StatsInstances() []InstanceStat {
+	return s.connector.Instances()
+}
+
+// RegisteredFunctions returns the names of the connected stream functions, sorted and
+// deduplicated across the instances, e.g. for the readiness checks.
+func (s *Server) RegisteredFunctions() []string {
+	names := make([]string, 0)
+	for _, instance := range s.connector.Instances() {
+		// the instances are sorted by the name
+		if n := len(names); n == 0 || names[n-1] != instance.Name {
+			names = append(names, instance.Name)
+		}
+	}
+	return names
+}
+
+// StatsSampling returns the number of the DataFrames forwarded and dropped by sampling per stage,
+// the stages without sampling are absent.
+func (s *Server) StatsSampling() map[string]SamplingStat {
+	result := make(map[string]SamplingStat, len(s.samplers))
+	for stage, sm := range s.samplers {
+		result[stage] = sm.stat()
+	}
+	return result
+}
+
+// StatsQueueWait returns how long the DataFrames waited in the send queues per priority.
+func (s *Server) StatsQueueWait() map[frame.Priority]QueueWaitStat {
+	return s.connector.QueueWaitStats()
 }
 
 // Downstreams return all the downstream servers.
@@ -384,14 +1483,6 @@ func (s *Server) Downstreams() map[string]*Client {
 	return s.downstreams
 }
 
-// AddWorkflow register sfn to this server.
-// func (s *Server) AddWorkflow(wfs ...Workflow) error {
-// 	for _, wf := range wfs {
-// 		s.router.Add(wf.Seq, wf.Name)
-// 	}
-// 	return nil
-// }
-
 func (s *Server) ConfigRouter(router Router) error {
 	s.mu.Lock()
 	s.router = router
@@ -422,7 +1513,10 @@ func (s *Server) dispatchToDownstreams(df *frame.DataFrame) {
 	}
 }
 
-// GetConnID get quic connection id
+// GetConnID returns the remote address of the quic connection.
+//
+// Deprecated: the server registers the connections by the ids it generates when they're
+// accepted, see SessionInfo.ID.
 func GetConnID(conn quic.Connection) string {
 	return conn.RemoteAddr().String()
 }
@@ -433,10 +1527,41 @@ func (s *Server) initOptions() {
 	if s.opts.Store == nil {
 		s.opts.Store = store.NewMemoryStore()
 	}
+	// send queue
+	if s.opts.SendQueueSize == 0 {
+		s.opts.SendQueueSize = DefaultSendQueueSize
+	}
+	// drain
+	if s.opts.DrainQuietPeriod == 0 {
+		s.opts.DrainQuietPeriod = DefaultDrainQuietPeriod
+	}
+	// ack window
+	if s.opts.MaxAckWindow <= 0 {
+		s.opts.MaxAckWindow = DefaultMaxAckWindow
+	}
+	// hops
+	if s.opts.MaxHops == 0 {
+		s.opts.MaxHops = DefaultMaxHops
+	}
+	// frame size
+	if s.opts.MaxHandshakeFrameSize == 0 {
+		s.opts.MaxHandshakeFrameSize = DefaultMaxHandshakeFrameSize
+	}
+	// clock
+	if s.opts.Clock == nil {
+		s.opts.Clock = clock.New()
+	}
+	if s.opts.MetadataCodec == nil {
+		s.opts.MetadataCodec = frame.DefaultMetadataCodec
+	}
 	// auth
 	if s.opts.Auths == nil {
 		s.opts.Auths = append(s.opts.Auths, auth.NewAuthNone())
 	}
+	// registry
+	if s.opts.Registry == nil {
+		s.opts.Registry = nopRegistry{}
+	}
 }
 
 func (s *Server) validateRouter() error {
@@ -466,6 +1591,76 @@ func (s *Server) SetAfterHandlers(handlers ...FrameHandler) {
 	s.afterHandlers = append(s.afterHandlers, handlers...)
 }
 
+// SetFrameFilter sets the predicate consulted before forwarding every DataFrame,
+// the frames it returns false for are dropped. nil means no filtering.
+func (s *Server) SetFrameFilter(filter func(f *frame.DataFrame) bool) {
+	s.frameFilter = filter
+}
+
+// FrameTransformer rewrites a DataFrame before it's routed, it returns the frame to route,
+// which may be the same frame modified in place, nil to drop it, or an error to log and drop it.
+type FrameTransformer func(f *frame.DataFrame) (*frame.DataFrame, error)
+
+// SetFrameTransformer sets the transformer invoked once per DataFrame after the frame filter
+// and before routing, e.g. strips the PII from the carriage. nil means the frames pass through
+// as they are. It runs on the data path of every frame, so it must be lightweight, the heavy
+// transforms belong in the stream functions.
+func (s *Server) SetFrameTransformer(transformer FrameTransformer) {
+	s.frameTransformer = transformer
+}
+
+// SetEchoMode sets whether the server echoes every DataFrame back to the stream it's received
+// from instead of routing it by the workflow. It's for diagnostics only, e.g. a source verifies
+// the connectivity and measures the RTT without any stream function, don't enable it in
+// production as no frame is delivered to the workflow.
+func (s *Server) SetEchoMode(enabled bool) {
+	if enabled {
+		logger.Warnf("%s[%s] echo mode is enabled, the DataFrames are echoed back instead of being routed", ServerLogPrefix, s.name)
+		atomic.StoreInt32(&s.echo, 1)
+		return
+	}
+	atomic.StoreInt32(&s.echo, 0)
+}
+
+// Pause rejects the DataFrames of the sources with a "paused" RejectedFrame until Resume, e.g.
+// while the workflow is being reconfigured. The sources and the stream functions stay connected,
+// the frames accepted before keep flowing through the stream functions, so the pipeline quiesces.
+func (s *Server) Pause() {
+	if atomic.CompareAndSwapInt32(&s.paused, 0, 1) {
+		logger.Printf("%s[%s] paused, the DataFrames of the sources are rejected", ServerLogPrefix, s.name)
+	}
+}
+
+// Resume accepts the DataFrames of the sources again after Pause.
+func (s *Server) Resume() {
+	if atomic.CompareAndSwapInt32(&s.paused, 1, 0) {
+		logger.Printf("%s[%s] resumed", ServerLogPrefix, s.name)
+	}
+}
+
+// Paused reports whether the server is paused by Pause.
+func (s *Server) Paused() bool {
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
+// OnDataFrame sets the observer invoked once per DataFrame after it's routed, it can't
+// alter the routing. The observer runs on the data path, it must be fast or dispatch
+// the event to its own goroutine or queue.
+func (s *Server) OnDataFrame(observer DataFrameObserver) {
+	s.dataFrameObserver = observer
+}
+
+// SetConnectHandler sets the handler invoked when an app connected, i.e. its handshake
+// succeeded.
+func (s *Server) SetConnectHandler(handler ConnectHandler) {
+	s.connectHandler = handler
+}
+
+// SetDisconnectHandler sets the handler invoked when the connection of an app ended.
+func (s *Server) SetDisconnectHandler(handler DisconnectHandler) {
+	s.disconnectHandler = handler
+}
+
 func (s *Server) authNames() []string {
 	result := []string{}
 	for _, auth := range s.opts.Auths {
@@ -474,18 +1669,45 @@ func (s *Server) authNames() []string {
 	return result
 }
 
-func (s *Server) authenticate(f *frame.HandshakeFrame) bool {
+// authenticate returns the context enriched by the authentication which accepts the handshake.
+func (s *Server) authenticate(ctx context.Context, f *frame.HandshakeFrame) (context.Context, bool) {
 	if len(s.opts.Auths) > 0 {
-		for _, auth := range s.opts.Auths {
-			isAuthenticated := auth.Authenticate(f)
+		for _, a := range s.opts.Auths {
+			authCtx, isAuthenticated := ctx, false
+			if ca, ok := a.(auth.ContextAuthentication); ok {
+				authCtx, isAuthenticated = ca.AuthenticateContext(ctx, f)
+			} else {
+				isAuthenticated = a.Authenticate(f)
+			}
+			if isAuthenticated && authCtx != nil {
+				// the credential of another tenant is refused
+				want, bound := auth.TenantFromContext(ctx)
+				if tenant, _ := auth.TenantFromContext(authCtx); bound && tenant != want {
+					isAuthenticated = false
+				}
+			}
 			if isAuthenticated {
-				logger.Debugf("%sauthenticate: [%s]=%v", ServerLogPrefix, auth.Type(), isAuthenticated)
-				return isAuthenticated
+				if logger.IsDebug() {
+					logger.Debugf("%sauthenticate: [%s]=%v", ServerLogPrefix, a.Type(), isAuthenticated)
+				}
+				if authCtx == nil {
+					authCtx = ctx
+				}
+				return authCtx, isAuthenticated
 			}
 		}
-		return false
+		return ctx, false
 	}
-	return true
+	return ctx, true
+}
+
+// isNilRoute reports whether the route is nil or a typed nil pointer.
+func isNilRoute(route Route) bool {
+	if route == nil {
+		return true
+	}
+	v := reflect.ValueOf(route)
+	return v.Kind() == reflect.Ptr && v.IsNil()
 }
 
 func mode() string {
@@ -2,11 +2,16 @@ package core
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
 	"github.com/yomorun/yomo/core/store"
+	pkgtls "github.com/yomorun/yomo/pkg/tls"
 )
 
 type ServerOptions struct {
@@ -16,6 +21,114 @@ type ServerOptions struct {
 	Auths      []auth.Authentication
 	Store      store.Store
 	Conn       net.PacketConn
+	// Profile overrides the stream limits and receive windows of the QuicConfig.
+	Profile *Profile
+	// Versions overrides the QUIC versions of the QuicConfig, nil keeps them.
+	Versions []quic.VersionNumber
+	// TransactionIDValidator validates the transaction id of every DataFrame,
+	// the frames fail the validation will be dropped. nil means no validation.
+	TransactionIDValidator TransactionIDValidator
+	// VerifyPeerCertificate is called during the TLS handshake after the normal
+	// certificate verification, an error aborts the QUIC handshake.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	// CertOptions are the parameters of the certificate generated without the TLSConfig in
+	// the development mode, the zero fields fall back to the environment variables.
+	CertOptions pkgtls.CertOptions
+	// SessionTicketKeys are the keys of the TLS session tickets shared by the instances,
+	// the first one encrypts the new tickets. nil means the random keys per instance.
+	SessionTicketKeys [][32]byte
+	// StreamWriteBufferSize is the size of the write buffer of every target stream, the small
+	// frames are coalesced in it before flushing. 0 means every frame is written directly.
+	StreamWriteBufferSize int
+	// SendQueueSize is the max number of the DataFrames queued per connection, the oldest of
+	// the lowest priority are dropped beyond it. 0 means DefaultSendQueueSize, a negative size
+	// means unlimited.
+	SendQueueSize int
+	// DrainQuietPeriod is how long a stream function sends no DataFrame for after the frames
+	// queued to it are written, before it's considered drained. 0 means
+	// DefaultDrainQuietPeriod, a negative period means it isn't waited.
+	DrainQuietPeriod time.Duration
+	// ReplaySize is the number of the last frames retained per stage, which are replayed to
+	// a (re)connected stream function of the stage. 0 means no replay.
+	ReplaySize int
+	// CloseOnProtocolViolation closes the stream when a client sends a frame its role
+	// is not allowed to send, otherwise the frame is dropped.
+	CloseOnProtocolViolation bool
+	// TerminalSink receives the DataFrames emitted by the terminal stage of the workflow.
+	TerminalSink TerminalSink
+	// MaxHandshakeFrameSize is the max size of the frames read before the connection is
+	// authenticated, default is DefaultMaxHandshakeFrameSize.
+	MaxHandshakeFrameSize int
+	// MaxFrameSize is the max size of the frames read after the connection is authenticated,
+	// 0 means unlimited.
+	MaxFrameSize int
+	// StreamThreshold is the size of the DataFrames whose carriages are streamed to the stream
+	// functions rather than buffered, 0 means never.
+	StreamThreshold int
+	// MaxHops is the max number of times a DataFrame can be forwarded, the frames
+	// exceed it will be dropped to prevent routing loops. default is DefaultMaxHops.
+	MaxHops uint32
+	// MaxSessions limits the number of the concurrent session handlers, 0 means unlimited.
+	MaxSessions int
+	// SessionPoolPolicy decides what to do with the excess sessions when MaxSessions is reached.
+	SessionPoolPolicy SessionPoolPolicy
+	// MaxSessionsPerIP limits the number of the concurrent sessions of a remote IP, the excess
+	// connections are rejected, 0 means unlimited.
+	MaxSessionsPerIP int
+	// StageWindows is the max number of the in-flight DataFrames per stream function
+	// instance of every stage, the stages not in it are unlimited.
+	StageWindows map[string]int
+	// SampleRates forwards 1 in every N DataFrames to the stages in it, e.g. a debug stream
+	// function, the other stages receive all the frames.
+	SampleRates map[string]int
+	// PingInterval is the interval the server pings the stream functions, 0 means disabled.
+	PingInterval time.Duration
+	// PingTimeout is how long the server waits for the PongFrame before evicting the
+	// stream function, default is PingInterval.
+	PingTimeout time.Duration
+	// FragmentTimeout enables reassembling the fragments of a message before routing it, the
+	// incomplete messages are dropped after it. 0 means the fragments are routed as they are.
+	FragmentTimeout time.Duration
+	// MaxPartialMessages and MaxPartialMessagesPerConn bound the incomplete messages of the
+	// reassembler in total and per connection, 0 means the defaults, negative means unlimited.
+	MaxPartialMessages        int
+	MaxPartialMessagesPerConn int
+	// ReadTimeout closes the stream which hasn't received any frame for it, so a half-open
+	// connection is detected before the idle timeout of QUIC, 0 means disabled.
+	ReadTimeout time.Duration
+	// HeartbeatMin and HeartbeatMax bound the heartbeat interval the clients negotiate at the
+	// handshake, 0 HeartbeatMax means the heartbeat isn't negotiated.
+	HeartbeatMin time.Duration
+	HeartbeatMax time.Duration
+	// MemoryBudget is the max approximate bytes of the frames held by the buffers, the oldest
+	// frames are dropped once it's exceeded, 0 means unlimited.
+	MemoryBudget int64
+	// DeadLetter is the name of the stream function the unroutable DataFrames are forwarded to,
+	// empty means they are dropped.
+	DeadLetter string
+	// MaxAckWindow caps the ack window the clients request at the handshake, the receivers of
+	// the transaction ids are sized by it. 0 or a negative size means DefaultMaxAckWindow.
+	MaxAckWindow int
+	// MaxOpenTransactions is the max number of the transactions a source has open at once, the
+	// DataFrames of its new transactions are rejected beyond it, 0 means unlimited.
+	MaxOpenTransactions int
+	// SNITenants maps the server names indicated by the clients in the TLS handshake to the
+	// app ids of the tenants, see WithSNITenants.
+	SNITenants map[string]string
+	// Registry mirrors the connected stream functions into a service discovery backend,
+	// default does nothing.
+	Registry Registry
+	// Clock is the source of time of the timing-dependent features, default is the real clock.
+	Clock clock.Clock
+	// MetadataCodec decodes the metadata of the DataFrames when the server needs it, e.g. by
+	// RoutingMetadataKey, default is frame.DefaultMetadataCodec.
+	MetadataCodec frame.MetadataCodec
+	// RoutingMetadataKey is the metadata key the stream function instance is picked by, the
+	// DataFrames of the same value go to the same instance. Empty means the metadata is opaque.
+	RoutingMetadataKey string
+	// Compression accepts ALPNDeflate by the TLS handshake, the streams of the clients offer
+	// it are compressed.
+	Compression bool
 }
 
 func WithAddr(addr string) ServerOption {
@@ -53,3 +166,358 @@ func WithConn(conn net.PacketConn) ServerOption {
 		o.Conn = conn
 	}
 }
+
+// WithTransactionIDValidator sets the validator of DataFrame's transaction id.
+func WithTransactionIDValidator(v TransactionIDValidator) ServerOption {
+	return func(o *ServerOptions) {
+		o.TransactionIDValidator = v
+	}
+}
+
+// WithRequireTransactionID rejects the DataFrames without transaction id by the RejectedFrame
+// with frame.RejectedMessageInvalidTransactionID, see DropStats.InvalidTransactionID.
+func WithRequireTransactionID() ServerOption {
+	return WithTransactionIDValidator(RequireTransactionID)
+}
+
+// WithServerClock sets the clock of the server, tests use it to control the time.
+func WithServerClock(c clock.Clock) ServerOption {
+	return func(o *ServerOptions) {
+		o.Clock = c
+	}
+}
+
+// WithServerProfile sets the deployment profile, e.g. ProfileEdge for the devices with
+// limited memory, which overrides the stream limits and receive windows of the quic
+// config together. A custom Profile overrides its non-zero fields only.
+func WithServerProfile(p Profile) ServerOption {
+	return func(o *ServerOptions) {
+		o.Profile = &p
+	}
+}
+
+// WithServerVersions sets the QUIC versions the server advertises, e.g. a draft version for
+// the interop testing, default is quic.Version1 and quic.VersionDraft29. The list must not
+// be empty, the server fails to listen with ErrNoQuicVersions otherwise.
+func WithServerVersions(versions ...quic.VersionNumber) ServerOption {
+	return func(o *ServerOptions) {
+		o.Versions = append([]quic.VersionNumber{}, versions...)
+	}
+}
+
+// WithMaxHandshakeFrameSize sets the max size of the frames read before the connection is
+// authenticated, which is much smaller than the data frames, so the unauthenticated clients
+// can't exhaust the memory by a huge handshake.
+func WithMaxHandshakeFrameSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxHandshakeFrameSize = size
+	}
+}
+
+// WithMaxFrameSize sets the max size of the frames read after the connection is authenticated.
+func WithMaxFrameSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxFrameSize = size
+	}
+}
+
+// WithStreamThreshold streams the carriages of the DataFrames larger than size to the stream
+// functions without buffering them, e.g. the big blobs. A frame is still buffered if it's
+// fanned out to more than one connection, or if it's needed as a whole, e.g. by the frame
+// filter, the transformer, the observers, the replay buffer, the terminal sink, the echo mode
+// or the downstream zippers. 0 means never.
+func WithStreamThreshold(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.StreamThreshold = size
+	}
+}
+
+// WithStageWindow caps the number of the in-flight DataFrames, i.e. queued or being written,
+// per stream function instance of the stage, the stream functions don't acknowledge the
+// DataFrames, so a frame is out of flight once it's written to the stream. The frames not
+// pinned to an instance by a routing key go to the instances whose windows aren't full. When
+// the windows of all the instances are full, the DataFrames of the sources are rejected
+// before they're acknowledged, so the sources with the ack window retransmit them later, see
+// WithAckWindow, and the frames of the other senders are dropped. Either way the reading of
+// the senders isn't blocked, see DropStats.StageFull.
+func WithStageWindow(name string, window int) ServerOption {
+	return func(o *ServerOptions) {
+		if o.StageWindows == nil {
+			o.StageWindows = make(map[string]int)
+		}
+		o.StageWindows[name] = window
+	}
+}
+
+// WithStageSampling forwards 1 in every n DataFrames to the stage, e.g. 100 for a 1% sample
+// of the traffic to a debug stream function, so it sees a representative subset instead of
+// the full firehose. The other stages are not affected.
+func WithStageSampling(name string, n int) ServerOption {
+	return func(o *ServerOptions) {
+		if o.SampleRates == nil {
+			o.SampleRates = make(map[string]int)
+		}
+		o.SampleRates[name] = n
+	}
+}
+
+// WithPing pings every registered stream function at the interval, the ones don't respond
+// a PongFrame within the timeout are evicted, it catches the stream functions which are
+// alive on QUIC but dead on the application, e.g. a stuck goroutine. Disabled by default.
+func WithPing(interval time.Duration, timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.PingInterval = interval
+		o.PingTimeout = timeout
+	}
+}
+
+// WithVerifyPeerCertificate sets the custom certificate verification of the server's
+// tls.Config, e.g. checking the client certificates against a CRL or OCSP.
+// It runs during the TLS handshake before any YoMo frame is processed, so it's the
+// earliest auth hook available. The callback is not invoked if the client sends no
+// certificate, unless the tls.Config requires one.
+func WithVerifyPeerCertificate(fn func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error) ServerOption {
+	return func(o *ServerOptions) {
+		o.VerifyPeerCertificate = fn
+	}
+}
+
+// WithCertOptions sets the organization, the extra SANs, the validity and the key algorithm of
+// the certificate generated in the development mode, they take precedence over the environment
+// variables, e.g. `YOMO_TLS_CERT_SANS`. It's ignored if the TLSConfig is set.
+func WithCertOptions(opts pkgtls.CertOptions) ServerOption {
+	return func(o *ServerOptions) {
+		o.CertOptions = opts
+	}
+}
+
+// WithSessionTicketKeys sets the TLS session ticket keys of the server, the instances
+// behind a load balancer share the same keys to resume the sessions and accept 0-RTT
+// of each other. The first key encrypts the new tickets, all the keys decrypt them.
+//
+// Anyone has the keys can decrypt the tickets, and the traffic protected by them, so the
+// keys must be random, kept secret like the private key, and rotated regularly: deploy the
+// new key as the first one, keep the previous keys after it until the tickets issued by
+// them expire, then drop them. Without this option, every instance generates its own
+// random keys, which is the safest choice if the resumption across instances is not needed.
+func WithSessionTicketKeys(keys ...[32]byte) ServerOption {
+	return func(o *ServerOptions) {
+		o.SessionTicketKeys = keys
+	}
+}
+
+// WithStreamWriteBufferSize sets the size of the write buffer of every target stream.
+// The QUIC stream send window is decided by the flow control of the receiver, it can be
+// tuned by InitialStreamReceiveWindow and MaxStreamReceiveWindow of the quic.Config of
+// both sides, see WithServerQuicConfig.
+func WithStreamWriteBufferSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.StreamWriteBufferSize = size
+	}
+}
+
+// WithMaxAckWindow caps the ack window the clients request at the handshake, since the server
+// remembers the transaction ids of twice the window per client. The larger windows are
+// clamped to it, the retransmitted frames beyond it may be routed twice.
+func WithMaxAckWindow(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxAckWindow = size
+	}
+}
+
+// WithSendQueueSize sets the max number of the DataFrames queued per connection, so a slow
+// target can't hold the frames without a bound. Once it's full, the oldest frame of the lowest
+// priority is dropped, see DropStats.QueueOverflow. A negative size means unlimited.
+func WithSendQueueSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.SendQueueSize = size
+	}
+}
+
+// WithDrainQuietPeriod sets how long a stream function must send no DataFrame for, after the
+// frames queued to it are written, before it's considered drained by DrainFunction or
+// Shutdown. The stream functions don't ack the frames, so the period must cover the time
+// they take to process a frame. A negative period means only the queues are waited.
+func WithDrainQuietPeriod(period time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.DrainQuietPeriod = period
+	}
+}
+
+// WithReplay retains the last n frames routed to every stage, and replays them to the
+// stream function connected to the stage in the original order. It's for the idempotent
+// stream functions, which can process the same frame twice.
+func WithReplay(n int) ServerOption {
+	return func(o *ServerOptions) {
+		o.ReplaySize = n
+	}
+}
+
+// WithCloseOnProtocolViolation closes the stream of the client which sends a frame its
+// role is not allowed to send, e.g. a registered sfn sends a second handshake. By default
+// the frame is dropped only. See ClientType.CanSend for the frames allowed per role.
+func WithCloseOnProtocolViolation() ServerOption {
+	return func(o *ServerOptions) {
+		o.CloseOnProtocolViolation = true
+	}
+}
+
+// WithTerminalSink writes the DataFrames emitted by the terminal stage of the workflow to
+// the sink instead of dropping them, e.g. sink.NewFileSink.
+func WithTerminalSink(sink TerminalSink) ServerOption {
+	return func(o *ServerOptions) {
+		o.TerminalSink = sink
+	}
+}
+
+// WithDeadLetter forwards the DataFrames which no stage of the workflow handles, e.g. their
+// data tag isn't observed by any connected stream function, to the dead-letter stream function
+// of the name instead of dropping them, so they can be inspected. The dead-letter stream
+// function receives them whatever data tags it observes, it doesn't have to be in the workflow.
+// The frames it issues are never dead-lettered again, see Server.StatsDeadLettered.
+func WithDeadLetter(name string) ServerOption {
+	return func(o *ServerOptions) {
+		o.DeadLetter = name
+	}
+}
+
+// WithMaxHops sets the max number of times a DataFrame can be forwarded.
+func WithMaxHops(max uint32) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxHops = max
+	}
+}
+
+// WithMaxSessions limits the number of the concurrent session handlers, the excess
+// sessions are queued or rejected according to the policy.
+func WithMaxSessions(max int, policy SessionPoolPolicy) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxSessions = max
+		o.SessionPoolPolicy = policy
+	}
+}
+
+// WithMemoryBudget limits the approximate bytes of the frames held by the send queues, the
+// replay buffer and the reassembler together, so a slow or adversarial consumer can't exhaust
+// the memory. Once it's exceeded, the oldest frames of the replay buffer and the oldest
+// incomplete messages of the reassembler are evicted first, then the oldest frames of the
+// lowest priority in the send queue being pushed to, see StatsOverBudget.
+func WithMemoryBudget(bytes int64) ServerOption {
+	return func(o *ServerOptions) {
+		o.MemoryBudget = bytes
+	}
+}
+
+// WithSNITenants binds the connections to the tenants by the server name indicated in the TLS
+// handshake, e.g. every customer has its own hostname pointing at the same zipper. The TLS
+// handshake of a server name not in the tenants fails, the empty name is for the clients
+// which don't indicate one. The app id of the YoMo handshake is replaced by the tenant's, so
+// the session is routed by the tenant's workflow, the handshake carrying the app id of the
+// other tenant is rejected.
+//
+// The server name only selects the tenant, any client may indicate it, so it doesn't
+// authenticate the client. The tenant is passed to the auth.ContextAuthentication by
+// auth.WithTenant to check the credential against it, see WithAuth.
+func WithSNITenants(tenants map[string]string) ServerOption {
+	return func(o *ServerOptions) {
+		o.SNITenants = tenants
+	}
+}
+
+// WithMaxOpenTransactions limits the transactions a source has open at once, so a source which
+// opens the transactions and never finishes them can't exhaust the zipper. A transaction is open
+// while any of its DataFrames is being routed or queued to a target, the fragments of a message
+// are one transaction. The DataFrames of the new transactions beyond it are rejected by the
+// RejectedFrame with frame.RejectedMessageTooManyTransactions, see Server.OpenTransactions.
+func WithMaxOpenTransactions(max int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxOpenTransactions = max
+	}
+}
+
+// WithRegistry sets the registry the connected stream function instances are registered into,
+// e.g. registry.NewConsul, and deregistered from once they disconnect. The backend is updated
+// in the background, its failures are logged and never affect the connections.
+func WithRegistry(registry Registry) ServerOption {
+	return func(o *ServerOptions) {
+		o.Registry = registry
+	}
+}
+
+// WithFragmentReassembly reassembles the fragments of a message, see SplitDataFrame, before
+// routing it to the stream functions, the messages not completed in the timeout are dropped.
+// Without it the fragments are routed as they are, and the fragments of the same message go
+// to the same stream function instance.
+func WithFragmentReassembly(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.FragmentTimeout = timeout
+	}
+}
+
+// WithFragmentLimits bounds the incomplete messages the reassembler holds in total and per
+// connection, see WithFragmentReassembly. The oldest incomplete message, of the connection
+// for the per connection limit, is evicted to make room for a new one. 0 means the defaults,
+// DefaultMaxPartialMessages and DefaultMaxPartialMessagesPerConn, negative means unlimited.
+func WithFragmentLimits(total int, perConn int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxPartialMessages = total
+		o.MaxPartialMessagesPerConn = perConn
+	}
+}
+
+// WithReadTimeout closes the stream which hasn't received any frame for the timeout, the
+// timeout is reset by every frame, so it must be longer than the interval of the slowest
+// client, e.g. the ping interval of the idle stream functions. 0 means disabled.
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.ReadTimeout = timeout
+	}
+}
+
+// WithHeartbeatBounds lets the clients negotiate the heartbeat interval at the handshake, e.g.
+// the low-power devices ping the server every minute instead of the QUIC keep-alive. The
+// requested interval is bounded by min and max, the connection is closed once it misses 3
+// heartbeats. The QUIC keep-alive of the server is disabled and the idle timeout covers 3
+// max intervals, so the clients without the heartbeat must keep the connection alive.
+func WithHeartbeatBounds(min time.Duration, max time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.HeartbeatMin = min
+		o.HeartbeatMax = max
+	}
+}
+
+// WithMaxSessionsPerIP limits the number of the concurrent sessions of a remote IP,
+// so a single host can't take up all the sessions, 0 means unlimited.
+func WithMaxSessionsPerIP(max int) ServerOption {
+	return func(o *ServerOptions) {
+		o.MaxSessionsPerIP = max
+	}
+}
+
+// WithMetadataCodec sets the codec of the metadata of the DataFrames, it must be the codec of
+// the sources. The metadata is forwarded as it is, it's decoded only for RoutingMetadataKey and
+// by Server.Metadata, e.g. in the TerminalSink.
+func WithMetadataCodec(codec frame.MetadataCodec) ServerOption {
+	return func(o *ServerOptions) {
+		o.MetadataCodec = codec
+	}
+}
+
+// WithRoutingMetadataKey routes the DataFrames of the same value of the metadata key to the
+// same stream function instance of a stage, the frames without the key are spread by the
+// weights. The metadata is decoded by the MetadataCodec.
+func WithRoutingMetadataKey(key string) ServerOption {
+	return func(o *ServerOptions) {
+		o.RoutingMetadataKey = key
+	}
+}
+
+// WithServerCompression compresses the connections of the clients which enable the compression
+// too, it's negotiated by the ALPN of the TLS handshake, the other connections are uncompressed.
+// The frames are decompressed when they're read, so they're forwarded between the compressed
+// and the uncompressed connections as they are.
+func WithServerCompression() ServerOption {
+	return func(o *ServerOptions) {
+		o.Compression = true
+	}
+}
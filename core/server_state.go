@@ -0,0 +1,60 @@
+package core
+
+import "github.com/yomorun/yomo/pkg/logger"
+
+// ServerState describes the lifecycle state of the server.
+type ServerState = string
+
+// The states of the server, the server only moves forward in this order.
+const (
+	ServerStateReady     ServerState = "Ready"
+	ServerStateStarting  ServerState = "Starting"
+	ServerStateListening ServerState = "Listening"
+	ServerStateDraining  ServerState = "Draining"
+	ServerStateClosed    ServerState = "Closed"
+)
+
+var serverStateOrder = map[ServerState]int{
+	ServerStateReady:     0,
+	ServerStateStarting:  1,
+	ServerStateListening: 2,
+	ServerStateDraining:  3,
+	ServerStateClosed:    4,
+}
+
+// StateChangeHandler is invoked when the state of the server changed.
+type StateChangeHandler func(from ServerState, to ServerState)
+
+// State returns the current state of the server.
+func (s *Server) State() ServerState {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+// SetStateChangeHandler sets the handler invoked when the state of the server changed.
+func (s *Server) SetStateChangeHandler(handler StateChangeHandler) {
+	s.stateMu.Lock()
+	s.stateHandler = handler
+	s.stateMu.Unlock()
+}
+
+// setState moves the server to the state, returns false if the transition is not
+// allowed, i.e. the state is not after the current one.
+func (s *Server) setState(state ServerState) bool {
+	s.stateMu.Lock()
+	from := s.state
+	if serverStateOrder[state] <= serverStateOrder[from] {
+		s.stateMu.Unlock()
+		return false
+	}
+	s.state = state
+	handler := s.stateHandler
+	s.stateMu.Unlock()
+
+	logger.Debugf("%s[%s] state: %s -> %s", ServerLogPrefix, s.name, from, state)
+	if handler != nil {
+		handler(from, state)
+	}
+	return true
+}
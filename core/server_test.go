@@ -0,0 +1,2275 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/core/log"
+	"github.com/yomorun/yomo/pkg/logger"
+	pkgtls "github.com/yomorun/yomo/pkg/tls"
+)
+
+// mockStream is an in-memory stream, it reads from r and writes to w.
+type mockStream struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (m *mockStream) Read(p []byte) (int, error) {
+	return m.r.Read(p)
+}
+
+func (m *mockStream) Write(p []byte) (int, error) {
+	return m.w.Write(p)
+}
+
+func (m *mockStream) Close() error {
+	return nil
+}
+
+// syncBuffer is a goroutine-safe bytes.Buffer.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Frames parses all the frames written to the buffer.
+func (b *syncBuffer) Frames() []frame.Frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	frames := []frame.Frame{}
+	r := bytes.NewReader(b.buf.Bytes())
+	for {
+		f, err := ParseFrame(r)
+		if err != nil {
+			return frames
+		}
+		frames = append(frames, f)
+	}
+}
+
+// encodeFrames concatenates the encoded frames.
+func encodeFrames(frames ...frame.Frame) []byte {
+	buf := []byte{}
+	for _, f := range frames {
+		buf = append(buf, f.Encode()...)
+	}
+	return buf
+}
+
+// connectSfn registers a sfn on the server, returns the buffer receives the frames written to it.
+func connectSfn(s *Server, connID string, name string, tags ...byte) *syncBuffer {
+	return connectHandshake(s, connID, frame.NewHandshakeFrame(name, byte(ClientTypeStreamFunction), tags, "", 0, nil))
+}
+
+// connectHandshake registers a client with the handshake frame.
+func connectHandshake(s *Server, connID string, handshake *frame.HandshakeFrame) *syncBuffer {
+	out := &syncBuffer{}
+	// the sfn stream keeps open after handshake
+	r, w := io.Pipe()
+	go w.Write(handshake.Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: connID, Stream: &mockStream{r: r, w: out}})
+	waitFor(func() bool { return s.connector.Get(connID) != nil })
+	return out
+}
+
+// waitFor waits until the condition is true or timeout.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func newDataFrame(tid string, issuer string, tag byte) *frame.DataFrame {
+	f := frame.NewDataFrame()
+	f.SetTransactionID(tid)
+	f.SetIssuer(issuer)
+	f.SetCarriage(tag, []byte(tid))
+	return f
+}
+
+// testRouter routes all the apps through the same sfn names.
+type testRouter struct {
+	names []string
+}
+
+func (r *testRouter) Route(appID string) Route {
+	route := &testRoute{}
+	for i, name := range r.names {
+		route.Add(i, name)
+	}
+	return route
+}
+
+func (r *testRouter) Clean() {}
+
+type testRoute struct {
+	names []string
+}
+
+func (r *testRoute) Add(index int, name string) {
+	r.names = append(r.names, name)
+}
+
+func (r *testRoute) GetForwardRoutes(current string) []string {
+	for i, name := range r.names {
+		if name == current {
+			return r.names[i+1:]
+		}
+	}
+	return r.names
+}
+
+func (r *testRoute) Exists(name string) bool {
+	for _, n := range r.names {
+		if MatchName(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestServer(names ...string) *Server {
+	s := NewServer("test-zipper")
+	s.ConfigRouter(&testRouter{names: names})
+	return s
+}
+
+// debugLogger records the debug messages, it's at DebugLevel regardless of the default logger.
+type debugLogger struct {
+	log.Logger
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *debugLogger) Enabled(level log.Level) bool { return true }
+
+func (l *debugLogger) Debugf(template string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(template, args...))
+}
+
+func TestHandleHandshakeFrameSessionDebug(t *testing.T) {
+	s := newTestServer("sfn-1")
+	l := &debugLogger{Logger: logger.Default()}
+	c := &Context{ConnID: "sfn-conn", Stream: &mockStream{w: ioutil.Discard}, logger: l}
+	c.WithFrame(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil))
+	assert.NoError(t, s.handleHandshakeFrame(c))
+
+	// the session logger at DebugLevel logs the handshake whatever the level of the default one
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if assert.NotEmpty(t, l.messages) {
+		assert.Contains(t, l.messages[0], "HandshakeFrame")
+	}
+}
+
+func BenchmarkHandshake(b *testing.B) {
+	s := newTestServer("sfn-1")
+	buf := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream := &mockStream{r: bytes.NewReader(buf), w: ioutil.Discard}
+		c := &Context{ConnID: "conn", Stream: stream}
+		s.handleConnection(context.Background(), c)
+	}
+}
+
+// countWriter counts the frames written by the unbuffered send queue drain, which writes
+// a frame at a time.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&w.n, 1)
+	return len(p), nil
+}
+
+// BenchmarkDataFrameThroughput drives the DataFrames of a source through the server to the
+// mock stream functions end to end, it's the baseline of the routing changes.
+func BenchmarkDataFrameThroughput(b *testing.B) {
+	cases := []struct {
+		name      string
+		workflow  []string
+		instances []string // the name of every stream function instance
+	}{
+		// every frame is forwarded to all the stages
+		{name: "fan-out", workflow: []string{"sfn-1", "sfn-2", "sfn-3"}, instances: []string{"sfn-1", "sfn-2", "sfn-3"}},
+		// every frame is forwarded to one of the instances
+		{name: "round-robin", workflow: []string{"sfn-1"}, instances: []string{"sfn-1", "sfn-1", "sfn-1"}},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			s := newTestServer(tc.workflow...)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			counters := make([]*countWriter, len(tc.instances))
+			for i, name := range tc.instances {
+				counters[i] = &countWriter{}
+				connID := fmt.Sprintf("%s-%d", name, i)
+				r, w := io.Pipe()
+				defer w.Close()
+				go w.Write(frame.NewHandshakeFrame(name, byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+				go s.handleConnection(ctx, &Context{ConnID: connID, Stream: &mockStream{r: r, w: counters[i]}})
+				waitFor(func() bool { return s.connector.Get(connID) != nil })
+			}
+			data := newDataFrame("tid", "source", 0x33).Encode()
+			source := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode()
+			source = append(source, bytes.Repeat(data, b.N)...)
+			delivered := func() int64 {
+				var n int64
+				for _, c := range counters {
+					n += atomic.LoadInt64(&c.n)
+				}
+				return n
+			}
+			expected := int64(b.N * len(tc.workflow))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			s.handleConnection(ctx, &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+			for delivered() < expected {
+				runtime.Gosched()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "frames/s")
+		})
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	assert.True(t, MatchName("sfn-1", "sfn-1"))
+	assert.False(t, MatchName("sfn-1", "sfn-10"))
+	assert.True(t, MatchName("detector-*", "detector-us-1"))
+	assert.False(t, MatchName("detector-*", "tracker-us-1"))
+	assert.True(t, MatchName("detector-??-1", "detector-eu-1"))
+	assert.False(t, MatchName("detector-[", "detector-["))
+}
+
+func TestForwardRoutesPatterns(t *testing.T) {
+	route := (&testRouter{names: []string{"decoder", "detector-*", "*"}}).Route("")
+	// the source is ahead of the first stage even if its name matches a pattern
+	assert.Equal(t, []string{"decoder", "detector-*", "*"}, forwardRoutes(route, "detector-cam", ClientTypeSource))
+	// the stream function forwards from the first stage matching its name
+	assert.Equal(t, []string{"*"}, forwardRoutes(route, "detector-eu-1", ClientTypeStreamFunction))
+	assert.Equal(t, []string{"detector-*", "*"}, forwardRoutes(route, "decoder", ClientTypeStreamFunction))
+	assert.Empty(t, forwardRoutes(route, "archiver", ClientTypeStreamFunction))
+
+	token, ok := stageOf((&testRouter{names: []string{"detector-*", "detector-eu"}}).Route(""), "detector-eu")
+	assert.True(t, ok)
+	assert.Equal(t, "detector-eu", token)
+}
+
+func TestHandleDataFrameWildcardStage(t *testing.T) {
+	s := newTestServer("detector-*", "alerter")
+	detector := connectSfn(s, "detector-conn", "detector-eu-1", 0x33)
+	alerter := connectSfn(s, "alerter-conn", "alerter", 0x34)
+
+	// the source named like the detectors enters the first stage
+	source := encodeFrames(
+		frame.NewHandshakeFrame("detector-cam", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "detector-cam", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(detector.Frames()) == 1 }))
+
+	// the output of the detector goes to the next stage
+	output := newDataFrame("tid-1", "detector-eu-1", 0x34)
+	s.handleConnection(context.Background(), &Context{ConnID: "detector-conn", Stream: &mockStream{r: bytes.NewReader(output.Encode()), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(alerter.Frames()) == 1 }))
+	assert.Len(t, detector.Frames(), 1)
+}
+
+func TestHandshakeRejectedWithoutWorkflow(t *testing.T) {
+	s := newTestServer()
+	buf := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode()
+	out := &bytes.Buffer{}
+	s.handleConnection(context.Background(), &Context{ConnID: "conn", Stream: &mockStream{r: bytes.NewReader(buf), w: out}})
+
+	f, err := ParseFrame(out)
+	assert.NoError(t, err)
+	rejected, ok := f.(*frame.RejectedFrame)
+	assert.True(t, ok)
+	assert.Equal(t, "no workflow configured", rejected.Message())
+	assert.Nil(t, s.connector.Get("conn"))
+}
+
+func TestHandleDataFrameForgedIssuer(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("forged", "another-source", 0x33),
+		newDataFrame("valid", "source", 0x33),
+		newDataFrame("blank", "", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	frames := sfn.Frames()
+	assert.Equal(t, "valid", frames[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "blank", frames[1].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "source", frames[1].(*frame.DataFrame).Issuer())
+}
+
+// mockQuicStream is a quic.Stream backed by mockStream.
+type mockQuicStream struct {
+	quic.Stream
+	*mockStream
+}
+
+func (m *mockQuicStream) Read(p []byte) (int, error)  { return m.mockStream.Read(p) }
+func (m *mockQuicStream) Write(p []byte) (int, error) { return m.mockStream.Write(p) }
+func (m *mockQuicStream) Close() error                { return m.mockStream.Close() }
+func (m *mockQuicStream) StreamID() quic.StreamID     { return 0 }
+func (m *mockQuicStream) SetReadDeadline(t time.Time) error {
+	if d, ok := m.r.(interface{ SetReadDeadline(t time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// deadlineReader is a pipe reader fails the pending read once the read deadline is set.
+type deadlineReader struct {
+	*io.PipeReader
+}
+
+func (r *deadlineReader) SetReadDeadline(t time.Time) error {
+	return r.CloseWithError(os.ErrDeadlineExceeded)
+}
+
+// timeoutReader is a pipe reader fails the pending read once the read deadline passes.
+type timeoutReader struct {
+	*io.PipeReader
+	w     *io.PipeWriter
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func (r *timeoutReader) SetReadDeadline(t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(time.Until(t), func() { r.w.CloseWithError(os.ErrDeadlineExceeded) })
+	return nil
+}
+
+// mockConn is a quic.Connection which accepts the streams sent to it.
+type mockConn struct {
+	quic.Connection
+	mu      sync.Mutex
+	addr    net.Addr
+	streams chan quic.Stream
+	closed  chan string
+	state   quic.ConnectionState
+	ctx     context.Context
+}
+
+func (m *mockConn) ConnectionState() quic.ConnectionState {
+	return m.state
+}
+
+func (m *mockConn) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+func newMockConn(id int) *mockConn {
+	return &mockConn{
+		addr:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: id},
+		streams: make(chan quic.Stream, 1),
+		closed:  make(chan string, 1),
+	}
+}
+
+func (m *mockConn) RemoteAddr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addr
+}
+
+// migrate changes the remote address.
+func (m *mockConn) migrate(addr net.Addr) {
+	m.mu.Lock()
+	m.addr = addr
+	m.mu.Unlock()
+}
+
+func (m *mockConn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	select {
+	case stream, ok := <-m.streams:
+		if !ok {
+			return nil, &quic.ApplicationError{ErrorCode: 0x00}
+		}
+		return stream, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *mockConn) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *mockConn) CloseWithError(code quic.ApplicationErrorCode, msg string) error {
+	m.closed <- msg
+	return nil
+}
+
+// mockListener is a quic.Listener which accepts the connections sent to it, or fails with
+// the errors sent to it.
+type mockListener struct {
+	quic.Listener
+	conns chan quic.Connection
+	errs  chan error
+}
+
+func (m *mockListener) Accept(ctx context.Context) (quic.Connection, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case err := <-m.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestSessionPoolReject(t *testing.T) {
+	s := newTestServer("sfn-1")
+	s.opts.MaxSessions = 1
+	s.sessions = newSessionPool(1, SessionPoolReject)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection)}
+	go s.serve(ctx, listener)
+
+	listener.conns <- newMockConn(1)
+	conn := newMockConn(2)
+	out := &syncBuffer{}
+	conn.streams <- &mockQuicStream{mockStream: &mockStream{r: &bytes.Buffer{}, w: out}}
+	listener.conns <- conn
+
+	select {
+	case msg := <-conn.closed:
+		assert.Equal(t, "too many sessions", msg)
+	case <-time.After(time.Second):
+		t.Fatal("the connection is not rejected")
+	}
+	frames := out.Frames()
+	assert.Len(t, frames, 1)
+	assert.Equal(t, "too many sessions", frames[0].(*frame.RejectedFrame).Message())
+}
+
+func TestMaxSessionsPerIP(t *testing.T) {
+	s := newTestServer("sfn-1")
+	s.opts.MaxSessionsPerIP = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection)}
+	go s.serve(ctx, listener)
+
+	first := newMockConn(1)
+	listener.conns <- first
+	assert.True(t, waitFor(func() bool { return s.ipSessions.count("127.0.0.1") == 1 }))
+
+	// the second connection of the same IP is rejected
+	conn := newMockConn(2)
+	out := &syncBuffer{}
+	conn.streams <- &mockQuicStream{mockStream: &mockStream{r: &bytes.Buffer{}, w: out}}
+	listener.conns <- conn
+	select {
+	case msg := <-conn.closed:
+		assert.Equal(t, "too many sessions from the address", msg)
+	case <-time.After(time.Second):
+		t.Fatal("the connection is not rejected")
+	}
+	assert.Equal(t, "too many sessions from the address", out.Frames()[0].(*frame.RejectedFrame).Message())
+
+	// other IPs are not affected
+	other := newMockConn(3)
+	other.migrate(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 3})
+	listener.conns <- other
+	assert.True(t, waitFor(func() bool { return s.ipSessions.count("10.0.0.1") == 1 }))
+
+	// the slot is released when the session ends
+	close(first.streams)
+	assert.True(t, waitFor(func() bool { return s.ipSessions.count("127.0.0.1") == 0 }))
+	listener.conns <- newMockConn(4)
+	assert.True(t, waitFor(func() bool { return s.ipSessions.count("127.0.0.1") == 1 }))
+}
+
+func TestServeAcceptErrors(t *testing.T) {
+	s := newTestServer("sfn-1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection, 1), errs: make(chan error, 2)}
+	served := make(chan error, 1)
+	go func() { served <- s.serve(ctx, listener) }()
+
+	// the transient errors are retried
+	listener.errs <- errors.New("too many open files")
+	listener.errs <- errors.New("too many open files")
+	// the connection is accepted after the errors, the listener would pick either
+	assert.True(t, waitFor(func() bool { return s.StatsAcceptErrors() == 2 }))
+	listener.conns <- newMockConn(1)
+	assert.True(t, waitFor(func() bool { return len(s.ListSessions()) == 1 }))
+	assert.EqualValues(t, 2, s.StatsAcceptErrors())
+
+	// the closed listener ends the loop
+	listener.errs <- net.ErrClosed
+	select {
+	case err := <-served:
+		assert.True(t, errors.Is(err, net.ErrClosed))
+	case <-time.After(time.Second):
+		t.Fatal("the server is not stopped")
+	}
+	assert.Equal(t, ServerStateClosed, s.State())
+}
+
+func BenchmarkIdleSessions(b *testing.B) {
+	for _, size := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				benchmarkIdleSessions(b, size, 10000)
+			}
+		})
+	}
+}
+
+func benchmarkIdleSessions(b *testing.B, size int, n int) {
+	s := newTestServer()
+	s.sessions = newSessionPool(size, SessionPoolQueue)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the excess connections stay in the accept queue of the listener
+	listener := &mockListener{conns: make(chan quic.Connection, n)}
+	pending := 0
+	if size > 0 {
+		pending = n - size
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	go s.serve(ctx, listener)
+	for i := 0; i < n; i++ {
+		listener.conns <- newMockConn(i)
+	}
+	for len(listener.conns) > pending {
+		time.Sleep(time.Millisecond)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapInuse+after.StackInuse-before.HeapInuse-before.StackInuse)/float64(n), "B/conn")
+	b.ReportMetric(float64(runtime.NumGoroutine()), "goroutines")
+}
+
+func TestHandleDataFrameMaxHops(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	looped := newDataFrame("looped", "source", 0x33)
+	looped.SetHops(DefaultMaxHops)
+	last := newDataFrame("last", "source", 0x33)
+	last.SetHops(DefaultMaxHops - 1)
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		looped,
+		last,
+		newDataFrame("new", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	frames := sfn.Frames()
+	assert.Equal(t, "last", frames[0].(*frame.DataFrame).TransactionID())
+	assert.EqualValues(t, DefaultMaxHops, frames[0].(*frame.DataFrame).Hops())
+	assert.Equal(t, "new", frames[1].(*frame.DataFrame).TransactionID())
+	assert.EqualValues(t, 1, frames[1].(*frame.DataFrame).Hops())
+	assert.EqualValues(t, 1, s.StatsHopsExceeded())
+}
+
+func TestServerTLSConfigVerifyPeerCertificate(t *testing.T) {
+	tc := &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s := NewServer("test-zipper", WithServerTLSConfig(tc))
+	got, err := s.tlsConfig("localhost")
+	assert.NoError(t, err)
+	// the config is cloned to reload the certificate, the custom one is kept unchanged
+	assert.Equal(t, tls.RequireAnyClientCert, got.ClientAuth)
+	assert.Nil(t, got.VerifyPeerCertificate)
+	assert.Nil(t, tc.GetCertificate)
+
+	errRevoked := errors.New("revoked")
+	s = NewServer("test-zipper", WithServerTLSConfig(tc), WithVerifyPeerCertificate(func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		return errRevoked
+	}))
+	got, err = s.tlsConfig("localhost")
+	assert.NoError(t, err)
+	assert.Nil(t, tc.VerifyPeerCertificate)
+	assert.Equal(t, tls.RequireAnyClientCert, got.ClientAuth)
+	assert.Equal(t, errRevoked, got.VerifyPeerCertificate(nil, nil))
+}
+
+// handshakeTLS runs a TLS handshake over a pipe, and waits for the session ticket.
+func handshakeTLS(t *testing.T, server *tls.Config, client *tls.Config) tls.ConnectionState {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		conn := tls.Server(c1, server)
+		if err := conn.Handshake(); err == nil {
+			conn.Write([]byte{0})
+		}
+	}()
+	conn := tls.Client(c2, client)
+	// the session ticket is received after the handshake
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	return conn.ConnectionState()
+}
+
+func TestServerSessionTicketKeys(t *testing.T) {
+	var key [32]byte
+	copy(key[:], "0123456789abcdef0123456789abcdef")
+	client := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"yomo"},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+
+	tc1, err := NewServer("zipper-1", WithSessionTicketKeys(key)).tlsConfig("localhost")
+	assert.NoError(t, err)
+	tc2, err := NewServer("zipper-2", WithSessionTicketKeys(key)).tlsConfig("localhost")
+	assert.NoError(t, err)
+	assert.False(t, handshakeTLS(t, tc1, client).DidResume)
+	// the session issued by zipper-1 is resumed by zipper-2
+	assert.True(t, handshakeTLS(t, tc2, client).DidResume)
+
+	tc3, err := NewServer("zipper-3").tlsConfig("localhost")
+	assert.NoError(t, err)
+	assert.False(t, handshakeTLS(t, tc3, client).DidResume)
+}
+
+func TestServerReloadCertificate(t *testing.T) {
+	client := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"yomo"}}
+	s := NewServer("zipper")
+	tc, err := s.tlsConfig("localhost")
+	assert.NoError(t, err)
+	original := handshakeTLS(t, tc, client).PeerCertificates[0]
+
+	renewed, err := pkgtls.CreateServerTLSConfig("localhost")
+	assert.NoError(t, err)
+	assert.NoError(t, s.ReloadCertificate(renewed.Certificates[0]))
+	// the new handshakes use the reloaded certificate without rebuilding the tls config
+	reloaded := handshakeTLS(t, tc, client).PeerCertificates[0]
+	assert.Equal(t, renewed.Certificates[0].Certificate[0], reloaded.Raw)
+	assert.NotEqual(t, original.Raw, reloaded.Raw)
+
+	assert.Error(t, s.ReloadCertificate(tls.Certificate{}))
+}
+
+func TestServerUptime(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	s := NewServer("test-zipper", WithServerClock(fake))
+	assert.True(t, s.StartedAt().IsZero())
+	assert.Zero(t, s.Uptime())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, "127.0.0.1:0")
+	assert.True(t, waitFor(func() bool { return !s.StartedAt().IsZero() }))
+	assert.True(t, now.Equal(s.StartedAt()))
+
+	fake.Advance(time.Minute)
+	assert.Equal(t, time.Minute, s.Uptime())
+}
+
+func TestServerDisconnectReason(t *testing.T) {
+	s := newTestServer("sfn-1")
+	reasons := make(chan DisconnectReason, 1)
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) {
+		assert.Equal(t, "sfn-1", name)
+		reasons <- reason
+	})
+
+	buf := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode()
+	// unknown frame type
+	buf = append(buf, 0x81, 0x00)
+	conn := newMockConn(1)
+	conn.streams <- &mockQuicStream{mockStream: &mockStream{r: bytes.NewReader(buf), w: ioutil.Discard}}
+	close(conn.streams)
+	s.serveConn(context.Background(), conn, GetConnID(conn))
+
+	reason := <-reasons
+	assert.Equal(t, DisconnectParseError, reason.Cause)
+	assert.Nil(t, s.connector.Get(GetConnID(conn)))
+}
+
+func TestServerStageEvents(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	record := func(event string) StageHandler {
+		return func(token string) {
+			mu.Lock()
+			events = append(events, event+":"+token)
+			mu.Unlock()
+		}
+	}
+	s.OnStageEmpty(record("empty"))
+	s.OnStageReady(record("ready"))
+	got := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}
+
+	connectSfn(s, "sfn-1-a", "sfn-1", 0x33)
+	connectSfn(s, "sfn-1-b", "sfn-1", 0x33)
+	assert.True(t, waitFor(func() bool { return len(got()) == 1 }))
+	assert.Equal(t, []string{"ready:sfn-1"}, got())
+
+	// it fires on the last instance only
+	s.deregister("sfn-1-a", DisconnectReason{Cause: DisconnectServerClose})
+	assert.Equal(t, []string{"ready:sfn-1"}, got())
+	s.deregister("sfn-1-b", DisconnectReason{Cause: DisconnectServerClose})
+	s.deregister("sfn-1-b", DisconnectReason{Cause: DisconnectServerClose})
+	assert.Equal(t, []string{"ready:sfn-1", "empty:sfn-1"}, got())
+
+	connectSfn(s, "sfn-1-c", "sfn-1", 0x33)
+	assert.True(t, waitFor(func() bool { return len(got()) == 3 }))
+	assert.Equal(t, "ready:sfn-1", got()[2])
+}
+
+func TestServerStageEventsReadStats(t *testing.T) {
+	s := newTestServer("sfn-1")
+	depths := make(chan map[string]int, 2)
+	alert := func(token string) { depths <- s.Stats().QueueDepths }
+	s.OnStageEmpty(alert)
+	s.OnStageReady(alert)
+
+	// the handlers read the stats of the stages they're invoked by
+	connectSfn(s, "sfn-1-a", "sfn-1", 0x33)
+	select {
+	case d := <-depths:
+		assert.Equal(t, map[string]int{"sfn-1": 0}, d)
+	case <-time.After(time.Second):
+		t.Fatal("the ready handler is blocked")
+	}
+	done := make(chan struct{})
+	go func() {
+		s.deregister("sfn-1-a", DisconnectReason{Cause: DisconnectServerClose})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the empty handler is blocked")
+	}
+	assert.Empty(t, <-depths)
+}
+
+func TestHandleConnectionReadTimeout(t *testing.T) {
+	s := newTestServer("sfn-1")
+	s.opts.ReadTimeout = 200 * time.Millisecond
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	r, w := io.Pipe()
+	stream := &mockQuicStream{mockStream: &mockStream{r: &timeoutReader{PipeReader: r, w: w}, w: ioutil.Discard}}
+	go func() {
+		w.Write(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode())
+		// the timeout is reset by every frame
+		for i := 0; i < 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			w.Write(newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33).Encode())
+		}
+	}()
+	reason := s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: stream})
+	assert.Equal(t, DisconnectReadTimeout, reason.Cause)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 3 }))
+}
+
+func TestHandleConnectionLengthMismatch(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	data := frame.NewDataFrame()
+	data.SetCarriage(0x33, []byte("yomo"))
+	buf := data.Encode()
+	// the carriage claims more bytes than the payload holds
+	buf[len(buf)-5] = 0x06
+	source := append(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode(), buf...)
+	reason := s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Equal(t, DisconnectParseError, reason.Cause)
+	assert.True(t, errors.Is(reason.Err, frame.ErrLengthMismatch))
+	assert.Empty(t, sfn.Frames())
+}
+
+func TestHandleConnectionMaxFrameSize(t *testing.T) {
+	s := newTestServer("sfn-1")
+	s.opts.MaxFrameSize = 32 * 1024
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	// the handshake is limited before the connection is authenticated
+	huge := frame.NewHandshakeFrame(strings.Repeat("s", DefaultMaxHandshakeFrameSize), byte(ClientTypeSource), nil, "", 0, nil)
+	reason := s.handleConnection(context.Background(), &Context{ConnID: "huge-conn", Stream: &mockStream{r: bytes.NewReader(huge.Encode()), w: ioutil.Discard}})
+	assert.Equal(t, DisconnectParseError, reason.Cause)
+	assert.True(t, errors.Is(reason.Err, ErrFrameTooLarge))
+	assert.Nil(t, s.connector.Get("huge-conn"))
+
+	// the data frames are limited by the larger size after the handshake
+	large := frame.NewDataFrame()
+	large.SetCarriage(0x33, make([]byte, 20*1024))
+	tooLarge := frame.NewDataFrame()
+	tooLarge.SetCarriage(0x33, make([]byte, 40*1024))
+	source := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), large, tooLarge)
+	reason = s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Equal(t, DisconnectParseError, reason.Cause)
+	assert.True(t, errors.Is(reason.Err, ErrFrameTooLarge))
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+}
+
+func TestHandleConnectionContextDone(t *testing.T) {
+	s := newTestServer("sfn-1")
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	ctx, cancel := context.WithCancel(context.Background())
+	reasons := make(chan DisconnectReason)
+	go func() {
+		reasons <- s.handleConnection(ctx, &Context{ConnID: "sfn-conn", Stream: &mockQuicStream{mockStream: &mockStream{r: &deadlineReader{r}, w: ioutil.Discard}}})
+	}()
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	// the pending read returns once the context is done
+	cancel()
+	select {
+	case reason := <-reasons:
+		assert.Equal(t, DisconnectServerClose, reason.Cause)
+		assert.Equal(t, context.Canceled, reason.Err)
+	case <-time.After(time.Second):
+		t.Fatal("handleConnection is not stopped")
+	}
+}
+
+func TestServerState(t *testing.T) {
+	s := NewServer("test-zipper")
+	assert.Equal(t, ServerStateReady, s.State())
+
+	var mu sync.Mutex
+	transitions := []string{}
+	s.SetStateChangeHandler(func(from ServerState, to ServerState) {
+		mu.Lock()
+		transitions = append(transitions, from+"->"+to)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.ListenAndServe(ctx, "127.0.0.1:0")
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+	cancel()
+	<-s.Done()
+	assert.Equal(t, ServerStateClosed, s.State())
+	// closed is the final state
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ServerStateClosed, s.State())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"Ready->Starting", "Starting->Listening", "Listening->Closed"}, transitions)
+}
+
+func TestHandleDataFrameFilter(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	sfn1 := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	sfn2 := connectSfn(s, "sfn-2-conn", "sfn-2", 0x33)
+	calls := 0
+	s.SetFrameFilter(func(f *frame.DataFrame) bool {
+		calls++
+		return f.TransactionID() != "blocked"
+	})
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("blocked", "source", 0x33),
+		newDataFrame("allowed", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	for _, sfn := range []*syncBuffer{sfn1, sfn2} {
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+		assert.Equal(t, "allowed", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	}
+	assert.Equal(t, 2, calls)
+	assert.EqualValues(t, 1, s.StatsFiltered())
+}
+
+func TestHandleDataFrameObserver(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	connectSfn(s, "sfn-2-conn", "sfn-2", 0x33)
+	events := make([]DataFrameEvent, 0)
+	s.OnDataFrame(func(e DataFrameEvent) {
+		events = append(events, e)
+	})
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		// no stream function observes the tag
+		newDataFrame("tid-2", "source", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, "tid-1", events[0].TransactionID)
+	assert.Equal(t, "source", events[0].Issuer)
+	assert.Equal(t, byte(0x33), events[0].Tag)
+	assert.Equal(t, len("tid-1"), events[0].Size)
+	assert.ElementsMatch(t, []string{"sfn-1-conn", "sfn-2-conn"}, events[0].Targets)
+}
+
+// freeAddr returns a free udp address on the loopback interface.
+func freeAddr(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestListenAndServeRestart(t *testing.T) {
+	addr := freeAddr(t)
+	for i := 0; i < 2; i++ {
+		// the port is released once the server is closed
+		s := newTestServer("sfn-1")
+		served := make(chan error, 1)
+		go func() { served <- s.ListenAndServe(context.Background(), addr) }()
+		assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+		assert.NoError(t, s.Close())
+		<-served
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestListenAndServeMulti(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.ListenAndServeMulti(ctx, addrs...)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	for i, addr := range addrs {
+		client := NewClient(fmt.Sprintf("sfn-%d", i+1), ClientTypeStreamFunction, WithObserveDataTags(0x33))
+		assert.NoError(t, client.Connect(ctx, addr))
+		defer client.Close()
+	}
+	assert.True(t, waitFor(func() bool { return len(s.StatsFunctions()) == 2 }))
+
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the server is not stopped")
+	}
+}
+
+func TestServerCloseStopsListenAndServe(t *testing.T) {
+	s := newTestServer("sfn-1")
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(context.Background(), freeAddr(t)) }()
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	assert.NoError(t, s.Close())
+	select {
+	case err := <-served:
+		assert.Equal(t, ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("ListenAndServe is not stopped by Close")
+	}
+	assert.Equal(t, ErrServerClosed, s.Err())
+
+	// the closed server doesn't listen again
+	assert.Equal(t, ErrServerClosed, s.ListenAndServe(context.Background(), freeAddr(t)))
+}
+
+func TestListenAndServeAddressInUse(t *testing.T) {
+	addr := freeAddr(t)
+	s1 := newTestServer("sfn-1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s1.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s1.State() == ServerStateListening }))
+
+	s2 := newTestServer("sfn-1")
+	err := s2.ListenAndServe(ctx, addr)
+	assert.True(t, errors.Is(err, ErrListen), err)
+	var le *ListenError
+	assert.True(t, errors.As(err, &le))
+	assert.Equal(t, addr, le.Addr)
+	assert.Equal(t, ServerStateClosed, s2.State())
+	assert.True(t, s2.StartedAt().IsZero())
+}
+
+func TestHandleDataFrameAck(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	handshake.AckWindow = 4
+	data := newDataFrame("tid-1", "source", 0x33)
+	out := &syncBuffer{}
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: r, w: out}})
+	// the retransmitted frame is acknowledged again but not routed twice
+	w.Write(encodeFrames(handshake, data, data))
+
+	assert.True(t, waitFor(func() bool { return len(out.Frames()) == 2 }))
+	for _, f := range out.Frames() {
+		assert.Equal(t, []string{"tid-1"}, f.(*frame.AckFrame).TransactionIDs())
+	}
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, sfn.Frames(), 1)
+}
+
+func TestHandleDataFrameRequireTransactionID(t *testing.T) {
+	s := NewServer("test-zipper", WithRequireTransactionID())
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+			frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+			newDataFrame("", "source", 0x33),
+			newDataFrame("tid-1", "source", 0x33),
+		)), w: source},
+	})
+
+	// the frame without the transaction id is rejected, the source keeps sending
+	assert.True(t, waitFor(func() bool { return len(source.Frames()) == 1 }))
+	rejected, ok := source.Frames()[0].(*frame.RejectedFrame)
+	if assert.True(t, ok) {
+		assert.Equal(t, frame.RejectedMessageInvalidTransactionID, rejected.Message())
+	}
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	assert.Equal(t, "tid-1", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.EqualValues(t, 1, s.Stats().Dropped.InvalidTransactionID)
+}
+
+func TestHandleDataFrameAckWindowClamped(t *testing.T) {
+	s := NewServer("test-zipper", WithMaxAckWindow(8))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	// the receiver isn't sized by the window of the client
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	handshake.AckWindow = 0xFFFFFFFF
+	out := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))), w: out},
+	})
+	assert.True(t, waitFor(func() bool { return len(out.Frames()) == 1 }))
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	v, ok := s.ackReceivers.Load("source-conn")
+	if assert.True(t, ok) {
+		assert.Len(t, v.(*ackReceiver).ring, 16)
+	}
+}
+
+func TestHandleDataFrameAckAfterReconnect(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	handshake.AckWindow = 4
+	handshake.ClientID = "client-1"
+	send := func(connID string, tid string) *syncBuffer {
+		out := &syncBuffer{}
+		s.handleConnection(context.Background(), &Context{
+			ConnID: connID,
+			Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame(tid, "source", 0x33))), w: out},
+		})
+		assert.True(t, waitFor(func() bool { return len(out.Frames()) == 1 }))
+		s.deregister(connID, DisconnectReason{Cause: DisconnectClientClose})
+		return out
+	}
+	send("source-conn-1", "tid-1")
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+
+	// the frame redelivered by the reconnected client is acknowledged again but not routed twice
+	out := send("source-conn-2", "tid-1")
+	assert.Equal(t, []string{"tid-1"}, out.Frames()[0].(*frame.AckFrame).TransactionIDs())
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, sfn.Frames(), 1)
+
+	// the other clients and the receivers released for the retention aren't shared
+	handshake.ClientID = "client-2"
+	send("source-conn-3", "tid-1")
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	handshake.ClientID = "client-1"
+	fake.Advance(ackRetention)
+	send("source-conn-4", "tid-1")
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 3 }))
+}
+
+func TestClientAckRetransmit(t *testing.T) {
+	s := newTestServer("sfn-1")
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	sfn := NewClient("sfn-1", ClientTypeStreamFunction, WithObserveDataTags(0x33))
+	received := make(chan string, 10)
+	sfn.SetDataFrameObserver(func(f *frame.DataFrame) { received <- f.TransactionID() })
+	assert.NoError(t, sfn.Connect(ctx, addr))
+	defer sfn.Close()
+
+	source := NewClient("source", ClientTypeSource, WithAckWindow(8, 100*time.Millisecond))
+	assert.NoError(t, source.Connect(ctx, addr))
+	defer source.Close()
+	// a frame lost while it's written is retransmitted
+	source.acks.track(newDataFrame("lost", "source", 0x33), time.Now())
+	assert.NoError(t, source.WriteFrame(newDataFrame("tid-1", "source", 0x33)))
+
+	assert.True(t, waitFor(func() bool { return source.AckPending() == 0 }))
+	assert.GreaterOrEqual(t, source.AckRetries(), int64(1))
+	assert.ElementsMatch(t, []string{"tid-1", "lost"}, []string{<-received, <-received})
+}
+
+func TestHandleSubscriptionFrame(t *testing.T) {
+	s := newTestServer("sfn-1")
+	r, w := io.Pipe()
+	defer w.Close()
+	sfn := &syncBuffer{}
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+
+	// the sfn observes 0x34 instead of 0x33 from now on
+	w.Write(frame.NewSubscriptionFrame([]byte{0x34}, 2).Encode())
+	assert.True(t, waitFor(func() bool {
+		app, _ := s.connector.App("sfn-conn")
+		return app.Weight() == 2
+	}))
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn-2", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	time.Sleep(50 * time.Millisecond)
+	frames := sfn.Frames()
+	assert.Len(t, frames, 2)
+	assert.Equal(t, "tid-1", frames[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "tid-2", frames[1].(*frame.DataFrame).TransactionID())
+	app, _ := s.connector.App("sfn-conn")
+	assert.Equal(t, []byte{0x34}, app.Observed())
+
+	// a source can't change the subscription
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frame.NewSubscriptionFrame([]byte{0x33}, 0))), w: ioutil.Discard}})
+	assert.EqualValues(t, 1, s.StatsProtocolViolation())
+}
+
+func TestHandleDataFrameFragments(t *testing.T) {
+	f := newDataFrame("msg-1", "source", 0x33)
+	f.SetCarriage(0x33, make([]byte, 1000))
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+
+	t.Run("sticky", func(t *testing.T) {
+		s := newTestServer("sfn-1")
+		sfns := []*syncBuffer{
+			connectSfn(s, "sfn-conn-1", "sfn-1", 0x33),
+			connectSfn(s, "sfn-conn-2", "sfn-1", 0x33),
+			connectSfn(s, "sfn-conn-3", "sfn-1", 0x33),
+		}
+		source := encodeFrames(append([]frame.Frame{handshake}, toFrames(SplitDataFrame(f, 100))...)...)
+		s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+		// all the fragments go to the same instance
+		assert.True(t, waitFor(func() bool {
+			for _, sfn := range sfns {
+				if len(sfn.Frames()) == 10 {
+					return true
+				}
+			}
+			return false
+		}))
+	})
+
+	t.Run("reassembly", func(t *testing.T) {
+		s := NewServer("test-zipper", WithFragmentReassembly(time.Second))
+		s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+		sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+		source := encodeFrames(append([]frame.Frame{handshake}, toFrames(SplitDataFrame(f, 100))...)...)
+		s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+		whole := sfn.Frames()[0].(*frame.DataFrame)
+		assert.Equal(t, "msg-1", whole.TransactionID())
+		assert.Len(t, whole.GetCarriage(), 1000)
+		assert.Nil(t, whole.Fragment())
+	})
+}
+
+type upperMetadataCodec struct{}
+
+func (upperMetadataCodec) Encode(md frame.Metadata) ([]byte, error) {
+	return []byte(strings.ToUpper(md["user"])), nil
+}
+
+func (upperMetadataCodec) Decode(buf []byte) (frame.Metadata, error) {
+	return frame.Metadata{"user": strings.ToLower(string(buf))}, nil
+}
+
+func TestHandleDataFrameRoutingMetadataKey(t *testing.T) {
+	s := NewServer("test-zipper", WithRoutingMetadataKey("user"), WithMetadataCodec(upperMetadataCodec{}))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfns := []*syncBuffer{
+		connectSfn(s, "sfn-conn-1", "sfn-1", 0x33),
+		connectSfn(s, "sfn-conn-2", "sfn-1", 0x33),
+		connectSfn(s, "sfn-conn-3", "sfn-1", 0x33),
+	}
+	frames := []frame.Frame{frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)}
+	for i := 0; i < 10; i++ {
+		f := newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33)
+		md, _ := upperMetadataCodec{}.Encode(frame.Metadata{"user": "alice"})
+		f.SetMetadata(md)
+		frames = append(frames, f)
+	}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frames...)), w: ioutil.Discard}})
+
+	// the frames of the same user go to the same instance, the metadata is forwarded as it is
+	var received *syncBuffer
+	assert.True(t, waitFor(func() bool {
+		for _, sfn := range sfns {
+			if len(sfn.Frames()) == 10 {
+				received = sfn
+				return true
+			}
+		}
+		return false
+	}))
+	f := received.Frames()[0].(*frame.DataFrame)
+	assert.Equal(t, []byte("ALICE"), f.Metadata())
+	md, err := s.Metadata(f)
+	assert.NoError(t, err)
+	assert.Equal(t, frame.Metadata{"user": "alice"}, md)
+}
+
+func toFrames(fragments []*frame.DataFrame) []frame.Frame {
+	frames := make([]frame.Frame, len(fragments))
+	for i, f := range fragments {
+		frames[i] = f
+	}
+	return frames
+}
+
+func TestServerStatsFrames(t *testing.T) {
+	s := newTestServer("sfn-1")
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	data := newDataFrame("tid", "source", 0x33)
+	source := encodeFrames(handshake, data, data, frame.NewPingFrame())
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	stats := s.StatsFrames()
+	assert.Len(t, stats, 3)
+	assert.Equal(t, FrameStat{Count: 1, Bytes: int64(len(handshake.Encode()))}, stats[frame.TagOfHandshakeFrame])
+	assert.Equal(t, FrameStat{Count: 2, Bytes: int64(2 * len(data.Encode()))}, stats[frame.TagOfDataFrame])
+	assert.Equal(t, FrameStat{Count: 1, Bytes: int64(len(frame.NewPingFrame().Encode()))}, stats[frame.TagOfPingFrame])
+}
+
+func TestHandleDataFrameEcho(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	s.SetEchoMode(true)
+
+	out := &syncBuffer{}
+	r, w := io.Pipe()
+	go s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: r, w: out}})
+	w.Write(encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("echo", "source", 0x33),
+	))
+
+	assert.True(t, waitFor(func() bool { return len(out.Frames()) == 1 }))
+	assert.Equal(t, "echo", out.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.Len(t, sfn.Frames(), 0)
+	w.Close()
+}
+
+// gateWriter blocks the writes until the gate is opened.
+type gateWriter struct {
+	gate chan struct{}
+	syncBuffer
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return w.syncBuffer.Write(p)
+}
+
+func TestHandleDataFrameStageWindow(t *testing.T) {
+	s := NewServer("test-zipper", WithStageWindow("sfn-1", 1), WithStageWindow("sfn-2", 1))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+			frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+			newDataFrame("tid-1", "source", 0x33),
+			newDataFrame("tid-2", "source", 0x33),
+		)), w: source},
+	})
+
+	// tid-1 is being written, the reading of the source isn't blocked, tid-2 is rejected
+	assert.Equal(t, 1, s.StatsInFlight()["sfn-1"])
+	assert.True(t, waitFor(func() bool { return len(source.Frames()) == 1 }))
+	rejected, ok := source.Frames()[0].(*frame.RejectedFrame)
+	assert.True(t, ok)
+	assert.Equal(t, frame.RejectedMessageStageFull, rejected.Message())
+
+	// the output of sfn-1 to the full sfn-2 is dropped
+	sfn2 := &gateWriter{gate: make(chan struct{})}
+	r2, w2 := io.Pipe()
+	go w2.Write(frame.NewHandshakeFrame("sfn-2", byte(ClientTypeStreamFunction), []byte{0x34}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-2-conn", Stream: &mockStream{r: r2, w: sfn2}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-2-conn") != nil }))
+	output := encodeFrames(
+		frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil),
+		newDataFrame("output-1", "sfn-1", 0x34),
+		newDataFrame("output-2", "sfn-1", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "sfn-output-conn", Stream: &mockStream{r: bytes.NewReader(output), w: ioutil.Discard}})
+	assert.EqualValues(t, 2, s.Stats().Dropped.StageFull)
+
+	close(sfn.gate)
+	close(sfn2.gate)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 && len(sfn2.Frames()) == 1 }))
+	assert.Equal(t, "tid-1", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "output-1", sfn2.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.True(t, waitFor(func() bool { return s.StatsInFlight()["sfn-1"] == 0 }))
+}
+
+func TestHandleDataFrameStageWindowInstances(t *testing.T) {
+	s := NewServer("test-zipper", WithStageWindow("sfn-1", 2))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	// the writes to the first instance never finish
+	congested := &gateWriter{gate: make(chan struct{})}
+	defer close(congested.gate)
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn-1", Stream: &mockStream{r: r, w: congested}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn-1") != nil }))
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+	)), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return s.StatsInFlight()["sfn-1"] == 2 }))
+
+	// the frames go to the instance whose window isn't full
+	sfn := connectSfn(s, "sfn-conn-2", "sfn-1", 0x33)
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-3", "source", 0x33),
+		newDataFrame("tid-4", "source", 0x33),
+	)), w: source}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	assert.Empty(t, source.Frames())
+	assert.Zero(t, s.Stats().Dropped.StageFull)
+}
+
+func TestServerQueueDepths(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+	assert.Equal(t, map[string]int{"sfn-1": 0}, s.QueueDepths())
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+		newDataFrame("tid-3", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	// tid-1 is being written
+	assert.True(t, waitFor(func() bool { return s.QueueDepths()["sfn-1"] == 2 }))
+	assert.Equal(t, map[string]int{"sfn-1": 2}, s.Stats().QueueDepths)
+
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 3 }))
+	assert.Equal(t, 0, s.QueueDepths()["sfn-1"])
+}
+
+func TestHandleDataFrameProcessingLatency(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	// the transformer takes a while before the routing
+	s.SetFrameTransformer(func(f *frame.DataFrame) (*frame.DataFrame, error) {
+		fake.Advance(200 * time.Microsecond)
+		return f, nil
+	})
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+
+	h := s.StatsProcessingLatency()
+	assert.EqualValues(t, 2, h.Count)
+	assert.Equal(t, 200*time.Microsecond, h.Avg())
+	assert.EqualValues(t, 2, h.Counts[3])
+}
+
+func TestHandleDataFrameExpired(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	s := NewServer("test-zipper", WithServerClock(fake))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	expiring := func(tid string, ttl time.Duration) *frame.DataFrame {
+		f := newDataFrame(tid, "source", 0x33)
+		f.SetExpiresAt(now.Add(ttl))
+		return f
+	}
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		expiring("tid-2", time.Second),
+		expiring("tid-3", 10*time.Second),
+		expiring("tid-4", -time.Second),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	// tid-4 is stale when it's received, tid-1 is being written to the congested sfn
+	assert.EqualValues(t, 1, s.StatsExpired())
+	assert.True(t, waitFor(func() bool { return s.QueueDepths()["sfn-1"] == 2 }))
+
+	// tid-2 gets stale while it's queued
+	fake.Advance(5 * time.Second)
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	tids := []string{}
+	for _, f := range sfn.Frames() {
+		tids = append(tids, f.(*frame.DataFrame).TransactionID())
+	}
+	assert.Equal(t, []string{"tid-1", "tid-3"}, tids)
+	assert.True(t, waitFor(func() bool { return s.StatsExpired() == 2 }))
+	assert.EqualValues(t, 2, s.Stats().Dropped.Expired)
+}
+
+func TestHandleDataFrameTransformer(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	s.SetFrameTransformer(func(f *frame.DataFrame) (*frame.DataFrame, error) {
+		switch f.TransactionID() {
+		case "dropped":
+			return nil, nil
+		case "failed":
+			return nil, errors.New("malformed")
+		}
+		f.SetCarriage(f.GetDataTag(), bytes.ToUpper(f.GetCarriage()))
+		return f, nil
+	})
+
+	transformed := newDataFrame("tid-1", "source", 0x33)
+	transformed.SetCarriage(0x33, []byte("hello"))
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("dropped", "source", 0x33),
+		newDataFrame("failed", "source", 0x33),
+		transformed,
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	f := sfn.Frames()[0].(*frame.DataFrame)
+	assert.Equal(t, "tid-1", f.TransactionID())
+	assert.Equal(t, []byte("HELLO"), f.GetCarriage())
+	assert.EqualValues(t, 2, s.StatsTransformDropped())
+}
+
+func TestServerConnectionChurn(t *testing.T) {
+	s := newTestServer("sfn-1")
+	connectSfn(s, "sfn-1-a", "sfn-1", 0x33)
+	connectSfn(s, "sfn-1-b", "sfn-1", 0x33)
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil))), w: ioutil.Discard},
+	})
+	s.deregister("sfn-1-a", DisconnectReason{Cause: DisconnectServerClose})
+	// the connection deregistered already isn't counted again
+	s.deregister("sfn-1-a", DisconnectReason{Cause: DisconnectServerClose})
+	connectSfn(s, "sfn-1-c", "sfn-1", 0x33)
+
+	churn := s.Stats().Churn
+	assert.Equal(t, ConnectionStat{Connects: 3, Disconnects: 1, Active: 2}, churn[ClientTypeStreamFunction])
+	assert.Equal(t, ConnectionStat{Connects: 1, Active: 1}, churn[ClientTypeSource])
+	assert.Equal(t, churn, s.StatsConnectionChurn())
+}
+
+func TestHandleDataFrameNoFirstStage(t *testing.T) {
+	s := newTestServer("sfn-1")
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: r, w: ioutil.Discard}})
+	w.Write(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode())
+	assert.True(t, waitFor(func() bool { return s.connector.Get("source-conn") != nil }))
+
+	// the workflow is reconfigured to empty after the source connected
+	appID, _ := s.connector.AppID("source-conn")
+	s.opts.Store.Set(appID, &testRoute{})
+	w.Write(newDataFrame("tid-1", "source", 0x33).Encode())
+	assert.True(t, waitFor(func() bool { return s.StatsNoFirstStage() == 1 }))
+	assert.EqualValues(t, 1, s.Stats().Dropped.NoFirstStage)
+}
+
+func TestHandleDataFrameNilStream(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	// the transiently nil registrations, a nil pointer in the interface and a nil interface
+	s.connector.Add("sfn-1-conn", (*mockStream)(nil))
+	s.connector.LinkApp("sfn-1-conn", "", "sfn-1", ClientTypeStreamFunction, []byte{0x33}, 0, 0)
+	s.connector.Add("sfn-2-conn", nil)
+	s.connector.LinkApp("sfn-2-conn", "", "sfn-2", ClientTypeStreamFunction, []byte{0x33}, 0, 0)
+	assert.Nil(t, s.connector.Get("sfn-1-conn"))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+	)
+	assert.NotPanics(t, func() {
+		s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	})
+	assert.EqualValues(t, 2, s.StatsNoStream())
+	assert.EqualValues(t, 2, s.Stats().Dropped.NoStream)
+
+	// the unresponsive stream function without a stream is evicted as well
+	assert.NotPanics(t, func() { s.evict("sfn-1-conn", (*mockStream)(nil)) })
+	_, ok := s.connector.App("sfn-1-conn")
+	assert.False(t, ok)
+}
+
+func TestHandleDataFrameMemoryBudget(t *testing.T) {
+	size := frameSize(newDataFrame("tid-0", "source", 0x33))
+	s := NewServer("test-zipper", WithMemoryBudget(2*size))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	frames := []frame.Frame{frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)}
+	for i := 0; i < 5; i++ {
+		frames = append(frames, newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33))
+	}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frames...)), w: ioutil.Discard}})
+
+	// tid-0 is being written to the congested sfn, the queue holds the latest frames within the budget
+	assert.True(t, waitFor(func() bool { return s.StatsOverBudget() > 0 }))
+	assert.LessOrEqual(t, s.Stats().BufferedBytes, 2*size)
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return int64(len(sfn.Frames()))+s.StatsOverBudget() == 5 }))
+	assert.Zero(t, s.StatsBufferedBytes())
+	assert.Equal(t, "tid-4", sfn.Frames()[len(sfn.Frames())-1].(*frame.DataFrame).TransactionID())
+}
+
+func TestServerStats(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	s.SetFrameFilter(func(f *frame.DataFrame) bool {
+		return f.TransactionID() != "blocked"
+	})
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+		newDataFrame("blocked", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	stats := s.Stats()
+	assert.Equal(t, ServerStateReady, stats.State)
+	assert.True(t, stats.StartedAt.IsZero())
+	assert.EqualValues(t, 3, stats.DataFrames)
+	assert.Equal(t, map[string]int64{"sfn-1": 2}, stats.Functions)
+	assert.EqualValues(t, 3, stats.Frames[frame.TagOfDataFrame].Count)
+	assert.Equal(t, DropStats{Filtered: 1}, stats.Dropped)
+	assert.Equal(t, 2, stats.Connections)
+	assert.Equal(t, s.StatsCounter(), stats.DataFrames)
+}
+
+func TestServerStatsDownstreams(t *testing.T) {
+	s := newTestServer("sfn-1")
+	ds := NewClient("zipper-1", ClientTypeUpstreamZipper, WithAckWindow(8, time.Minute))
+	out := &syncBuffer{}
+	ds.stream = &mockQuicStream{mockStream: &mockStream{w: out}}
+	ds.state = ConnStateConnected
+	s.AddDownstreamServer("10.0.0.2:9000", ds)
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Len(t, out.Frames(), 2)
+	// the frames are held until the downstream acknowledges them
+	ds.acks.ack([]string{"tid-1"})
+	assert.Equal(t, map[string]DownstreamStat{"10.0.0.2:9000": {Window: 8, Unacked: 1}}, s.Stats().Downstreams)
+}
+
+func TestServerResetStats(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	s.SetFrameFilter(func(f *frame.DataFrame) bool {
+		return f.TransactionID() != "blocked"
+	})
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: r, w: ioutil.Discard}})
+	w.Write(encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("blocked", "source", 0x33),
+	))
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 && s.StatsFiltered() == 1 }))
+
+	s.ResetStats()
+	stats := s.Stats()
+	assert.Zero(t, stats.DataFrames)
+	assert.Equal(t, map[string]int64{"sfn-1": 0}, stats.Functions)
+	assert.Empty(t, stats.Frames)
+	assert.Equal(t, DropStats{}, stats.Dropped)
+	assert.Zero(t, s.StatsProcessingLatency().Count)
+	assert.Zero(t, s.StatsInstances()[0].Frames)
+	// the gauges are kept
+	assert.Equal(t, 2, stats.Connections)
+	assert.Equal(t, ConnectionStat{Active: 1}, stats.Churn[ClientTypeStreamFunction])
+
+	// the frames flowing while resetting are counted from the fresh baseline
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			s.ResetStats()
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		w.Write(newDataFrame(fmt.Sprintf("tid-%d", i+2), "source", 0x33).Encode())
+	}
+	<-done
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 51 }))
+	s.ResetStats()
+	w.Write(newDataFrame("tid-last", "source", 0x33).Encode())
+	assert.True(t, waitFor(func() bool { return s.StatsCounter() == 1 }))
+}
+
+func TestHandleDataFrameMaxPayloadSize(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	small := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	small.MaxPayloadSize = 4
+	smallSfn1 := connectHandshake(s, "sfn-1-small", small)
+	bigSfn1 := connectSfn(s, "sfn-1-big", "sfn-1", 0x33)
+	small = frame.NewHandshakeFrame("sfn-2", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	small.MaxPayloadSize = 4
+	smallSfn2 := connectHandshake(s, "sfn-2-small", small)
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("large-carriage", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(bigSfn1.Frames()) == 1 }))
+	assert.Empty(t, smallSfn1.Frames())
+	assert.Empty(t, smallSfn2.Frames())
+	assert.EqualValues(t, 1, s.StatsCapacitySkipped())
+}
+
+func TestHandleDataFrameWeight(t *testing.T) {
+	s := newTestServer("sfn-1")
+	heavy := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	heavy.Weight = 3
+	heavySfn := connectHandshake(s, "sfn-1-heavy", heavy)
+	lightSfn := connectSfn(s, "sfn-1-light", "sfn-1", 0x33)
+
+	const total = 2000
+	frames := make([]frame.Frame, 0, total+1)
+	frames = append(frames, frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil))
+	for i := 0; i < total; i++ {
+		frames = append(frames, newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33))
+	}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frames...)), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(heavySfn.Frames())+len(lightSfn.Frames()) == total }))
+
+	instances := s.StatsInstances()
+	assert.Len(t, instances, 2)
+	assert.Equal(t, InstanceStat{ConnID: "sfn-1-heavy", Name: "sfn-1", Weight: 3, Frames: int64(len(heavySfn.Frames()))}, instances[0])
+	assert.Equal(t, InstanceStat{ConnID: "sfn-1-light", Name: "sfn-1", Weight: DefaultWeight, Frames: int64(len(lightSfn.Frames()))}, instances[1])
+	// the heavy instance receives 3/4 of the frames
+	assert.InDelta(t, 0.75, float64(instances[0].Frames)/total, 0.05)
+}
+
+func TestServerRegisteredFunctions(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	assert.Empty(t, s.RegisteredFunctions())
+
+	connectSfn(s, "sfn-2-conn", "sfn-2", 0x34)
+	connectSfn(s, "sfn-1-a", "sfn-1", 0x33)
+	connectSfn(s, "sfn-1-b", "sfn-1", 0x33)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil))), w: ioutil.Discard}})
+	assert.Equal(t, []string{"sfn-1", "sfn-2"}, s.RegisteredFunctions())
+
+	s.deregister("sfn-2-conn", DisconnectReason{Cause: DisconnectServerClose})
+	assert.Equal(t, []string{"sfn-1"}, s.RegisteredFunctions())
+}
+
+func TestHandleDataFrameSampling(t *testing.T) {
+	s := NewServer("test-zipper", WithStageSampling("debug", 4))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "debug"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	debug := connectSfn(s, "debug-conn", "debug", 0x33)
+
+	frames := []frame.Frame{frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)}
+	for i := 0; i < 10; i++ {
+		frames = append(frames, newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33))
+	}
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(frames...)), w: ioutil.Discard}})
+
+	// the debug stage receives the 1st, 5th and 9th frames, the others receive all
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 10 && len(debug.Frames()) == 3 }))
+	assert.Equal(t, "tid-4", debug.Frames()[1].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, map[string]SamplingStat{"debug": {Sampled: 3, Dropped: 7}}, s.StatsSampling())
+}
+
+func TestHandleDataFrameEmptyCarriage(t *testing.T) {
+	s := newTestServer("sfn-1")
+	small := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	small.MaxPayloadSize = 1
+	sfn := connectHandshake(s, "sfn-conn", small)
+
+	signal := frame.NewDataFrame()
+	signal.SetTransactionID("signal")
+	signal.SetCarriage(0x33, nil)
+	source := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), signal)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	// the signal is routed like any other frame
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	received := sfn.Frames()[0].(*frame.DataFrame)
+	assert.Equal(t, "signal", received.TransactionID())
+	assert.EqualValues(t, 0x33, received.GetDataTag())
+	assert.Empty(t, received.GetCarriage())
+	assert.Zero(t, s.StatsCapacitySkipped())
+}
+
+func TestHandshakeReplay(t *testing.T) {
+	s := NewServer("test-zipper", WithReplay(2))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x34),
+		newDataFrame("tid-3", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	// the sfn observes 0x33 only
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	assert.Equal(t, "tid-3", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.EqualValues(t, 1, s.StatsReplayed())
+}
+
+func TestProtocolViolation(t *testing.T) {
+	s := newTestServer("sfn-1")
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	source := encodeFrames(
+		newDataFrame("before-handshake", "source", 0x33),
+		handshake,
+		handshake,
+		frame.NewPingFrame(),
+	)
+	reason := s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Equal(t, DisconnectClientClose, reason.Cause)
+	assert.EqualValues(t, 2, s.StatsProtocolViolation())
+
+	s = NewServer("test-zipper", WithCloseOnProtocolViolation())
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	reason = s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.Equal(t, DisconnectServerClose, reason.Cause)
+	assert.EqualError(t, reason.Err, "protocol violation, None can not send DataFrame")
+	assert.EqualValues(t, 1, s.StatsProtocolViolation())
+}
+
+// mockSink records the frames written to it.
+type mockSink struct {
+	mu     sync.Mutex
+	frames []*frame.DataFrame
+}
+
+func (m *mockSink) Write(f *frame.DataFrame) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames = append(m.frames, f)
+	return nil
+}
+
+func (m *mockSink) Close() error { return nil }
+
+func TestHandleDataFrameTerminalSink(t *testing.T) {
+	sink := &mockSink{}
+	s := NewServer("test-zipper", WithTerminalSink(sink))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+
+	sfn1 := encodeFrames(
+		frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil),
+		newDataFrame("not-terminal", "sfn-1", 0x34),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "sfn-1-conn", Stream: &mockStream{r: bytes.NewReader(sfn1), w: ioutil.Discard}})
+	sfn2 := encodeFrames(
+		frame.NewHandshakeFrame("sfn-2", byte(ClientTypeStreamFunction), []byte{0x34}, "", 0, nil),
+		newDataFrame("terminal", "sfn-2", 0x35),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "sfn-2-conn", Stream: &mockStream{r: bytes.NewReader(sfn2), w: ioutil.Discard}})
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	assert.Len(t, sink.frames, 1)
+	assert.Equal(t, "terminal", sink.frames[0].TransactionID())
+}
+
+func TestHandleDataFrameDeliveryAge(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewServer("test-zipper", WithServerClock(clock.NewFake(now)))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	data := newDataFrame("tid", "source", 0x33)
+	data.SetCreatedAt(now.Add(-30 * time.Millisecond))
+	source := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), data)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	// the created time is not rewritten when forwarding
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	assert.True(t, data.CreatedAt().Equal(sfn.Frames()[0].(*frame.DataFrame).CreatedAt()))
+	assert.Zero(t, s.StatsDeliveryAge().Count)
+
+	// the output of sfn-1 is terminal
+	output := newDataFrame("tid", "sfn-1", 0x34)
+	output.SetCreatedAt(data.CreatedAt())
+	terminal := encodeFrames(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil), output)
+	s.handleConnection(context.Background(), &Context{ConnID: "sfn-output-conn", Stream: &mockStream{r: bytes.NewReader(terminal), w: ioutil.Discard}})
+	age := s.StatsDeliveryAge()
+	assert.EqualValues(t, 1, age.Count)
+	assert.Equal(t, 30*time.Millisecond, age.Sum)
+	assert.EqualValues(t, 1, age.Counts[3])
+}
+
+func TestConnectionMigration(t *testing.T) {
+	s := newTestServer("sfn-1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection)}
+	go s.serve(ctx, listener)
+
+	conn := newMockConn(1)
+	r, w := io.Pipe()
+	out := &syncBuffer{}
+	conn.streams <- &mockQuicStream{mockStream: &mockStream{r: r, w: out}}
+	listener.conns <- conn
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	var connID string
+	assert.True(t, waitFor(func() bool {
+		connID = sessionID(s, conn)
+		return s.connector.Get(connID) != nil
+	}))
+	// the id is generated by the server, not taken from the remote address
+	assert.NotEqual(t, GetConnID(conn), connID)
+
+	// the client moves to another network
+	conn.migrate(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2})
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("after-migration", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(out.Frames()) == 1 }))
+	assert.Equal(t, "after-migration", out.Frames()[0].(*frame.DataFrame).TransactionID())
+	app, ok := s.connector.App(connID)
+	assert.True(t, ok)
+	assert.Equal(t, "sfn-1", app.Name())
+}
+
+func TestServerPause(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	r, w := io.Pipe()
+	defer w.Close()
+	sfn1 := &syncBuffer{}
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-1-conn", Stream: &mockStream{r: r, w: sfn1}})
+	w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	sfn2 := connectSfn(s, "sfn-2-conn", "sfn-2", 0x34)
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-1-conn") != nil }))
+
+	s.Pause()
+	assert.True(t, s.Paused())
+	assert.True(t, s.Stats().Paused)
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	source := &syncBuffer{}
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))), w: source},
+	})
+	assert.True(t, waitFor(func() bool { return len(source.Frames()) > 0 }))
+	rejected, ok := source.Frames()[len(source.Frames())-1].(*frame.RejectedFrame)
+	assert.True(t, ok)
+	assert.Equal(t, frame.RejectedMessagePaused, rejected.Message())
+	assert.Empty(t, sfn1.Frames())
+	assert.EqualValues(t, 1, s.StatsPaused())
+
+	// the in-flight frames of the stream functions keep flowing
+	w.Write(newDataFrame("tid-0", "sfn-1", 0x34).Encode())
+	assert.True(t, waitFor(func() bool { return len(sfn2.Frames()) == 1 }))
+
+	s.Resume()
+	assert.False(t, s.Paused())
+	s.handleConnection(context.Background(), &Context{
+		ConnID: "source-conn-2",
+		Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-2", "source", 0x33))), w: ioutil.Discard},
+	})
+	assert.True(t, waitFor(func() bool { return len(sfn1.Frames()) == 1 }))
+	assert.Equal(t, "tid-2", sfn1.Frames()[0].(*frame.DataFrame).TransactionID())
+}
+
+type tenantKey struct{}
+
+// tenantAuth accepts the handshakes with a payload, and sets the payload as the tenant id.
+type tenantAuth struct{}
+
+func (a *tenantAuth) Type() auth.AuthType { return auth.AuthTypeAppKey }
+
+func (a *tenantAuth) Authenticate(f *frame.HandshakeFrame) bool { return len(f.AuthPayload()) > 0 }
+
+func (a *tenantAuth) AuthenticateContext(ctx context.Context, f *frame.HandshakeFrame) (context.Context, bool) {
+	if !a.Authenticate(f) {
+		return ctx, false
+	}
+	return context.WithValue(ctx, tenantKey{}, string(f.AuthPayload())), true
+}
+
+func TestHandleConnectionContextAuthentication(t *testing.T) {
+	s := NewServer("test-zipper", WithAuth(&tenantAuth{}))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	var mu sync.Mutex
+	tenants := map[frame.Type]interface{}{}
+	s.SetAfterHandlers(func(c *Context) error {
+		mu.Lock()
+		tenants[c.Frame.Type()] = c.Context().Value(tenantKey{})
+		mu.Unlock()
+		return nil
+	})
+	sfn := connectHandshake(s, "sfn-1-conn", frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", byte(auth.AuthTypeAppKey), []byte("tenant-a")))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", byte(auth.AuthTypeAppKey), []byte("tenant-b")),
+		newDataFrame("tid-1", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the data frame carries the tenant set by the authentication of its connection
+	assert.Equal(t, "tenant-b", tenants[frame.TagOfDataFrame])
+
+	// the handshake without a payload is refused, the context of the connection isn't enriched
+	c := &Context{ConnID: "anonymous-conn", Frame: frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)}
+	assert.Error(t, s.handleHandshakeFrame(c))
+	assert.Nil(t, c.Context().Value(tenantKey{}))
+}
+
+func TestClientControlStream(t *testing.T) {
+	s := newTestServer("sfn-1")
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	sfn := NewClient("sfn-1", ClientTypeStreamFunction, WithObserveDataTags(0x33), WithControlStream())
+	received := make(chan string, 10)
+	sfn.SetDataFrameObserver(func(f *frame.DataFrame) { received <- f.TransactionID() })
+	assert.NoError(t, sfn.Connect(ctx, addr))
+	defer sfn.Close()
+
+	source := NewClient("source", ClientTypeSource, WithAckWindow(8, time.Second), WithControlStream())
+	assert.NoError(t, source.Connect(ctx, addr))
+	defer source.Close()
+	assert.True(t, waitFor(func() bool {
+		source.controlMu.Lock()
+		defer source.controlMu.Unlock()
+		return source.control != nil
+	}))
+	assert.True(t, waitFor(func() bool {
+		n := 0
+		s.connector.(*connector).controls.Range(func(_, _ interface{}) bool { n++; return true })
+		return n == 2
+	}))
+
+	// the acks are written to the control stream
+	assert.NoError(t, source.WriteFrame(newDataFrame("tid-1", "source", 0x33)))
+	assert.Equal(t, "tid-1", <-received)
+	assert.True(t, waitFor(func() bool { return source.AckPending() == 0 }))
+
+	// the subscription is sent by the control stream
+	assert.NoError(t, sfn.UpdateSubscription([]byte{0x34}, 0))
+	assert.True(t, waitFor(func() bool {
+		return len(s.connector.GetConnIDs("", "sfn-1", 0x34, 0)) == 1
+	}))
+	assert.NoError(t, source.WriteFrame(newDataFrame("tid-2", "source", 0x34)))
+	assert.Equal(t, "tid-2", <-received)
+}
+
+func TestServerCompression(t *testing.T) {
+	for _, compression := range []bool{true, false} {
+		t.Run(fmt.Sprintf("server-compression=%v", compression), func(t *testing.T) {
+			opts := []ServerOption{}
+			if compression {
+				opts = append(opts, WithServerCompression())
+			}
+			s := NewServer("test-zipper", opts...)
+			s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+			addr := freeAddr(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.ListenAndServe(ctx, addr)
+			assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+			// the uncompressed sfn receives the frames of the compressed source
+			sfn := NewClient("sfn-1", ClientTypeStreamFunction, WithObserveDataTags(0x33), WithControlStream())
+			received := make(chan *frame.DataFrame, 10)
+			sfn.SetDataFrameObserver(func(f *frame.DataFrame) { received <- f })
+			assert.NoError(t, sfn.Connect(ctx, addr))
+			defer sfn.Close()
+			assert.Equal(t, ALPN, sfn.conn.ConnectionState().TLS.NegotiatedProtocol)
+
+			source := NewClient("source", ClientTypeSource, WithClientCompression(), WithAckWindow(8, time.Second), WithControlStream())
+			assert.NoError(t, source.Connect(ctx, addr))
+			defer source.Close()
+			expected := ALPN
+			if compression {
+				expected = ALPNDeflate
+			}
+			assert.Equal(t, expected, source.conn.ConnectionState().TLS.NegotiatedProtocol)
+
+			for i := 0; i < 3; i++ {
+				f := newDataFrame(fmt.Sprintf("tid-%d", i), "source", 0x33)
+				f.SetCarriage(0x33, bytes.Repeat([]byte("yomo"), 1000))
+				assert.NoError(t, source.WriteFrame(f))
+			}
+			for i := 0; i < 3; i++ {
+				f := <-received
+				assert.Equal(t, fmt.Sprintf("tid-%d", i), f.TransactionID())
+				assert.Equal(t, bytes.Repeat([]byte("yomo"), 1000), f.GetCarriage())
+			}
+			// the acks are read from the compressed control stream
+			assert.True(t, waitFor(func() bool { return source.AckPending() == 0 }))
+		})
+	}
+}
+
+func TestServerUniStream(t *testing.T) {
+	s := NewServer("test-zipper")
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	sfn := NewClient("sfn-1", ClientTypeStreamFunction, WithObserveDataTags(0x33))
+	received := make(chan *frame.DataFrame, 10)
+	sfn.SetDataFrameObserver(func(f *frame.DataFrame) { received <- f })
+	assert.NoError(t, sfn.Connect(ctx, addr))
+	defer sfn.Close()
+
+	source := NewClient("source", ClientTypeSource)
+	assert.NoError(t, source.Connect(ctx, addr))
+	defer source.Close()
+
+	// the source pushes the frames on its own unidirectional streams
+	for i := 0; i < 2; i++ {
+		stream, err := source.conn.OpenUniStreamSync(ctx)
+		assert.NoError(t, err)
+		_, err = stream.Write(encodeFrames(
+			newDataFrame(fmt.Sprintf("tid-%d-1", i), "source", 0x33),
+			newDataFrame(fmt.Sprintf("tid-%d-2", i), "source", 0x33),
+		))
+		assert.NoError(t, err)
+		assert.NoError(t, stream.Close())
+		for j := 1; j <= 2; j++ {
+			select {
+			case f := <-received:
+				assert.Equal(t, fmt.Sprintf("tid-%d-%d", i, j), f.TransactionID())
+			case <-time.After(time.Second):
+				t.Fatal("the frame on the unidirectional stream is not routed")
+			}
+		}
+	}
+
+	// the other frames are rejected, the connection keeps open
+	stream, err := source.conn.OpenUniStreamSync(ctx)
+	assert.NoError(t, err)
+	_, err = stream.Write(frame.NewPingFrame().Encode())
+	assert.NoError(t, err)
+	assert.True(t, waitFor(func() bool { return s.StatsProtocolViolation() == 1 }))
+	assert.NoError(t, source.WriteFrame(newDataFrame("tid-bidi", "source", 0x33)))
+	select {
+	case f := <-received:
+		assert.Equal(t, "tid-bidi", f.TransactionID())
+	case <-time.After(time.Second):
+		t.Fatal("the connection is closed by the unidirectional stream")
+	}
+}
+
+// brokenWriter fails every write.
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// ctxStream is a stream which carries a context like quic.Stream.
+type ctxStream struct {
+	*mockStream
+	ctx context.Context
+}
+
+func (s *ctxStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestServerLiveFunctions(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2", "sfn-3")
+	connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	s.connector.Add("sfn-2-conn", &mockStream{w: brokenWriter{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.connector.Add("sfn-3-conn", &ctxStream{mockStream: &mockStream{w: &syncBuffer{}}, ctx: ctx})
+	assert.Len(t, s.LiveFunctions(), 3)
+
+	// the write to sfn-2 fails
+	assert.NoError(t, s.connector.WriteControl(frame.NewPingFrame(), "sfn-2-conn"))
+	assert.True(t, waitFor(func() bool { return len(s.LiveFunctions()) == 2 }))
+	assert.NotContains(t, s.LiveFunctions(), "sfn-2-conn")
+
+	// the stream of sfn-3 is closed
+	cancel()
+	live := s.LiveFunctions()
+	assert.Len(t, live, 1)
+	assert.Contains(t, live, "sfn-1-conn")
+	// they are still registered until the disconnect is cleaned up
+	assert.Len(t, s.StatsFunctions(), 3)
+}
+
+func TestRejectRegisteredConnection(t *testing.T) {
+	s := newTestServer("sfn-1")
+	out := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	defer w.Close()
+	go s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: r, w: out}})
+	w.Write(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil).Encode())
+	assert.True(t, waitFor(func() bool { return s.connector.Get("source-conn") != nil }))
+
+	// the RejectedFrame is queued behind the frame being written rather than written directly
+	assert.NoError(t, s.connector.WriteControl(frame.NewPingFrame(), "source-conn"))
+	s.reject(&Context{ConnID: "source-conn", Stream: &mockStream{w: out}}, frame.RejectedMessagePaused)
+	assert.Empty(t, out.Frames())
+	close(out.gate)
+	assert.True(t, waitFor(func() bool { return len(out.Frames()) == 2 }))
+	assert.Equal(t, frame.TagOfRejectedFrame, out.Frames()[1].Type())
+}
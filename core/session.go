@@ -0,0 +1,349 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/yomorun/yomo/core/log"
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// ErrSessionNotFound is returned by CloseSession when the session doesn't exist.
+var ErrSessionNotFound = errors.New("session not found")
+
+// errClosedByAdmin is the error of the DisconnectReason of the sessions closed by CloseSession.
+var errClosedByAdmin = errors.New("session closed by admin")
+
+// SessionInfo describes a session, i.e. a connection accepted by the server.
+type SessionInfo struct {
+	// ID is the connection id.
+	ID string
+	// RequestID is the short unique id in the "request_id" field of the session's log lines.
+	RequestID string
+	// RemoteAddr is the current address of the client.
+	RemoteAddr string
+	// AppID is the app id of the client, empty if it hasn't registered.
+	AppID string
+	// Name is the name of the client, empty if it hasn't registered.
+	Name string
+	// ClientType is the role of the client, ClientTypeNone if it hasn't registered.
+	ClientType ClientType
+	// BytesReceived is the size of the frames received from the client.
+	BytesReceived int64
+	// FramesReceived is the number of the frames received from the client.
+	FramesReceived int64
+	// CreatedAt is the time the session is accepted.
+	CreatedAt time.Time
+	// Idle is how long the session hasn't received any frame.
+	Idle time.Duration
+	// Resumed reports whether the TLS session is resumed by a session ticket rather than a
+	// full handshake, see WithSessionTicketKeys. The server doesn't accept 0-RTT data, so a
+	// resumed session still takes a round trip.
+	Resumed bool
+	// HandshakeDuration is how long the QUIC handshake took, 0 if it isn't known.
+	HandshakeDuration time.Duration
+	// Buffering is the frames buffered by the server to be written to the client, quic-go
+	// doesn't expose the flow control windows, so a PendingWrite that keeps growing while
+	// the queue is short means the QUIC send buffer or the peer's receive window is full.
+	Buffering BufferStat
+	// TLS is the security parameters negotiated by the TLS handshake of the connection.
+	TLS TLSInfo
+}
+
+// TLSInfo describes the security parameters negotiated by the TLS handshake, e.g. to audit
+// that all the connections use TLS 1.3 and the expected ALPN.
+type TLSInfo struct {
+	// Version is the TLS version, e.g. tls.VersionTLS13.
+	Version uint16
+	// CipherSuite is the cipher suite, e.g. tls.TLS_AES_128_GCM_SHA256.
+	CipherSuite uint16
+	// ALPN is the negotiated application protocol, ALPN or ALPNDeflate.
+	ALPN string
+	// ServerName is the server name indicated by the client, empty if it's not sent.
+	ServerName string
+	// PeerSubject is the subject of the client certificate, empty if the client doesn't
+	// present one, see WithServerTLSConfig.
+	PeerSubject string
+}
+
+// newTLSInfo returns the TLSInfo of the connection state.
+func newTLSInfo(state quic.ConnectionState) TLSInfo {
+	info := TLSInfo{
+		Version:     state.TLS.Version,
+		CipherSuite: state.TLS.CipherSuite,
+		ALPN:        state.TLS.NegotiatedProtocol,
+		ServerName:  state.TLS.ServerName,
+	}
+	if len(state.TLS.PeerCertificates) > 0 {
+		info.PeerSubject = state.TLS.PeerCertificates[0].Subject.String()
+	}
+	return info
+}
+
+// VersionName returns the name of the TLS version, e.g. "TLS 1.3".
+func (i TLSInfo) VersionName() string {
+	switch i.Version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	case 0:
+		return ""
+	}
+	return fmt.Sprintf("0x%04X", i.Version)
+}
+
+// CipherSuiteName returns the name of the cipher suite, e.g. "TLS_AES_128_GCM_SHA256".
+func (i TLSInfo) CipherSuiteName() string {
+	if i.CipherSuite == 0 {
+		return ""
+	}
+	return tls.CipherSuiteName(i.CipherSuite)
+}
+
+// session tracks a connection accepted by the server.
+type session struct {
+	id         string
+	requestID  string
+	logger     log.Logger // adds the request id to the log lines of the session
+	conn       quic.Connection
+	ip         string // the remote IP when it's accepted
+	createdAt  time.Time
+	bytes      int64
+	frames     int64
+	lastActive int64 // unix nano
+	resumed    bool  // the TLS session is resumed
+	handshake  time.Duration
+	tls        TLSInfo
+}
+
+func newSession(id string, conn quic.Connection, now time.Time) *session {
+	requestID := newRequestID()
+	return &session{
+		id:         id,
+		requestID:  requestID,
+		logger:     logger.With("request_id", requestID),
+		conn:       conn,
+		ip:         remoteIP(conn.RemoteAddr()),
+		createdAt:  now,
+		lastActive: now.UnixNano(),
+		resumed:    conn.ConnectionState().TLS.DidResume,
+		tls:        newTLSInfo(conn.ConnectionState()),
+	}
+}
+
+// newRequestID generates a short random id to correlate the log lines of a session.
+func newRequestID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%012x", time.Now().UnixNano()&0xFFFFFFFFFFFF)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newConnID generates the id of an accepted connection. The remote address doesn't identify
+// the connection, it changes when the connection migrates and it's reused by another
+// connection later.
+func newConnID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// remoteIP returns the IP of the address without the port.
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case nil:
+		return ""
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// ipCounter counts the sessions per remote IP.
+type ipCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// acquire counts a session of the ip, returns false if the ip already has max sessions,
+// max <= 0 means unlimited.
+func (c *ipCounter) acquire(ip string, max int) bool {
+	if max <= 0 {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	if c.counts[ip] >= max {
+		return false
+	}
+	c.counts[ip]++
+	return true
+}
+
+// release a session of the ip counted by acquire.
+func (c *ipCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.counts[ip]; ok {
+		if n <= 1 {
+			delete(c.counts, ip)
+		} else {
+			c.counts[ip] = n - 1
+		}
+	}
+}
+
+// count returns the number of the sessions of the ip.
+func (c *ipCounter) count(ip string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[ip]
+}
+
+// Logger returns the logger of the session, the default logger if it's not tracked.
+func (s *session) Logger() log.Logger {
+	if s == nil {
+		return logger.With()
+	}
+	return s.logger
+}
+
+// observe a frame of n bytes received at now.
+func (s *session) observe(n int64, now time.Time) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.bytes, n)
+	atomic.AddInt64(&s.frames, 1)
+	atomic.StoreInt64(&s.lastActive, now.UnixNano())
+}
+
+// session returns the session of the connection, nil if it's not tracked.
+func (s *Server) session(connID string) *session {
+	if v, ok := s.registry.Load(connID); ok {
+		return v.(*session)
+	}
+	return nil
+}
+
+// ListSessions returns all the sessions ordered by the time they're accepted.
+func (s *Server) ListSessions() []SessionInfo {
+	now := s.opts.Clock.Now()
+	result := make([]SessionInfo, 0)
+	s.registry.Range(func(key interface{}, val interface{}) bool {
+		result = append(result, s.sessionInfo(val.(*session), now))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// sessionInfo returns the SessionInfo of the session at now.
+func (s *Server) sessionInfo(sess *session, now time.Time) SessionInfo {
+	info := SessionInfo{
+		ID:                sess.id,
+		RequestID:         sess.requestID,
+		RemoteAddr:        sess.conn.RemoteAddr().String(),
+		ClientType:        s.clientType(sess.id),
+		BytesReceived:     atomic.LoadInt64(&sess.bytes),
+		FramesReceived:    atomic.LoadInt64(&sess.frames),
+		CreatedAt:         sess.createdAt,
+		Idle:              now.Sub(time.Unix(0, atomic.LoadInt64(&sess.lastActive))),
+		Resumed:           sess.resumed,
+		HandshakeDuration: sess.handshake,
+		TLS:               sess.tls,
+	}
+	info.Buffering, _ = s.connector.Buffering(sess.id)
+	if info.ClientType != ClientTypeNone {
+		if app, ok := s.connector.App(sess.id); ok {
+			info.AppID = app.ID()
+			info.Name = app.Name()
+		}
+	}
+	return info
+}
+
+// connected invokes the connect handler after the app of the connection is registered.
+func (s *Server) connected(connID string) {
+	if s.connectHandler == nil {
+		return
+	}
+	if sess := s.session(connID); sess != nil {
+		s.connectHandler(s.sessionInfo(sess, s.opts.Clock.Now()))
+		return
+	}
+	// the connection isn't tracked, e.g. it's served by a custom listener
+	info := SessionInfo{ID: connID, ClientType: s.clientType(connID)}
+	if app, ok := s.connector.App(connID); ok {
+		info.AppID = app.ID()
+		info.Name = app.Name()
+	}
+	s.connectHandler(info)
+}
+
+// CloseSession closes the session, e.g. a stuck or abusive client, its app is deregistered
+// and the disconnect handler is invoked before the connection is closed.
+func (s *Server) CloseSession(id string) error {
+	sess := s.session(id)
+	if sess == nil {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	s.deregister(id, DisconnectReason{Cause: DisconnectServerClose, Err: errClosedByAdmin})
+	return sess.conn.CloseWithError(0xC3, errClosedByAdmin.Error())
+}
+
+// deregister removes the app of the connection, the frames are no longer routed to it. It's
+// the only teardown of the connections, whoever removes the app from the connector first
+// runs it, so the disconnect handler and the stats see every connection once. It returns
+// false if the connection isn't registered, or it's deregistered already.
+func (s *Server) deregister(connID string, reason DisconnectReason) bool {
+	if _, ok := s.connector.App(connID); !ok {
+		return false
+	}
+	app, ok := s.connector.Remove(connID)
+	if !ok {
+		return false
+	}
+	s.stages.unlink(connID)
+	s.releaseAckReceiver(connID)
+	s.heartbeats.Delete(connID)
+	s.observers.Delete(connID)
+	s.connStats.disconnect(app.ClientType())
+	s.deregisterApp(connID, app)
+	log.With(s.session(connID).Logger(), "conn_id", connID, "name", app.Name(), "cause", reason.Cause.String()).
+		Printf("%s💔 [%s::%s](%s) is deregistered, reason: %s", ServerLogPrefix, app.ID(), app.Name(), connID, reason)
+	if s.disconnectHandler != nil {
+		s.disconnectHandler(connID, app.Name(), reason)
+	}
+	return true
+}
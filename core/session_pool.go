@@ -0,0 +1,58 @@
+package core
+
+import "context"
+
+// SessionPoolPolicy decides what to do with the excess sessions when the pool is full.
+type SessionPoolPolicy byte
+
+const (
+	// SessionPoolQueue waits for a session to finish before accepting the next one.
+	SessionPoolQueue SessionPoolPolicy = iota
+	// SessionPoolReject rejects the excess sessions with a RejectedFrame.
+	SessionPoolReject
+)
+
+// sessionPool bounds the number of the concurrent session handlers, a nil pool is unlimited.
+type sessionPool struct {
+	slots  chan struct{}
+	policy SessionPoolPolicy
+}
+
+func newSessionPool(size int, policy SessionPoolPolicy) *sessionPool {
+	if size <= 0 {
+		return nil
+	}
+	return &sessionPool{
+		slots:  make(chan struct{}, size),
+		policy: policy,
+	}
+}
+
+// acquire a slot, returns false if the session should be rejected.
+func (p *sessionPool) acquire(ctx context.Context) bool {
+	if p == nil {
+		return true
+	}
+	if p.policy == SessionPoolReject {
+		select {
+		case p.slots <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release a slot.
+func (p *sessionPool) release() {
+	if p == nil {
+		return
+	}
+	<-p.slots
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestListAndCloseSessions(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := newTestServer("sfn-1")
+	s.opts.Clock = fake
+	reasons := make(chan DisconnectReason, 1)
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) {
+		reasons <- reason
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection)}
+	go s.serve(ctx, listener)
+
+	// a registered sfn and an unregistered client, both streams keep open
+	handshake := frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil)
+	r, w := io.Pipe()
+	go w.Write(handshake.Encode())
+	sfn := newMockConn(1)
+	sfn.streams <- &mockQuicStream{mockStream: &mockStream{r: r, w: &syncBuffer{}}}
+	listener.conns <- sfn
+	idle, _ := io.Pipe()
+	unregistered := newMockConn(2)
+	unregistered.streams <- &mockQuicStream{mockStream: &mockStream{r: idle, w: &syncBuffer{}}}
+	listener.conns <- unregistered
+	var sfnID, unregisteredID string
+	assert.True(t, waitFor(func() bool {
+		sfnID, unregisteredID = sessionID(s, sfn), sessionID(s, unregistered)
+		return len(s.ListSessions()) == 2 && s.connector.Get(sfnID) != nil
+	}))
+
+	fake.Advance(5 * time.Second)
+	sessions := s.ListSessions()
+	// the sessions are created at the same time, they're sorted by the ids
+	if sessions[0].ID != sfnID {
+		sessions[0], sessions[1] = sessions[1], sessions[0]
+	}
+	assert.Len(t, sessions[0].RequestID, 12)
+	assert.NotEqual(t, sessions[0].RequestID, sessions[1].RequestID)
+	assert.Equal(t, SessionInfo{
+		ID:             sfnID,
+		RequestID:      sessions[0].RequestID,
+		RemoteAddr:     "127.0.0.1:1",
+		Name:           "sfn-1",
+		ClientType:     ClientTypeStreamFunction,
+		BytesReceived:  int64(len(handshake.Encode())),
+		FramesReceived: 1,
+		CreatedAt:      time.Unix(0, 0),
+		Idle:           5 * time.Second,
+	}, sessions[0])
+	assert.Equal(t, unregisteredID, sessions[1].ID)
+	assert.Equal(t, ClientTypeNone, sessions[1].ClientType)
+	assert.EqualValues(t, 0, sessions[1].FramesReceived)
+
+	assert.NoError(t, s.CloseSession(sfnID))
+	assert.Nil(t, s.connector.Get(sfnID))
+	reason := <-reasons
+	assert.Equal(t, DisconnectServerClose, reason.Cause)
+	assert.Equal(t, errClosedByAdmin.Error(), <-sfn.closed)
+
+	assert.True(t, errors.Is(s.CloseSession("unknown"), ErrSessionNotFound))
+}
+
+// sessionID returns the id of the session accepted from the conn, "" if there's none.
+func sessionID(s *Server, conn quic.Connection) string {
+	for _, info := range s.ListSessions() {
+		if info.RemoteAddr == conn.RemoteAddr().String() {
+			return info.ID
+		}
+	}
+	return ""
+}
+
+func TestSessionLogger(t *testing.T) {
+	s := newTestServer("sfn-1")
+	conn := newMockConn(1)
+	sess := newSession(GetConnID(conn), conn, time.Now())
+	s.registry.Store(sess.id, sess)
+	defer s.registry.Delete(sess.id)
+
+	// the handlers of the session log by the session logger
+	c := &Context{ConnID: sess.id, Stream: &mockStream{r: bytes.NewReader(nil), w: ioutil.Discard}}
+	s.handleConnection(context.Background(), c)
+	assert.Same(t, sess.Logger(), c.Logger())
+
+	// the untracked connections log by the default logger
+	var untracked *session
+	assert.NotNil(t, untracked.Logger())
+	assert.NotNil(t, (&Context{}).Logger())
+}
+
+func TestSessionResumption(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &mockListener{conns: make(chan quic.Connection)}
+	go s.serve(ctx, listener)
+
+	// the handshake of the resumed session starts before it's accepted
+	resumed := newMockConn(1)
+	resumed.state.TLS.DidResume = true
+	resumed.ctx = context.WithValue(context.Background(), quic.ConnectionTracingKey, uint64(1))
+	assert.Nil(t, s.handshakes.TracerForConnection(resumed.ctx, logging.PerspectiveServer, nil))
+	fake.Advance(30 * time.Millisecond)
+	full := newMockConn(2)
+	for _, conn := range []*mockConn{resumed, full} {
+		idle, _ := io.Pipe()
+		conn.streams <- &mockQuicStream{mockStream: &mockStream{r: idle, w: &syncBuffer{}}}
+		listener.conns <- conn
+	}
+	assert.True(t, waitFor(func() bool { return len(s.ListSessions()) == 2 }))
+
+	sessions := s.ListSessions()
+	if sessions[0].ID != sessionID(s, resumed) {
+		sessions[0], sessions[1] = sessions[1], sessions[0]
+	}
+	assert.True(t, sessions[0].Resumed)
+	assert.Equal(t, 30*time.Millisecond, sessions[0].HandshakeDuration)
+	assert.False(t, sessions[1].Resumed)
+	assert.Zero(t, sessions[1].HandshakeDuration)
+	stats := s.Stats()
+	assert.EqualValues(t, 2, stats.Accepted)
+	assert.EqualValues(t, 1, stats.Resumed)
+
+	// the tracer is added to the quic config
+	qc := s.handshakes.apply(nil)
+	assert.Equal(t, s.handshakes, qc.Tracer)
+	assert.Nil(t, defaultServerQuicConfig().Tracer)
+}
+
+func TestConnectHandlerTLS(t *testing.T) {
+	s := NewServer("test-zipper")
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	infos := make(chan SessionInfo, 1)
+	s.SetConnectHandler(func(info SessionInfo) { infos <- info })
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	client := NewClient("source", ClientTypeSource)
+	assert.NoError(t, client.Connect(ctx, addr))
+	defer client.Close()
+
+	select {
+	case info := <-infos:
+		assert.Equal(t, "source", info.Name)
+		assert.Equal(t, ClientTypeSource, info.ClientType)
+		assert.Equal(t, "TLS 1.3", info.TLS.VersionName())
+		assert.NotEmpty(t, info.TLS.CipherSuiteName())
+		assert.Equal(t, ALPN, info.TLS.ALPN)
+		assert.Empty(t, info.TLS.PeerSubject)
+	case <-time.After(time.Second):
+		t.Fatal("the connect handler isn't invoked")
+	}
+	sessions := s.ListSessions()
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, uint16(tls.VersionTLS13), sessions[0].TLS.Version)
+	}
+}
+
+func TestTLSInfoNames(t *testing.T) {
+	assert.Equal(t, "TLS 1.2", TLSInfo{Version: tls.VersionTLS12}.VersionName())
+	assert.Equal(t, "0x0999", TLSInfo{Version: 0x0999}.VersionName())
+	assert.Empty(t, TLSInfo{}.VersionName())
+	assert.Empty(t, TLSInfo{}.CipherSuiteName())
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", TLSInfo{CipherSuite: tls.TLS_AES_128_GCM_SHA256}.CipherSuiteName())
+}
+
+func TestDeregisterOnce(t *testing.T) {
+	s := newTestServer("sfn-1")
+	var disconnects int64
+	s.SetDisconnectHandler(func(connID string, name string, reason DisconnectReason) {
+		atomic.AddInt64(&disconnects, 1)
+	})
+	connectSfn(s, "sfn-conn", "sfn-1", 0x33)
+
+	// e.g. the eviction races with the close of the connection
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.deregister("sfn-conn", DisconnectReason{Cause: DisconnectWriteError})
+		}()
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt64(&disconnects))
+	assert.Equal(t, ConnectionStat{Connects: 1, Disconnects: 1}, s.StatsConnectionChurn()[ClientTypeStreamFunction])
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// ShutdownPhase describes the progress of Server.Shutdown.
+type ShutdownPhase = string
+
+// The phases of the shutdown, in this order.
+const (
+	// ShutdownNone means the server isn't shutting down.
+	ShutdownNone ShutdownPhase = ""
+	// ShutdownSources means the sources are being closed, their DataFrames are rejected.
+	ShutdownSources ShutdownPhase = "Sources"
+	// ShutdownDraining means the DataFrames queued to the stages are being written.
+	ShutdownDraining ShutdownPhase = "Draining"
+	// ShutdownClosing means the stream functions and the other connections are being closed.
+	ShutdownClosing ShutdownPhase = "Closing"
+	// ShutdownDone means the server is closed.
+	ShutdownDone ShutdownPhase = "Done"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether the stages are drained.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// errServerShutdown is the reason of the connections closed by Shutdown.
+var errServerShutdown = errors.New("server shutdown")
+
+// ShutdownProgress describes how far Server.Shutdown goes.
+type ShutdownProgress struct {
+	// Phase is the current phase.
+	Phase ShutdownPhase
+	// Stage is the workflow token of the stage being drained.
+	Stage string
+	// Drained is the workflow tokens of the drained stages in the order of the workflow.
+	Drained []string
+	// Queued is the number of the DataFrames still queued to the connections.
+	Queued int
+}
+
+// Shutdown closes the server without losing the DataFrames in-flight: the sources are closed
+// first and the new DataFrames of the sources are rejected, then it waits until the stages are
+// drained one by one in the order of the workflow, then the stream functions and the other
+// connections are closed. If the ctx is done before the stages are drained, the connections
+// are closed anyway and the error of the ctx is returned. The progress is reported by
+// ShutdownProgress and Stats.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.setState(ServerStateDraining)
+	s.setShutdown(func(p *ShutdownProgress) { p.Phase = ShutdownSources })
+	logger.Printf("%s[%s] shutting down, close the sources", ServerLogPrefix, s.name)
+	s.Pause()
+	s.closeClients(ClientTypeSource)
+
+	s.setShutdown(func(p *ShutdownProgress) { p.Phase = ShutdownDraining })
+	err := s.drainStages(ctx)
+	if err != nil {
+		logger.Warnf("%s[%s] stages aren't drained: %v, queued=%d", ServerLogPrefix, s.name, err, s.queued())
+	}
+
+	s.setShutdown(func(p *ShutdownProgress) {
+		p.Phase = ShutdownClosing
+		p.Stage = ""
+	})
+	logger.Printf("%s[%s] close the stream functions", ServerLogPrefix, s.name)
+	s.closeClients(ClientTypeStreamFunction, ClientTypeUpstreamZipper, ClientTypeObserver)
+	s.Close()
+	s.setShutdown(func(p *ShutdownProgress) { p.Phase = ShutdownDone })
+	return err
+}
+
+// ShutdownProgress returns the progress of Shutdown, the phase is ShutdownNone if it isn't
+// called.
+func (s *Server) ShutdownProgress() ShutdownProgress {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	p := s.shutdown
+	p.Drained = append([]string(nil), p.Drained...)
+	p.Queued = s.queued()
+	return p
+}
+
+func (s *Server) setShutdown(update func(p *ShutdownProgress)) {
+	s.shutdownMu.Lock()
+	update(&s.shutdown)
+	s.shutdownMu.Unlock()
+}
+
+// drainStages waits until the DataFrames queued to every stage are written and its stream
+// functions are done with them, i.e. they send nothing for the drain quiet period, since the
+// stream functions don't ack the frames. The stages are waited in the order of the workflow,
+// since a stage is refilled by the stage ahead of it. Finally it waits until nothing is
+// queued to any connection, e.g. the downstream zippers.
+func (s *Server) drainStages(ctx context.Context) error {
+	for _, token := range s.stageOrder() {
+		s.setShutdown(func(p *ShutdownProgress) { p.Stage = token })
+		busy := func() bool { return s.stages.depths(s.connector.Pending)[token] > 0 }
+		linked := func(connID string) bool { return s.stages.linkedTo(connID, token) }
+		if err := s.waitQuiet(ctx, busy, linked); err != nil {
+			return err
+		}
+		logger.Infof("%s[%s] stage %s is drained", ServerLogPrefix, s.name, token)
+		s.setShutdown(func(p *ShutdownProgress) { p.Drained = append(p.Drained, token) })
+	}
+	return s.waitDrained(ctx, func() bool { return s.queued() == 0 })
+}
+
+// waitDrained polls until drained reports true, or the ctx is done.
+func (s *Server) waitDrained(ctx context.Context, drained func() bool) error {
+	for !drained() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.opts.Clock.After(shutdownPollInterval):
+		}
+	}
+	return nil
+}
+
+// stageOrder returns the workflow tokens of the connected stream functions in the order of
+// their workflows.
+func (s *Server) stageOrder() []string {
+	if s.router == nil {
+		return nil
+	}
+	tokens := make([]string, 0)
+	seen := make(map[string]bool)
+	apps := make(map[string]bool)
+	for connID := range s.connector.GetSnapshot() {
+		app, ok := s.connector.App(connID)
+		if !ok || apps[app.ID()] {
+			continue
+		}
+		apps[app.ID()] = true
+		route := s.router.Route(app.ID())
+		if isNilRoute(route) {
+			continue
+		}
+		for _, token := range route.GetForwardRoutes("") {
+			if !seen[token] {
+				seen[token] = true
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// queued returns the number of the DataFrames queued to all the connections.
+func (s *Server) queued() int {
+	n := 0
+	for connID := range s.connector.GetSnapshot() {
+		n += s.connector.Depth(connID)
+	}
+	return n
+}
+
+// closeClients deregisters the connections of the client types and closes them.
+func (s *Server) closeClients(types ...ClientType) {
+	for connID := range s.connector.GetSnapshot() {
+		app, ok := s.connector.App(connID)
+		if !ok || !containsClientType(types, app.ClientType()) {
+			continue
+		}
+		// the teardown runs once if it's evicted meanwhile, the connection is closed anyway
+		s.deregister(connID, DisconnectReason{Cause: DisconnectServerClose, Err: errServerShutdown})
+		if sess := s.session(connID); sess != nil && sess.conn != nil {
+			sess.conn.CloseWithError(0xC4, errServerShutdown.Error())
+		}
+	}
+}
+
+func containsClientType(types []ClientType, t ClientType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestServer("sfn-1", "sfn-2")
+	assert.Equal(t, ShutdownNone, s.ShutdownProgress().Phase)
+
+	sfn1 := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-1-conn", Stream: &mockStream{r: r, w: sfn1}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-1-conn") != nil }))
+	connectSfn(s, "sfn-2-conn", "sfn-2", 0x34)
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+		newDataFrame("tid-3", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return s.QueueDepths()["sfn-1"] == 2 }))
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+
+	// the source is closed first, the first stage is being drained
+	assert.True(t, waitFor(func() bool { return s.ShutdownProgress().Stage == "sfn-1" }))
+	_, ok := s.connector.App("source-conn")
+	assert.False(t, ok)
+	progress := s.Stats().Shutdown
+	assert.Equal(t, ShutdownDraining, progress.Phase)
+	assert.Equal(t, 2, progress.Queued)
+	assert.Empty(t, progress.Drained)
+	_, ok = s.connector.App("sfn-1-conn")
+	assert.True(t, ok)
+
+	close(sfn1.gate)
+	assert.NoError(t, <-done)
+	assert.Len(t, sfn1.Frames(), 3)
+	progress = s.ShutdownProgress()
+	assert.Equal(t, ShutdownDone, progress.Phase)
+	assert.Equal(t, []string{"sfn-1", "sfn-2"}, progress.Drained)
+	assert.Equal(t, 0, progress.Queued)
+	_, ok = s.connector.App("sfn-1-conn")
+	assert.False(t, ok)
+	assert.Equal(t, ServerStateClosed, s.State())
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s := newTestServer("sfn-1")
+	sfn := &gateWriter{gate: make(chan struct{})}
+	defer close(sfn.gate)
+	r, w := io.Pipe()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-conn") != nil }))
+
+	source := encodeFrames(
+		frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil),
+		newDataFrame("tid-1", "source", 0x33),
+		newDataFrame("tid-2", "source", 0x33),
+	)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(source), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return s.QueueDepths()["sfn-1"] == 1 }))
+
+	// the stream functions are closed anyway
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	progress := s.ShutdownProgress()
+	assert.Equal(t, ShutdownDone, progress.Phase)
+	assert.Empty(t, progress.Drained)
+	_, ok := s.connector.App("sfn-conn")
+	assert.False(t, ok)
+}
+
+func TestServerShutdownQuiet(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewServer("test-zipper", WithServerClock(fake), WithDrainQuietPeriod(time.Second))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+	connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	sfn2 := connectSfn(s, "sfn-2-conn", "sfn-2", 0x34)
+	advance := func(d time.Duration) {
+		waitFor(func() bool { return fake.Waiters() > 0 })
+		fake.Advance(d)
+	}
+
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	assert.True(t, waitFor(func() bool { return s.ShutdownProgress().Stage == "sfn-1" }))
+
+	// nothing is queued to sfn-1, but it still sends the output of the frame it's processing
+	advance(500 * time.Millisecond)
+	assert.NoError(t, s.mainFrameHandler(&Context{ConnID: "sfn-1-conn", Frame: newDataFrame("tid-1", "sfn-1", 0x34)}))
+	advance(800 * time.Millisecond)
+	assert.Empty(t, s.ShutdownProgress().Drained)
+
+	advance(200 * time.Millisecond)
+	assert.True(t, waitFor(func() bool { return len(s.ShutdownProgress().Drained) == 1 }))
+	for len(s.ShutdownProgress().Drained) < 2 {
+		advance(100 * time.Millisecond)
+	}
+	assert.NoError(t, <-done)
+	// the output is written before sfn-2 is closed
+	assert.Len(t, sfn2.Frames(), 1)
+}
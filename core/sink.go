@@ -0,0 +1,12 @@
+package core
+
+import "github.com/yomorun/yomo/core/frame"
+
+// TerminalSink receives the DataFrames emitted by the terminal stage of the workflow,
+// which are dropped if there's no sink.
+type TerminalSink interface {
+	// Write a DataFrame to the sink.
+	Write(f *frame.DataFrame) error
+	// Close the sink.
+	Close() error
+}
@@ -0,0 +1,235 @@
+// Package sink provides the implementations of core.TerminalSink.
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// FileOptions are the options of FileSink.
+type FileOptions struct {
+	// MaxSize is the size in bytes which the file is rotated at, 0 means never rotate.
+	MaxSize int64
+	// MaxBackups is the number of the rotated files to keep, the rotated files are
+	// named path.1 (the newest) to path.MaxBackups. default is 1.
+	MaxBackups int
+	// FlushInterval is the interval flushing the buffered records to the file, 0 means
+	// every record is flushed when it's written.
+	FlushInterval time.Duration
+	// SyncInterval is the interval committing the file to the stable storage by fsync,
+	// 0 means the file is synced only when it's rotated or closed.
+	SyncInterval time.Duration
+	// Clock is the source of the record timestamps and the intervals.
+	Clock clock.Clock
+}
+
+// FileOption configures FileSink.
+type FileOption func(o *FileOptions)
+
+// WithMaxSize rotates the file when it exceeds size bytes, keeps backups rotated files.
+func WithMaxSize(size int64, backups int) FileOption {
+	return func(o *FileOptions) {
+		o.MaxSize = size
+		o.MaxBackups = backups
+	}
+}
+
+// WithFlushInterval flushes the buffered records every interval.
+func WithFlushInterval(interval time.Duration) FileOption {
+	return func(o *FileOptions) {
+		o.FlushInterval = interval
+	}
+}
+
+// WithSyncInterval fsyncs the file every interval.
+func WithSyncInterval(interval time.Duration) FileOption {
+	return func(o *FileOptions) {
+		o.SyncInterval = interval
+	}
+}
+
+// WithClock sets the clock of the sink.
+func WithClock(c clock.Clock) FileOption {
+	return func(o *FileOptions) {
+		o.Clock = c
+	}
+}
+
+// record is a line of the file.
+type record struct {
+	Time     time.Time `json:"time"`
+	TID      string    `json:"tid"`
+	Tag      byte      `json:"tag"`
+	Carriage []byte    `json:"carriage"`
+}
+
+// FileSink appends the carriages of the DataFrames to a file as JSON lines, each line
+// has the timestamp, the transaction id, the tag and the base64 encoded carriage.
+type FileSink struct {
+	path   string
+	opts   FileOptions
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	done   chan struct{}
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// NewFileSink opens the file at path in append mode and creates a FileSink.
+func NewFileSink(path string, opts ...FileOption) (*FileSink, error) {
+	s := &FileSink{
+		path: path,
+		done: make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(&s.opts)
+	}
+	if s.opts.MaxBackups <= 0 {
+		s.opts.MaxBackups = 1
+	}
+	if s.opts.Clock == nil {
+		s.opts.Clock = clock.New()
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if s.opts.FlushInterval > 0 {
+		s.every(s.opts.FlushInterval, func() error { return s.buf.Flush() })
+	}
+	if s.opts.SyncInterval > 0 {
+		s.every(s.opts.SyncInterval, s.sync)
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	if s.buf == nil {
+		s.buf = bufio.NewWriter(file)
+	} else {
+		s.buf.Reset(file)
+	}
+	return nil
+}
+
+// every runs fn with the lock held every interval until the sink is closed.
+func (s *FileSink) every(interval time.Duration, fn func() error) {
+	ticker := s.opts.Clock.NewTicker(interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				s.mu.Lock()
+				if !s.closed {
+					fn()
+				}
+				s.mu.Unlock()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Write implements core.TerminalSink.
+func (s *FileSink) Write(f *frame.DataFrame) error {
+	line, err := json.Marshal(record{
+		Time:     s.opts.Clock.Now(),
+		TID:      f.TransactionID(),
+		Tag:      f.GetDataTag(),
+		Carriage: f.GetCarriage(),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("sink: file %s is closed", s.path)
+	}
+	if s.opts.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.opts.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.buf.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.opts.FlushInterval == 0 {
+		return s.buf.Flush()
+	}
+	return nil
+}
+
+func (s *FileSink) sync() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// rotate renames path.i to path.i+1, the current file to path.1, then opens a new file.
+func (s *FileSink) rotate() error {
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	for i := s.opts.MaxBackups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", s.path, i)
+		if _, err := os.Stat(src); err == nil {
+			if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// Close implements core.TerminalSink, the buffered records are flushed and synced.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	err := s.sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/clock"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func newDataFrame(tid string, carriage string) *frame.DataFrame {
+	f := frame.NewDataFrame()
+	f.SetTransactionID(tid)
+	f.SetCarriage(0x33, []byte(carriage))
+	return f
+}
+
+func readRecords(t *testing.T, path string) []record {
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	records := []record{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r record
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestFileSink(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "terminal.log")
+	s, err := NewFileSink(path, WithClock(clock.NewFake(now)))
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Write(newDataFrame("tid-1", "hello")))
+	assert.NoError(t, s.Write(newDataFrame("tid-2", "yomo")))
+	records := readRecords(t, path)
+	assert.Equal(t, []record{
+		{Time: now, TID: "tid-1", Tag: 0x33, Carriage: []byte("hello")},
+		{Time: now, TID: "tid-2", Tag: 0x33, Carriage: []byte("yomo")},
+	}, records)
+
+	assert.NoError(t, s.Close())
+	assert.Error(t, s.Write(newDataFrame("tid-3", "closed")))
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	// the records are of the same size at a fixed time
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "terminal.log")
+	line, _ := json.Marshal(record{Time: now, TID: "tid-0", Tag: 0x33, Carriage: []byte("data")})
+	// two records per file
+	s, err := NewFileSink(path, WithClock(clock.NewFake(now)), WithMaxSize(int64(2*(len(line)+1)), 2))
+	assert.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, s.Write(newDataFrame(fmt.Sprintf("tid-%d", i), "data")))
+	}
+	assert.NoError(t, s.Close())
+
+	tids := func(path string) []string {
+		result := []string{}
+		for _, r := range readRecords(t, path) {
+			result = append(result, r.TID)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"tid-6"}, tids(path))
+	assert.Equal(t, []string{"tid-4", "tid-5"}, tids(path+".1"))
+	assert.Equal(t, []string{"tid-2", "tid-3"}, tids(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileSinkFlushInterval(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	path := filepath.Join(t.TempDir(), "terminal.log")
+	s, err := NewFileSink(path, WithClock(fake), WithFlushInterval(time.Second))
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.NoError(t, s.Write(newDataFrame("tid-1", "hello")))
+	assert.Empty(t, readRecords(t, path))
+
+	fake.Advance(time.Second)
+	assert.Eventually(t, func() bool { return len(readRecords(t, path)) == 1 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// sniConfigForClient fails the TLS handshake of the server names which aren't in the
+// SNITenants, the others are handed to the next GetConfigForClient of the tls config.
+func (s *Server) sniConfigForClient(next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if _, ok := s.opts.SNITenants[hello.ServerName]; !ok {
+			return nil, fmt.Errorf("unknown server name %q", hello.ServerName)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+}
+
+// sniTenant returns the tenant of the server name of the session and the server name, false
+// if the server name isn't in the SNITenants.
+func (s *Server) sniTenant(connID string) (string, string, bool) {
+	serverName := ""
+	if sess := s.session(connID); sess != nil {
+		serverName = sess.tls.ServerName
+	}
+	tenant, ok := s.opts.SNITenants[serverName]
+	return tenant, serverName, ok
+}
+
+// tenantOf returns the app id the connection is routed by. It's the tenant of the server
+// name of the session if the SNITenants is set, the app id of the handshake must be empty or
+// the same, otherwise it's the app id of the handshake.
+func (s *Server) tenantOf(connID string, appID string) (string, error) {
+	if len(s.opts.SNITenants) == 0 {
+		return appID, nil
+	}
+	tenant, serverName, ok := s.sniTenant(connID)
+	if !ok {
+		return "", fmt.Errorf("handshake rejected, unknown server name %q", serverName)
+	}
+	if appID != "" && appID != tenant {
+		return "", fmt.Errorf("handshake rejected, app id %q isn't the tenant of the server name %q", appID, serverName)
+	}
+	return tenant, nil
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// tenantRouter routes every app by its own workflow.
+type tenantRouter map[string][]string
+
+func (r tenantRouter) Route(appID string) Route {
+	return (&testRouter{names: r[appID]}).Route(appID)
+}
+
+func (r tenantRouter) Clean() {}
+
+func sniClient(name string, clientType ClientType, serverName string, opts ...ClientOption) *Client {
+	tc := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{ALPN}, ServerName: serverName}
+	return NewClient(name, clientType, append(opts, WithClientTLSConfig(tc))...)
+}
+
+func TestServerSNITenants(t *testing.T) {
+	s := NewServer("test-zipper", WithSNITenants(map[string]string{
+		"a.example.com": "tenant-a",
+		"b.example.com": "tenant-b",
+	}))
+	s.ConfigRouter(tenantRouter{"tenant-a": {"sfn-a"}, "tenant-b": {"sfn-b"}})
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ListenAndServe(ctx, addr)
+	assert.True(t, waitFor(func() bool { return s.State() == ServerStateListening }))
+
+	var (
+		mu        sync.Mutex
+		connected []string
+	)
+	s.SetConnectHandler(func(info SessionInfo) {
+		mu.Lock()
+		connected = append(connected, info.Name+"@"+info.AppID)
+		mu.Unlock()
+	})
+	received := make(map[string]chan string)
+	for _, tenant := range []struct{ sfn, serverName string }{{"sfn-a", "a.example.com"}, {"sfn-b", "b.example.com"}} {
+		ch := make(chan string, 2)
+		received[tenant.sfn] = ch
+		sfn := sniClient(tenant.sfn, ClientTypeStreamFunction, tenant.serverName, WithObserveDataTags(0x33))
+		sfn.SetDataFrameObserver(func(f *frame.DataFrame) { ch <- f.TransactionID() })
+		assert.NoError(t, sfn.Connect(ctx, addr))
+		defer sfn.Close()
+	}
+	assert.True(t, waitFor(func() bool { return len(s.connector.GetSnapshot()) == 2 }))
+
+	// the sfn-a isn't in the workflow of the tenant-b, its session is closed
+	intruder := sniClient("sfn-a", ClientTypeStreamFunction, "b.example.com", WithObserveDataTags(0x33))
+	assert.NoError(t, intruder.Connect(ctx, addr))
+	defer intruder.Close()
+	assert.True(t, waitFor(func() bool { return s.Stats().Accepted == 3 && len(s.ListSessions()) == 2 }))
+
+	source := sniClient("source", ClientTypeSource, "b.example.com")
+	assert.NoError(t, source.Connect(ctx, addr))
+	defer source.Close()
+	assert.True(t, waitFor(func() bool { return len(s.connector.GetSnapshot()) == 3 }))
+	assert.NoError(t, source.WriteFrame(newDataFrame("tid-b", "source", 0x33)))
+
+	select {
+	case tid := <-received["sfn-b"]:
+		assert.Equal(t, "tid-b", tid)
+	case <-time.After(time.Second):
+		t.Fatal("the frame isn't routed by the workflow of the tenant-b")
+	}
+	assert.Empty(t, received["sfn-a"])
+	mu.Lock()
+	assert.ElementsMatch(t, []string{"sfn-a@tenant-a", "sfn-b@tenant-b", "source@tenant-b"}, connected)
+	mu.Unlock()
+	for _, sess := range s.ListSessions() {
+		if sess.Name == "source" {
+			assert.Equal(t, "b.example.com", sess.TLS.ServerName)
+		}
+	}
+
+	// the TLS handshake of an unknown server name fails
+	unknown := sniClient("source", ClientTypeSource, "c.example.com")
+	assert.Error(t, unknown.Connect(ctx, addr))
+}
+
+func TestServerSNITenantOf(t *testing.T) {
+	s := NewServer("test-zipper")
+	appID, err := s.tenantOf("conn", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, "app", appID)
+
+	// the untracked connections don't indicate a server name
+	s = NewServer("test-zipper", WithSNITenants(map[string]string{"": "tenant-default"}))
+	appID, err = s.tenantOf("conn", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-default", appID)
+	_, err = s.tenantOf("conn", "tenant-other")
+	assert.Error(t, err)
+
+	s = NewServer("test-zipper", WithSNITenants(map[string]string{"a.example.com": "tenant-a"}))
+	_, err = s.tenantOf("conn", "")
+	assert.Error(t, err)
+}
+
+// credentialTenantAuth accepts the handshakes with a payload, the payload is the tenant of the
+// credential.
+type credentialTenantAuth struct {
+	selected chan string
+}
+
+func (a *credentialTenantAuth) Type() auth.AuthType { return auth.AuthTypeAppKey }
+
+func (a *credentialTenantAuth) Authenticate(f *frame.HandshakeFrame) bool {
+	return len(f.AuthPayload()) > 0
+}
+
+func (a *credentialTenantAuth) AuthenticateContext(ctx context.Context, f *frame.HandshakeFrame) (context.Context, bool) {
+	tenant, _ := auth.TenantFromContext(ctx)
+	a.selected <- tenant
+	if !a.Authenticate(f) {
+		return ctx, false
+	}
+	return auth.WithTenant(ctx, string(f.AuthPayload())), true
+}
+
+func TestServerSNITenantAuthentication(t *testing.T) {
+	// the untracked connections don't indicate a server name
+	a := &credentialTenantAuth{selected: make(chan string, 2)}
+	s := NewServer("test-zipper", WithSNITenants(map[string]string{"": "tenant-a"}), WithAuth(a))
+	s.ConfigRouter(tenantRouter{"tenant-a": {"sfn-a"}})
+
+	source := func(tenant string) *Context {
+		return &Context{ConnID: "source-" + tenant, Frame: frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", byte(auth.AuthTypeAppKey), []byte(tenant))}
+	}
+	// the credential of another tenant is refused though the server name selects the tenant-a
+	assert.Error(t, s.handleHandshakeFrame(source("tenant-b")))
+	assert.Equal(t, "tenant-a", <-a.selected)
+	_, ok := s.connector.App("source-tenant-b")
+	assert.False(t, ok)
+
+	c := source("tenant-a")
+	c.Stream = &mockStream{w: ioutil.Discard}
+	assert.NoError(t, s.handleHandshakeFrame(c))
+	assert.Equal(t, "tenant-a", <-a.selected)
+	appID, ok := s.connector.AppID("source-tenant-a")
+	assert.True(t, ok)
+	assert.Equal(t, "tenant-a", appID)
+}
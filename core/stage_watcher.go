@@ -0,0 +1,155 @@
+package core
+
+import "sync"
+
+// StageHandler is invoked with the workflow token of a stage, e.g. by OnStageEmpty.
+type StageHandler func(token string)
+
+// stageWatcher counts the connected stream function instances per workflow token, it
+// reports the transitions of the count between zero and non-zero.
+type stageWatcher struct {
+	// notifying serializes the handlers in the order of the transitions, they're invoked
+	// without mu held so they can read the depths
+	notifying sync.Mutex
+	mu        sync.Mutex
+	counts    map[string]int      // token -> instances
+	linked    map[string][]string // connID -> tokens
+	empty     StageHandler
+	ready     StageHandler
+}
+
+// stageEvent is a transition of a stage to be reported to its handler.
+type stageEvent struct {
+	handler StageHandler
+	token   string
+}
+
+func newStageWatcher() *stageWatcher {
+	return &stageWatcher{
+		counts: make(map[string]int),
+		linked: make(map[string][]string),
+	}
+}
+
+// link counts the instance of the connection for the tokens, the previous tokens of the
+// connection are released first, e.g. the stream function handshakes again.
+func (w *stageWatcher) link(connID string, tokens []string) {
+	w.notifying.Lock()
+	defer w.notifying.Unlock()
+	w.mu.Lock()
+	events := w.unlinkLocked(connID)
+	if len(tokens) > 0 {
+		w.linked[connID] = tokens
+	}
+	for _, token := range tokens {
+		w.counts[token]++
+		if w.counts[token] == 1 && w.ready != nil {
+			events = append(events, stageEvent{w.ready, token})
+		}
+	}
+	w.mu.Unlock()
+	notifyStages(events)
+}
+
+// unlink releases the tokens of the connection, it's a no-op if it isn't linked.
+func (w *stageWatcher) unlink(connID string) {
+	w.notifying.Lock()
+	defer w.notifying.Unlock()
+	w.mu.Lock()
+	events := w.unlinkLocked(connID)
+	w.mu.Unlock()
+	notifyStages(events)
+}
+
+// unlinkLocked releases the tokens of the connection, returns the stages became empty.
+func (w *stageWatcher) unlinkLocked(connID string) []stageEvent {
+	tokens, ok := w.linked[connID]
+	if !ok {
+		return nil
+	}
+	delete(w.linked, connID)
+	var events []stageEvent
+	for _, token := range tokens {
+		w.counts[token]--
+		if w.counts[token] > 0 {
+			continue
+		}
+		delete(w.counts, token)
+		if w.empty != nil {
+			events = append(events, stageEvent{w.empty, token})
+		}
+	}
+	return events
+}
+
+func notifyStages(events []stageEvent) {
+	for _, e := range events {
+		e.handler(e.token)
+	}
+}
+
+// stageTokens returns the tokens of the route matched by the stream function name.
+func stageTokens(route Route, name string) []string {
+	tokens := make([]string, 0)
+	for _, token := range route.GetForwardRoutes("") {
+		if MatchName(token, name) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// depths sums the depth of every linked connection per token, the tokens without instances
+// are 0.
+func (w *stageWatcher) depths(depth func(connID string) int) map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(map[string]int, len(w.counts))
+	for token := range w.counts {
+		result[token] = 0
+	}
+	for connID, tokens := range w.linked {
+		n := depth(connID)
+		for _, token := range tokens {
+			result[token] += n
+		}
+	}
+	return result
+}
+
+// linkedTo reports whether the connection is linked to the stage of the token.
+func (w *stageWatcher) linkedTo(connID string, token string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range w.linked[connID] {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// QueueDepths returns the number of the DataFrames queued to the instances of every workflow
+// stage by its token and not written yet. A sustained backlog of a stage tells it needs more
+// instances, the queues are read without locking so it's cheap to poll.
+func (s *Server) QueueDepths() map[string]int {
+	return s.stages.depths(s.connector.Depth)
+}
+
+// OnStageEmpty sets the handler invoked when the last stream function instance of a workflow
+// stage disconnects, the DataFrames routed to the stage are dropped until an instance connects
+// again. It fires once per transition and runs while the instances are being tracked, so it
+// must not block, but it may read the stats, e.g. QueueDepths.
+func (s *Server) OnStageEmpty(handler StageHandler) {
+	s.stages.mu.Lock()
+	s.stages.empty = handler
+	s.stages.mu.Unlock()
+}
+
+// OnStageReady sets the handler invoked when the first stream function instance of a workflow
+// stage connects, it fires once per transition like OnStageEmpty.
+func (s *Server) OnStageReady(handler StageHandler) {
+	s.stages.mu.Lock()
+	s.stages.ready = handler
+	s.stages.mu.Unlock()
+}
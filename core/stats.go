@@ -0,0 +1,322 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yomorun/yomo/core/frame"
+)
+
+// ServerStats is the stats of the server, see Server.Stats about the consistency.
+type ServerStats struct {
+	// State is the lifecycle state of the server.
+	State ServerState
+	// Paused reports whether the server is paused, see Server.Pause.
+	Paused bool
+	// StartedAt is the time the server started listening, zero if it hasn't started yet.
+	StartedAt time.Time
+	// Uptime is how long the server has been listening.
+	Uptime time.Duration
+	// DataFrames is the total number of the received DataFrames.
+	DataFrames int64
+	// Functions is the number of the DataFrames written to every stream function.
+	Functions map[string]int64
+	// Frames is the count and the size of the received frames per frame type.
+	Frames map[frame.Type]FrameStat
+	// Dropped is the number of the dropped DataFrames per reason.
+	Dropped DropStats
+	// Replayed is the number of the DataFrames replayed to the (re)connected stream functions.
+	Replayed int64
+	// DeadLettered is the number of the unroutable DataFrames forwarded to the dead-letter
+	// stream function, see WithDeadLetter.
+	DeadLettered int64
+	// Sessions is the number of the active sessions.
+	Sessions int64
+	// AcceptErrors is the number of the transient errors accepting the connections.
+	AcceptErrors int64
+	// Accepted is the number of the accepted sessions.
+	Accepted int64
+	// Resumed is the number of the accepted sessions whose TLS sessions are resumed, the
+	// resumption rate is Resumed / Accepted.
+	Resumed int64
+	// BrokenStreams is the number of the target streams broken by a write error, their
+	// connections are disconnected.
+	BrokenStreams int64
+	// Connections is the number of the connected apps.
+	Connections int
+	// BufferedBytes is the approximate bytes of the frames held by the buffers.
+	BufferedBytes int64
+	// Churn is the number of the connects and the disconnects per client type.
+	Churn map[ClientType]ConnectionStat
+	// Downstreams is the delivery to every downstream zipper by its address.
+	Downstreams map[string]DownstreamStat
+	// QueueDepths is the number of the DataFrames queued to every workflow stage by its token,
+	// see Server.QueueDepths.
+	QueueDepths map[string]int
+	// OpenTransactions is the number of the open transactions per source, see
+	// Server.OpenTransactions.
+	OpenTransactions map[string]int
+	// Shutdown is the progress of Server.Shutdown.
+	Shutdown ShutdownProgress
+}
+
+// DownstreamStat is the delivery of the DataFrames to a downstream zipper, the frames are
+// held until they're acknowledged if the client of the downstream enables the ack window.
+type DownstreamStat struct {
+	// Window is the max number of the unacknowledged frames, 0 if the ack window is disabled.
+	Window int
+	// Unacked is the number of the frames waiting for the ack.
+	Unacked int
+	// Retries is how many times the frames are retransmitted.
+	Retries int64
+}
+
+// ConnectionStat is the churn of the connections of a client type, the counters are
+// cumulative, the rates are derived by sampling them periodically, e.g. the connects
+// per second. A high churn of the stream functions often means they're crash-looping.
+type ConnectionStat struct {
+	// Connects is the number of the connections registered by the handshake.
+	Connects int64
+	// Disconnects is the number of the registered connections closed or deregistered.
+	Disconnects int64
+	// Active is the number of the registered connections now.
+	Active int64
+}
+
+// connectionCounters counts the connects and the disconnects per client type.
+type connectionCounters struct {
+	mu    sync.Mutex
+	stats map[ClientType]*ConnectionStat
+}
+
+func (c *connectionCounters) stat(t ClientType) *ConnectionStat {
+	if c.stats == nil {
+		c.stats = make(map[ClientType]*ConnectionStat)
+	}
+	stat, ok := c.stats[t]
+	if !ok {
+		stat = &ConnectionStat{}
+		c.stats[t] = stat
+	}
+	return stat
+}
+
+func (c *connectionCounters) connect(t ClientType) {
+	c.mu.Lock()
+	stat := c.stat(t)
+	stat.Connects++
+	stat.Active++
+	c.mu.Unlock()
+}
+
+func (c *connectionCounters) disconnect(t ClientType) {
+	c.mu.Lock()
+	stat := c.stat(t)
+	stat.Disconnects++
+	stat.Active--
+	c.mu.Unlock()
+}
+
+func (c *connectionCounters) snapshot() map[ClientType]ConnectionStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[ClientType]ConnectionStat, len(c.stats))
+	for t, stat := range c.stats {
+		result[t] = *stat
+	}
+	return result
+}
+
+// reset the connects and the disconnects, the active connections are kept.
+func (c *connectionCounters) reset() {
+	c.mu.Lock()
+	for _, stat := range c.stats {
+		stat.Connects = 0
+		stat.Disconnects = 0
+	}
+	c.mu.Unlock()
+}
+
+// DropStats is the number of the dropped DataFrames per reason.
+type DropStats struct {
+	// HopsExceeded is dropped for exceeding the max hops.
+	HopsExceeded int64
+	// Filtered is dropped by the frame filter.
+	Filtered int64
+	// ProtocolViolation is sent by the clients which are not allowed to send them.
+	ProtocolViolation int64
+	// CapacitySkipped is not sent to a stream function which can't handle the carriage.
+	CapacitySkipped int64
+	// OverBudget is evicted from the buffers for exceeding the memory budget.
+	OverBudget int64
+	// NoFirstStage is sent by the sources while the workflow has no first stage configured.
+	NoFirstStage int64
+	// Transformed is dropped by the frame transformer.
+	Transformed int64
+	// Expired is stale when it's received or while it's queued.
+	Expired int64
+	// Paused is sent by the sources while the server is paused.
+	Paused int64
+	// TooManyTransactions is sent by the sources which have too many open transactions.
+	TooManyTransactions int64
+	// InvalidTransactionID fails the validation of its transaction id, see
+	// WithTransactionIDValidator.
+	InvalidTransactionID int64
+	// WriteFailed is not written to a target which failed, e.g. its stream is reset, the
+	// target is evicted if its stream is broken, it's counted per target.
+	WriteFailed int64
+	// CarriageFailed is streamed to a target while its carriage can't be read from the source,
+	// the rest of it is padded with zeros.
+	CarriageFailed int64
+	// NoStream is routed to a stream function without a stream, e.g. it's disconnecting.
+	NoStream int64
+	// QueueOverflow is dropped from a full send queue, see WithSendQueueSize.
+	QueueOverflow int64
+	// StageFull is refused by the full windows of the stages, the frames of the sources are
+	// rejected and the others are dropped, see WithStageWindow.
+	StageFull int64
+}
+
+// functionCounters counts the DataFrames written to every stream function.
+type functionCounters struct {
+	counters sync.Map // name -> *int64
+}
+
+func (c *functionCounters) inc(name string) {
+	v, ok := c.counters.Load(name)
+	if !ok {
+		v, _ = c.counters.LoadOrStore(name, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+func (c *functionCounters) snapshot() map[string]int64 {
+	result := make(map[string]int64)
+	c.counters.Range(func(key interface{}, val interface{}) bool {
+		result[key.(string)] = atomic.LoadInt64(val.(*int64))
+		return true
+	})
+	return result
+}
+
+func (c *functionCounters) reset() {
+	c.counters.Range(func(key interface{}, val interface{}) bool {
+		atomic.StoreInt64(val.(*int64), 0)
+		return true
+	})
+}
+
+// Stats returns all the stats of the server in one call, so the status endpoint doesn't
+// combine several getters. Every counter is read atomically on its own and the data path
+// keeps running while they're read, so the counters aren't consistent with each other,
+// e.g. DataFrames may count a DataFrame which is not in Functions or Dropped yet.
+func (s *Server) Stats() ServerStats {
+	startedAt := s.StartedAt()
+	stats := ServerStats{
+		State:      s.State(),
+		Paused:     s.Paused(),
+		StartedAt:  startedAt,
+		DataFrames: atomic.LoadInt64(&s.counterOfDataFrame),
+		Functions:  s.functionStats.snapshot(),
+		Frames:     s.frameStats.snapshot(),
+		Dropped: DropStats{
+			HopsExceeded:         atomic.LoadInt64(&s.counterOfHopsExceeded),
+			Filtered:             atomic.LoadInt64(&s.counterOfFiltered),
+			ProtocolViolation:    atomic.LoadInt64(&s.counterOfViolation),
+			CapacitySkipped:      s.connector.CapacitySkipped(),
+			OverBudget:           s.budget.Dropped(),
+			NoFirstStage:         atomic.LoadInt64(&s.counterOfNoFirstStage),
+			Transformed:          atomic.LoadInt64(&s.counterOfTransformed),
+			Expired:              s.StatsExpired(),
+			Paused:               atomic.LoadInt64(&s.counterOfPaused),
+			TooManyTransactions:  atomic.LoadInt64(&s.counterOfTooManyTransactions),
+			InvalidTransactionID: atomic.LoadInt64(&s.counterOfInvalidTransactionID),
+			WriteFailed:          atomic.LoadInt64(&s.counterOfWriteFailed),
+			CarriageFailed:       atomic.LoadInt64(&s.counterOfCarriageFailed),
+			NoStream:             s.connector.NoStream(),
+			QueueOverflow:        s.connector.Overflowed(),
+			StageFull:            atomic.LoadInt64(&s.counterOfStageFull),
+		},
+		Replayed:         atomic.LoadInt64(&s.counterOfReplayed),
+		DeadLettered:     atomic.LoadInt64(&s.counterOfDeadLettered),
+		Sessions:         atomic.LoadInt64(&s.activeSessions),
+		AcceptErrors:     atomic.LoadInt64(&s.counterOfAcceptErrors),
+		Accepted:         atomic.LoadInt64(&s.counterOfAccepted),
+		Resumed:          atomic.LoadInt64(&s.counterOfResumed),
+		BrokenStreams:    atomic.LoadInt64(&s.counterOfBrokenStreams),
+		Connections:      len(s.connector.GetSnapshot()),
+		Churn:            s.connStats.snapshot(),
+		BufferedBytes:    s.budget.Held(),
+		Downstreams:      s.downstreamStats(),
+		QueueDepths:      s.QueueDepths(),
+		OpenTransactions: s.OpenTransactions(),
+		Shutdown:         s.ShutdownProgress(),
+	}
+	if !startedAt.IsZero() {
+		stats.Uptime = s.opts.Clock.Now().Sub(startedAt)
+	}
+	return stats
+}
+
+func (s *Server) downstreamStats() map[string]DownstreamStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]DownstreamStat, len(s.downstreams))
+	for addr, ds := range s.downstreams {
+		result[addr] = DownstreamStat{
+			Window:  ds.AckWindow(),
+			Unacked: ds.AckPending(),
+			Retries: ds.AckRetries(),
+		}
+	}
+	return result
+}
+
+// ResetStats zeroes the cumulative counters, e.g. to take a fresh baseline after a deployment,
+// i.e. the DataFrames, the frames per function and per type, the dropped frames, the replayed
+// frames, the sampling, the connects and disconnects, and the latency histograms. The gauges
+// describing the current state are not reset, e.g. the active sessions and connections, the
+// buffered bytes and the in-flight frames, nor are the counters of every session.
+//
+// It's safe to call while the frames are flowing, every counter is zeroed atomically, so an
+// increment is either before or after the reset, but a frame being routed meanwhile may be
+// counted by some counters and not by the others.
+func (s *Server) ResetStats() {
+	for _, counter := range []*int64{
+		&s.counterOfDataFrame,
+		&s.counterOfHopsExceeded,
+		&s.counterOfFiltered,
+		&s.counterOfReplayed,
+		&s.counterOfViolation,
+		&s.counterOfAcceptErrors,
+		&s.counterOfPaused,
+		&s.counterOfStageFull,
+		&s.counterOfTooManyTransactions,
+		&s.counterOfInvalidTransactionID,
+		&s.counterOfWriteFailed,
+		&s.counterOfCarriageFailed,
+		&s.counterOfBrokenStreams,
+		&s.counterOfDeadLettered,
+		&s.counterOfExpired,
+		&s.counterOfTransformed,
+		&s.counterOfNoFirstStage,
+		&s.counterOfAccepted,
+		&s.counterOfResumed,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+	s.functionStats.reset()
+	s.frameStats.reset()
+	s.connStats.reset()
+	s.connector.ResetStats()
+	s.budget.resetDropped()
+	s.deliveryAge.reset()
+	s.processingLatency.reset()
+	for _, sm := range s.samplers {
+		sm.reset()
+	}
+	if s.reassembler != nil {
+		s.reassembler.resetExpired()
+	}
+}
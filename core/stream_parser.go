@@ -1,24 +1,116 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/yomorun/y3"
+	"github.com/yomorun/y3/encoding"
 	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/logger"
 )
 
+// ParseError is returned by ParseFrame when the packet read from stream is not a valid frame.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return "parse frame: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrFrameTooLarge is wrapped in the ParseError when the frame exceeds the max frame size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// readPacket reads a y3 packet, the packet larger than max is rejected by its header
+// before the value is read. max <= 0 means unlimited.
+func readPacket(stream io.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		return y3.ReadPacket(stream)
+	}
+	header, length, err := readPacketHead(stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFrameSize(len(header)+length, max); err != nil {
+		return nil, err
+	}
+	return readPacketValue(stream, header, length)
+}
+
+// readPacketHead reads the tag and the length of a y3 packet, returns the bytes read and the
+// length of the value following them.
+func readPacketHead(stream io.Reader) ([]byte, int, error) {
+	// the tag and the length, a varint of 5 bytes at most
+	header := make([]byte, 1, 6)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return nil, 0, err
+	}
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(stream, b); err != nil {
+			return nil, 0, err
+		}
+		header = append(header, b[0])
+		if b[0]&0x80 != 0x80 {
+			break
+		}
+		if len(header) == cap(header) {
+			return nil, 0, &ParseError{Err: y3.ErrMalformed}
+		}
+	}
+	var length int32
+	codec := encoding.VarCodec{}
+	if err := codec.DecodePVarInt32(header[1:], &length); err != nil || length < 0 {
+		return nil, 0, &ParseError{Err: y3.ErrMalformed}
+	}
+	return header, int(length), nil
+}
+
+// readPacketValue reads the value of the packet whose head is read, returns the whole packet.
+func readPacketValue(stream io.Reader, header []byte, length int) ([]byte, error) {
+	buf := make([]byte, len(header)+length)
+	copy(buf, header)
+	if _, err := io.ReadFull(stream, buf[len(header):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// checkFrameSize rejects the frame larger than max, max <= 0 means unlimited.
+func checkFrameSize(size int, max int) error {
+	if max > 0 && size > max {
+		return &ParseError{Err: fmt.Errorf("%w: %d bytes exceed the limit %d", ErrFrameTooLarge, size, max)}
+	}
+	return nil
+}
+
 // ParseFrame parses the frame from QUIC stream.
 func ParseFrame(stream io.Reader) (frame.Frame, error) {
 	buf, err := y3.ReadPacket(stream)
 	if err != nil {
 		return nil, err
 	}
-	// if len(buf) > 512 {
-	// 	logger.Debugf("%s🔗 parsed out total %d bytes: \n\thead 64 bytes are: [%# x], \n\ttail 64 bytes are: [%#x]", ParseFrameLogPrefix, len(buf), buf[0:64], buf[len(buf)-64:])
-	// } else {
-	// 	logger.Debugf("%s🔗 parsed out: [%# x]", ParseFrameLogPrefix, buf)
-	// }
+	f, err := decodeFrame(buf)
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	return f, nil
+}
+
+func decodeFrame(buf []byte) (frame.Frame, error) {
+	// the dump costs nothing unless it's debug mode, see frame.SetDebugFrameSize
+	if logger.IsDebug() {
+		if dump := frame.Dump(buf); dump != "" {
+			logger.Debugf("%s🔗 parsed out total %d bytes: %s", ParseFrameLogPrefix, len(buf), dump)
+		}
+	}
 
 	frameType := buf[0]
 	// determine the frame type
@@ -31,23 +123,28 @@ func ParseFrame(stream io.Reader) (frame.Frame, error) {
 		data, err := readDataFrame(buf)
 		// logger.Debugf("%sDataFrame: tid=%s, tag=%#x, len(carriage)=%d", ParseFrameLogPrefix, data.TransactionID(), data.GetDataTag(), len(data.GetCarriage()))
 		return data, err
+	case 0x80 | byte(frame.TagOfPingFrame):
+		return frame.DecodeToPingFrame(buf)
+	case 0x80 | byte(frame.TagOfPongFrame):
+		return frame.DecodeToPongFrame(buf)
 	case 0x80 | byte(frame.TagOfAcceptedFrame):
 		return frame.DecodeToAcceptedFrame(buf)
 	case 0x80 | byte(frame.TagOfRejectedFrame):
 		return frame.DecodeToRejectedFrame(buf)
+	case 0x80 | byte(frame.TagOfAckFrame):
+		return frame.DecodeToAckFrame(buf)
+	case 0x80 | byte(frame.TagOfSubscriptionFrame):
+		return frame.DecodeToSubscriptionFrame(buf)
+	case 0x80 | byte(frame.TagOfCapabilitiesRequestFrame):
+		return frame.DecodeToCapabilitiesRequestFrame(buf)
+	case 0x80 | byte(frame.TagOfCapabilitiesFrame):
+		return frame.DecodeToCapabilitiesFrame(buf)
 	default:
 		return nil, fmt.Errorf("unknown frame type, buf[0]=%#x", buf[0])
 	}
 }
 
 func readHandshakeFrame(buf []byte) (*frame.HandshakeFrame, error) {
-	// parse to HandshakeFrame
-	// handshake, err := frame.DecodeToHandshakeFrame(buf)
-	// if err != nil {
-	// 	logger.Errorf("%sreadHandshakeFrame: err=%v", ParseFrameLogPrefix, err)
-	// 	return nil
-	// }
-	// return handshake
 	return frame.DecodeToHandshakeFrame(buf)
 }
 
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/logger"
+)
+
+// errCarriageConsumed is returned when buffering the carriage of a StreamingDataFrame which
+// has been partially read.
+var errCarriageConsumed = errors.New("the carriage has been consumed")
+
+// StreamingDataFrame is a DataFrame whose carriage is read from the stream on demand instead
+// of being buffered, see FrameStream.SetStreamThreshold. The embedded DataFrame carries the
+// MetaFrame and the data tag, its carriage is empty until ReadAll.
+type StreamingDataFrame struct {
+	*frame.DataFrame
+	carriage *io.LimitedReader
+	size     int
+	buffered bool
+}
+
+// Size returns the size of the carriage.
+func (f *StreamingDataFrame) Size() int {
+	return f.size
+}
+
+// Carriage returns the reader of the carriage. It reads from the stream the frame is read
+// from, so it must be consumed before the next frame is read, the unread bytes are discarded
+// by then.
+func (f *StreamingDataFrame) Carriage() io.Reader {
+	return f.carriage
+}
+
+// ReadAll reads the carriage into the DataFrame and returns it, it fails if the carriage has
+// been partially read.
+func (f *StreamingDataFrame) ReadAll() (*frame.DataFrame, error) {
+	if f.buffered {
+		return f.DataFrame, nil
+	}
+	if f.carriage.N != int64(f.size) {
+		return nil, errCarriageConsumed
+	}
+	buf := make([]byte, f.size)
+	if _, err := io.ReadFull(f.carriage, buf); err != nil {
+		return nil, err
+	}
+	f.DataFrame.SetCarriage(f.Tag(), buf)
+	f.buffered = true
+	return f.DataFrame, nil
+}
+
+// Encode reads the carriage into the DataFrame and encodes it, nil if the carriage can't be read.
+func (f *StreamingDataFrame) Encode() []byte {
+	data, err := f.ReadAll()
+	if err != nil {
+		return nil
+	}
+	return data.Encode()
+}
+
+// discard drops the unread bytes of the carriage.
+func (f *StreamingDataFrame) discard() error {
+	_, err := io.Copy(ioutil.Discard, f.carriage)
+	return err
+}
+
+// readStreamingDataFrame reads the DataFrame whose head is read, up to its carriage. The value
+// is read as a whole if it isn't a MetaFrame followed by a PayloadFrame, the layout encoded
+// by DataFrame.Encode.
+func readStreamingDataFrame(stream io.Reader, header []byte, length int) (frame.Frame, error) {
+	head := header
+	total := len(header) + length
+	readWhole := func() (frame.Frame, error) {
+		buf, err := readPacketValue(stream, head, total-len(head))
+		if err != nil {
+			return nil, err
+		}
+		f, err := decodeFrame(buf)
+		if err != nil {
+			return nil, &ParseError{Err: err}
+		}
+		return f, nil
+	}
+	// readChildHead reads the head of the next packet, false if there's no room for it
+	readChildHead := func() (byte, int, bool, error) {
+		if total-len(head) < 2 {
+			return 0, 0, false, nil
+		}
+		h, n, err := readPacketHead(stream)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		head = append(head, h...)
+		if len(head)+n > total {
+			return 0, 0, false, &ParseError{Err: &frame.LengthError{Tag: h[0], Declared: n, Actual: total - len(head)}}
+		}
+		return h[0], n, true, nil
+	}
+
+	// MetaFrame
+	tag, n, ok, err := readChildHead()
+	if err != nil {
+		return nil, err
+	}
+	if !ok || tag != 0x80|byte(frame.TagOfMetaFrame) {
+		return readWhole()
+	}
+	meta, err := readPacketValue(stream, nil, n)
+	if err != nil {
+		return nil, err
+	}
+	head = append(head, meta...)
+	// PayloadFrame
+	if tag, _, ok, err = readChildHead(); err != nil {
+		return nil, err
+	}
+	if !ok || tag != 0x80|byte(frame.TagOfPayloadFrame) {
+		return readWhole()
+	}
+	// carriage
+	if tag, _, ok, err = readChildHead(); err != nil {
+		return nil, err
+	}
+	if !ok || tag&0x80 == 0x80 {
+		return readWhole()
+	}
+	data, size, err := frame.DecodeToDataFrameHead(head)
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	if logger.IsDebug() {
+		logger.Debugf("%s🔗 parsed out the head of %d bytes, streaming the carriage of %d bytes, tid=%s", ParseFrameLogPrefix, len(head), size, data.TransactionID())
+	}
+	return &StreamingDataFrame{
+		DataFrame: data,
+		carriage:  &io.LimitedReader{R: stream, N: int64(size)},
+		size:      size,
+	}, nil
+}
+
+// dataFrame returns the DataFrame of the context, the carriage of the StreamingDataFrame may
+// not be buffered.
+func dataFrame(c *Context) *frame.DataFrame {
+	if sf, ok := c.Frame.(*StreamingDataFrame); ok {
+		return sf.DataFrame
+	}
+	return c.Frame.(*frame.DataFrame)
+}
+
+// streamingFrame returns the StreamingDataFrame of the context whose carriage isn't buffered.
+func streamingFrame(c *Context) *StreamingDataFrame {
+	if sf, ok := c.Frame.(*StreamingDataFrame); ok && !sf.buffered {
+		return sf
+	}
+	return nil
+}
+
+// bufferCarriage reads the carriage of the StreamingDataFrame of the context, which is replaced
+// by the buffered DataFrame.
+func bufferCarriage(c *Context) (*frame.DataFrame, error) {
+	sf, ok := c.Frame.(*StreamingDataFrame)
+	if !ok {
+		return c.Frame.(*frame.DataFrame), nil
+	}
+	f, err := sf.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	c.WithFrame(f)
+	return f, nil
+}
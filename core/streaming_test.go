@@ -0,0 +1,192 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/y3"
+	"github.com/yomorun/yomo/core/frame"
+)
+
+func newBlobFrame(tid string, size int) *frame.DataFrame {
+	f := newDataFrame(tid, "source", 0x33)
+	f.SetCarriage(0x33, bytes.Repeat([]byte(tid), size/len(tid)))
+	return f
+}
+
+func TestFrameStreamStreaming(t *testing.T) {
+	blob1 := newBlobFrame("blob-1", 1200)
+	blob2 := newBlobFrame("blob-2", 1200)
+	small := newDataFrame("small", "source", 0x33)
+	buf := encodeFrames(frame.NewPingFrame(), blob1, blob2, small)
+	fs := NewFrameStream(&mockStream{r: bytes.NewReader(buf)})
+	fs.SetStreamThreshold(1000)
+
+	// the other frames are never streamed
+	f, err := fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfPingFrame, f.Type())
+
+	// read a part of the carriage, the rest is discarded by the next read
+	f, err = fs.ReadFrame()
+	assert.NoError(t, err)
+	sf, ok := f.(*StreamingDataFrame)
+	assert.True(t, ok)
+	assert.Equal(t, "blob-1", sf.TransactionID())
+	assert.Equal(t, 1200, sf.Size())
+	assert.Empty(t, sf.GetCarriage())
+	part := make([]byte, 6)
+	_, err = io.ReadFull(sf.Carriage(), part)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("blob-1"), part)
+	_, err = sf.ReadAll()
+	assert.Equal(t, errCarriageConsumed, err)
+
+	// buffer the carriage
+	f, err = fs.ReadFrame()
+	assert.NoError(t, err)
+	sf, ok = f.(*StreamingDataFrame)
+	assert.True(t, ok)
+	assert.Equal(t, blob2.Encode(), sf.Encode())
+	data, err := sf.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, blob2.GetCarriage(), data.GetCarriage())
+
+	f, err = fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "small", f.(*frame.DataFrame).TransactionID())
+
+	_, err = fs.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameStreamStreamingLayout(t *testing.T) {
+	// the payload ahead of the meta can't be streamed, it's read as a whole
+	blob := newBlobFrame("blob", 1200)
+	encoder := y3.NewNodePacketEncoder(byte(frame.TagOfDataFrame))
+	payload := frame.NewPayloadFrame(0x33).SetCarriage(blob.GetCarriage())
+	encoder.AddBytes(payload.Encode())
+	encoder.AddBytes(blob.GetMetaFrame().Encode())
+	buf := encodeFrames(frame.NewPingFrame())
+	buf = append(encoder.Encode(), buf...)
+
+	fs := NewFrameStream(&mockStream{r: bytes.NewReader(buf)})
+	fs.SetStreamThreshold(1000)
+	f, err := fs.ReadFrame()
+	assert.NoError(t, err)
+	data, ok := f.(*frame.DataFrame)
+	assert.True(t, ok)
+	assert.Equal(t, "blob", data.TransactionID())
+	assert.Equal(t, blob.GetCarriage(), data.GetCarriage())
+
+	f, err = fs.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, frame.TagOfPingFrame, f.Type())
+}
+
+func TestHandleDataFrameStreaming(t *testing.T) {
+	s := NewServer("test-zipper", WithStreamThreshold(1000))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+
+	blob := newBlobFrame("blob", 100000)
+	small := newDataFrame("small", "source", 0x33)
+	buf := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), blob, small)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(buf), w: ioutil.Discard}})
+
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	frames := sfn.Frames()
+	received := frames[0].(*frame.DataFrame)
+	assert.Equal(t, "blob", received.TransactionID())
+	assert.Equal(t, uint32(1), received.Hops())
+	assert.Equal(t, blob.GetCarriage(), received.GetCarriage())
+	assert.Equal(t, "small", frames[1].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, int64(2), s.StatsInstances()[0].Frames)
+
+	// the carriage is counted by its frame
+	stat := s.StatsFrames()[frame.TagOfDataFrame]
+	assert.Equal(t, int64(2), stat.Count)
+	assert.Equal(t, int64(len(blob.Encode())+len(small.Encode())), stat.Bytes)
+}
+
+func TestHandleDataFrameStreamingFanOut(t *testing.T) {
+	// the carriage is buffered to fan out
+	s := NewServer("test-zipper", WithStreamThreshold(1000))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1", "sfn-2"}})
+	sfn1 := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+	sfn2 := connectSfn(s, "sfn-2-conn", "sfn-2", 0x33)
+
+	blob := newBlobFrame("blob", 100000)
+	buf := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), blob)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(buf), w: ioutil.Discard}})
+
+	for _, sfn := range []*syncBuffer{sfn1, sfn2} {
+		assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+		assert.Equal(t, blob.GetCarriage(), sfn.Frames()[0].(*frame.DataFrame).GetCarriage())
+	}
+}
+
+func TestHandleDataFrameStreamingOverBudget(t *testing.T) {
+	s := NewServer("test-zipper", WithStreamThreshold(1000), WithMemoryBudget(1))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := &gateWriter{gate: make(chan struct{})}
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write(frame.NewHandshakeFrame("sfn-1", byte(ClientTypeStreamFunction), []byte{0x33}, "", 0, nil).Encode())
+	go s.handleConnection(context.Background(), &Context{ConnID: "sfn-1-conn", Stream: &mockStream{r: r, w: sfn}})
+	assert.True(t, waitFor(func() bool { return s.connector.Get("sfn-1-conn") != nil }))
+
+	// tid-1 is being written, the blob is queued behind it
+	handshake := frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-1-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newDataFrame("tid-1", "source", 0x33))), w: ioutil.Discard}})
+	assert.True(t, waitFor(func() bool { return s.connector.Depth("sfn-1-conn") == 0 }))
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		s.handleConnection(context.Background(), &Context{ConnID: "source-2-conn", Stream: &mockStream{r: bytes.NewReader(encodeFrames(handshake, newBlobFrame("blob", 100000))), w: ioutil.Discard}})
+	}()
+	assert.True(t, waitFor(func() bool { return s.connector.Depth("sfn-1-conn") == 1 }))
+
+	// the blob is dropped by the budget, its target isn't evicted
+	s.handleConnection(context.Background(), &Context{ConnID: "source-1-conn", Stream: &mockStream{r: bytes.NewReader(newDataFrame("tid-2", "source", 0x33).Encode()), w: ioutil.Discard}})
+	<-streamed
+	close(sfn.gate)
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+	assert.Equal(t, "tid-1", sfn.Frames()[0].(*frame.DataFrame).TransactionID())
+	assert.Equal(t, "tid-2", sfn.Frames()[1].(*frame.DataFrame).TransactionID())
+	stats := s.Stats()
+	assert.EqualValues(t, 1, stats.Dropped.OverBudget)
+	assert.Zero(t, stats.Dropped.WriteFailed)
+	_, ok := s.connector.App("sfn-1-conn")
+	assert.True(t, ok)
+	assert.True(t, s.connector.Live("sfn-1-conn"))
+}
+
+func TestHandleDataFrameStreamingReadError(t *testing.T) {
+	s := NewServer("test-zipper", WithStreamThreshold(1000))
+	s.ConfigRouter(&testRouter{names: []string{"sfn-1"}})
+	sfn := connectSfn(s, "sfn-1-conn", "sfn-1", 0x33)
+
+	// the source is gone in the middle of the carriage
+	blob := newBlobFrame("blob", 100000)
+	buf := encodeFrames(frame.NewHandshakeFrame("source", byte(ClientTypeSource), nil, "", 0, nil), blob)
+	s.handleConnection(context.Background(), &Context{ConnID: "source-conn", Stream: &mockStream{r: bytes.NewReader(buf[:len(buf)-500]), w: ioutil.Discard}})
+
+	// the rest of the carriage is padded, the target is still usable
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 1 }))
+	carriage := sfn.Frames()[0].(*frame.DataFrame).GetCarriage()
+	size := len(blob.GetCarriage())
+	assert.Equal(t, blob.GetCarriage()[:size-500], carriage[:size-500])
+	assert.Equal(t, make([]byte, 500), carriage[size-500:])
+	stats := s.Stats()
+	assert.EqualValues(t, 1, stats.Dropped.CarriageFailed)
+	assert.Zero(t, stats.Dropped.WriteFailed)
+	assert.True(t, s.connector.Live("sfn-1-conn"))
+
+	assert.NoError(t, s.connector.Write(newDataFrame("tid-1", "source", 0x33), "sfn-1-conn"))
+	assert.True(t, waitFor(func() bool { return len(sfn.Frames()) == 2 }))
+}
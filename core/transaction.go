@@ -0,0 +1,35 @@
+package core
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// TransactionIDGenerator generates the transaction id of DataFrames.
+type TransactionIDGenerator func() string
+
+// TransactionIDValidator validates the transaction id of DataFrames.
+type TransactionIDValidator func(tid string) error
+
+// ErrEmptyTransactionID is returned when a DataFrame carries an empty transaction id.
+var ErrEmptyTransactionID = errors.New("transaction id is empty")
+
+// NewUUID generates a random (version 4) UUID, it is the default TransactionIDGenerator.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// RequireTransactionID is a TransactionIDValidator which rejects the empty transaction ids.
+func RequireTransactionID(tid string) error {
+	if tid == "" {
+		return ErrEmptyTransactionID
+	}
+	return nil
+}
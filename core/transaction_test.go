@@ -0,0 +1,20 @@
+package core
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUID(t *testing.T) {
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	id := NewUUID()
+	assert.Regexp(t, re, id)
+	assert.NotEqual(t, id, NewUUID())
+}
+
+func TestRequireTransactionID(t *testing.T) {
+	assert.Equal(t, ErrEmptyTransactionID, RequireTransactionID(""))
+	assert.NoError(t, RequireTransactionID(NewUUID()))
+}
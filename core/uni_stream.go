@@ -0,0 +1,64 @@
+package core
+
+import (
+	"compress/flate"
+	"context"
+	"io"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// uniStream is the unidirectional stream opened by a pure producer, e.g. a source which
+// only pushes the DataFrames. The client never reads a reply, so the writes are discarded.
+type uniStream struct {
+	quic.ReceiveStream
+	r io.Reader
+}
+
+// newUniStream wraps the receive stream, the data is decompressed if the connection is
+// compressed.
+func newUniStream(stream quic.ReceiveStream, compressed bool) *uniStream {
+	s := &uniStream{ReceiveStream: stream, r: stream}
+	if compressed {
+		s.r = flate.NewReader(stream)
+	}
+	return s
+}
+
+func (s *uniStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// Write discards the data, since there's no way to send it back on the stream.
+func (s *uniStream) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close stops receiving the data of the stream.
+func (s *uniStream) Close() error {
+	s.CancelRead(0)
+	return nil
+}
+
+// serveUniStreams accepts the unidirectional streams of the connection in parallel with the
+// bidirectional ones, until the ctx is done or the connection is closed. Their frames are
+// routed the same way, but only the DataFrames are allowed, the client has to be authenticated
+// by the handshake on the bidirectional stream before opening them. Ending a unidirectional
+// stream doesn't close the connection.
+func (s *Server) serveUniStreams(ctx context.Context, conn quic.Connection, connID string) {
+	sessLogger := s.session(connID).Logger()
+	for {
+		stream, err := conn.AcceptUniStream(ctx)
+		if err != nil {
+			sessLogger.Debugf("%s(%s) stop accepting the unidirectional streams: %v", ServerLogPrefix, connID, err)
+			return
+		}
+		sessLogger.Infof("%s[uni-stream:%d] created, connID=%s", ServerLogPrefix, stream.StreamID(), connID)
+		go func(stream quic.ReceiveStream) {
+			c := &Context{ConnID: connID, Stream: newUniStream(stream, isCompressed(conn)), uni: true}
+			defer c.Clean()
+			reason := s.handleConnection(ctx, c)
+			sessLogger.Infof("%s[uni-stream:%d] done, reason: %s", ServerLogPrefix, stream.StreamID(), reason)
+		}(stream)
+	}
+}
@@ -1,5 +1,10 @@
 package core
 
+import (
+	"fmt"
+	"sort"
+)
+
 // Workflow describes stream function workflows.
 type Workflow struct {
 	// Seq represents the sequence id when executing workflows.
@@ -8,3 +13,72 @@ type Workflow struct {
 	// Token represents the name of workflow.
 	Name string
 }
+
+// DefaultMaxStages is the max number of the stages of the workflows by default, it's a sanity
+// guard against the misconfiguration, since every stage adds to the routing of every frame.
+const DefaultMaxStages = 256
+
+// Workflows is the container of the workflows executed by a zipper.
+type Workflows []Workflow
+
+// Validate returns an error if any of the workflows has an empty name, a negative
+// seq or a seq used by another workflow, or there're more than DefaultMaxStages workflows.
+func (wfs Workflows) Validate() error {
+	return wfs.ValidateMaxStages(DefaultMaxStages)
+}
+
+// ValidateMaxStages is the same as Validate with the max number of the stages, 0 means
+// DefaultMaxStages.
+func (wfs Workflows) ValidateMaxStages(max int) error {
+	if err := CheckStages(len(wfs), max); err != nil {
+		return err
+	}
+	seqs := make(map[int]string, len(wfs))
+	for _, wf := range wfs {
+		if wf.Name == "" {
+			return fmt.Errorf("workflow: empty name at seq %d", wf.Seq)
+		}
+		if wf.Seq < 0 {
+			return fmt.Errorf("workflow: negative seq %d of [%s]", wf.Seq, wf.Name)
+		}
+		if name, ok := seqs[wf.Seq]; ok {
+			return fmt.Errorf("workflow: duplicate seq %d of [%s] and [%s]", wf.Seq, name, wf.Name)
+		}
+		seqs[wf.Seq] = wf.Name
+	}
+	return nil
+}
+
+// CheckStages returns an error if the number of the stages exceeds the max, 0 means
+// DefaultMaxStages.
+func CheckStages(stages int, max int) error {
+	if max <= 0 {
+		max = DefaultMaxStages
+	}
+	if stages > max {
+		return fmt.Errorf("workflow: %d stages exceed the max %d", stages, max)
+	}
+	return nil
+}
+
+// ValidateContiguous is the same as Validate, additionally requires the seqs start
+// from 0 without gaps.
+func (wfs Workflows) ValidateContiguous() error {
+	if err := wfs.Validate(); err != nil {
+		return err
+	}
+	for i, wf := range wfs.Sorted() {
+		if wf.Seq != i {
+			return fmt.Errorf("workflow: missing seq %d before [%s]", i, wf.Name)
+		}
+	}
+	return nil
+}
+
+// Sorted returns a copy of the workflows in the order of seq.
+func (wfs Workflows) Sorted() Workflows {
+	sorted := make(Workflows, len(wfs))
+	copy(sorted, wfs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Seq < sorted[j].Seq })
+	return sorted
+}
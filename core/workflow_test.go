@@ -0,0 +1,36 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkflowsValidate(t *testing.T) {
+	assert.NoError(t, Workflows{{Seq: 1, Name: "sfn-2"}, {Seq: 0, Name: "sfn-1"}}.ValidateContiguous())
+	assert.NoError(t, Workflows{{Seq: 0, Name: "sfn-1"}, {Seq: 2, Name: "sfn-2"}}.Validate())
+
+	assert.EqualError(t, Workflows{{Seq: 0, Name: "sfn-1"}, {Seq: 0, Name: "sfn-2"}}.Validate(), "workflow: duplicate seq 0 of [sfn-1] and [sfn-2]")
+	assert.EqualError(t, Workflows{{Seq: 0, Name: ""}}.Validate(), "workflow: empty name at seq 0")
+	assert.EqualError(t, Workflows{{Seq: -1, Name: "sfn-1"}}.Validate(), "workflow: negative seq -1 of [sfn-1]")
+	assert.EqualError(t, Workflows{{Seq: 0, Name: "sfn-1"}, {Seq: 2, Name: "sfn-2"}}.ValidateContiguous(), "workflow: missing seq 1 before [sfn-2]")
+}
+
+func TestWorkflowsMaxStages(t *testing.T) {
+	wfs := make(Workflows, DefaultMaxStages+1)
+	for i := range wfs {
+		wfs[i] = Workflow{Seq: i, Name: fmt.Sprintf("sfn-%d", i)}
+	}
+	assert.EqualError(t, wfs.Validate(), "workflow: 257 stages exceed the max 256")
+	assert.NoError(t, wfs[:DefaultMaxStages].ValidateContiguous())
+	assert.NoError(t, wfs.ValidateMaxStages(DefaultMaxStages+1))
+	assert.EqualError(t, wfs[:3].ValidateMaxStages(2), "workflow: 3 stages exceed the max 2")
+	assert.NoError(t, CheckStages(DefaultMaxStages, 0))
+}
+
+func TestWorkflowsSorted(t *testing.T) {
+	wfs := Workflows{{Seq: 2, Name: "sfn-3"}, {Seq: 0, Name: "sfn-1"}, {Seq: 1, Name: "sfn-2"}}
+	assert.Equal(t, Workflows{{Seq: 0, Name: "sfn-1"}, {Seq: 1, Name: "sfn-2"}, {Seq: 2, Name: "sfn-3"}}, wfs.Sorted())
+	assert.Equal(t, "sfn-3", wfs[0].Name)
+}
@@ -1,11 +1,16 @@
 package yomo
 
 import (
+	"crypto/cipher"
 	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/yomorun/yomo/core"
 	"github.com/yomorun/yomo/core/auth"
+	"github.com/yomorun/yomo/core/frame"
 	"github.com/yomorun/yomo/core/log"
 	pkgauth "github.com/yomorun/yomo/pkg/auth"
 )
@@ -29,6 +34,11 @@ type Options struct {
 	QuicConfig           *quic.Config
 	TLSConfig            *tls.Config
 	Logger               log.Logger
+	CarriageAEAD         cipher.AEAD // encrypts the carriage end to end, see WithCarriageEncryption
+	SequenceGapHandler   core.SequenceGapHandler
+	FrameTTL             time.Duration       // how long the DataFrames written by the source stay fresh, 0 means forever
+	MaxStages            int                 // the max number of the stages of the workflows, 0 means core.DefaultMaxStages
+	MetadataCodec        frame.MetadataCodec // encodes the metadata written by the source, default is frame.DefaultMetadataCodec
 }
 
 // WithZipperAddr return a new options with ZipperAddr set to addr.
@@ -47,6 +57,14 @@ func WithZipperAddr(addr string) Option {
 
 // TODO: WithWorkflowConfig
 
+// WithMaxStages sets the max number of the stages of the workflows (used by zipper), the
+// workflows with more stages are refused, default is core.DefaultMaxStages.
+func WithMaxStages(max int) Option {
+	return func(o *Options) {
+		o.MaxStages = max
+	}
+}
+
 // WithMeshConfigURL sets the initial edge-mesh config URL for the YoMo-Zipper.
 func WithMeshConfigURL(url string) Option {
 	return func(o *Options) {
@@ -88,6 +106,16 @@ func WithAuth(auth auth.Authentication) Option {
 	}
 }
 
+// WithVerifyPeerCertificate sets the custom certificate verification during the TLS handshake (used by server)
+func WithVerifyPeerCertificate(fn func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error) Option {
+	return func(o *Options) {
+		o.ServerOptions = append(
+			o.ServerOptions,
+			core.WithVerifyPeerCertificate(fn),
+		)
+	}
+}
+
 // WithAppKeyCredential sets the client credential (used by client): AppKey
 func WithAppKeyCredential(appID string, appSecret string) Option {
 	return WithCredential(pkgauth.NewAppKeyCredential(appID, appSecret))
@@ -113,6 +141,122 @@ func WithObserveDataTags(tags ...byte) Option {
 	}
 }
 
+// WithMaxPayloadSize sets the max carriage length the stream function can handle (used by sfn)
+func WithMaxPayloadSize(size uint32) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithMaxPayloadSize(size),
+		)
+	}
+}
+
+// WithWeight sets the share of the frames the stream function receives among the instances
+// of the same name (used by sfn)
+func WithWeight(weight uint32) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithWeight(weight),
+		)
+	}
+}
+
+// WithDialer sets how the client dials the YoMo-Zipper, e.g. through a proxy (used by client)
+func WithDialer(dialer core.Dialer) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithDialer(dialer),
+		)
+	}
+}
+
+// WithLocalAddr binds the client to the local UDP address, e.g. of a specific egress
+// interface (used by client)
+func WithLocalAddr(addr string) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithLocalAddr(addr),
+		)
+	}
+}
+
+// WithPacketConn dials the YoMo-Zipper over the pre-dialed packet conn, which is owned
+// by the caller (used by client)
+func WithPacketConn(conn net.PacketConn) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithPacketConn(conn),
+		)
+	}
+}
+
+// WithAckWindow makes the YoMo-Zipper acknowledge the DataFrames, the frames not acknowledged
+// in the timeout are retransmitted, at most size frames are unacknowledged (used by source
+// and downstream zipper). The unacknowledged frames are delivered again in order once the
+// connection is re-established, so no frame is lost when the downstream zipper restarts, but
+// the frames received before it restarts may be routed twice, see core.WithAckWindow.
+func WithAckWindow(size int, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithAckWindow(size, timeout),
+		)
+	}
+}
+
+// WithCarriageEncryption encrypts the carriage of the DataFrames by the AEAD (used by source
+// and sfn), e.g. carriage.NewAESGCM. The source seals the carriage it writes, the sfn opens
+// the carriage it receives and seals the carriage it returns, so the key is shared by the
+// source and the sfns out of band, the zipper routes the ciphertext and never decrypts it.
+func WithCarriageEncryption(aead cipher.AEAD) Option {
+	return func(o *Options) {
+		o.CarriageAEAD = aead
+	}
+}
+
+// WithSequenceGapHandler sets the handler invoked when the stream function detects the gap of
+// the sequence numbers set by a source per tag, i.e. the frames lost in between (used by sfn)
+func WithSequenceGapHandler(handler core.SequenceGapHandler) Option {
+	return func(o *Options) {
+		o.SequenceGapHandler = handler
+	}
+}
+
+// WithFrameTTL sets how long the DataFrames written by the source stay fresh, the stale
+// frames are dropped by the YoMo-Zipper instead of being delivered late, e.g. after they're
+// queued by the backpressure (used by source). The expiry is compared with the clock of the
+// YoMo-Zipper, so the clocks should be synchronized.
+func WithFrameTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.FrameTTL = ttl
+	}
+}
+
+// WithMetadataCodec sets the codec of the metadata of the DataFrames (used by source and
+// zipper), e.g. protobuf or msgpack for the tracing system. The source encodes the metadata
+// it writes by it, the zipper forwards the metadata as it is and decodes it only when it's
+// needed, e.g. by core.WithRoutingMetadataKey, so they must use the same codec.
+func WithMetadataCodec(codec frame.MetadataCodec) Option {
+	return func(o *Options) {
+		o.MetadataCodec = codec
+		o.ServerOptions = append(o.ServerOptions, core.WithMetadataCodec(codec))
+	}
+}
+
+// WithCompression compresses all the frames of the connections transparently (used by source,
+// sfn and zipper), it's negotiated by the ALPN of the TLS handshake, so the connection is
+// uncompressed unless both of the client and the zipper enable it.
+func WithCompression() Option {
+	return func(o *Options) {
+		o.ClientOptions = append(o.ClientOptions, core.WithClientCompression())
+		o.ServerOptions = append(o.ServerOptions, core.WithServerCompression())
+	}
+}
+
 // WithLogger sets the client logger
 func WithLogger(logger log.Logger) Option {
 	return func(o *Options) {
@@ -123,6 +267,16 @@ func WithLogger(logger log.Logger) Option {
 	}
 }
 
+// WithTransactionIDGenerator sets the transaction id generator (used by client)
+func WithTransactionIDGenerator(gen core.TransactionIDGenerator) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(
+			o.ClientOptions,
+			core.WithTransactionIDGenerator(gen),
+		)
+	}
+}
+
 // NewOptions creates a new options for YoMo-Client.
 func NewOptions(opts ...Option) *Options {
 	options := &Options{}
@@ -134,6 +288,9 @@ func NewOptions(opts ...Option) *Options {
 	if options.ZipperAddr == "" {
 		options.ZipperAddr = DefaultZipperAddr
 	}
+	if options.MetadataCodec == nil {
+		options.MetadataCodec = frame.DefaultMetadataCodec
+	}
 
 	return options
 }
@@ -0,0 +1,44 @@
+// Package carriage provides the application layer encryption of the DataFrame carriage,
+// the carriage is sealed by the source and opened by the stream functions which share the
+// key out of band, the YoMo-Zipper routes the ciphertext untouched and never has the key.
+package carriage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// ErrMalformed is returned by Open when the sealed carriage is shorter than the nonce.
+var ErrMalformed = errors.New("carriage: malformed sealed carriage")
+
+// NewAESGCM creates an AES-GCM AEAD, the key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates the carriage of the tag, the result is the random nonce
+// followed by the ciphertext. The tag is authenticated as well, so the sealed carriage
+// can't be opened with another tag.
+func Seal(aead cipher.AEAD, tag byte, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte{tag}), nil
+}
+
+// Open decrypts and authenticates the carriage sealed by Seal.
+func Open(aead cipher.AEAD, tag byte, sealed []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrMalformed
+	}
+	return aead.Open(nil, sealed[:n], sealed[n:], []byte{tag})
+}
@@ -0,0 +1,41 @@
+package carriage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealOpen(t *testing.T) {
+	aead, err := NewAESGCM(bytes.Repeat([]byte{0x01}, 32))
+	assert.NoError(t, err)
+
+	sealed, err := Seal(aead, 0x33, []byte("yomo"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(sealed), "yomo")
+	assert.Len(t, sealed, aead.NonceSize()+len("yomo")+aead.Overhead())
+
+	plaintext, err := Open(aead, 0x33, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("yomo"), plaintext)
+
+	// another tag, a tampered carriage or another key fails
+	_, err = Open(aead, 0x34, sealed)
+	assert.Error(t, err)
+	sealed[len(sealed)-1] ^= 0xFF
+	_, err = Open(aead, 0x33, sealed)
+	assert.Error(t, err)
+	other, _ := NewAESGCM(bytes.Repeat([]byte{0x02}, 32))
+	sealed, _ = Seal(aead, 0x33, []byte("yomo"))
+	_, err = Open(other, 0x33, sealed)
+	assert.Error(t, err)
+
+	_, err = Open(aead, 0x33, []byte{0x01})
+	assert.Equal(t, ErrMalformed, err)
+}
+
+func TestNewAESGCMInvalidKey(t *testing.T) {
+	_, err := NewAESGCM([]byte("short"))
+	assert.Error(t, err)
+}
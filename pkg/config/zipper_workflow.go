@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 
@@ -16,6 +17,30 @@ type App struct {
 // Workflow represents a YoMo Workflow.
 type Workflow struct {
 	Functions []App `yaml:"functions"`
+	// Entries pin the sources to their entry functions, the other sources enter the workflow
+	// by default. An entry function starts a pipeline which ends before the next entry
+	// function in the order of the functions, the DataFrames are forwarded within the
+	// pipeline only, so the independent pipelines never forward to each other.
+	Entries []Entry `yaml:"entries"`
+}
+
+// Entry pins a source to the function its DataFrames enter the workflow by, e.g. the sources
+// of independent pipelines sharing a workflow.
+type Entry struct {
+	// Source is the name of the source.
+	Source string `yaml:"source"`
+	// Function is the name of the entry function in the workflow.
+	Function string `yaml:"function"`
+}
+
+// EntryOf returns the entry function of the source, false if it's not pinned.
+func (w Workflow) EntryOf(source string) (string, bool) {
+	for _, e := range w.Entries {
+		if e.Source == source {
+			return e.Function, true
+		}
+	}
+	return "", false
 }
 
 // WorkflowConfig represents a YoMo Workflow config.
@@ -28,6 +53,28 @@ type WorkflowConfig struct {
 	Port int `yaml:"port"`
 	// Workflow represents the sfn workflow.
 	Workflow `yaml:",inline"`
+	// Overrides are the workflows of the apps routed differently from the shared workflow.
+	Overrides []Override `yaml:"overrides"`
+}
+
+// Override is the workflow of the clients authenticated by the app id, which replaces the
+// shared workflow for them, e.g. the sources of different apps forward to different next
+// stages after the same sfn.
+type Override struct {
+	// AppID is the app id of the clients.
+	AppID string `yaml:"app_id"`
+	// Workflow represents the sfn workflow of the app.
+	Workflow `yaml:",inline"`
+}
+
+// WorkflowOf returns the workflow of the app id, the shared workflow if there's no override.
+func (c *WorkflowConfig) WorkflowOf(appID string) Workflow {
+	for _, o := range c.Overrides {
+		if o.AppID == appID {
+			return o.Workflow
+		}
+	}
+	return c.Workflow
 }
 
 // LoadWorkflowConfig the WorkflowConfig by path.
@@ -92,6 +139,18 @@ func validateWorkflowConfig(wfConf *WorkflowConfig) error {
 	m := map[string][]App{
 		"Functions": wfConf.Functions,
 	}
+	if err := validateEntries("entries", wfConf.Workflow); err != nil {
+		return err
+	}
+	for i, o := range wfConf.Overrides {
+		if o.AppID == "" {
+			return fmt.Errorf("Missing app_id in overrides[%d] of workflow config", i)
+		}
+		m["Overrides["+o.AppID+"]"] = o.Functions
+		if err := validateEntries("overrides["+o.AppID+"].entries", o.Workflow); err != nil {
+			return err
+		}
+	}
 
 	missingParams := []string{}
 	for k, apps := range m {
@@ -117,3 +176,28 @@ func validateWorkflowConfig(wfConf *WorkflowConfig) error {
 
 	return nil
 }
+
+// validateEntries checks the entries refer to the functions of the workflow.
+func validateEntries(name string, wf Workflow) error {
+	sources := make(map[string]bool)
+	for i, e := range wf.Entries {
+		if e.Source == "" || e.Function == "" {
+			return fmt.Errorf("Missing source or function in %s[%d] of workflow config", name, i)
+		}
+		if sources[e.Source] {
+			return fmt.Errorf("Duplicate source %s in %s of workflow config", e.Source, name)
+		}
+		sources[e.Source] = true
+		found := false
+		for _, app := range wf.Functions {
+			if app.Name == e.Function {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Unknown function %s in %s[%d] of workflow config", e.Function, name, i)
+		}
+	}
+	return nil
+}
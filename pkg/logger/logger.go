@@ -3,40 +3,82 @@ package logger
 import (
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/yomorun/yomo/core/log"
 )
 
-var logger = Default(isEnableDebug())
+var (
+	// current holds the default logger, it's replaced by EnableDebug while the others log.
+	current atomic.Value // loggerHolder
+	// debug is 1 if the DebugLevel is enabled, accessed atomically.
+	debug int32
+)
+
+// loggerHolder keeps the concrete type stored in current the same.
+type loggerHolder struct{ log.Logger }
+
+func init() {
+	current.Store(loggerHolder{Default(isEnableDebug())})
+	if isEnableDebug() || logLevel() == log.DebugLevel {
+		debug = 1
+	}
+}
+
+// defaultLogger returns the default logger.
+func defaultLogger() log.Logger {
+	return current.Load().(loggerHolder).Logger
+}
 
 // EnableDebug enables the development model for logging.
 func EnableDebug() {
-	logger = Default(true)
+	atomic.StoreInt32(&debug, 1)
+	current.Store(loggerHolder{Default(true)})
+}
+
+// IsDebug indicates whether the DebugLevel is enabled, hot paths use it to avoid
+// building the debug messages which will be discarded.
+func IsDebug() bool {
+	return atomic.LoadInt32(&debug) == 1
 }
 
 // Printf prints a formated message without a specified level.
 func Printf(format string, v ...interface{}) {
-	logger.Printf(format, v...)
+	defaultLogger().Printf(format, v...)
 }
 
 // Debugf logs a message at DebugLevel.
 func Debugf(template string, args ...interface{}) {
-	logger.Debugf(template, args...)
+	defaultLogger().Debugf(template, args...)
 }
 
 // Infof logs a message at InfoLevel.
 func Infof(template string, args ...interface{}) {
-	logger.Infof(template, args...)
+	defaultLogger().Infof(template, args...)
 }
 
 // Warnf logs a message at WarnLevel.
 func Warnf(template string, args ...interface{}) {
-	logger.Warnf(template, args...)
+	defaultLogger().Warnf(template, args...)
 }
 
 // Errorf logs a message at ErrorLevel.
 func Errorf(template string, args ...interface{}) {
-	logger.Errorf(template, args...)
+	defaultLogger().Errorf(template, args...)
+}
+
+// With returns a logger with the key/value pairs added to every message, e.g. "conn_id",
+// "name" and "frame_type", With() returns the default logger itself.
+func With(keysAndValues ...interface{}) log.Logger {
+	if len(keysAndValues) == 0 {
+		return defaultLogger()
+	}
+	return log.With(defaultLogger(), keysAndValues...)
+}
+
+// SetEncoding sets the format of the default logger, "console" (default) or "json".
+func SetEncoding(enc string) {
+	defaultLogger().SetEncoding(enc)
 }
 
 // isEnableDebug indicates whether the debug is enabled.
@@ -69,6 +111,17 @@ func logLevel() log.Level {
 	return level
 }
 
+// logColor returns whether the color codes are enabled by YOMO_LOG_COLOR, false if it isn't set.
+func logColor() (bool, bool) {
+	switch strings.ToLower(os.Getenv("YOMO_LOG_COLOR")) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
 func output() string {
 	return strings.ToLower(os.Getenv("YOMO_LOG_OUTPUT"))
 }
@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnableDebugConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		EnableDebug()
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if IsDebug() {
+				Debugf("debug %d", i)
+			}
+			With("i", i)
+		}
+	}()
+	wg.Wait()
+	assert.True(t, IsDebug())
+}
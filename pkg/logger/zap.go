@@ -1,8 +1,13 @@
 package logger
 
 import (
+	"fmt"
 	stdlog "log"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yomorun/yomo/core/log"
@@ -17,14 +22,23 @@ const (
 
 // zapLogger is the logger implementation in go.uber.org/zap
 type zapLogger struct {
+	mu          *sync.Mutex // guards the settings and the building of the instance
 	level       zapcore.Level
 	debug       bool
 	encoding    string
 	opts        []zap.Option
 	logger      *zap.Logger
-	instance    *zap.SugaredLogger
+	built       atomic.Value // zapInstance, it's rebuilt once the encoding changes
 	output      string
 	errorOutput string
+	fields      []interface{} // the key/value pairs added by With
+}
+
+// zapInstance is the built instance with the encoding it's built by, so the logging reads
+// them together without the lock.
+type zapInstance struct {
+	sugared *zap.SugaredLogger
+	json    bool
 }
 
 // Default the default logger instance
@@ -56,6 +70,7 @@ func New(opts ...zap.Option) log.Logger {
 	stdlog.Default().SetOutput(new(logWriter))
 
 	z := zapLogger{
+		mu:       new(sync.Mutex),
 		level:    zap.ErrorLevel,
 		debug:    false,
 		encoding: "console",
@@ -66,11 +81,11 @@ func New(opts ...zap.Option) log.Logger {
 }
 
 func openSinks(cfg zap.Config) (zapcore.WriteSyncer, zapcore.WriteSyncer, error) {
-	sink, closeOut, err := zap.Open(cfg.OutputPaths...)
+	sink, closeOut, err := openPaths(cfg.OutputPaths)
 	if err != nil {
 		return nil, nil, err
 	}
-	errSink, _, err := zap.Open(cfg.ErrorOutputPaths...)
+	errSink, _, err := openPaths(cfg.ErrorOutputPaths)
 	if err != nil {
 		closeOut()
 		return nil, nil, err
@@ -78,31 +93,157 @@ func openSinks(cfg zap.Config) (zapcore.WriteSyncer, zapcore.WriteSyncer, error)
 	return sink, errSink, nil
 }
 
-// SetEncoding set logger message coding
+// openPaths opens the sinks of the paths, the color codes are removed from the ones which
+// aren't a terminal, e.g. the files.
+func openPaths(paths []string) (zapcore.WriteSyncer, func(), error) {
+	sinks := make([]zapcore.WriteSyncer, 0, len(paths))
+	closers := make([]func(), 0, len(paths))
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	for _, path := range paths {
+		sink, c, err := zap.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, c)
+		if !isColored(path) {
+			sink = &noColorWriter{sink}
+		}
+		sinks = append(sinks, sink)
+	}
+	return zapcore.NewMultiWriteSyncer(sinks...), closeAll, nil
+}
+
+// isColored indicates whether the color codes are kept in the output of the path, it's
+// YOMO_LOG_COLOR if it's set, otherwise whether the output is a terminal.
+func isColored(path string) bool {
+	if color, ok := logColor(); ok {
+		return color
+	}
+	switch path {
+	case "stderr":
+		return isTerminal(os.Stderr)
+	case "stdout":
+		return isTerminal(os.Stdout)
+	}
+	return false
+}
+
+// isTerminal indicates whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// noColorWriter removes the ANSI color codes, e.g. of the log prefixes and the levels, before
+// writing to the underlying sink.
+type noColorWriter struct {
+	zapcore.WriteSyncer
+}
+
+func (w *noColorWriter) Write(p []byte) (int, error) {
+	if _, err := w.WriteSyncer.Write(colorPattern.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetEncoding set logger message coding, "console" is the pretty format, "json" emits the
+// machine-parseable fields: ts, level, component, msg and the fields added by With.
 func (z *zapLogger) SetEncoding(enc string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
 	z.encoding = enc
+	// rebuild the instance with the new encoder
+	z.built.Store(zapInstance{})
+}
+
+// With returns a child logger with the fields, they're the JSON fields in the JSON format,
+// and appended to the messages as key=value in the pretty format.
+func (z *zapLogger) With(keysAndValues ...interface{}) log.Logger {
+	// the child shares the sinks of the parent
+	parent := z.instance()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	child := &zapLogger{
+		mu:          new(sync.Mutex),
+		level:       z.level,
+		debug:       z.debug,
+		encoding:    z.encoding,
+		opts:        z.opts,
+		logger:      z.logger,
+		output:      z.output,
+		errorOutput: z.errorOutput,
+		fields:      append(append([]interface{}{}, z.fields...), keysAndValues...),
+	}
+	if parent.json {
+		parent.sugared = parent.sugared.With(keysAndValues...)
+	}
+	child.built.Store(parent)
+	return child
+}
+
+// pretty formats the message of the pretty format, the fields are appended to it.
+func (z *zapLogger) pretty(template string, args []interface{}) string {
+	msg := fmt.Sprintf(template, args...)
+	if len(z.fields) == 0 {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(msg, "\n"))
+	for i := 0; i+1 < len(z.fields); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", z.fields[i], z.fields[i+1])
+	}
+	return b.String()
+}
+
+// enabled reports whether the level is enabled, so the message isn't formatted in vain.
+func (z *zapLogger) enabled(lvl zapcore.Level) bool {
+	return z.Instance().Desugar().Core().Enabled(lvl)
+}
+
+// Enabled reports whether the messages of the level are logged.
+func (z *zapLogger) Enabled(lvl log.Level) bool {
+	return z.enabled(zapLevel(lvl))
+}
+
+func (z *zapLogger) isJSON() bool {
+	return z.instance().json
 }
 
 // SetLevel set logger level
 func (z *zapLogger) SetLevel(lvl log.Level) {
-	isDebug := lvl == log.DebugLevel
-	level := zap.ErrorLevel
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.level = zapLevel(lvl)
+	z.debug = lvl == log.DebugLevel
+}
+
+// zapLevel returns the zap level of the log level, the others are ErrorLevel.
+func zapLevel(lvl log.Level) zapcore.Level {
 	switch lvl {
 	case log.DebugLevel:
-		level = zap.DebugLevel
+		return zap.DebugLevel
 	case log.InfoLevel:
-		level = zap.InfoLevel
+		return zap.InfoLevel
 	case log.WarnLevel:
-		level = zap.WarnLevel
-	case log.ErrorLevel:
-		level = zap.ErrorLevel
+		return zap.WarnLevel
+	default:
+		return zap.ErrorLevel
 	}
-	z.level = level
-	z.debug = isDebug
 }
 
 // Output file path to write log message
 func (z *zapLogger) Output(file string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
 	if file != "" {
 		z.output = file
 	}
@@ -110,99 +251,203 @@ func (z *zapLogger) Output(file string) {
 
 // ErrorOutput file path to write log message
 func (z *zapLogger) ErrorOutput(file string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
 	if file != "" {
 		z.errorOutput = file
 	}
 }
 
-// Printf logs a message wihout level
+// Printf logs a message wihout level, it's at InfoLevel in the JSON format.
 func (z *zapLogger) Printf(format string, v ...interface{}) {
+	if z.isJSON() {
+		msg, fields := structured(format, v)
+		z.Instance().Infow(msg, fields...)
+		return
+	}
+	if len(z.fields) > 0 {
+		stdlog.Println(z.pretty(format, v))
+		return
+	}
 	stdlog.Printf(format, v...)
 }
 
 // Debugf logs a message at DebugLevel
 func (z *zapLogger) Debugf(template string, args ...interface{}) {
+	if !z.enabled(zapcore.DebugLevel) {
+		return
+	}
+	if z.isJSON() {
+		msg, fields := structured(template, args)
+		z.Instance().Debugw(msg, fields...)
+		return
+	}
+	if len(z.fields) > 0 {
+		z.Instance().Debug(z.pretty(template, args))
+		return
+	}
 	z.Instance().Debugf(template, args...)
 }
 
 // Infof logs a message at InfoLevel
 func (z *zapLogger) Infof(template string, args ...interface{}) {
+	if !z.enabled(zapcore.InfoLevel) {
+		return
+	}
+	if z.isJSON() {
+		msg, fields := structured(template, args)
+		z.Instance().Infow(msg, fields...)
+		return
+	}
+	if len(z.fields) > 0 {
+		z.Instance().Info(z.pretty(template, args))
+		return
+	}
 	z.Instance().Infof(template, args...)
 }
 
 // Warnf logs a message at WarnLevel
-func (z zapLogger) Warnf(template string, args ...interface{}) {
+func (z *zapLogger) Warnf(template string, args ...interface{}) {
+	if !z.enabled(zapcore.WarnLevel) {
+		return
+	}
+	if z.isJSON() {
+		msg, fields := structured(template, args)
+		z.Instance().Warnw(msg, fields...)
+		return
+	}
+	if len(z.fields) > 0 {
+		z.Instance().Warn(z.pretty(template, args))
+		return
+	}
 	z.Instance().Warnf(template, args...)
 }
 
 // Errorf logs a message at ErrorLevel
-func (z zapLogger) Errorf(template string, args ...interface{}) {
+func (z *zapLogger) Errorf(template string, args ...interface{}) {
+	if !z.enabled(zapcore.ErrorLevel) {
+		return
+	}
+	if z.isJSON() {
+		msg, fields := structured(template, args)
+		z.Instance().Errorw(msg, fields...)
+		return
+	}
+	if len(z.fields) > 0 {
+		z.Instance().Error(z.pretty(template, args))
+		return
+	}
 	z.Instance().Errorf(template, args...)
 }
 
+var (
+	// prefixPattern matches the colored component prefix, e.g. ServerLogPrefix.
+	prefixPattern = regexp.MustCompile(`^\x1b\[[0-9;]*m\[([^\]]+)\]\x1b\[0m ?`)
+	// colorPattern matches the ANSI color codes.
+	colorPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+)
+
+// structured formats the message for the JSON format, the component prefix is moved to the
+// "component" field and the color codes are removed.
+func structured(template string, args []interface{}) (string, []interface{}) {
+	msg := fmt.Sprintf(template, args...)
+	var fields []interface{}
+	if m := prefixPattern.FindStringSubmatch(msg); m != nil {
+		fields = []interface{}{"component", m[1]}
+		msg = msg[len(m[0]):]
+	}
+	msg = strings.TrimSpace(colorPattern.ReplaceAllString(msg, ""))
+	return msg, fields
+}
+
+// Instance returns the zap logger, it's built by the settings once it's used.
 func (z *zapLogger) Instance() *zap.SugaredLogger {
-	if z.instance == nil {
-		// zap
-		encoderConfig := zapcore.EncoderConfig{
-			TimeKey:        "ts",
-			LevelKey:       "level",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			FunctionKey:    zapcore.OmitKey,
-			MessageKey:     "msg",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.CapitalColorLevelEncoder,
-			EncodeTime:     timeEncoder,
-			EncodeDuration: zapcore.SecondsDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-		}
-		cfg := zap.Config{
-			Level:             zap.NewAtomicLevelAt(zap.ErrorLevel),
-			Development:       z.debug,
-			DisableCaller:     true,
-			DisableStacktrace: true,
-			Encoding:          z.encoding,
-			EncoderConfig:     encoderConfig,
-			OutputPaths:       []string{"stderr"},
-			ErrorOutputPaths:  []string{"stderr"},
-		}
-		cfg.Level.SetLevel(z.level)
-		if z.debug {
-			// set the minimal level to debug
-			cfg.Level.SetLevel(zap.DebugLevel)
-		}
-		// output
-		if z.output != "" {
-			cfg.OutputPaths = append(cfg.OutputPaths, z.output)
-		}
-		encoder := zapcore.NewConsoleEncoder(encoderConfig)
-		sink, _, err := openSinks(cfg)
-		if err != nil {
-			panic(err)
+	return z.instance().sugared
+}
+
+// instance returns the built instance, it's built if the settings have changed.
+func (z *zapLogger) instance() zapInstance {
+	if built, ok := z.built.Load().(zapInstance); ok && built.sugared != nil {
+		return built
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if built, ok := z.built.Load().(zapInstance); ok && built.sugared != nil {
+		return built
+	}
+	// zap
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:        "ts",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.CapitalColorLevelEncoder,
+		EncodeTime:     timeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	cfg := zap.Config{
+		Level:             zap.NewAtomicLevelAt(zap.ErrorLevel),
+		Development:       z.debug,
+		DisableCaller:     true,
+		DisableStacktrace: true,
+		Encoding:          z.encoding,
+		EncoderConfig:     encoderConfig,
+		OutputPaths:       []string{"stderr"},
+		ErrorOutputPaths:  []string{"stderr"},
+	}
+	cfg.Level.SetLevel(z.level)
+	if z.debug {
+		// set the minimal level to debug
+		cfg.Level.SetLevel(zap.DebugLevel)
+	}
+	// output
+	if z.output != "" {
+		cfg.OutputPaths = append(cfg.OutputPaths, z.output)
+	}
+	encoder := zapcore.NewConsoleEncoder(encoderConfig)
+	if z.encoding == "json" {
+		encoderConfig.EncodeLevel = zapcore.LowercaseLevelEncoder
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+	sink, _, err := openSinks(cfg)
+	if err != nil {
+		panic(err)
+	}
+	core := zapcore.NewCore(encoder, sink, cfg.Level)
+	// error output
+	if z.errorOutput != "" {
+		rotatedLogger := errorRotatedLogger(z.errorOutput, 10, 30, 7)
+		errorSink := zapcore.AddSync(rotatedLogger)
+		if !isColored(z.errorOutput) {
+			errorSink = &noColorWriter{errorSink}
 		}
-		core := zapcore.NewCore(encoder, sink, cfg.Level)
-		// error output
-		if z.errorOutput != "" {
-			rotatedLogger := errorRotatedLogger(z.errorOutput, 10, 30, 7)
-			errorOutputOption := zap.Hooks(func(entry zapcore.Entry) error {
-				if entry.Level == zap.ErrorLevel {
-					msg, err := encoder.EncodeEntry(entry, nil)
-					if err != nil {
-						return err
-					}
-					rotatedLogger.Write(msg.Bytes())
+		errorOutputOption := zap.Hooks(func(entry zapcore.Entry) error {
+			if entry.Level == zap.ErrorLevel {
+				msg, err := encoder.EncodeEntry(entry, nil)
+				if err != nil {
+					return err
 				}
-				return nil
-			})
-			z.opts = append(z.opts, errorOutputOption)
-		}
-		logger := zap.New(core, z.opts...)
+				errorSink.Write(msg.Bytes())
+			}
+			return nil
+		})
+		z.opts = append(z.opts, errorOutputOption)
+	}
+	logger := zap.New(core, z.opts...)
 
-		z.logger = logger
-		z.instance = z.logger.Sugar()
+	z.logger = logger
+	built := zapInstance{sugared: z.logger.Sugar(), json: z.encoding == "json"}
+	if built.json {
+		built.sugared = built.sugared.With(z.fields...)
 	}
-	return z.instance
+	z.built.Store(built)
+	return built
 }
 
 func errorRotatedLogger(file string, maxSize, maxBacukups, maxAge int) *lumberjack.Logger {
@@ -224,5 +469,11 @@ type logWriter struct{}
 func (l logWriter) Write(bytes []byte) (int, error) {
 	os.Stderr.WriteString(time.Now().Format(timeFormat))
 	os.Stderr.Write([]byte("\t"))
+	if !isColored("stderr") {
+		if _, err := os.Stderr.Write(colorPattern.ReplaceAll(bytes, nil)); err != nil {
+			return 0, err
+		}
+		return len(bytes), nil
+	}
 	return os.Stderr.Write(bytes)
 }
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core/log"
+)
+
+func TestJSONEncoding(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "yomo.log")
+	l := New()
+	l.SetLevel(log.InfoLevel)
+	l.SetEncoding("json")
+	l.Output(file)
+
+	l.(log.FieldLogger).With("conn_id", "conn-1", "name", "sfn-1").Warnf("%s❤️  [%s] is connected!", "\033[32m[core:server]\033[0m ", "\033[31msfn-1\033[0m")
+	l.Errorf("no prefix")
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "core:server", entry["component"])
+	assert.Equal(t, "❤️  [sfn-1] is connected!", entry["msg"])
+	assert.Equal(t, "conn-1", entry["conn_id"])
+	assert.Equal(t, "sfn-1", entry["name"])
+
+	entry = nil
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "no prefix", entry["msg"])
+	assert.NotContains(t, entry, "component")
+	assert.NotContains(t, entry, "conn_id")
+}
+
+func TestWithConsoleEncoding(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "yomo.log")
+	l := New()
+	l.SetLevel(log.InfoLevel)
+	l.Output(file)
+
+	child := l.(log.FieldLogger).With("request_id", "a1b2c3")
+	child.Infof("%s is connected!", "sfn-1")
+	l.Infof("no fields")
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+	// the pretty format appends the fields to the message
+	assert.True(t, strings.HasSuffix(lines[0], "sfn-1 is connected! request_id=a1b2c3"), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], "no fields"), lines[1])
+}
+
+func TestNoColorOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "yomo.log")
+	l := New()
+	l.SetLevel(log.InfoLevel)
+	l.Output(file)
+
+	l.Warnf("%s❤️  [%s] is connected!", "\033[32m[core:server]\033[0m ", "\033[31msfn-1\033[0m")
+
+	// the file isn't a terminal, the prefix and the level aren't colored
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	line := strings.TrimSpace(string(data))
+	assert.NotContains(t, line, "\033[")
+	assert.Contains(t, line, "WARN")
+	assert.True(t, strings.HasSuffix(line, "[core:server] ❤️  [sfn-1] is connected!"), line)
+
+	defer os.Unsetenv("YOMO_LOG_COLOR")
+	os.Setenv("YOMO_LOG_COLOR", "true")
+	assert.True(t, isColored(file))
+	os.Setenv("YOMO_LOG_COLOR", "false")
+	assert.False(t, isColored("stderr"))
+}
+
+func TestSetEncodingConcurrently(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "yomo.log")
+	l := New()
+	l.SetLevel(log.InfoLevel)
+	l.Output(file)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.SetEncoding("json")
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.Infof("message %d", i)
+			l.(log.FieldLogger).With("i", i)
+		}
+	}()
+	wg.Wait()
+
+	// the messages after the encoding is set are JSON
+	l.Errorf("json")
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &entry))
+	assert.Equal(t, "json", entry["msg"])
+}
+
+func TestEnabled(t *testing.T) {
+	l := New()
+	l.SetLevel(log.InfoLevel)
+	assert.False(t, log.IsDebug(l))
+	assert.True(t, l.(log.LevelEnabler).Enabled(log.WarnLevel))
+
+	l = New()
+	l.SetLevel(log.DebugLevel)
+	assert.True(t, log.IsDebug(l))
+}
@@ -0,0 +1,87 @@
+// Package registry provides the service discovery backends the YoMo-Zipper mirrors the
+// connected stream functions into, see core.WithRegistry.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yomorun/yomo/core"
+)
+
+var _ core.Registry = (*Consul)(nil)
+
+// Consul registers the stream function instances as the services of the local Consul agent
+// by its HTTP API, the service name is the name of the stream function, the service id is
+// the connection id of the instance.
+type Consul struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// NewConsul creates a Consul registry of the agent address, e.g. "http://127.0.0.1:8500",
+// the token is the ACL token, empty means no ACL.
+func NewConsul(addr string, token string) *Consul {
+	return &Consul{
+		addr:   addr,
+		token:  token,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type consulService struct {
+	ID   string            `json:"ID"`
+	Name string            `json:"Name"`
+	Tags []string          `json:"Tags,omitempty"`
+	Meta map[string]string `json:"Meta,omitempty"`
+}
+
+// Register the instance as a service of the agent, which replaces the existing one of the id.
+func (c *Consul) Register(id string, meta core.RegistryMeta) error {
+	service := consulService{
+		ID:   id,
+		Name: meta.Name,
+		Meta: map[string]string{
+			"app_id": meta.AppID,
+			"weight": strconv.FormatUint(uint64(meta.Weight), 10),
+		},
+	}
+	for _, tag := range meta.ObserveDataTags {
+		service.Tags = append(service.Tags, fmt.Sprintf("tag-%#x", tag))
+	}
+	body, err := json.Marshal(service)
+	if err != nil {
+		return err
+	}
+	return c.put("/v1/agent/service/register", body)
+}
+
+// Deregister the service of the instance from the agent.
+func (c *Consul) Deregister(id string) error {
+	return c.put("/v1/agent/service/deregister/"+url.PathEscape(id), nil)
+}
+
+func (c *Consul) put(path string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry: consul %s: %s", path, resp.Status)
+	}
+	return nil
+}
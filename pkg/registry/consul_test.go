@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core"
+)
+
+func TestConsul(t *testing.T) {
+	var services []consulService
+	var deregistered []string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "secret", r.Header.Get("X-Consul-Token"))
+		switch r.URL.Path {
+		case "/v1/agent/service/register":
+			var service consulService
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&service))
+			services = append(services, service)
+		case "/v1/agent/service/deregister/127.0.0.1:9000":
+			deregistered = append(deregistered, "127.0.0.1:9000")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer agent.Close()
+
+	c := NewConsul(agent.URL, "secret")
+	assert.NoError(t, c.Register("127.0.0.1:9000", core.RegistryMeta{AppID: "app", Name: "sfn-1", ObserveDataTags: []byte{0x33}, Weight: 2}))
+	assert.NoError(t, c.Deregister("127.0.0.1:9000"))
+	assert.Error(t, c.Deregister("unknown"))
+
+	assert.Equal(t, []consulService{{
+		ID:   "127.0.0.1:9000",
+		Name: "sfn-1",
+		Tags: []string{"tag-0x33"},
+		Meta: map[string]string{"app_id": "app", "weight": "2"},
+	}}, services)
+	assert.Equal(t, []string{"127.0.0.1:9000"}, deregistered)
+}
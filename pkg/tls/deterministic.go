@@ -0,0 +1,44 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// DeterministicCertOptions returns the options which generate the same certificate for the
+// same seed and time, e.g. to pin the certificate or to assert on the TLS behavior in the
+// tests. The keys are ed25519, the randomness is derived from the seed, so the options are
+// consumed by a certificate, call it again for the next one. It's for the tests only, the
+// key is predictable by anyone knows the seed.
+func DeterministicCertOptions(seed string, now time.Time) CertOptions {
+	return CertOptions{
+		KeyAlgorithm: KeyAlgorithmEd25519,
+		Rand:         &seededReader{seed: []byte(seed)},
+		Now:          func() time.Time { return now },
+	}
+}
+
+// seededReader is the stream of SHA-256(seed || counter) blocks.
+type seededReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var counter [8]byte
+			binary.BigEndian.PutUint64(counter[:], r.counter)
+			r.counter++
+			sum := sha256.Sum256(append(append([]byte(nil), r.seed...), counter[:]...))
+			r.buf = sum[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -2,28 +2,112 @@ package tls
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var isDev bool
 
-// CreateServerTLSConfig creates server tls config.
-func CreateServerTLSConfig(host string) (*tls.Config, error) {
+// ALPN is the protocol of YoMo negotiated by the TLS handshake.
+const ALPN = "yomo"
+
+// The key algorithms of the generated certificate.
+const (
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+	KeyAlgorithmRSA2048   = "rsa-2048"
+	KeyAlgorithmEd25519   = "ed25519"
+)
+
+// CertOptions are the parameters of the certificate generated in the development mode, the
+// zero fields fall back to the environment variables, then to the defaults, so the binary
+// can be adjusted without code.
+type CertOptions struct {
+	// Organization is the organization of the subject, `YOMO_TLS_CERT_ORG` separated by
+	// commas, default is "YoMo".
+	Organization []string
+	// SANs are the extra DNS names and IP addresses besides localhost and the listening
+	// hosts, `YOMO_TLS_CERT_SANS` separated by commas.
+	SANs []string
+	// ValidityDays is how many days the certificate is valid, `YOMO_TLS_CERT_VALIDITY_DAYS`,
+	// default is 365.
+	ValidityDays int
+	// KeyAlgorithm is the algorithm of the keys, `YOMO_TLS_CERT_KEY_ALGORITHM`, one of
+	// "ecdsa-p256" (default), "ecdsa-p384", "rsa-2048" and "ed25519".
+	KeyAlgorithm string
+	// Rand is the source of the randomness of the keys and the serial numbers, default is
+	// crypto/rand. The certificate is reproducible by a fixed source with the "ed25519" keys
+	// only, the ECDSA and the RSA keys of the standard library aren't, see
+	// DeterministicCertOptions. It must never be fixed out of the tests.
+	Rand io.Reader
+	// Now returns the time the certificate is valid from, default is time.Now.
+	Now func() time.Time
+}
+
+// withEnv fills the zero fields by the environment variables.
+func (o CertOptions) withEnv() (CertOptions, error) {
+	if len(o.Organization) == 0 {
+		o.Organization = splitEnv("YOMO_TLS_CERT_ORG")
+	}
+	if len(o.SANs) == 0 {
+		o.SANs = splitEnv("YOMO_TLS_CERT_SANS")
+	}
+	if o.ValidityDays == 0 {
+		if v := os.Getenv("YOMO_TLS_CERT_VALIDITY_DAYS"); v != "" {
+			days, err := strconv.Atoi(v)
+			if err != nil || days <= 0 {
+				return o, fmt.Errorf("tls: invalid YOMO_TLS_CERT_VALIDITY_DAYS: %q", v)
+			}
+			o.ValidityDays = days
+		}
+	}
+	if o.KeyAlgorithm == "" {
+		o.KeyAlgorithm = strings.ToLower(os.Getenv("YOMO_TLS_CERT_KEY_ALGORITHM"))
+	}
+	return o, nil
+}
+
+// splitEnv returns the non-empty values of the environment variable separated by commas.
+func splitEnv(key string) []string {
+	var result []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// CreateServerTLSConfig creates server tls config, the certificate of the development
+// mode covers all the hosts.
+func CreateServerTLSConfig(host ...string) (*tls.Config, error) {
+	return CreateServerTLSConfigWithOptions(CertOptions{}, host...)
+}
+
+// CreateServerTLSConfigWithOptions creates server tls config, the certificate of the
+// development mode is generated by the options and it covers all the hosts.
+func CreateServerTLSConfigWithOptions(opts CertOptions, host ...string) (*tls.Config, error) {
 	// development mode
 	if isDev {
-		tc, err := developmentTLSConfig(host)
+		tc, err := developmentTLSConfig(opts, host...)
 		if err != nil {
 			return nil, err
 		}
@@ -45,7 +129,7 @@ func CreateServerTLSConfig(host string) (*tls.Config, error) {
 		Certificates: []tls.Certificate{*tlsCert},
 		ClientCAs:    pool,
 		ClientAuth:   tls.RequireAndVerifyClientCert,
-		NextProtos:   []string{"yomo"},
+		NextProtos:   []string{ALPN},
 	}, nil
 }
 
@@ -55,7 +139,7 @@ func CreateClientTLSConfig() (*tls.Config, error) {
 	if isDev {
 		return &tls.Config{
 			InsecureSkipVerify: true,
-			NextProtos:         []string{"yomo"},
+			NextProtos:         []string{ALPN},
 			ClientSessionCache: tls.NewLRUClientSessionCache(64),
 		}, nil
 	}
@@ -74,7 +158,7 @@ func CreateClientTLSConfig() (*tls.Config, error) {
 		InsecureSkipVerify: false,
 		Certificates:       []tls.Certificate{*tlsCert},
 		RootCAs:            pool,
-		NextProtos:         []string{"yomo"},
+		NextProtos:         []string{ALPN},
 		ClientSessionCache: tls.NewLRUClientSessionCache(0),
 	}, nil
 }
@@ -144,8 +228,12 @@ func IsDev() bool {
 }
 
 // developmentTLSConfig Setup a bare-bones TLS config for the server
-func developmentTLSConfig(host ...string) (*tls.Config, error) {
-	tlsCert, err := generateCertificate(host...)
+func developmentTLSConfig(opts CertOptions, host ...string) (*tls.Config, error) {
+	opts, err := opts.withEnv()
+	if err != nil {
+		return nil, err
+	}
+	tlsCert, err := generateCertificate(opts, os.Getenv("YOMO_TLS_DEV_CA") == "true", host...)
 	if err != nil {
 		return nil, err
 	}
@@ -153,40 +241,30 @@ func developmentTLSConfig(host ...string) (*tls.Config, error) {
 	return &tls.Config{
 		Certificates:       []tls.Certificate{tlsCert},
 		ClientSessionCache: tls.NewLRUClientSessionCache(1),
-		NextProtos:         []string{"yomo"},
+		NextProtos:         []string{ALPN},
 	}, nil
 }
 
-func generateCertificate(host ...string) (tls.Certificate, error) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// generateCertificate generates the leaf certificate of the server, it's self-signed by
+// default. It's signed by a generated CA instead if withCA is true, the CA certificate is
+// appended to the chain, configured by the environment variable `YOMO_TLS_DEV_CA=true`.
+func generateCertificate(opts CertOptions, withCA bool, host ...string) (tls.Certificate, error) {
+	if opts.Rand == nil {
+		opts.Rand = rand.Reader
+	}
+	priv, err := generateKey(opts.Rand, opts.KeyAlgorithm)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(time.Hour * 24 * 365)
-
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	template, err := certificateTemplate(opts)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
-
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"YoMo"},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-		DNSNames:              []string{"localhost"},
-	}
-
-	for _, h := range host {
+	template.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	template.DNSNames = []string{"localhost"}
+	for _, h := range append(host, opts.SANs...) {
 		if ip := net.ParseIP(h); ip != nil {
 			template.IPAddresses = append(template.IPAddresses, ip)
 		} else {
@@ -194,28 +272,49 @@ func generateCertificate(host ...string) (tls.Certificate, error) {
 		}
 	}
 
-	template.IsCA = true
-	template.KeyUsage |= x509.KeyUsageCertSign
+	// the leaf is self-signed unless it's signed by the CA
+	parent, parentKey := template, priv
+	var caDER []byte
+	if withCA {
+		if parentKey, err = generateKey(opts.Rand, opts.KeyAlgorithm); err != nil {
+			return tls.Certificate{}, err
+		}
+		if parent, err = certificateTemplate(opts); err != nil {
+			return tls.Certificate{}, err
+		}
+		parent.Subject.CommonName = "YoMo Development CA"
+		parent.IsCA = true
+		parent.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		if caDER, err = x509.CreateCertificate(opts.Rand, parent, parent, parentKey.Public(), parentKey); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	derBytes, err := x509.CreateCertificate(opts.Rand, template, parent, priv.Public(), parentKey)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
 
-	// create public key
+	// create public key, followed by the CA certificate if any
 	certOut := bytes.NewBuffer(nil)
 	err = pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	if err != nil {
 		return tls.Certificate{}, err
 	}
+	if caDER != nil {
+		err = pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
 
 	// create private key
 	keyOut := bytes.NewBuffer(nil)
-	b, err := x509.MarshalECPrivateKey(priv)
+	b, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
 		return tls.Certificate{}, err
 	}
-	err = pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+	err = pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: b})
 	if err != nil {
 		return tls.Certificate{}, err
 	}
@@ -223,6 +322,56 @@ func generateCertificate(host ...string) (tls.Certificate, error) {
 	return tls.X509KeyPair(certOut.Bytes(), keyOut.Bytes())
 }
 
+// generateKey generates the private key of the algorithm, ECDSA P-256 by default.
+func generateKey(random io.Reader, algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "", KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), random)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), random)
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(random, 2048)
+	case KeyAlgorithmEd25519:
+		_, priv, err := ed25519.GenerateKey(random)
+		return priv, err
+	}
+	return nil, fmt.Errorf("tls: unknown key algorithm: %q", algorithm)
+}
+
+// certificateTemplate returns the template valid for the days of the options, a year by
+// default, with a random serial number.
+func certificateTemplate(opts CertOptions) (*x509.Certificate, error) {
+	days := opts.ValidityDays
+	if days <= 0 {
+		days = 365
+	}
+	organization := opts.Organization
+	if len(organization) == 0 {
+		organization = []string{"YoMo"}
+	}
+	notBefore := time.Now()
+	if opts.Now != nil {
+		notBefore = opts.Now()
+	}
+	notAfter := notBefore.Add(time.Hour * 24 * time.Duration(days))
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(opts.Rand, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: organization,
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+	}, nil
+}
+
 func init() {
 	env := os.Getenv("YOMO_ENV")
 	isDev = len(env) == 0 || env != "production"
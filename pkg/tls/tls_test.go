@@ -0,0 +1,142 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCertificateLeaf(t *testing.T) {
+	cert, err := generateCertificate(CertOptions{}, false, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Len(t, cert.Certificate, 1)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.True(t, leaf.BasicConstraintsValid)
+	assert.False(t, leaf.IsCA)
+	assert.Zero(t, leaf.KeyUsage&x509.KeyUsageCertSign)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	assert.NoError(t, handshake(cert, pool, "localhost"))
+	assert.NoError(t, handshake(cert, pool, "127.0.0.1"))
+}
+
+func TestGenerateCertificateWithCA(t *testing.T) {
+	cert, err := generateCertificate(CertOptions{}, true)
+	assert.NoError(t, err)
+	assert.Len(t, cert.Certificate, 2)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.False(t, leaf.IsCA)
+	ca, err := x509.ParseCertificate(cert.Certificate[1])
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+	assert.NotZero(t, ca.KeyUsage&x509.KeyUsageCertSign)
+
+	// the client trusts the CA only
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	assert.NoError(t, handshake(cert, pool, "localhost"))
+	assert.Error(t, handshake(cert, x509.NewCertPool(), "localhost"))
+}
+
+// handshake with a client verifies the certificate by the roots, the key usage and the
+// basic constraints.
+func handshake(cert tls.Certificate, roots *x509.CertPool, serverName string) error {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go server.Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{RootCAs: roots, ServerName: serverName})
+	if err := client.Handshake(); err != nil {
+		return err
+	}
+	state := client.ConnectionState()
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+func TestGenerateCertificateOptions(t *testing.T) {
+	defer os.Unsetenv("YOMO_TLS_CERT_ORG")
+	defer os.Unsetenv("YOMO_TLS_CERT_SANS")
+	defer os.Unsetenv("YOMO_TLS_CERT_VALIDITY_DAYS")
+	defer os.Unsetenv("YOMO_TLS_CERT_KEY_ALGORITHM")
+	os.Setenv("YOMO_TLS_CERT_ORG", "Env Org")
+	os.Setenv("YOMO_TLS_CERT_SANS", "zipper.internal, 10.0.0.1")
+	os.Setenv("YOMO_TLS_CERT_VALIDITY_DAYS", "30")
+	os.Setenv("YOMO_TLS_CERT_KEY_ALGORITHM", "rsa-2048")
+
+	// the options take precedence over the environment variables
+	opts, err := CertOptions{Organization: []string{"Acme"}, KeyAlgorithm: KeyAlgorithmEd25519}.withEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, CertOptions{
+		Organization: []string{"Acme"},
+		SANs:         []string{"zipper.internal", "10.0.0.1"},
+		ValidityDays: 30,
+		KeyAlgorithm: KeyAlgorithmEd25519,
+	}, opts)
+
+	cert, err := generateCertificate(opts, false)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Acme"}, leaf.Subject.Organization)
+	assert.Equal(t, []string{"localhost", "zipper.internal"}, leaf.DNSNames)
+	assert.Equal(t, "10.0.0.1", leaf.IPAddresses[0].String())
+	assert.Equal(t, 30*24*time.Hour, leaf.NotAfter.Sub(leaf.NotBefore))
+	assert.Equal(t, x509.Ed25519, leaf.PublicKeyAlgorithm)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	assert.NoError(t, handshake(cert, pool, "zipper.internal"))
+
+	// the environment variables are the fallback
+	opts, err = CertOptions{}.withEnv()
+	assert.NoError(t, err)
+	cert, err = generateCertificate(opts, true)
+	assert.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Env Org"}, leaf.Subject.Organization)
+	assert.Equal(t, x509.RSA, leaf.PublicKeyAlgorithm)
+
+	os.Setenv("YOMO_TLS_CERT_KEY_ALGORITHM", "dsa")
+	_, err = developmentTLSConfig(CertOptions{})
+	assert.Error(t, err)
+	os.Setenv("YOMO_TLS_CERT_VALIDITY_DAYS", "-1")
+	_, err = CertOptions{}.withEnv()
+	assert.Error(t, err)
+}
+
+func TestGenerateCertificateDeterministic(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	cert1, err := generateCertificate(DeterministicCertOptions("test", now), true, "127.0.0.1")
+	assert.NoError(t, err)
+	cert2, err := generateCertificate(DeterministicCertOptions("test", now), true, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, cert1.Certificate, cert2.Certificate)
+
+	leaf, err := x509.ParseCertificate(cert1.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, now, leaf.NotBefore)
+	assert.Equal(t, x509.Ed25519, leaf.PublicKeyAlgorithm)
+
+	// another seed generates another certificate
+	cert3, err := generateCertificate(DeterministicCertOptions("another", now), true, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, cert1.Certificate, cert3.Certificate)
+}
@@ -1,6 +1,7 @@
 package yomo
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/yomorun/yomo/core"
@@ -17,10 +18,15 @@ func newRouter(config *config.WorkflowConfig) core.Router {
 	return &router{config: config}
 }
 
-// router interface
+// router interface, the route of the app is from its override if any, otherwise the
+// shared workflow.
 func (r *router) Route(appID string) core.Route {
 	logger.Debugf("%sapp[%s] workflowconfig is %#v", zipperLogPrefix, appID, r.config)
-	return newRoute(r.config)
+	if r.config == nil {
+		return newRoute(nil)
+	}
+	wf := r.config.WorkflowOf(appID)
+	return newRoute(&wf)
 }
 
 func (r *router) Clean() {
@@ -30,20 +36,28 @@ func (r *router) Clean() {
 // route interface
 type route struct {
 	data sync.Map
+	// entries are the entry functions of the pinned sources
+	entries map[string]string
 }
 
-func newRoute(config *config.WorkflowConfig) *route {
-	if config == nil {
+func newRoute(workflow *config.Workflow) *route {
+	if workflow == nil {
 		logger.Errorf("%sworkflowconfig is nil", zipperLogPrefix)
 		return nil
 	}
 	r := route{
 		data: sync.Map{},
 	}
-	logger.Debugf("%sworkflowconfig %+v", zipperLogPrefix, *config)
-	for i, app := range config.Functions {
+	logger.Debugf("%sworkflow %+v", zipperLogPrefix, *workflow)
+	for i, app := range workflow.Functions {
 		r.Add(i, app.Name)
 	}
+	if len(workflow.Entries) > 0 {
+		r.entries = make(map[string]string, len(workflow.Entries))
+		for _, e := range workflow.Entries {
+			r.entries[e.Source] = e.Function
+		}
+	}
 
 	return &r
 }
@@ -57,7 +71,7 @@ func (r *route) Exists(name string) bool {
 	var ok bool
 	logger.Debugf("%srouter[%v] exists name: %s", zipperLogPrefix, r, name)
 	r.data.Range(func(key interface{}, val interface{}) bool {
-		if val.(string) == name {
+		if core.MatchName(val.(string), name) {
 			ok = true
 			return false
 		}
@@ -67,23 +81,72 @@ func (r *route) Exists(name string) bool {
 	return ok
 }
 
+// GetForwardRoutes returns the stages after current in the order of the workflow, current is
+// the token of a stage, the other names, e.g. the sources or "", are ahead of the first stage.
+// The pinned source enters the pipeline of its entry function, and the stages of a pipeline
+// are forwarded within it only, see config.Workflow.Entries.
 func (r *route) GetForwardRoutes(current string) []string {
+	stages := r.stages()
 	idx := -1
-	r.data.Range(func(key interface{}, val interface{}) bool {
-		if val.(string) == current {
-			idx = key.(int)
-			return false
+	for i, token := range stages {
+		if token == current {
+			idx = i
+			break
 		}
-		return true
-	})
+	}
+	if entry, ok := r.entries[current]; ok && idx < 0 {
+		for i, token := range stages {
+			if token == entry {
+				return append([]string{}, stages[i:r.pipelineEnd(stages, i)]...)
+			}
+		}
+		return []string{entry}
+	}
+	if idx < 0 {
+		return append([]string{}, stages...)
+	}
+	return append([]string{}, stages[idx+1:r.pipelineEnd(stages, idx)]...)
+}
 
-	routes := make([]string, 0)
-	r.data.Range(func(key interface{}, val interface{}) bool {
-		if key.(int) > idx {
-			routes = append(routes, val.(string))
+// pipelineEnd returns the index after the last stage of the pipeline of the stage at idx, a
+// pipeline starts at an entry function and ends before the next one. The stages ahead of the
+// first entry function aren't in a pipeline, they're forwarded to all the next stages.
+func (r *route) pipelineEnd(stages []string, idx int) int {
+	if len(r.entries) == 0 {
+		return len(stages)
+	}
+	entries := make(map[string]bool, len(r.entries))
+	for _, entry := range r.entries {
+		entries[entry] = true
+	}
+	piped := false
+	for i := 0; i <= idx; i++ {
+		piped = piped || entries[stages[i]]
+	}
+	if !piped {
+		return len(stages)
+	}
+	for i := idx + 1; i < len(stages); i++ {
+		if entries[stages[i]] {
+			return i
 		}
+	}
+	return len(stages)
+}
+
+// stages returns the tokens of the stages ordered by their indexes.
+func (r *route) stages() []string {
+	indexes := make([]int, 0)
+	tokens := make(map[int]string)
+	r.data.Range(func(key interface{}, val interface{}) bool {
+		indexes = append(indexes, key.(int))
+		tokens[key.(int)] = val.(string)
 		return true
 	})
-
-	return routes
+	sort.Ints(indexes)
+	result := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		result = append(result, tokens[i])
+	}
+	return result
 }
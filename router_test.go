@@ -0,0 +1,90 @@
+package yomo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/pkg/config"
+)
+
+func TestRouterOverrides(t *testing.T) {
+	conf := &config.WorkflowConfig{
+		Workflow: config.Workflow{Functions: []config.App{{Name: "decoder"}, {Name: "alerter"}}},
+		Overrides: []config.Override{
+			{AppID: "app-b", Workflow: config.Workflow{Functions: []config.App{{Name: "decoder"}, {Name: "archiver"}}}},
+		},
+	}
+	r := newRouter(conf)
+
+	// the apps without override share the workflow
+	shared := r.Route("app-a")
+	assert.Equal(t, []string{"alerter"}, shared.GetForwardRoutes("decoder"))
+	assert.False(t, shared.Exists("archiver"))
+
+	// the same stage forwards to another next stage for app-b
+	override := r.Route("app-b")
+	assert.Equal(t, []string{"archiver"}, override.GetForwardRoutes("decoder"))
+	assert.True(t, override.Exists("archiver"))
+	assert.False(t, override.Exists("alerter"))
+}
+
+func TestRouterPatterns(t *testing.T) {
+	conf := &config.WorkflowConfig{
+		Workflow: config.Workflow{Functions: []config.App{{Name: "*"}}},
+	}
+	r := newRouter(conf).Route("app-a")
+	// "" is ahead of the first stage, even if the stage matches any name
+	assert.Equal(t, []string{"*"}, r.GetForwardRoutes(""))
+	assert.True(t, r.Exists("sfn-1"))
+
+	conf = &config.WorkflowConfig{
+		Workflow: config.Workflow{Functions: []config.App{{Name: "decoder"}, {Name: "detector-*"}, {Name: "detector-eu"}, {Name: "alerter"}}},
+	}
+	r = newRouter(conf).Route("app-a")
+	// the source named like a pattern enters the first stage
+	assert.Equal(t, []string{"decoder", "detector-*", "detector-eu", "alerter"}, r.GetForwardRoutes("detector-cam"))
+	// the stages are looked up by their tokens exactly, in the order of the workflow
+	assert.Equal(t, []string{"detector-eu", "alerter"}, r.GetForwardRoutes("detector-*"))
+	assert.Equal(t, []string{"alerter"}, r.GetForwardRoutes("detector-eu"))
+	assert.True(t, r.Exists("detector-us-1"))
+	assert.False(t, r.Exists("tracker-us-1"))
+}
+
+func TestRouterEntries(t *testing.T) {
+	conf := &config.WorkflowConfig{
+		Workflow: config.Workflow{
+			Functions: []config.App{{Name: "decoder-a"}, {Name: "alerter-a"}, {Name: "decoder-b"}, {Name: "archiver-b"}},
+			Entries:   []config.Entry{{Source: "camera-1", Function: "decoder-a"}, {Source: "sensor-2", Function: "decoder-b"}},
+		},
+	}
+	r := newRouter(conf).Route("app-a")
+
+	// the pinned sources enter their pipelines
+	assert.Equal(t, []string{"decoder-a", "alerter-a"}, r.GetForwardRoutes("camera-1"))
+	assert.Equal(t, []string{"decoder-b", "archiver-b"}, r.GetForwardRoutes("sensor-2"))
+
+	// the other sources enter the workflow by default
+	assert.Equal(t, []string{"decoder-a", "alerter-a", "decoder-b", "archiver-b"}, r.GetForwardRoutes("sensor-3"))
+
+	// the stages are forwarded within their pipelines, pipeline A never forwards to B
+	assert.Equal(t, []string{"alerter-a"}, r.GetForwardRoutes("decoder-a"))
+	assert.Empty(t, r.GetForwardRoutes("alerter-a"))
+	assert.Equal(t, []string{"archiver-b"}, r.GetForwardRoutes("decoder-b"))
+	assert.Empty(t, r.GetForwardRoutes("archiver-b"))
+}
+
+func TestRouterEntriesShared(t *testing.T) {
+	conf := &config.WorkflowConfig{
+		Workflow: config.Workflow{
+			Functions: []config.App{{Name: "normalizer"}, {Name: "decoder-a"}, {Name: "alerter-a"}, {Name: "decoder-b"}},
+			Entries:   []config.Entry{{Source: "camera-1", Function: "decoder-a"}, {Source: "camera-2", Function: "decoder-a"}, {Source: "sensor-2", Function: "decoder-b"}},
+		},
+	}
+	r := newRouter(conf).Route("app-a")
+
+	// the sources pinned to the same entry share the pipeline
+	assert.Equal(t, r.GetForwardRoutes("camera-1"), r.GetForwardRoutes("camera-2"))
+	assert.Equal(t, []string{"decoder-b"}, r.GetForwardRoutes("sensor-2"))
+	// the stage ahead of the pipelines is forwarded to all the next stages
+	assert.Equal(t, []string{"decoder-a", "alerter-a", "decoder-b"}, r.GetForwardRoutes("normalizer"))
+}
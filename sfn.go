@@ -2,9 +2,11 @@ package yomo
 
 import (
 	"context"
+	"crypto/cipher"
 
 	"github.com/yomorun/yomo/core"
 	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/carriage"
 )
 
 const (
@@ -26,6 +28,10 @@ type StreamFunction interface {
 	Close() error
 	// Send a data to zipper.
 	Write(tag byte, carriage []byte) error
+	// UpdateSubscription replaces the observed data tags and the weight without reconnecting.
+	UpdateSubscription(tags []byte, weight uint32) error
+	// SequenceGaps returns how many gaps of the sequence numbers set by the sources are detected.
+	SequenceGaps() int64
 }
 
 // NewStreamFunction create a stream function.
@@ -37,6 +43,9 @@ func NewStreamFunction(name string, opts ...Option) StreamFunction {
 		zipperEndpoint:  options.ZipperAddr,
 		client:          client,
 		observeDataTags: make([]byte, 0),
+		aead:            options.CarriageAEAD,
+		sequences:       core.NewSequenceTracker(),
+		onGap:           options.SequenceGapHandler,
 	}
 
 	return sfn
@@ -54,6 +63,9 @@ type streamFunction struct {
 	pfn             core.PipeHandler
 	pIn             chan []byte
 	pOut            chan *frame.PayloadFrame
+	aead            cipher.AEAD // seals and opens the carriage, nil means plaintext
+	sequences       *core.SequenceTracker
+	onGap           core.SequenceGapHandler
 }
 
 // SetObserveDataTags set the data tag list that will be observed.
@@ -83,7 +95,13 @@ func (s *streamFunction) Connect() error {
 	// notify underlying network operations, when data with tag we observed arrived, invoke the func
 	s.client.SetDataFrameObserver(func(data *frame.DataFrame) {
 		s.client.Logger().Debugf("%sreceive DataFrame, tag=%# x, carraige=%# x", streamFunctionLogPrefix, data.Tag(), data.GetCarriage())
-		s.onDataFrame(data.GetCarriage(), data.GetMetaFrame())
+		s.observeSequence(data)
+		plaintext, err := s.open(data.GetDataTag(), data.GetCarriage())
+		if err != nil {
+			s.client.Logger().Errorf("%sdrop DataFrame, tid=%s, open carriage error: %v", streamFunctionLogPrefix, data.TransactionID(), err)
+			return
+		}
+		s.onDataFrame(plaintext, data.GetMetaFrame())
 	})
 
 	if s.pfn != nil {
@@ -101,9 +119,14 @@ func (s *streamFunction) Connect() error {
 				data := <-s.pOut
 				if data != nil {
 					s.client.Logger().Debugf("%spipe fn send: tag=%#x, data=%# x", streamFunctionLogPrefix, data.Tag, data.Carriage)
+					sealed, err := s.seal(data.Tag, data.Carriage)
+					if err != nil {
+						s.client.Logger().Errorf("%spipe fn seal carriage error: %v", streamFunctionLogPrefix, err)
+						continue
+					}
 					frame := frame.NewDataFrame()
 					// todo: frame.SetTransactionID
-					frame.SetCarriage(data.Tag, data.Carriage)
+					frame.SetCarriage(data.Tag, sealed)
 					s.client.WriteFrame(frame)
 				}
 			}
@@ -147,15 +170,29 @@ func (s *streamFunction) onDataFrame(data []byte, metaFrame *frame.MetaFrame) {
 			// invoke serverless
 			tag, resp := s.fn(data)
 			s.client.Logger().Debugf("%sexecute-done fn: tag=%#x, resp[%d]=%# x", streamFunctionLogPrefix, tag, len(resp), frame.Shortly(resp))
-			// if resp is not nil, means the user's function has returned something, we should send it to the zipper
-			if len(resp) != 0 {
+			// if resp is not nil, means the user's function has returned something, we should send it to the zipper,
+			// the empty resp is sent as well, e.g. a signal carried by the tag only
+			if resp != nil {
 				s.client.Logger().Debugf("%sstart WriteFrame(): tag=%#x, data[%d]=%# x", streamFunctionLogPrefix, tag, len(resp), frame.Shortly(resp))
+				sealed, err := s.seal(tag, resp)
+				if err != nil {
+					s.client.Logger().Errorf("%sseal carriage error: %v", streamFunctionLogPrefix, err)
+					return
+				}
 				// build a DataFrame
 				// TODO: seems we should implement a DeepCopy() of MetaFrame in the future
 				frame := frame.NewDataFrame()
 				// reuse transactionID
 				frame.SetTransactionID(metaFrame.TransactionID())
-				frame.SetCarriage(tag, resp)
+				// carry the hops, so the loops through stream functions are detected
+				frame.SetHops(metaFrame.Hops())
+				// carry the created time of the source for the end-to-end latency
+				frame.SetCreatedAt(metaFrame.CreatedAt())
+				// carry the expiry, the result of the stale data is stale as well
+				frame.SetExpiresAt(metaFrame.ExpiresAt())
+				// carry the metadata of the source as it is
+				frame.SetMetadata(metaFrame.Metadata())
+				frame.SetCarriage(tag, sealed)
 				s.client.WriteFrame(frame)
 			}
 		}()
@@ -168,8 +205,52 @@ func (s *streamFunction) onDataFrame(data []byte, metaFrame *frame.MetaFrame) {
 }
 
 // Send a DataFrame to zipper.
-func (s *streamFunction) Write(tag byte, carriage []byte) error {
+func (s *streamFunction) Write(tag byte, data []byte) error {
+	sealed, err := s.seal(tag, data)
+	if err != nil {
+		return err
+	}
 	frame := frame.NewDataFrame()
-	frame.SetCarriage(tag, carriage)
+	frame.SetCarriage(tag, sealed)
 	return s.client.WriteFrame(frame)
 }
+
+// UpdateSubscription replaces the observed data tags and the weight without reconnecting,
+// the zipper routes the next frames by them.
+func (s *streamFunction) UpdateSubscription(tags []byte, weight uint32) error {
+	return s.client.UpdateSubscription(tags, weight)
+}
+
+// SequenceGaps returns how many gaps of the sequence numbers set by the sources are detected.
+func (s *streamFunction) SequenceGaps() int64 {
+	return s.sequences.Gaps()
+}
+
+// observeSequence reports the frames lost before the DataFrame by its sequence number.
+func (s *streamFunction) observeSequence(data *frame.DataFrame) {
+	sequence := data.Sequence()
+	missed := s.sequences.Observe(data.Issuer(), data.GetDataTag(), sequence)
+	if missed == 0 {
+		return
+	}
+	s.client.Logger().Warnf("%sDataFrame gap from [%s], %d frames missed before sequence %d", streamFunctionLogPrefix, data.Issuer(), missed, sequence)
+	if s.onGap != nil {
+		s.onGap(data.Issuer(), sequence-missed, sequence)
+	}
+}
+
+// seal the carriage if the carriage encryption is enabled.
+func (s *streamFunction) seal(tag byte, data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+	return carriage.Seal(s.aead, tag, data)
+}
+
+// open the carriage if the carriage encryption is enabled.
+func (s *streamFunction) open(tag byte, data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+	return carriage.Open(s.aead, tag, data)
+}
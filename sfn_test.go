@@ -1,9 +1,12 @@
 package yomo
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/pkg/carriage"
 )
 
 func TestSfnConnectToServer(t *testing.T) {
@@ -21,3 +24,134 @@ func TestSfnConnectToServer(t *testing.T) {
 	err := sfn.Connect()
 	assert.Nil(t, err)
 }
+
+func TestSfnCarriageEncryption(t *testing.T) {
+	aead, err := carriage.NewAESGCM(bytes.Repeat([]byte{0x01}, 32))
+	assert.Nil(t, err)
+
+	received := make(chan []byte, 1)
+	sfn := NewStreamFunction(
+		"test-sfn",
+		WithZipperAddr("localhost:9000"),
+		WithObserveDataTags(0x35),
+		WithCarriageEncryption(aead),
+	)
+	defer sfn.Close()
+	sfn.SetHandler(func(data []byte) (byte, []byte) {
+		received <- data
+		return 0, nil
+	})
+	assert.Nil(t, sfn.Connect())
+
+	source := NewSource("test-source", WithCarriageEncryption(aead))
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+	assert.Nil(t, source.WriteWithTag(0x35, []byte("secret")))
+
+	select {
+	case data := <-received:
+		assert.Equal(t, []byte("secret"), data)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the sfn receives nothing")
+	}
+}
+
+func TestSfnEmptyCarriage(t *testing.T) {
+	received := make(chan []byte, 1)
+	sfn := NewStreamFunction(
+		"test-sfn",
+		WithZipperAddr("localhost:9000"),
+		WithObserveDataTags(0x36),
+	)
+	defer sfn.Close()
+	sfn.SetHandler(func(data []byte) (byte, []byte) {
+		received <- data
+		return 0x37, []byte{}
+	})
+	assert.Nil(t, sfn.Connect())
+
+	source := NewSource("test-source")
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+	// a signal carried by the tag only
+	assert.Nil(t, source.WriteWithTag(0x36, nil))
+
+	select {
+	case data := <-received:
+		assert.Empty(t, data)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the sfn receives nothing")
+	}
+}
+
+func TestSfnSequenceGap(t *testing.T) {
+	received := make(chan []byte, 2)
+	gaps := make(chan [2]uint64, 1)
+	sfn := NewStreamFunction(
+		"test-sfn",
+		WithZipperAddr("localhost:9000"),
+		WithObserveDataTags(0x39),
+		WithSequenceGapHandler(func(issuer string, expected, got uint64) {
+			assert.Equal(t, "test-source", issuer)
+			gaps <- [2]uint64{expected, got}
+		}),
+	)
+	defer sfn.Close()
+	sfn.SetHandler(func(data []byte) (byte, []byte) {
+		received <- data
+		return 0, nil
+	})
+	assert.Nil(t, sfn.Connect())
+
+	source := NewSource("test-source")
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+	assert.Nil(t, source.WriteWithTag(0x39, []byte("1")))
+	// the frames 2 and 3 are lost
+	source.(*yomoSource).sequences[0x39] += 2
+	assert.Nil(t, source.WriteWithTag(0x39, []byte("4")))
+
+	select {
+	case gap := <-gaps:
+		assert.Equal(t, [2]uint64{2, 4}, gap)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the gap isn't detected")
+	}
+	assert.EqualValues(t, 1, sfn.SequenceGaps())
+}
+
+func TestSfnSequenceTags(t *testing.T) {
+	received := make(chan []byte, 3)
+	sfn := NewStreamFunction(
+		"test-sfn",
+		WithZipperAddr("localhost:9000"),
+		WithObserveDataTags(0x3b, 0x3c),
+		WithSequenceGapHandler(func(issuer string, expected, got uint64) {
+			t.Errorf("unexpected gap of [%s] between %d and %d", issuer, expected, got)
+		}),
+	)
+	defer sfn.Close()
+	sfn.SetHandler(func(data []byte) (byte, []byte) {
+		received <- data
+		return 0, nil
+	})
+	assert.Nil(t, sfn.Connect())
+
+	source := NewSource("test-source-tags")
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+	// the tags are numbered separately, the unobserved tag isn't a gap either
+	assert.Nil(t, source.WriteWithTag(0x3b, []byte("1")))
+	assert.Nil(t, source.WriteWithTag(0x3d, []byte("-")))
+	assert.Nil(t, source.WriteWithTag(0x3c, []byte("2")))
+	assert.Nil(t, source.WriteWithTag(0x3b, []byte("3")))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(3 * time.Second):
+			t.Fatal("the sfn receives nothing")
+		}
+	}
+	assert.EqualValues(t, 0, sfn.SequenceGaps())
+}
@@ -2,9 +2,14 @@ package yomo
 
 import (
 	"context"
+	"crypto/cipher"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/yomorun/yomo/core"
 	"github.com/yomorun/yomo/core/frame"
+	"github.com/yomorun/yomo/pkg/carriage"
 )
 
 const (
@@ -17,12 +22,27 @@ type Source interface {
 	Close() error
 	// Connect to YoMo-Zipper.
 	Connect() error
+	// ConnectContext connects to the YoMo-Zipper at the endpoint with the extra dial options,
+	// e.g. WithLocalAddr, the dial and the handshake are aborted once the ctx is done. The
+	// source keeps reconnecting after the ctx is done until it's closed.
+	ConnectContext(ctx context.Context, endpoint string, opts ...Option) error
 	// SetDataTag will set the tag of data when invoking Write().
 	SetDataTag(tag uint8)
 	// Write the data to downstream.
 	Write(p []byte) (n int, err error)
-	// WriteWithTag will write data with specified tag, default transactionID is epoch time.
+	// WriteWithTag will write data with specified tag, the transactionID is generated by the
+	// TransactionIDGenerator, default is UUID.
 	WriteWithTag(tag uint8, data []byte) error
+	// WriteWithMetadata writes data with specified tag like WriteWithTag, the metadata is
+	// encoded by the codec set by WithMetadataCodec.
+	WriteWithMetadata(tag uint8, data []byte, md frame.Metadata) error
+	// Flush blocks until the frames written are handed to the YoMo-Zipper, and acknowledged
+	// if WithAckWindow is set, e.g. before closing the source of a batch job. It returns a
+	// *core.UnflushedError with the number of the remaining frames once the ctx is done.
+	Flush(ctx context.Context) error
+	// Ping sends an empty DataFrame and waits for it to be echoed back, returns the round trip
+	// time. The YoMo-Zipper must be in the echo mode, see core.Server.SetEchoMode.
+	Ping(ctx context.Context) (time.Duration, error)
 }
 
 // YoMo-Source
@@ -31,6 +51,11 @@ type yomoSource struct {
 	zipperEndpoint string
 	client         *core.Client
 	tag            uint8
+	aead           cipher.AEAD
+	pings          sync.Map    // transaction id -> chan struct{}
+	sequences      [256]uint64 // the last sequence number per tag, accessed atomically
+	ttl            time.Duration
+	codec          frame.MetadataCodec
 }
 
 var _ Source = &yomoSource{}
@@ -40,11 +65,16 @@ func NewSource(name string, opts ...Option) Source {
 	options := NewOptions(opts...)
 	client := core.NewClient(name, core.ClientTypeSource, options.ClientOptions...)
 
-	return &yomoSource{
+	s := &yomoSource{
 		name:           name,
 		zipperEndpoint: options.ZipperAddr,
 		client:         client,
+		aead:           options.CarriageAEAD,
+		ttl:            options.FrameTTL,
+		codec:          options.MetadataCodec,
 	}
+	client.SetDataFrameObserver(s.handleEcho)
+	return s
 }
 
 // Write the data to downstream.
@@ -69,17 +99,103 @@ func (s *yomoSource) Close() error {
 
 // Connect to YoMo-Zipper.
 func (s *yomoSource) Connect() error {
-	err := s.client.Connect(context.Background(), s.zipperEndpoint)
+	return s.ConnectContext(context.Background(), s.zipperEndpoint)
+}
+
+// ConnectContext connects to the YoMo-Zipper at the endpoint with the extra dial options.
+func (s *yomoSource) ConnectContext(ctx context.Context, endpoint string, opts ...Option) error {
+	options := &Options{}
+	for _, o := range opts {
+		o(options)
+	}
+	if err := s.client.Init(options.ClientOptions...); err != nil {
+		return err
+	}
+	s.zipperEndpoint = endpoint
+	err := s.client.Connect(ctx, endpoint)
 	if err != nil {
 		s.client.Logger().Errorf("%sConnect() error: %s", sourceLogPrefix, err)
 	}
 	return err
 }
 
-// WriteWithTag will write data with specified tag, default transactionID is epoch time.
+// WriteWithTag will write data with specified tag, the transactionID is generated by the
+// TransactionIDGenerator, default is UUID. The frames of every tag are numbered from 1 in the
+// order they're written, so the stream functions detect the lost frames of the tags they
+// observe, see WithSequenceGapHandler.
 func (s *yomoSource) WriteWithTag(tag uint8, data []byte) error {
+	return s.write(tag, data, nil)
+}
+
+// WriteWithMetadata writes data with specified tag and the metadata encoded by the codec.
+func (s *yomoSource) WriteWithMetadata(tag uint8, data []byte, md frame.Metadata) error {
+	metadata, err := s.codec.Encode(md)
+	if err != nil {
+		return err
+	}
+	return s.write(tag, data, metadata)
+}
+
+func (s *yomoSource) write(tag uint8, data []byte, metadata []byte) error {
 	s.client.Logger().Debugf("%sWriteWithTag: len(data)=%d, data=%# x", sourceLogPrefix, len(data), frame.Shortly(data))
-	frame := frame.NewDataFrame()
-	frame.SetCarriage(byte(tag), data)
-	return s.client.WriteFrame(frame)
+	if s.aead != nil {
+		sealed, err := carriage.Seal(s.aead, tag, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	now := s.client.Clock().Now()
+	builder := frame.NewDataFrameBuilder(s.client.NewTransactionID()).
+		WithCarriage(byte(tag), data).
+		WithCreatedAt(now).
+		WithSequence(atomic.AddUint64(&s.sequences[tag], 1)).
+		WithMetadata(metadata)
+	if s.ttl > 0 {
+		builder.WithExpiresAt(now.Add(s.ttl))
+	}
+	f, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	return s.client.WriteFrame(f)
+}
+
+// Flush blocks until the frames written are handed to the YoMo-Zipper.
+func (s *yomoSource) Flush(ctx context.Context) error {
+	err := s.client.Flush(ctx)
+	if err != nil {
+		s.client.Logger().Errorf("%sFlush() error: %v", sourceLogPrefix, err)
+	}
+	return err
+}
+
+// Ping sends an empty DataFrame and waits for the YoMo-Zipper in the echo mode to echo it back.
+func (s *yomoSource) Ping(ctx context.Context) (time.Duration, error) {
+	tid := s.client.NewTransactionID()
+	echoed := make(chan struct{})
+	s.pings.Store(tid, echoed)
+	defer s.pings.Delete(tid)
+
+	f, err := frame.NewDataFrameBuilder(tid).WithTag(s.tag).Build()
+	if err != nil {
+		return 0, err
+	}
+	start := s.client.Clock().Now()
+	if err := s.client.WriteFrame(f); err != nil {
+		return 0, err
+	}
+	select {
+	case <-echoed:
+		return s.client.Clock().Now().Sub(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// handleEcho completes the Ping waiting for the echoed frame.
+func (s *yomoSource) handleEcho(f *frame.DataFrame) {
+	if echoed, ok := s.pings.LoadAndDelete(f.TransactionID()); ok {
+		close(echoed.(chan struct{}))
+	}
 }
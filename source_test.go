@@ -1,9 +1,18 @@
 package yomo
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core"
+	"github.com/yomorun/yomo/core/frame"
 )
 
 func TestSourceSendDataToServer(t *testing.T) {
@@ -18,4 +27,88 @@ func TestSourceSendDataToServer(t *testing.T) {
 	n, err := source.Write([]byte("test"))
 	assert.Greater(t, n, 0, "[source.Write] expected n > 0, but got %d", n)
 	assert.Nil(t, err)
+
+	// send data with the metadata
+	err = source.WriteWithMetadata(0x33, []byte("test"), frame.Metadata{"trace-id": "abc"})
+	assert.Nil(t, err)
+}
+
+// freeAddr returns a local UDP address which isn't in use.
+func freeAddr(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestSourcePing(t *testing.T) {
+	addr := freeAddr(t)
+	z := NewZipperWithOptions("echo-zipper", WithZipperAddr(addr))
+	defer z.Close()
+	z.ConfigWorkflow("test/workflow.yaml")
+	server := z.(*zipper).server
+	server.SetEchoMode(true)
+	go z.ListenAndServe()
+	assert.Eventually(t, func() bool { return server.State() == core.ServerStateListening }, time.Second, 10*time.Millisecond)
+
+	source := NewSource("test-source", WithZipperAddr(addr))
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rtt, err := source.Ping(ctx)
+	assert.Nil(t, err)
+	assert.Greater(t, int64(rtt), int64(0))
+}
+
+func TestSourceConnectContext(t *testing.T) {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pconn.Close()
+
+	source := NewSource("test-source")
+	defer source.Close()
+	assert.Nil(t, source.ConnectContext(context.Background(), "127.0.0.1:9000", WithPacketConn(pconn)))
+	assert.Nil(t, source.WriteWithTag(0x33, []byte("test")))
+
+	bound := NewSource("test-source")
+	defer bound.Close()
+	assert.Nil(t, bound.ConnectContext(context.Background(), "127.0.0.1:9000", WithLocalAddr("127.0.0.1:0")))
+	assert.Nil(t, bound.WriteWithTag(0x33, []byte("test")))
+}
+
+func TestSourceConnectContextCanceled(t *testing.T) {
+	dialed := make(chan struct{})
+	var once sync.Once
+	source := NewSource("test-source", WithDialer(func(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (quic.Connection, error) {
+		// the source keeps reconnecting until it's closed
+		once.Do(func() { close(dialed) })
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	defer source.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-dialed
+		cancel()
+	}()
+	err := source.ConnectContext(ctx, "localhost:9000")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestSourceFlush(t *testing.T) {
+	source := NewSource("test-source", WithAckWindow(4, time.Second))
+	defer source.Close()
+	assert.Nil(t, source.Connect())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, source.WriteWithTag(0x33, []byte("batch")))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.Nil(t, source.Flush(ctx))
 }
@@ -24,6 +24,9 @@ type Zipper interface {
 	// ConfigWorkflow will register workflows from config files to zipper.
 	ConfigWorkflow(conf string) error
 
+	// AddWorkflow will register the workflows to zipper, invalid workflows are refused.
+	AddWorkflow(wfs ...core.Workflow) error
+
 	// ConfigMesh will register edge-mesh config URL
 	ConfigMesh(url string) error
 
@@ -42,8 +45,11 @@ type Zipper interface {
 	// Close will close the zipper.
 	Close() error
 
+	// Shutdown closes the zipper after the DataFrames in-flight are drained, the sources are
+	// closed first and the stream functions last, see core.Server.Shutdown.
+	Shutdown(ctx context.Context) error
+
 	// ReadConfigFile(conf string) error
-	// AddWorkflow(wf ...core.Workflow) error
 	// ConfigDownstream(opts ...interface{}) error
 	// Connect() error
 	// RemoveDownstreamZipper(downstream Zipper) error
@@ -58,6 +64,7 @@ type zipper struct {
 	server            *core.Server
 	client            *core.Client
 	downstreamZippers []Zipper
+	maxStages         int // the max number of the stages of the workflows, 0 means core.DefaultMaxStages
 }
 
 var _ Zipper = &zipper{}
@@ -108,9 +115,10 @@ func createZipperServer(name string, options *Options) *zipper {
 	// create underlying QUIC server
 	srv := core.NewServer(name, options.ServerOptions...)
 	z := &zipper{
-		server: srv,
-		name:   name,
-		addr:   options.ZipperAddr,
+		server:    srv,
+		name:      name,
+		addr:      options.ZipperAddr,
+		maxStages: options.MaxStages,
 	}
 	// initialize
 	z.init()
@@ -129,10 +137,32 @@ func (z *zipper) ConfigWorkflow(conf string) error {
 }
 
 func (z *zipper) configWorkflow(config *config.WorkflowConfig) error {
+	if err := core.CheckStages(len(config.Functions), z.maxStages); err != nil {
+		return err
+	}
+	for _, o := range config.Overrides {
+		if err := core.CheckStages(len(o.Functions), z.maxStages); err != nil {
+			return fmt.Errorf("%w, app_id=%s", err, o.AppID)
+		}
+	}
 	// router
 	return z.server.ConfigRouter(newRouter(config))
 }
 
+// AddWorkflow will validate the workflows and register them to zipper in the order of seq.
+func (z *zipper) AddWorkflow(wfs ...core.Workflow) error {
+	workflows := core.Workflows(wfs)
+	if err := workflows.ValidateMaxStages(z.maxStages); err != nil {
+		return err
+	}
+	conf := &config.WorkflowConfig{Name: z.name}
+	for _, wf := range workflows.Sorted() {
+		conf.Functions = append(conf.Functions, config.App{Name: wf.Name})
+	}
+	logger.Debugf("%sAddWorkflow config=%+v", zipperLogPrefix, conf)
+	return z.configWorkflow(conf)
+}
+
 func (z *zipper) ConfigMesh(url string) error {
 	if url == "" {
 		return nil
@@ -232,10 +262,28 @@ func (z *zipper) Close() error {
 	return nil
 }
 
+// Shutdown closes the server in order if zipper is Server, then closes the client.
+func (z *zipper) Shutdown(ctx context.Context) error {
+	var err error
+	if z.server != nil {
+		if err = z.server.Shutdown(ctx); err != nil {
+			logger.Errorf("%s Shutdown(): %v", zipperLogPrefix, err)
+		}
+	}
+	if z.client != nil {
+		if cerr := z.client.Close(); cerr != nil {
+			logger.Errorf("%s Shutdown(): %v", zipperLogPrefix, cerr)
+			return cerr
+		}
+	}
+	return err
+}
+
 // Stats inspects current server.
 func (z *zipper) Stats() int {
-	log.Printf("[%s] all sfn connected: %d", z.name, len(z.server.StatsFunctions()))
-	for k := range z.server.StatsFunctions() {
+	funcs := z.server.LiveFunctions()
+	log.Printf("[%s] all sfn connected: %d", z.name, len(funcs))
+	for k := range funcs {
 		log.Printf("[%s] -> ConnID=%v", z.name, k)
 	}
 
@@ -246,5 +294,5 @@ func (z *zipper) Stats() int {
 
 	log.Printf("[%s] total DataFrames received: %d", z.name, z.server.StatsCounter())
 
-	return len(z.server.StatsFunctions())
+	return len(funcs)
 }
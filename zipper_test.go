@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/yomorun/yomo/core"
+	"github.com/yomorun/yomo/pkg/config"
 )
 
 func TestZipperRun(t *testing.T) {
@@ -15,3 +17,18 @@ func TestZipperRun(t *testing.T) {
 	time.Sleep(time.Second)
 	assert.Nil(t, err)
 }
+
+func TestZipperMaxStages(t *testing.T) {
+	z := createZipperServer("zipper", NewOptions(WithMaxStages(2)))
+	assert.NoError(t, z.AddWorkflow(core.Workflow{Seq: 0, Name: "sfn-1"}, core.Workflow{Seq: 1, Name: "sfn-2"}))
+	assert.EqualError(t, z.AddWorkflow(
+		core.Workflow{Seq: 0, Name: "sfn-1"},
+		core.Workflow{Seq: 1, Name: "sfn-2"},
+		core.Workflow{Seq: 2, Name: "sfn-3"},
+	), "workflow: 3 stages exceed the max 2")
+
+	conf := &config.WorkflowConfig{Name: "zipper"}
+	conf.Overrides = []config.Override{{AppID: "app-1"}}
+	conf.Overrides[0].Functions = []config.App{{Name: "sfn-1"}, {Name: "sfn-2"}, {Name: "sfn-3"}}
+	assert.EqualError(t, z.configWorkflow(conf), "workflow: 3 stages exceed the max 2, app_id=app-1")
+}